@@ -1,18 +1,79 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/scheduler"
+	"github.com/mstgnz/goflow/pkg/server"
+	"github.com/mstgnz/goflow/pkg/store"
 	"github.com/mstgnz/goflow/pkg/workflow"
 )
 
 func main() {
 	// Define command-line flags
 	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
-	runFile := runCmd.String("file", "", "Path to the workflow file")
+	runFile := runCmd.String("file", "", "Path to the workflow file (.json, .yaml or .yml)")
+	runInputs := inputFlags{}
+	runCmd.Var(runInputs, "input", "Workflow input as name=value, can be repeated")
+	runStateDir := runCmd.String("state-dir", "", "Directory to store run state in, in memory if empty")
+	runIncludes := fileFlags{}
+	runCmd.Var(&runIncludes, "include", "Workflow file that workflow steps can run, can be repeated")
+
+	resumeCmd := flag.NewFlagSet("resume", flag.ExitOnError)
+	resumeRun := resumeCmd.String("run", "", "ID of the run to resume")
+	resumeFile := resumeCmd.String("file", "", "Path to the workflow file of the run")
+	resumeStateDir := resumeCmd.String("state-dir", "", "Directory the run state is stored in")
+	resumeIncludes := fileFlags{}
+	resumeCmd.Var(&resumeIncludes, "include", "Workflow file that workflow steps can run, can be repeated")
+
+	replayCmd := flag.NewFlagSet("replay", flag.ExitOnError)
+	replayRun := replayCmd.String("run", "", "ID of the run to replay")
+	replayFile := replayCmd.String("file", "", "Path to the workflow file to check the run against")
+	replayStateDir := replayCmd.String("state-dir", "", "Directory the run state is stored in")
+
+	signalCmd := flag.NewFlagSet("signal", flag.ExitOnError)
+	signalRun := signalCmd.String("run", "", "ID of the run to signal")
+	signalName := signalCmd.String("name", "", "Name of the signal")
+	signalPayload := signalCmd.String("payload", "", "Payload of the signal as a JSON object")
+	signalFile := signalCmd.String("file", "", "Path to the workflow file of the run")
+	signalIncludes := fileFlags{}
+	signalCmd.Var(&signalIncludes, "include", "Workflow file that workflow steps can run, can be repeated")
+	signalStateDir := signalCmd.String("state-dir", "", "Directory the run state is stored in")
+
+	scheduleCmd := flag.NewFlagSet("schedule", flag.ExitOnError)
+	scheduleFile := scheduleCmd.String("file", "", "Path to the workflow file to schedule")
+	scheduleIncludes := fileFlags{}
+	scheduleCmd.Var(&scheduleIncludes, "include", "Workflow file that workflow steps can run, can be repeated")
+	scheduleInputs := inputFlags{}
+	scheduleCmd.Var(scheduleInputs, "input", "Workflow input as name=value, can be repeated")
+	scheduleStateDir := scheduleCmd.String("state-dir", "", "Directory to store run state and last fire times in, in memory if empty")
+	schedule := scheduler.Schedule{}
+	scheduleCmd.StringVar(&schedule.Name, "name", "", "Name of the schedule, defaults to the workflow name")
+	scheduleCmd.StringVar(&schedule.Cron, "cron", "", "Cron expression with 5 or 6 fields, e.g. \"0 2 * * *\"")
+	scheduleCmd.StringVar(&schedule.Every, "every", "", "Fixed interval instead of a cron expression, e.g. 15m")
+	scheduleCmd.StringVar(&schedule.TimeZone, "tz", "", "Time zone of the cron expression, defaults to UTC")
+	scheduleCmd.StringVar(&schedule.Overlap, "overlap", scheduler.OverlapSkip, "What to do when a run is still running: skip, queue or cancel_previous")
+	scheduleCmd.StringVar(&schedule.CatchUp, "catch-up", scheduler.CatchUpNone, "Runs to start for missed fire times: none, last or all")
+
+	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	serveAddr := serveCmd.String("addr", "127.0.0.1:8080", "Address to listen on. Set "+tokenEnv+" before listening on other interfaces")
+	serveFiles := fileFlags{}
+	serveCmd.Var(&serveFiles, "file", "Workflow file to load at startup, can be repeated")
+	serveStateDir := serveCmd.String("state-dir", "", "Directory to store run state in, in memory if empty")
+	serveShutdownTimeout := serveCmd.Duration("shutdown-timeout", server.DefaultShutdownTimeout, "How long to wait for runs on shutdown before cancelling them")
+	serveAllowUpload := serveCmd.Bool("allow-upload", false, "Allow clients to load workflows with POST /workflows")
+	serveEnv := serveCmd.String("env", "", "Comma-separated environment variables that expressions can read, none if empty")
 
 	// Parse command-line arguments
 	if len(os.Args) < 2 {
@@ -35,7 +96,72 @@ func main() {
 			os.Exit(1)
 		}
 
-		runWorkflow(*runFile)
+		runWorkflow(*runFile, runIncludes, runInputs, *runStateDir)
+	case "resume":
+		err := resumeCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing arguments: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *resumeRun == "" || *resumeFile == "" || *resumeStateDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -run, -file and -state-dir flags are required\n")
+			resumeCmd.Usage()
+			os.Exit(1)
+		}
+
+		resumeWorkflow(*resumeFile, resumeIncludes, *resumeRun, *resumeStateDir)
+	case "replay":
+		err := replayCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing arguments: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *replayRun == "" || *replayFile == "" || *replayStateDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -run, -file and -state-dir flags are required\n")
+			replayCmd.Usage()
+			os.Exit(1)
+		}
+
+		replayRunEvents(*replayFile, *replayRun, *replayStateDir)
+	case "signal":
+		err := signalCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing arguments: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *signalRun == "" || *signalName == "" || *signalFile == "" || *signalStateDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: -run, -name, -file and -state-dir flags are required\n")
+			signalCmd.Usage()
+			os.Exit(1)
+		}
+
+		signalWorkflow(*signalFile, signalIncludes, *signalRun, *signalName, *signalPayload, *signalStateDir)
+	case "schedule":
+		err := scheduleCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing arguments: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *scheduleFile == "" || (schedule.Cron == "") == (schedule.Every == "") {
+			fmt.Fprintf(os.Stderr, "Error: -file and either -cron or -every flags are required\n")
+			scheduleCmd.Usage()
+			os.Exit(1)
+		}
+
+		schedule.Inputs = scheduleInputs
+		scheduleWorkflow(*scheduleFile, scheduleIncludes, schedule, *scheduleStateDir)
+	case "serve":
+		err := serveCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing arguments: %v\n", err)
+			os.Exit(1)
+		}
+
+		serveAPI(*serveAddr, serveFiles, *serveStateDir, *serveShutdownTimeout, *serveAllowUpload, envNames(*serveEnv))
 	default:
 		printUsage()
 		os.Exit(1)
@@ -44,23 +170,56 @@ func main() {
 
 func printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  goflow run -file <workflow-file>")
+	fmt.Println("  goflow run -file <workflow-file> [-include <workflow-file> ...] [-input name=value ...] [-state-dir <dir>]")
+	fmt.Println("  goflow resume -run <run-id> -file <workflow-file> [-include <workflow-file> ...] -state-dir <dir>")
+	fmt.Println("  goflow replay -run <run-id> -file <workflow-file> -state-dir <dir>")
+	fmt.Println("  goflow signal -run <run-id> -name <signal> [-payload <json>] -file <workflow-file> [-include <workflow-file> ...] -state-dir <dir>")
+	fmt.Println("  goflow schedule -file <workflow-file> (-cron <expr> [-tz <zone>] | -every <duration>) [-overlap skip|queue|cancel_previous] [-catch-up none|last|all] [-input name=value ...] [-state-dir <dir>]")
+	fmt.Println("  [GOFLOW_API_TOKEN=<token>] goflow serve [-addr <host:port>] [-file <workflow-file> ...] [-state-dir <dir>] [-shutdown-timeout <duration>] [-allow-upload] [-env <name,...>]")
 }
 
-func runWorkflow(filePath string) {
-	// Create a new workflow engine
-	engine := workflow.NewEngine()
+// inputFlags collects repeated -input name=value flags. Values that look like
+// JSON lists or objects are decoded, everything else is passed as a string and
+// converted to the declared input type by the engine
+type inputFlags map[string]any
 
-	// Register default tasks
-	engine.RegisterDefaultTasks()
+func (f inputFlags) String() string {
+	return fmt.Sprint(map[string]any(f))
+}
 
-	// Load the workflow
-	err := engine.Load(filePath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading workflow: %v\n", err)
-		os.Exit(1)
+func (f inputFlags) Set(value string) error {
+	name, raw, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("input must have the form name=value, got %q", value)
+	}
+
+	if strings.HasPrefix(raw, "[") || strings.HasPrefix(raw, "{") {
+		var decoded any
+		if err := json.Unmarshal([]byte(raw), &decoded); err == nil {
+			f[name] = decoded
+			return nil
+		}
 	}
 
+	f[name] = raw
+	return nil
+}
+
+// fileFlags collects repeated file flags
+type fileFlags []string
+
+func (f *fileFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func runWorkflow(filePath string, includes []string, inputs map[string]any, stateDir string) {
+	engine := newEngine(filePath, includes, stateDir)
+
 	// Get the workflow name from the file
 	workflowName := getWorkflowNameFromFile(filePath)
 	if workflowName == "" {
@@ -68,40 +227,348 @@ func runWorkflow(filePath string) {
 		os.Exit(1)
 	}
 
+	// Cancel the run on Ctrl+C, giving running tasks the grace period to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Run the workflow
 	fmt.Printf("Running workflow: %s\n", workflowName)
-	state, err := engine.Run(workflowName)
+	state, err := engine.RunContext(ctx, workflowName, inputs)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error running workflow: %v\n", err)
+		if state != nil {
+			fmt.Fprintf(os.Stderr, "Run ID: %s\n", state.RunID)
+		}
+		os.Exit(1)
+	}
+
+	printState(state)
+}
+
+func resumeWorkflow(filePath string, includes []string, runID, stateDir string) {
+	engine := newEngine(filePath, includes, stateDir)
+
+	// Cancel the run on Ctrl+C, so it can be resumed again later
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Resume the run
+	fmt.Printf("Resuming run: %s\n", runID)
+	state, err := engine.Resume(ctx, runID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error resuming run: %v\n", err)
+		os.Exit(1)
+	}
+
+	printState(state)
+}
+
+func signalWorkflow(filePath string, includes []string, runID, name, payload, stateDir string) {
+	engine := newEngine(filePath, includes, stateDir)
+
+	var data map[string]any
+	if payload != "" {
+		if err := json.Unmarshal([]byte(payload), &data); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: payload must be a JSON object: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Cancel the run on Ctrl+C, so it can be resumed again later
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Send the signal, then follow the run until it ends or waits again
+	fmt.Printf("Sending signal %s to run: %s\n", name, runID)
+	if err := engine.Signal(ctx, runID, name, data); err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending signal: %v\n", err)
+		os.Exit(1)
+	}
+
+	state, err := engine.Wait(ctx, runID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error waiting for run: %v\n", err)
+		os.Exit(1)
+	}
+
+	printState(state)
+}
+
+func scheduleWorkflow(filePath string, includes []string, schedule scheduler.Schedule, stateDir string) {
+	engine := newEngine(filePath, includes, stateDir)
+
+	schedule.Workflow = getWorkflowNameFromFile(filePath)
+	if schedule.Workflow == "" {
+		fmt.Fprintf(os.Stderr, "Error: could not determine workflow name\n")
+		os.Exit(1)
+	}
+	if schedule.Name == "" {
+		schedule.Name = schedule.Workflow
+	}
+
+	// Store the last fire times next to the run state, so missed fires can be caught up on
+	var schedules store.ScheduleStore = store.NewMemoryStore()
+	if stateDir != "" {
+		fileStore, err := store.NewFileStore(stateDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening state directory: %v\n", err)
+			os.Exit(1)
+		}
+		schedules = fileStore
+
+		// Wake up the runs that were sleeping when the process last exited
+		if _, err := engine.RestoreWakeUps(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring wake-ups: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	s := scheduler.New(engine, schedules)
+	s.SetRunHandler(func(name string, state *models.WorkflowState, err error) {
+		if state == nil {
+			fmt.Fprintf(os.Stderr, "Schedule %s: %v\n", name, err)
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error running workflow: %v\n", err)
+		}
+		printState(state)
+	})
+	if err := s.Add(schedule); err != nil {
+		fmt.Fprintf(os.Stderr, "Error adding schedule: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Cancel the runs and stop on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := s.Start(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting scheduler: %v\n", err)
 		os.Exit(1)
 	}
+	next, _ := s.Next(schedule.Name)
+	fmt.Printf("Scheduled workflow %s, next run at %s\n", schedule.Workflow, next.Format(time.RFC3339))
 
+	<-ctx.Done()
+	fmt.Println("Stopping scheduler")
+	s.Stop()
+}
+
+func serveAPI(addr string, files []string, stateDir string, shutdownTimeout time.Duration, allowUpload bool, env []string) {
+	// Workflows can also be uploaded once the server runs, if it allows it
+	engine := newEngine("", files, stateDir)
+
+	// Clients can read what expressions evaluate to, so expressions only read
+	// the environment variables that are listed
+	engine.SetEnvAllowlist(env...)
+	if stateDir != "" {
+		// Wake up the runs that were sleeping when the process last exited
+		if _, err := engine.RestoreWakeUps(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error restoring wake-ups: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Shut down gracefully on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := server.New(engine)
+	s.SetShutdownTimeout(shutdownTimeout)
+	s.SetAllowUpload(allowUpload)
+
+	// Anyone who can reach the API can start, cancel and signal runs, unless
+	// it requires a token
+	token := os.Getenv(tokenEnv)
+	s.SetToken(token)
+	if token == "" && !isLoopback(addr) {
+		fmt.Fprintf(os.Stderr, "Warning: serving the API on %s without a token, set %s to require one\n", addr, tokenEnv)
+	}
+
+	fmt.Printf("Serving the API on %s\n", addr)
+	if err := s.ListenAndServe(ctx, addr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error serving the API: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Server stopped")
+}
+
+// tokenEnv is the environment variable with the bearer token goflow serve requires
+const tokenEnv = "GOFLOW_API_TOKEN"
+
+// isLoopback reports whether an address only listens on the loopback interface
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// envNames splits a comma-separated list of environment variable names
+func envNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func replayRunEvents(filePath, runID, stateDir string) {
+	engine := newEngine(filePath, nil, stateDir)
+
+	events, err := engine.Events(runID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading events: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Print the history of the run
+	for _, event := range events {
+		fmt.Printf("%4d %s %-16s %s\n", event.Seq, event.Time.Format(time.RFC3339Nano), event.Type, event.StepID)
+	}
+
+	// Rebuild the state and check the workflow against the history
+	state, err := engine.Replay(events)
+	if state != nil {
+		printState(state)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error replaying run: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newEngine creates an engine with the default tasks and the workflow of a
+// file loaded, if there is one, along with the included workflows its
+// workflow steps run. Run state is stored in stateDir, or in memory if it is empty
+func newEngine(filePath string, includes []string, stateDir string) *workflow.Engine {
+	// Create a new workflow engine
+	engine := workflow.NewEngine()
+
+	// Store run state in files, so runs can be resumed after the process exits
+	if stateDir != "" {
+		fileStore, err := store.NewFileStore(stateDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening state directory: %v\n", err)
+			os.Exit(1)
+		}
+		engine.SetStateStore(fileStore)
+	}
+
+	// Register default tasks
+	engine.RegisterDefaultTasks()
+
+	// Load the workflow and the ones it runs
+	for _, path := range append([]string{filePath}, includes...) {
+		if path == "" {
+			continue
+		}
+		if err := engine.Load(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading workflow %s: %v\n", path, err)
+			os.Exit(1)
+		}
+	}
+
+	return engine
+}
+
+// printState prints the status, step results and outputs of a finished run
+func printState(state *models.WorkflowState) {
 	// Print the result
-	fmt.Printf("Workflow completed with status: %s\n", state.Status)
+	if state.Status == models.RunWaiting {
+		fmt.Println("Workflow is waiting")
+	} else {
+		fmt.Printf("Workflow completed with status: %s\n", state.Status)
+	}
+	fmt.Printf("Run ID: %s\n", state.RunID)
+	if state.ParentRunID != "" {
+		fmt.Printf("Parent run ID: %s\n", state.ParentRunID)
+	}
 
-	// Print the step results
+	// Print the step results in the order the steps started
 	fmt.Println("Step results:")
-	for stepID, result := range state.StepResults {
-		fmt.Printf("  %s: %v\n", stepID, result.Success)
+	stepIDs := make([]string, 0, len(state.StepResults))
+	for stepID := range state.StepResults {
+		stepIDs = append(stepIDs, stepID)
+	}
+	sort.Slice(stepIDs, func(i, j int) bool {
+		a, b := state.StepResults[stepIDs[i]].StartedAt, state.StepResults[stepIDs[j]].StartedAt
+		switch {
+		case a.IsZero() != b.IsZero():
+			// Steps that never started come last
+			return b.IsZero()
+		case !a.Equal(b):
+			return a.Before(b)
+		}
+		return stepIDs[i] < stepIDs[j]
+	})
+	for _, stepID := range stepIDs {
+		result := state.StepResults[stepID]
+		fmt.Printf("  %s: %s", stepID, result.Status)
+		if result.AttemptCount > 0 {
+			fmt.Printf(" after %d attempt(s) in %s", result.AttemptCount, result.Duration)
+		}
+		if result.Error != "" {
+			fmt.Printf(" (%s)", result.Error)
+		}
+		fmt.Println()
+		if result.Status == models.StepWaiting {
+			fmt.Print("    waiting")
+			if result.Signal != "" {
+				fmt.Printf(" for signal %s", result.Signal)
+			}
+			if !result.WakeAt.IsZero() {
+				fmt.Printf(" until %s", result.WakeAt.Format(time.RFC3339))
+			}
+			fmt.Println()
+		}
+		if result.ChildRunID != "" {
+			fmt.Printf("    child run: %s\n", result.ChildRunID)
+		}
+		if c := result.Compensation; c != nil {
+			fmt.Printf("    compensated by %s: %s %s\n", c.Task, c.Status, c.Error)
+		}
+	}
+
+	// Print the outputs
+	if len(state.Outputs) > 0 {
+		fmt.Println("Outputs:")
+		names := make([]string, 0, len(state.Outputs))
+		for name := range state.Outputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %s: %v\n", name, state.Outputs[name])
+		}
 	}
 }
 
 func getWorkflowNameFromFile(filePath string) string {
-	// Read the file
-	data, err := os.ReadFile(filePath)
+	format, err := workflow.FormatFromPath(filePath)
 	if err != nil {
 		return ""
 	}
 
-	// Parse the JSON
-	var workflow struct {
-		Name string `json:"name"`
+	// Read the file
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return ""
 	}
 
-	err = json.Unmarshal(data, &workflow)
+	// Parse the workflow definition
+	wf, err := workflow.Parse(data, format)
 	if err != nil {
 		return ""
 	}
 
-	return workflow.Name
+	return wf.Name
 }
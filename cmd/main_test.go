@@ -60,4 +60,84 @@ func TestGetWorkflowNameFromFile(t *testing.T) {
 	if name != "" {
 		t.Errorf("Expected empty workflow name for invalid JSON, got %s", name)
 	}
+
+	// Test with a YAML file
+	tmpfile3, err := os.CreateTemp("", "workflow-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmpfile3.Name())
+
+	if _, err := tmpfile3.Write([]byte("name: yaml_workflow\nsteps: []\n")); err != nil {
+		t.Fatalf("Failed to write to temporary file: %v", err)
+	}
+	if err := tmpfile3.Close(); err != nil {
+		t.Fatalf("Failed to close temporary file: %v", err)
+	}
+
+	name = getWorkflowNameFromFile(tmpfile3.Name())
+	if name != "yaml_workflow" {
+		t.Errorf("Expected workflow name yaml_workflow, got %s", name)
+	}
+}
+
+func TestInputFlags(t *testing.T) {
+	inputs := inputFlags{}
+
+	for _, value := range []string{"order_id=A-100", "amount=12.5", `items=["a","b"]`, "note=a=b"} {
+		if err := inputs.Set(value); err != nil {
+			t.Fatalf("Failed to set input %s: %v", value, err)
+		}
+	}
+
+	if inputs["order_id"] != "A-100" {
+		t.Errorf("Expected order_id A-100, got %v", inputs["order_id"])
+	}
+
+	// Values are converted to the declared type by the engine
+	if inputs["amount"] != "12.5" {
+		t.Errorf("Expected amount 12.5 as a string, got %v", inputs["amount"])
+	}
+
+	if items, ok := inputs["items"].([]any); !ok || len(items) != 2 {
+		t.Errorf("Expected items to be decoded as a list, got %v", inputs["items"])
+	}
+
+	if inputs["note"] != "a=b" {
+		t.Errorf("Expected note a=b, got %v", inputs["note"])
+	}
+
+	// Test with an invalid input
+	if err := inputs.Set("missing_value"); err == nil {
+		t.Error("Expected error for input without a value")
+	}
+}
+
+func TestEnvNames(t *testing.T) {
+	names := envNames(" AWS_REGION, ,LOG_LEVEL ")
+	if len(names) != 2 || names[0] != "AWS_REGION" || names[1] != "LOG_LEVEL" {
+		t.Errorf("Expected AWS_REGION and LOG_LEVEL, got %v", names)
+	}
+
+	if names := envNames(""); names != nil {
+		t.Errorf("Expected no names, got %v", names)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:8080": true,
+		"localhost:8080": true,
+		"[::1]:8080":     true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.5:8080":  false,
+		"example.com:80": false,
+		"8080":           false,
+	}
+	for addr, expected := range tests {
+		if got := isLoopback(addr); got != expected {
+			t.Errorf("Expected isLoopback(%q) to be %v, got %v", addr, expected, got)
+		}
+	}
 }
@@ -1,3 +1,5 @@
 module github.com/mstgnz/goflow
 
 go 1.24.0
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,226 @@
+// Package clock tells the time to code that waits for it, so that code can
+// be tested with a clock that is moved by hand. The engine passes its clock
+// to tasks through their context, see FromContext
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and starts timers
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d has passed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer started by a Clock
+type Timer interface {
+	// Stop stops the timer, and reports whether it had not fired yet
+	Stop() bool
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// contextKey is the key of the clock in a context
+type contextKey struct{}
+
+// NewContext returns a context that carries a clock
+func NewContext(ctx context.Context, c Clock) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the clock of a context, or Real if it has none
+func FromContext(ctx context.Context) Clock {
+	if c, ok := ctx.Value(contextKey{}).(Clock); ok {
+		return c
+	}
+	return Real
+}
+
+// Sleep waits for a duration on the clock of the context. It returns the
+// context's error as soon as the context is done
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	done := make(chan struct{})
+	timer := FromContext(ctx).AfterFunc(d, func() { close(done) })
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// WithTimeout is like context.WithTimeout, but the timeout runs on the clock
+// of the context. The context's Err is context.DeadlineExceeded once the
+// timeout has passed
+func WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	c := FromContext(ctx)
+	if c == Real {
+		return context.WithTimeout(ctx, d)
+	}
+
+	t := &timeoutContext{Context: ctx, deadline: c.Now().Add(d), done: make(chan struct{})}
+	t.mu.Lock()
+	t.timer = c.AfterFunc(d, func() { t.cancel(context.DeadlineExceeded) })
+	t.stop = context.AfterFunc(ctx, func() { t.cancel(ctx.Err()) })
+	t.mu.Unlock()
+	return t, func() { t.cancel(context.Canceled) }
+}
+
+// timeoutContext is a context whose deadline is on a clock other than Real
+type timeoutContext struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+
+	mu    sync.Mutex
+	err   error
+	timer Timer
+	stop  func() bool // stops watching the parent
+}
+
+func (t *timeoutContext) Deadline() (time.Time, bool) {
+	return t.deadline, true
+}
+
+func (t *timeoutContext) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *timeoutContext) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// cancel ends the context with an error, unless it already ended
+func (t *timeoutContext) cancel(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	t.err = err
+	close(t.done)
+	t.timer.Stop()
+	t.stop()
+}
+
+// Fake is a clock that only moves when it is told to. It is safe for
+// concurrent use
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed *sync.Cond // broadcast when a timer is added
+}
+
+// fakeTimer is a timer of a Fake clock
+type fakeTimer struct {
+	clock *Fake
+	at    time.Time
+	f     func()
+}
+
+// NewFake creates a fake clock set to the given time
+func NewFake(now time.Time) *Fake {
+	c := &Fake{now: now}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the time the clock is set to
+func (c *Fake) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc starts a timer that fires once the clock has been moved by d.
+// A timer that is due fires right away
+func (c *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	if d <= 0 {
+		go f()
+		return t
+	}
+	c.timers = append(c.timers, t)
+	c.changed.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward and fires the timers that are due
+func (c *Fake) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+
+	var due, pending []*fakeTimer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	for _, t := range due {
+		go t.f()
+	}
+}
+
+// Timers returns the number of timers that have neither fired nor been
+// stopped, so a test can tell when the code under test started waiting
+func (c *Fake) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil waits until at least n timers are pending, e.g. until the code
+// under test sleeps, so the test can move the clock past its wake-up
+func (c *Fake) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
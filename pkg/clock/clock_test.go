@@ -0,0 +1,138 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+
+	fired := make(chan string, 3)
+	c.AfterFunc(time.Hour, func() { fired <- "hour" })
+	c.AfterFunc(time.Minute, func() { fired <- "minute" })
+	stopped := c.AfterFunc(time.Minute, func() { fired <- "stopped" })
+
+	if !stopped.Stop() {
+		t.Error("Expected Stop to stop a pending timer")
+	}
+	if c.Timers() != 2 {
+		t.Errorf("Expected 2 timers, got %d", c.Timers())
+	}
+
+	// Only the timers that are due fire
+	c.Advance(30 * time.Minute)
+	if got := <-fired; got != "minute" {
+		t.Errorf("Expected the minute timer to fire, got %s", got)
+	}
+	if !c.Now().Equal(start.Add(30 * time.Minute)) {
+		t.Errorf("Expected the clock to move by 30m, got %s", c.Now())
+	}
+	if c.Timers() != 1 {
+		t.Errorf("Expected 1 timer, got %d", c.Timers())
+	}
+
+	c.Advance(30 * time.Minute)
+	if got := <-fired; got != "hour" {
+		t.Errorf("Expected the hour timer to fire, got %s", got)
+	}
+
+	select {
+	case got := <-fired:
+		t.Errorf("Expected no more timers to fire, got %s", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestFakeDueTimer(t *testing.T) {
+	c := NewFake(time.Now())
+
+	fired := make(chan struct{})
+	timer := c.AfterFunc(0, func() { close(fired) })
+	<-fired
+
+	if timer.Stop() {
+		t.Error("Expected Stop to report that the timer has fired")
+	}
+}
+
+func TestSleep(t *testing.T) {
+	c := NewFake(time.Now())
+	ctx := NewContext(context.Background(), c)
+
+	if FromContext(ctx) != c {
+		t.Error("Expected the clock of the context")
+	}
+	if FromContext(context.Background()) != Real {
+		t.Error("Expected the real clock without a clock in the context")
+	}
+
+	// Sleep returns once the clock has moved past its wake-up
+	done := make(chan error, 1)
+	go func() {
+		done <- Sleep(ctx, time.Hour)
+	}()
+
+	c.BlockUntil(1)
+	c.Advance(time.Hour)
+	if err := <-done; err != nil {
+		t.Errorf("Expected sleep to end, got %v", err)
+	}
+
+	// A cancelled context ends the sleep early
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := Sleep(ctx, time.Hour); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if c.Timers() != 0 {
+		t.Errorf("Expected the timer to be stopped, got %d timers", c.Timers())
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	c := NewFake(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+	ctx := NewContext(context.Background(), c)
+
+	timeoutCtx, cancel := WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	if deadline, ok := timeoutCtx.Deadline(); !ok || !deadline.Equal(c.Now().Add(time.Minute)) {
+		t.Errorf("Expected a deadline a minute from now, got %s", deadline)
+	}
+	if FromContext(timeoutCtx) != c {
+		t.Error("Expected the context to carry the clock")
+	}
+
+	// The timeout only passes when the clock moves
+	c.Advance(59 * time.Second)
+	select {
+	case <-timeoutCtx.Done():
+		t.Fatal("Expected the context not to be done before its timeout")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	c.Advance(time.Second)
+	<-timeoutCtx.Done()
+	if timeoutCtx.Err() != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", timeoutCtx.Err())
+	}
+
+	// Cancelling the parent or the context itself ends it too
+	parent, cancelParent := context.WithCancel(ctx)
+	child, cancelChild := WithTimeout(parent, time.Minute)
+	defer cancelChild()
+	cancelParent()
+	<-child.Done()
+	if child.Err() != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", child.Err())
+	}
+
+	child, cancelChild = WithTimeout(ctx, time.Minute)
+	cancelChild()
+	if child.Err() != context.Canceled || c.Timers() != 0 {
+		t.Errorf("Expected a cancelled context without timers, got %v and %d timers", child.Err(), c.Timers())
+	}
+}
@@ -0,0 +1,234 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// checker infers static types and reports misuse that is visible before evaluation
+type checker struct {
+	scope Scope
+}
+
+func (c *checker) errorf(n node, format string, args ...any) error {
+	return &Error{Pos: n.position(), Msg: fmt.Sprintf(format, args...)}
+}
+
+// check returns the static type of a node
+func (c *checker) check(n node) (*Schema, error) {
+	switch n := n.(type) {
+	case *literalNode:
+		return Of(typeOf(n.value)), nil
+
+	case *identNode:
+		if c.scope == nil {
+			return AnySchema, nil
+		}
+		s, ok := c.scope[n.name]
+		if !ok {
+			return nil, c.errorf(n, "unknown variable %s", n.name)
+		}
+		return s, nil
+
+	case *memberNode:
+		object, err := c.check(n.object)
+		if err != nil {
+			return nil, err
+		}
+		field, ok := object.field(n.name)
+		if !ok {
+			if object.Type == Map {
+				return nil, c.errorf(n, "unknown field %s", n.name)
+			}
+			return nil, c.errorf(n, "cannot access field %s of %s", n.name, object.Type)
+		}
+		return field, nil
+
+	case *indexNode:
+		return c.checkIndex(n)
+
+	case *callNode:
+		return c.checkCall(n)
+
+	case *listNode:
+		var elem *Schema
+		for _, item := range n.items {
+			s, err := c.check(item)
+			if err != nil {
+				return nil, err
+			}
+			if elem == nil || elem.Type == s.Type {
+				elem = s
+			} else {
+				elem = AnySchema
+			}
+		}
+		if elem == nil {
+			elem = AnySchema
+		}
+		return &Schema{Type: List, Elem: elem}, nil
+
+	case *unaryNode:
+		operand, err := c.check(n.operand)
+		if err != nil {
+			return nil, err
+		}
+		want := Bool
+		if n.op == "-" {
+			want = Number
+		}
+		if !compatible(operand.Type, want) {
+			return nil, c.errorf(n, "operator %s needs a %s operand, got %s", n.op, want, operand.Type)
+		}
+		return Of(want), nil
+
+	case *binaryNode:
+		return c.checkBinary(n)
+	}
+
+	return nil, c.errorf(n, "unsupported expression")
+}
+
+func (c *checker) checkIndex(n *indexNode) (*Schema, error) {
+	object, err := c.check(n.object)
+	if err != nil {
+		return nil, err
+	}
+	index, err := c.check(n.index)
+	if err != nil {
+		return nil, err
+	}
+
+	// A constant string index is checked like a field
+	if lit, ok := n.index.(*literalNode); ok {
+		if name, ok := lit.value.(string); ok && object.Type == Map {
+			field, ok := object.field(name)
+			if !ok {
+				return nil, c.errorf(n, "unknown field %s", name)
+			}
+			return field, nil
+		}
+	}
+
+	switch object.Type {
+	case Any:
+		return AnySchema, nil
+	case List:
+		if !compatible(index.Type, Number) {
+			return nil, c.errorf(n, "list index must be a number, got %s", index.Type)
+		}
+		if object.Elem != nil {
+			return object.Elem, nil
+		}
+		return AnySchema, nil
+	case Map:
+		if !compatible(index.Type, String) {
+			return nil, c.errorf(n, "map key must be a string, got %s", index.Type)
+		}
+		return AnySchema, nil
+	default:
+		return nil, c.errorf(n, "cannot index %s", object.Type)
+	}
+}
+
+func (c *checker) checkCall(n *callNode) (*Schema, error) {
+	fn, ok := functions[n.name]
+	if !ok {
+		return nil, c.errorf(n, "unknown function %s", n.name)
+	}
+	if len(n.args) != len(fn.args) {
+		return nil, c.errorf(n, "%s expects %d arguments, got %d", n.name, len(fn.args), len(n.args))
+	}
+
+	for i, arg := range n.args {
+		s, err := c.check(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !compatible(s.Type, fn.args[i]) {
+			return nil, c.errorf(arg, "argument %d of %s must be a %s, got %s", i+1, n.name, fn.args[i], s.Type)
+		}
+	}
+
+	// Regular expressions are compiled once, so they must be constant
+	if n.name == "matches" {
+		lit, ok := n.args[1].(*literalNode)
+		var pattern string
+		if ok {
+			pattern, ok = lit.value.(string)
+		}
+		if !ok {
+			return nil, c.errorf(n.args[1], "the pattern of matches must be a constant string")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, c.errorf(lit, "invalid regular expression: %v", err)
+		}
+		n.re = re
+	}
+
+	return Of(fn.result), nil
+}
+
+func (c *checker) checkBinary(n *binaryNode) (*Schema, error) {
+	left, err := c.check(n.left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.check(n.right)
+	if err != nil {
+		return nil, err
+	}
+	l, r := left.Type, right.Type
+
+	switch n.op {
+	case "&&", "||":
+		if !compatible(l, Bool) || !compatible(r, Bool) {
+			return nil, c.errorf(n, "operator %s needs bool operands, got %s and %s", n.op, l, r)
+		}
+		return Of(Bool), nil
+
+	case "==", "!=":
+		return Of(Bool), nil
+
+	case "<", "<=", ">", ">=":
+		// Numbers and numeric strings can be compared with each other
+		if !orderable(l) || !orderable(r) {
+			return nil, c.errorf(n, "cannot compare %s and %s with %s", l, r, n.op)
+		}
+		return Of(Bool), nil
+
+	case "in", "not in":
+		if r != Any && r != List && r != Map && r != String {
+			return nil, c.errorf(n, "right side of %s must be a list, map or string, got %s", n.op, r)
+		}
+		return Of(Bool), nil
+
+	case "+":
+		switch {
+		case l == Any && r == Any:
+			return AnySchema, nil
+		case compatible(l, Number) && compatible(r, Number):
+			return Of(Number), nil
+		case compatible(l, String) && compatible(r, String):
+			return Of(String), nil
+		}
+		return nil, c.errorf(n, "cannot add %s and %s", l, r)
+
+	default:
+		if !compatible(l, Number) || !compatible(r, Number) {
+			return nil, c.errorf(n, "operator %s needs number operands, got %s and %s", n.op, l, r)
+		}
+		return Of(Number), nil
+	}
+}
+
+// compatible reports whether a value of type have can be used where want is expected
+func compatible(have, want Type) bool {
+	return have == Any || want == Any || have == want
+}
+
+// orderable reports whether a type can be used with < <= > >=
+func orderable(t Type) bool {
+	return t == Any || t == Number || t == String
+}
@@ -0,0 +1,404 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+func evalErrorf(n node, format string, args ...any) error {
+	return &Error{Pos: n.position(), Msg: fmt.Sprintf(format, args...)}
+}
+
+// eval evaluates a node. Missing variables, fields and list elements evaluate to nil
+func eval(n node, vars map[string]any) (any, error) {
+	switch n := n.(type) {
+	case *literalNode:
+		return n.value, nil
+
+	case *identNode:
+		return vars[n.name], nil
+
+	case *memberNode:
+		object, err := eval(n.object, vars)
+		if err != nil {
+			return nil, err
+		}
+		return field(object, n.name), nil
+
+	case *indexNode:
+		object, err := eval(n.object, vars)
+		if err != nil {
+			return nil, err
+		}
+		index, err := eval(n.index, vars)
+		if err != nil {
+			return nil, err
+		}
+		return lookup(n, object, index)
+
+	case *callNode:
+		args := make([]any, len(n.args))
+		for i, arg := range n.args {
+			value, err := eval(arg, vars)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = value
+		}
+		// The pattern of matches is passed compiled
+		if n.re != nil {
+			args[1] = n.re
+		}
+		result, err := functions[n.name].call(args)
+		if err != nil {
+			return nil, evalErrorf(n, "%s: %v", n.name, err)
+		}
+		return result, nil
+
+	case *listNode:
+		items := make([]any, len(n.items))
+		for i, item := range n.items {
+			value, err := eval(item, vars)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = value
+		}
+		return items, nil
+
+	case *unaryNode:
+		operand, err := eval(n.operand, vars)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			b, err := toBool(n, operand)
+			return !b, err
+		}
+		f, ok := toNumber(operand)
+		if !ok {
+			return nil, evalErrorf(n, "operator - needs a number, got %s", typeOf(operand))
+		}
+		return -f, nil
+
+	case *binaryNode:
+		return evalBinary(n, vars)
+	}
+
+	return nil, evalErrorf(n, "unsupported expression")
+}
+
+func evalBinary(n *binaryNode, vars map[string]any) (any, error) {
+	left, err := eval(n.left, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// Boolean operators short-circuit
+	if n.op == "&&" || n.op == "||" {
+		l, err := toBool(n, left)
+		if err != nil {
+			return nil, err
+		}
+		if (n.op == "&&" && !l) || (n.op == "||" && l) {
+			return l, nil
+		}
+		right, err := eval(n.right, vars)
+		if err != nil {
+			return nil, err
+		}
+		return toBool(n, right)
+	}
+
+	right, err := eval(n.right, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+
+	case "<", "<=", ">", ">=":
+		cmp, err := compare(n, left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+
+	case "in", "not in":
+		found, err := contains(right, left)
+		if err != nil {
+			return nil, evalErrorf(n, "%v", err)
+		}
+		return found == (n.op == "in"), nil
+
+	case "+":
+		if ls, ok := left.(string); ok {
+			if rs, ok := right.(string); ok {
+				return ls + rs, nil
+			}
+		}
+	}
+
+	// The remaining operators are arithmetic
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+	if !lok || !rok {
+		return nil, evalErrorf(n, "operator %s needs number operands, got %s and %s", n.op, typeOf(left), typeOf(right))
+	}
+
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, evalErrorf(n, "division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, evalErrorf(n, "division by zero")
+		}
+		return math.Mod(l, r), nil
+	}
+
+	return nil, evalErrorf(n, "unsupported operator %s", n.op)
+}
+
+// toBool converts an operand of a boolean operator. Null counts as false
+func toBool(n node, value any) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case nil:
+		return false, nil
+	}
+	return false, evalErrorf(n, "expected bool, got %s", typeOf(value))
+}
+
+// typeOf returns the expression type of a Go value
+func typeOf(value any) Type {
+	if value == nil {
+		return Null
+	}
+	if _, ok := toNumber(value); ok {
+		return Number
+	}
+
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Bool:
+		return Bool
+	case reflect.String:
+		return String
+	case reflect.Slice, reflect.Array:
+		return List
+	case reflect.Map:
+		return Map
+	}
+	return Any
+}
+
+// toNumber converts any Go number to float64
+func toNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case interface{ Float64() (float64, error) }:
+		// json.Number
+		f, err := v.Float64()
+		return f, err == nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// numericPair converts two values to numbers when one is a number and the
+// other a number or a numeric string, e.g. an amount passed as "100.00"
+func numericPair(a, b any) (float64, float64, bool) {
+	x, xok := toNumber(a)
+	y, yok := toNumber(b)
+
+	if xok && !yok {
+		if s, ok := b.(string); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			y, yok = f, err == nil
+		}
+	} else if yok && !xok {
+		if s, ok := a.(string); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			x, xok = f, err == nil
+		}
+	}
+
+	return x, y, xok && yok
+}
+
+// equal compares two values, treating all numbers alike
+func equal(a, b any) bool {
+	if x, y, ok := numericPair(a, b); ok {
+		return x == y
+	}
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// normalize converts numbers to float64 and containers to []any and map[string]any
+func normalize(value any) any {
+	if f, ok := toNumber(value); ok {
+		return f
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]any, rv.Len())
+		for i := range items {
+			items[i] = normalize(rv.Index(i).Interface())
+		}
+		return items
+	case reflect.Map:
+		m := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = normalize(iter.Value().Interface())
+		}
+		return m
+	}
+	return value
+}
+
+// compare orders two numbers or two strings
+func compare(n node, a, b any) (int, error) {
+	if x, y, ok := numericPair(a, b); ok {
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	}
+
+	return 0, evalErrorf(n, "cannot compare %s and %s", typeOf(a), typeOf(b))
+}
+
+// field returns a field of a map or struct-like value, or nil
+func field(object any, name string) any {
+	if m, ok := object.(map[string]any); ok {
+		return m[name]
+	}
+
+	rv := reflect.ValueOf(object)
+	if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+		v := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()))
+		if v.IsValid() {
+			return v.Interface()
+		}
+	}
+	return nil
+}
+
+// lookup indexes a list with a number or a map with a string
+func lookup(n node, object, index any) (any, error) {
+	if object == nil {
+		return nil, nil
+	}
+
+	if key, ok := index.(string); ok {
+		return field(object, key), nil
+	}
+
+	i, ok := toNumber(index)
+	if !ok {
+		return nil, evalErrorf(n, "invalid index of type %s", typeOf(index))
+	}
+
+	rv := reflect.ValueOf(object)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, evalErrorf(n, "cannot index %s with a number", typeOf(object))
+	}
+	if i != math.Trunc(i) || i < 0 || i >= float64(rv.Len()) {
+		return nil, nil
+	}
+	return rv.Index(int(i)).Interface(), nil
+}
+
+// contains reports whether a list holds a value, a map has a key or a string has a substring
+func contains(container, value any) (bool, error) {
+	if container == nil {
+		return false, nil
+	}
+
+	if s, ok := container.(string); ok {
+		sub, ok := value.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot look for %s in a string", typeOf(value))
+		}
+		return strings.Contains(s, sub), nil
+	}
+
+	rv := reflect.ValueOf(container)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if equal(rv.Index(i).Interface(), value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		key, ok := value.(string)
+		if !ok {
+			return false, nil
+		}
+		return hasKey(rv, key), nil
+	}
+
+	return false, fmt.Errorf("cannot look for a value in %s", typeOf(container))
+}
+
+// hasKey reports whether a map has a key, even when its value is nil
+func hasKey(rv reflect.Value, key string) bool {
+	if rv.Type().Key().Kind() != reflect.String {
+		return false
+	}
+	return rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).IsValid()
+}
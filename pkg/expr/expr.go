@@ -0,0 +1,121 @@
+// Package expr implements the expression language used by workflow conditions.
+//
+// Expressions support literals (numbers, strings, true, false, null and
+// lists), variables with nested field and index access (payment.amount,
+// items[0], data["key"]), comparisons, the boolean operators && || ! (or
+// and, or, not), arithmetic, in / not in, and a small set of functions such
+// as lower, contains and matches. Expressions are compiled once and
+// type-checked against a Scope, then evaluated many times
+package expr
+
+import (
+	"fmt"
+)
+
+// Error is a syntax, type or evaluation error in an expression
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos+1, e.Msg)
+}
+
+// Program is a compiled expression
+type Program struct {
+	source string
+	root   node
+	typ    *Schema
+}
+
+// Compile parses an expression and type-checks it against a scope. With a
+// nil scope any variable is accepted and only the syntax and literal types
+// are checked
+func Compile(source string, scope Scope) (*Program, error) {
+	root, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &checker{scope: scope}
+	typ, err := c.check(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Program{source: source, root: root, typ: typ}, nil
+}
+
+// MustCompile is like Compile but panics on error
+func MustCompile(source string, scope Scope) *Program {
+	p, err := Compile(source, scope)
+	if err != nil {
+		panic(fmt.Sprintf("expr: compile %q: %v", source, err))
+	}
+	return p
+}
+
+// Source returns the source of the expression
+func (p *Program) Source() string {
+	return p.source
+}
+
+// Type returns the static type of the expression's result
+func (p *Program) Type() Type {
+	return p.typ.Type
+}
+
+// Vars returns the names of the variables the expression reads, in the
+// order they first appear
+func (p *Program) Vars() []string {
+	var names []string
+	seen := make(map[string]bool)
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *identNode:
+			if !seen[n.name] {
+				seen[n.name] = true
+				names = append(names, n.name)
+			}
+		case *memberNode:
+			walk(n.object)
+		case *indexNode:
+			walk(n.object)
+			walk(n.index)
+		case *callNode:
+			for _, arg := range n.args {
+				walk(arg)
+			}
+		case *listNode:
+			for _, item := range n.items {
+				walk(item)
+			}
+		case *unaryNode:
+			walk(n.operand)
+		case *binaryNode:
+			walk(n.left)
+			walk(n.right)
+		}
+	}
+	walk(p.root)
+	return names
+}
+
+// Eval evaluates the expression with the given variables
+func (p *Program) Eval(vars map[string]any) (any, error) {
+	return eval(p.root, vars)
+}
+
+// EvalBool evaluates the expression as a condition. Only a true boolean
+// result is true; null, missing values and non-boolean results are false
+func (p *Program) EvalBool(vars map[string]any) (bool, error) {
+	value, err := p.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := value.(bool)
+	return ok && b, nil
+}
@@ -0,0 +1,374 @@
+package expr
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func testVars() map[string]any {
+	return map[string]any{
+		"payment": map[string]any{
+			"amount":   "150.00",
+			"currency": "EUR",
+			"success":  true,
+		},
+		"order": map[string]any{
+			"items": []any{
+				map[string]any{"sku": "A-1", "qty": 2},
+				map[string]any{"sku": "B-2", "qty": 1},
+			},
+			"tags":  []string{"gift", "express"},
+			"total": 42,
+		},
+		"inputs": map[string]string{
+			"country": "DE",
+		},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected any
+	}{
+		// Literals and arithmetic
+		{`1 + 2 * 3`, float64(7)},
+		{`(1 + 2) * 3`, float64(9)},
+		{`10 % 4`, float64(2)},
+		{`-order.total`, float64(-42)},
+		{`"a" + "b"`, "ab"},
+		{`null`, nil},
+
+		// Nested access
+		{`payment.currency`, "EUR"},
+		{`order.items[0].sku`, "A-1"},
+		{`order.items.1.qty`, 1},
+		{`order["total"]`, 42},
+		{`order.missing`, nil},
+		{`order.items[5]`, nil},
+		{`missing.field`, nil},
+
+		// Comparisons, including numeric strings
+		{`payment.amount > 100`, true},
+		{`payment.amount == 150`, true},
+		{`order.total >= 42`, true},
+		{`"abc" < "abd"`, true},
+		{`payment.currency != "USD"`, true},
+
+		// Boolean operators
+		{`payment.success && order.total > 10`, true},
+		{`payment.success and not (order.total > 10)`, false},
+		{`!payment.success || true`, true},
+		{`missing.flag || false`, false},
+
+		// in and not in
+		{`inputs.country in ["DE", "AT", "CH"]`, true},
+		{`"gift" in order.tags`, true},
+		{`"cash" not in order.tags`, true},
+		{`"total" in order`, true},
+		{`"UR" in payment.currency`, true},
+
+		// Functions
+		{`lower(payment.currency)`, "eur"},
+		{`upper("de")`, "DE"},
+		{`trim("  x ")`, "x"},
+		{`len(order.items)`, float64(2)},
+		{`len("héllo")`, float64(5)},
+		{`contains(order.tags, "express")`, true},
+		{`startsWith(order.items[1].sku, "B-")`, true},
+		{`endsWith("file.csv", ".csv")`, true},
+		{`matches(payment.currency, "^[A-Z]{3}$")`, true},
+		{`number(payment.amount) * 2`, float64(300)},
+		{`string(order.total)`, "42"},
+		{`default(order.missing, "none")`, "none"},
+	}
+
+	for _, tt := range tests {
+		p, err := Compile(tt.source, nil)
+		if err != nil {
+			t.Errorf("%s: failed to compile: %v", tt.source, err)
+			continue
+		}
+
+		result, err := p.Eval(testVars())
+		if err != nil {
+			t.Errorf("%s: failed to evaluate: %v", tt.source, err)
+			continue
+		}
+
+		if !equal(result, tt.expected) {
+			t.Errorf("%s: expected %v (%T), got %v (%T)", tt.source, tt.expected, tt.expected, result, result)
+		}
+	}
+}
+
+func TestEvalBool(t *testing.T) {
+	tests := map[string]bool{
+		`payment.success`:     true,
+		`payment.missing`:     false,
+		`payment.currency`:    false,
+		`order.total > 100`:   false,
+		`missing.field.value`: false,
+	}
+
+	for source, expected := range tests {
+		result, err := MustCompile(source, nil).EvalBool(testVars())
+		if err != nil {
+			t.Errorf("%s: failed to evaluate: %v", source, err)
+			continue
+		}
+
+		if result != expected {
+			t.Errorf("%s: expected %v, got %v", source, expected, result)
+		}
+	}
+}
+
+func TestEvalIndexOutOfRange(t *testing.T) {
+	vars := map[string]any{
+		"items": []any{"a", "b"},
+		"n": map[string]any{
+			"huge":     1e19,
+			"inf":      math.Inf(1),
+			"minusInf": math.Inf(-1),
+			"nan":      math.NaN(),
+		},
+	}
+
+	sources := []string{
+		`items[10000000000000000000]`,
+		`items[9223372036854775808]`,
+		`items[n.huge]`,
+		`items[n.inf]`,
+		`items[n.minusInf]`,
+		`items[n.nan]`,
+		`items[-1]`,
+		`items[-10000000000000000000]`,
+		`items[2]`,
+	}
+
+	for _, source := range sources {
+		result, err := MustCompile(source, nil).Eval(vars)
+		if err != nil {
+			t.Errorf("%s: failed to evaluate: %v", source, err)
+			continue
+		}
+		if result != nil {
+			t.Errorf("%s: expected null, got %v", source, result)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := map[string]string{
+		`payment.missing > 100`:     "cannot compare null and number",
+		`payment.currency > 100`:    "cannot compare string and number",
+		`payment.currency && true`:  "expected bool, got string",
+		`order.total / 0`:           "division by zero",
+		`order.tags - 1`:            "needs number operands",
+		`matches(order.total, "a")`: "matches: expected a string, got number",
+	}
+
+	for source, expected := range tests {
+		_, err := MustCompile(source, nil).Eval(testVars())
+		if err == nil {
+			t.Errorf("%s: expected error", source)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q, got %q", source, expected, err.Error())
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := map[string]string{
+		``:                            "empty expression",
+		`payment.`:                    "expected field name",
+		`(1 + 2`:                      `expected ")"`,
+		`1 +`:                         "unexpected end of expression",
+		`"open`:                       "unterminated string",
+		`a # b`:                       "unexpected character",
+		`a b`:                         `unexpected "b"`,
+		`order.items[0] == {}`:        "unexpected character",
+		`nope(1)`:                     "unknown function nope",
+		`len(1, 2)`:                   "len expects 1 arguments, got 2",
+		`lower(1)`:                    "argument 1 of lower must be a string, got number",
+		`matches("a", "(")`:           "invalid regular expression",
+		`matches("a", order.tags[0])`: "the pattern of matches must be a constant string",
+		`"a" - 1`:                     "needs number operands, got string and number",
+		`"a" + 1`:                     "cannot add string and number",
+		`true > 1`:                    "cannot compare bool and number",
+		`!"yes"`:                      "operator ! needs a bool operand",
+		`1 && true`:                   "needs bool operands",
+		`"a" in 1`:                    "must be a list, map or string",
+		`"abc".length`:                "cannot access field length of string",
+		`[1, 2]["x"]`:                 "list index must be a number",
+	}
+
+	for source, expected := range tests {
+		_, err := Compile(source, nil)
+		if err == nil {
+			t.Errorf("%q: expected compile error", source)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected error containing %q, got %q", source, expected, err.Error())
+		}
+	}
+}
+
+func TestCompileNesting(t *testing.T) {
+	nested := func(open, close string, n int) string {
+		return strings.Repeat(open, n) + "1" + strings.Repeat(close, n)
+	}
+
+	// Nesting up to the limit is fine, beyond it is an error rather than a stack overflow
+	if _, err := Compile(nested("(", ")", maxNesting-1), nil); err != nil {
+		t.Errorf("Expected nesting below the limit to compile, got %v", err)
+	}
+	for _, source := range []string{
+		nested("(", ")", maxNesting),
+		nested("[", "]", maxNesting),
+		nested("len(", ")", maxNesting),
+		strings.Repeat("!", 100_000) + "true",
+		nested("(", ")", 100_000),
+	} {
+		if _, err := Compile(source, nil); err == nil || !strings.Contains(err.Error(), "nested more than") {
+			t.Errorf("Expected nesting error for %.20s..., got %v", source, err)
+		}
+	}
+}
+
+func TestCompileWithScope(t *testing.T) {
+	scope := Scope{
+		"steps": {
+			Type: Map,
+			Fields: map[string]*Schema{
+				"payment": {
+					Type: Map,
+					Fields: map[string]*Schema{
+						"success": Of(Bool),
+						"error":   Of(String),
+						"data":    OpenMap(),
+					},
+				},
+			},
+		},
+		"inputs": OpenMap(),
+	}
+
+	valid := []string{
+		`steps.payment.success`,
+		`steps.payment.data.amount > 100`,
+		`steps["payment"].error == ""`,
+		`inputs.order_id != null`,
+	}
+
+	for _, source := range valid {
+		if _, err := Compile(source, scope); err != nil {
+			t.Errorf("%s: unexpected error: %v", source, err)
+		}
+	}
+
+	invalid := map[string]string{
+		`payment.success`:             "unknown variable payment",
+		`steps.refund.success`:        "unknown field refund",
+		`steps.payment.sucess`:        "unknown field sucess",
+		`steps["refund"]`:             "unknown field refund",
+		`steps.payment.success > 1`:   "cannot compare bool and number",
+		`steps.payment.error && true`: "needs bool operands, got string and bool",
+	}
+
+	for source, expected := range invalid {
+		_, err := Compile(source, scope)
+		if err == nil {
+			t.Errorf("%s: expected compile error", source)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q, got %q", source, expected, err.Error())
+		}
+	}
+}
+
+func TestProgramType(t *testing.T) {
+	tests := map[string]Type{
+		`1 + 2`:         Number,
+		`"a" + "b"`:     String,
+		`a == b`:        Bool,
+		`lower(a)`:      String,
+		`a.b`:           Any,
+		`[1, 2]`:        List,
+		`default(a, 1)`: Any,
+		`a in ["x"]`:    Bool,
+	}
+
+	for source, expected := range tests {
+		if typ := MustCompile(source, nil).Type(); typ != expected {
+			t.Errorf("%s: expected type %s, got %s", source, expected, typ)
+		}
+	}
+}
+
+func TestProgramVars(t *testing.T) {
+	tests := map[string][]string{
+		`1 + 2`:                         nil,
+		`env.TOKEN`:                     {"env"},
+		`default(env.REGION, "eu")`:     {"env"},
+		`steps.a.data[inputs.key]`:      {"steps", "inputs"},
+		`!(a && b) || [a, c] == d["x"]`: {"a", "b", "c", "d"},
+	}
+
+	for source, expected := range tests {
+		if vars := MustCompile(source, nil).Vars(); !slices.Equal(vars, expected) {
+			t.Errorf("%s: expected vars %v, got %v", source, expected, vars)
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	_, err := Compile(`payment.amount > `, nil)
+	if err == nil {
+		t.Fatal("Expected compile error")
+	}
+
+	exprErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Expected *Error, got %T", err)
+	}
+
+	if exprErr.Pos != 17 {
+		t.Errorf("Expected error at position 17, got %d", exprErr.Pos)
+	}
+
+	if !strings.HasPrefix(err.Error(), "column 18:") {
+		t.Errorf("Expected error to start with the column, got %q", err.Error())
+	}
+}
+
+func TestToString(t *testing.T) {
+	tests := []struct {
+		value    any
+		expected string
+	}{
+		{nil, ""},
+		{"text", "text"},
+		{true, "true"},
+		{100, "100"},
+		{2.5, "2.5"},
+		{[]any{"a", 1}, `["a",1]`},
+		{map[string]any{"k": "v"}, `{"k":"v"}`},
+	}
+
+	for _, tt := range tests {
+		if result := ToString(tt.value); result != tt.expected {
+			t.Errorf("Expected %q for %v, got %q", tt.expected, tt.value, result)
+		}
+	}
+}
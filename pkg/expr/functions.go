@@ -0,0 +1,166 @@
+package expr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// function is a built-in function
+type function struct {
+	args   []Type
+	result Type
+	call   func(args []any) (any, error)
+}
+
+// functions are the built-in functions available to every expression
+var functions = map[string]function{
+	"len": {
+		args:   []Type{Any},
+		result: Number,
+		call: func(args []any) (any, error) {
+			if s, ok := args[0].(string); ok {
+				return float64(len([]rune(s))), nil
+			}
+			if args[0] == nil {
+				return float64(0), nil
+			}
+			rv := reflect.ValueOf(args[0])
+			switch rv.Kind() {
+			case reflect.Slice, reflect.Array, reflect.Map:
+				return float64(rv.Len()), nil
+			}
+			return nil, fmt.Errorf("cannot take the length of %s", typeOf(args[0]))
+		},
+	},
+	"lower": stringFunction(strings.ToLower),
+	"upper": stringFunction(strings.ToUpper),
+	"trim":  stringFunction(strings.TrimSpace),
+	"contains": {
+		args:   []Type{Any, Any},
+		result: Bool,
+		call: func(args []any) (any, error) {
+			return contains(args[0], args[1])
+		},
+	},
+	"startsWith": stringPredicate(strings.HasPrefix),
+	"endsWith":   stringPredicate(strings.HasSuffix),
+	"matches": {
+		args:   []Type{String, String},
+		result: Bool,
+		call: func(args []any) (any, error) {
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got %s", typeOf(args[0]))
+			}
+			re, ok := args[1].(*regexp.Regexp)
+			if !ok {
+				return nil, errors.New("expected a compiled pattern")
+			}
+			return re.MatchString(s), nil
+		},
+	},
+	"string": {
+		args:   []Type{Any},
+		result: String,
+		call: func(args []any) (any, error) {
+			return ToString(args[0]), nil
+		},
+	},
+	"number": {
+		args:   []Type{Any},
+		result: Number,
+		call: func(args []any) (any, error) {
+			if f, ok := toNumber(args[0]); ok {
+				return f, nil
+			}
+			if s, ok := args[0].(string); ok {
+				return strconv.ParseFloat(strings.TrimSpace(s), 64)
+			}
+			return nil, fmt.Errorf("cannot convert %s to a number", typeOf(args[0]))
+		},
+	},
+	"default": {
+		args:   []Type{Any, Any},
+		result: Any,
+		call: func(args []any) (any, error) {
+			if args[0] == nil {
+				return args[1], nil
+			}
+			return args[0], nil
+		},
+	},
+}
+
+// stringFunction wraps a string transformation as a function
+func stringFunction(fn func(string) string) function {
+	return function{
+		args:   []Type{String},
+		result: String,
+		call: func(args []any) (any, error) {
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a string, got %s", typeOf(args[0]))
+			}
+			return fn(s), nil
+		},
+	}
+}
+
+// stringPredicate wraps a test on two strings as a function
+func stringPredicate(fn func(string, string) bool) function {
+	return function{
+		args:   []Type{String, String},
+		result: Bool,
+		call: func(args []any) (any, error) {
+			a, b, err := twoStrings(args)
+			if err != nil {
+				return nil, err
+			}
+			return fn(a, b), nil
+		},
+	}
+}
+
+func twoStrings(args []any) (string, string, error) {
+	a, ok := args[0].(string)
+	if !ok {
+		return "", "", fmt.Errorf("expected a string, got %s", typeOf(args[0]))
+	}
+	b, ok := args[1].(string)
+	if !ok {
+		return "", "", fmt.Errorf("expected a string, got %s", typeOf(args[1]))
+	}
+	return a, b, nil
+}
+
+// ToNumber converts any Go number to float64
+func ToNumber(value any) (float64, bool) {
+	return toNumber(value)
+}
+
+// ToString formats a value as text. Strings are returned as is, whole numbers
+// without a fraction, and lists and maps as JSON
+func ToString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	}
+
+	if f, ok := toNumber(value); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	if data, err := json.Marshal(value); err == nil {
+		return string(data)
+	}
+	return fmt.Sprint(value)
+}
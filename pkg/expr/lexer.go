@@ -0,0 +1,145 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokenKind is the kind of a lexical token
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+// token is a lexical token of an expression
+type token struct {
+	kind  tokenKind
+	text  string
+	value any
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// operators lists the operator tokens, longest first so that "<=" wins over "<"
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ",",
+}
+
+// keywordOperators are words that behave like operators
+var keywordOperators = map[string]string{
+	"and": "&&",
+	"or":  "||",
+	"not": "!",
+	"in":  "in",
+}
+
+// lex splits an expression into tokens
+func lex(source string) ([]token, error) {
+	var tokens []token
+	i := 0
+
+	for i < len(source) {
+		c := rune(source[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i]))) {
+				i++
+			}
+			word := source[start:i]
+			if op, ok := keywordOperators[word]; ok {
+				tokens = append(tokens, token{kind: tokenOperator, text: op, pos: start})
+			} else {
+				tokens = append(tokens, token{kind: tokenIdent, text: word, pos: start})
+			}
+
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(source) && (unicode.IsDigit(rune(source[i])) || source[i] == '.') {
+				// A dot not followed by a digit is member access, e.g. items.0 is not a number
+				if source[i] == '.' && (i+1 >= len(source) || !unicode.IsDigit(rune(source[i+1]))) {
+					break
+				}
+				i++
+			}
+			value, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, &Error{Pos: start, Msg: fmt.Sprintf("invalid number %q", source[start:i])}
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:i], value: value, pos: start})
+
+		case c == '"' || c == '\'':
+			start := i
+			value, n, err := lexString(source[i:])
+			if err != nil {
+				return nil, &Error{Pos: start, Msg: err.Error()}
+			}
+			i += n
+			tokens = append(tokens, token{kind: tokenString, text: source[start:i], value: value, pos: start})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &Error{Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+// lexString reads a quoted string and returns its value and the number of bytes consumed
+func lexString(source string) (string, int, error) {
+	quote := source[0]
+	var b strings.Builder
+
+	for i := 1; i < len(source); i++ {
+		switch source[i] {
+		case quote:
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i >= len(source) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			switch source[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(source[i])
+			}
+		default:
+			b.WriteByte(source[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
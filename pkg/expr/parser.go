@@ -0,0 +1,342 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// node is a node of the expression syntax tree
+type node interface {
+	position() int
+}
+
+// literalNode is a constant value
+type literalNode struct {
+	pos   int
+	value any
+}
+
+// identNode is a variable reference
+type identNode struct {
+	pos  int
+	name string
+}
+
+// memberNode is a field access with a dot, e.g. payment.amount
+type memberNode struct {
+	pos    int
+	object node
+	name   string
+}
+
+// indexNode is an access with brackets, e.g. items[0] or data["key"]
+type indexNode struct {
+	pos    int
+	object node
+	index  node
+}
+
+// callNode is a function call
+type callNode struct {
+	pos  int
+	name string
+	args []node
+	re   *regexp.Regexp // pattern of a matches call, compiled when it is checked
+}
+
+// listNode is a list literal, e.g. ["a", "b"]
+type listNode struct {
+	pos   int
+	items []node
+}
+
+// unaryNode is a prefix operator
+type unaryNode struct {
+	pos     int
+	op      string
+	operand node
+}
+
+// binaryNode is an infix operator
+type binaryNode struct {
+	pos   int
+	op    string
+	left  node
+	right node
+}
+
+func (n *literalNode) position() int { return n.pos }
+func (n *identNode) position() int   { return n.pos }
+func (n *memberNode) position() int  { return n.pos }
+func (n *indexNode) position() int   { return n.pos }
+func (n *callNode) position() int    { return n.pos }
+func (n *listNode) position() int    { return n.pos }
+func (n *unaryNode) position() int   { return n.pos }
+func (n *binaryNode) position() int  { return n.pos }
+
+// precedence of the binary operators, higher binds tighter
+var precedence = map[string]int{
+	"||":     1,
+	"&&":     2,
+	"==":     3,
+	"!=":     3,
+	"<":      4,
+	"<=":     4,
+	">":      4,
+	">=":     4,
+	"in":     4,
+	"not in": 4,
+	"+":      5,
+	"-":      5,
+	"*":      6,
+	"/":      6,
+	"%":      6,
+}
+
+// maxNesting is how deeply operands can be nested in parentheses, lists,
+// calls and prefix operators. Deeper expressions are rejected rather than
+// exhaust the stack of the parser, the checker or the evaluator
+const maxNesting = 100
+
+// parser is a precedence climbing parser over a token list
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int // operands being parsed
+}
+
+// parse parses an expression into a syntax tree
+func parse(source string) (node, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, &Error{Pos: 0, Msg: "empty expression"}
+	}
+
+	n, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %s", tok)}
+	}
+
+	return n, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// expect consumes the given operator or fails
+func (p *parser) expect(op string) error {
+	tok := p.next()
+	if tok.kind != tokenOperator || tok.text != op {
+		return &Error{Pos: tok.pos, Msg: fmt.Sprintf("expected %q, got %s", op, tok)}
+	}
+	return nil
+}
+
+// binaryOp returns the binary operator at the current position, if any
+func (p *parser) binaryOp() (string, int) {
+	tok := p.peek()
+	if tok.kind != tokenOperator {
+		return "", 0
+	}
+
+	// "not in" is the only two-token operator
+	if tok.text == "!" {
+		if next := p.tokens[p.pos+1]; next.kind == tokenOperator && next.text == "in" {
+			return "not in", 2
+		}
+		return "", 0
+	}
+
+	if _, ok := precedence[tok.text]; ok {
+		return tok.text, 1
+	}
+	return "", 0
+}
+
+// parseBinary parses operators with at least the given precedence
+func (p *parser) parseBinary(minPrec int) (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, n := p.binaryOp()
+		if op == "" || precedence[op] < minPrec {
+			return left, nil
+		}
+
+		pos := p.peek().pos
+		p.pos += n
+
+		right, err := p.parseBinary(precedence[op] + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryNode{pos: pos, op: op, left: left, right: right}
+	}
+}
+
+// parseUnary parses prefix operators. Every operand is parsed by it, so it
+// keeps track of how deeply operands are nested
+func (p *parser) parseUnary() (node, error) {
+	tok := p.peek()
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxNesting {
+		return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("expression is nested more than %d levels deep", maxNesting)}
+	}
+	if tok.kind == tokenOperator && (tok.text == "!" || tok.text == "-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{pos: tok.pos, op: tok.text, operand: operand}, nil
+	}
+
+	return p.parsePostfix()
+}
+
+// parsePostfix parses member access, indexing and calls after a primary expression
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		if tok.kind != tokenOperator {
+			return n, nil
+		}
+
+		switch tok.text {
+		case ".":
+			p.next()
+			field := p.next()
+			switch field.kind {
+			case tokenIdent:
+				n = &memberNode{pos: field.pos, object: n, name: field.text}
+			case tokenNumber:
+				// items.0 is the same as items[0]
+				n = &indexNode{pos: field.pos, object: n, index: &literalNode{pos: field.pos, value: field.value}}
+			default:
+				return nil, &Error{Pos: field.pos, Msg: fmt.Sprintf("expected field name, got %s", field)}
+			}
+
+		case "[":
+			p.next()
+			index, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{pos: tok.pos, object: n, index: index}
+
+		case "(":
+			ident, ok := n.(*identNode)
+			if !ok {
+				return nil, &Error{Pos: tok.pos, Msg: "only functions can be called"}
+			}
+			p.next()
+			args, err := p.parseList(")")
+			if err != nil {
+				return nil, err
+			}
+			n = &callNode{pos: ident.pos, name: ident.name, args: args}
+
+		default:
+			return n, nil
+		}
+	}
+}
+
+// parsePrimary parses literals, identifiers, lists and parenthesised expressions
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenNumber, tokenString:
+		return &literalNode{pos: tok.pos, value: tok.value}, nil
+
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{pos: tok.pos, value: true}, nil
+		case "false":
+			return &literalNode{pos: tok.pos, value: false}, nil
+		case "null", "nil":
+			return &literalNode{pos: tok.pos, value: nil}, nil
+		}
+		return &identNode{pos: tok.pos, name: tok.text}, nil
+
+	case tokenOperator:
+		switch tok.text {
+		case "(":
+			n, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{pos: tok.pos, items: items}, nil
+		}
+	}
+
+	return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %s", tok)}
+}
+
+// parseList parses comma separated expressions up to the closing operator
+func (p *parser) parseList(closing string) ([]node, error) {
+	var items []node
+
+	if tok := p.peek(); tok.kind == tokenOperator && tok.text == closing {
+		p.next()
+		return items, nil
+	}
+
+	for {
+		item, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		tok := p.next()
+		if tok.kind == tokenOperator && tok.text == closing {
+			return items, nil
+		}
+		if tok.kind != tokenOperator || tok.text != "," {
+			return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("expected \",\" or %q, got %s", closing, tok)}
+		}
+	}
+}
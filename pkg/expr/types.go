@@ -0,0 +1,82 @@
+package expr
+
+// Type is the static type of a value in an expression
+type Type int
+
+const (
+	// Any is a value whose type is only known at runtime
+	Any Type = iota
+	// Null is the null value
+	Null
+	// Bool is a boolean
+	Bool
+	// Number is a number. All numbers are compared and computed as float64
+	Number
+	// String is a string
+	String
+	// List is a list of values
+	List
+	// Map is a map with string keys
+	Map
+)
+
+func (t Type) String() string {
+	switch t {
+	case Null:
+		return "null"
+	case Bool:
+		return "bool"
+	case Number:
+		return "number"
+	case String:
+		return "string"
+	case List:
+		return "list"
+	case Map:
+		return "map"
+	default:
+		return "any"
+	}
+}
+
+// Schema describes the shape of a variable for type checking
+type Schema struct {
+	Type Type
+	// Fields are the known fields of a map
+	Fields map[string]*Schema
+	// Open allows fields other than Fields, which are then of type Any
+	Open bool
+	// Elem is the type of the elements of a list
+	Elem *Schema
+}
+
+// Scope maps the variables an expression may use to their schemas
+type Scope map[string]*Schema
+
+// AnySchema is the schema of a value whose type is only known at runtime
+var AnySchema = &Schema{Type: Any}
+
+// OpenMap returns the schema of a map whose fields are not known in advance
+func OpenMap() *Schema {
+	return &Schema{Type: Map, Open: true}
+}
+
+// Of returns the schema of a scalar type
+func Of(t Type) *Schema {
+	return &Schema{Type: t}
+}
+
+// field returns the schema of a field of a map, and whether the field is allowed
+func (s *Schema) field(name string) (*Schema, bool) {
+	switch s.Type {
+	case Any:
+		return AnySchema, true
+	case Map:
+		if f, ok := s.Fields[name]; ok {
+			return f, true
+		}
+		return AnySchema, s.Open
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"time"
+)
+
+// Event types
+const (
+	EventRunStarted     = "run_started"     // WorkflowName, Data: inputs, Steps, ParentRunID and Depth of a child run, IdempotencyKey
+	EventRunResumed     = "run_resumed"     //
+	EventStepScheduled  = "step_scheduled"  // StepID, Task, Params
+	EventStepSkipped    = "step_skipped"    // StepID, its condition was false or no predecessor led to it
+	EventAttemptStarted = "attempt_started" // StepID, Attempt, Iteration
+	EventAttemptFailed  = "attempt_failed"  // StepID, Attempt, Iteration, Error, Duration
+	EventTaskOutput     = "task_output"     // StepID, Attempt, Iteration, Data, Duration
+	EventStepReconciled = "step_reconciled" // StepID, Data, from tasks.Reconciler
+	EventStepCompleted  = "step_completed"  // StepID, Branch
+	EventStepFailed     = "step_failed"     // StepID, Status, Error, ErrorClass
+	EventStepHandled    = "step_handled"    // StepID, its failure was routed to handlers or ignored
+
+	EventIterationStarted = "iteration_started" // StepID, Iteration, Params, Data: {"item": item} of a foreach step
+	EventIterationFailed  = "iteration_failed"  // StepID, Iteration, Error
+	EventLoopCompleted    = "loop_completed"    // StepID, Data: the step's data
+	EventChildRunStarted  = "child_run_started" // StepID, ChildRunID
+	EventStepWaiting      = "step_waiting"      // StepID, Signal, WakeAt when the wait times out
+	EventSignalReceived   = "signal_received"   // StepID, Signal, Data: payload
+	EventRunWaiting       = "run_waiting"       // all steps left to run are waiting
+	EventRunFinished      = "run_finished"      // Status, Data: outputs, Error
+
+	EventRunCompensating       = "run_compensating"       // Error: why the run failed
+	EventCompensationStarted   = "compensation_started"   // StepID, Task, Params
+	EventCompensationCompleted = "compensation_completed" // StepID, Data, Duration
+	EventCompensationFailed    = "compensation_failed"    // StepID, Error, Duration
+)
+
+// Event records a single transition of a workflow run. The events of a run
+// form an ordered log, and its WorkflowState is the result of applying them
+// in order
+type Event struct {
+	Seq            int               `json:"seq"`
+	Type           string            `json:"type"`
+	Time           time.Time         `json:"time"`
+	RunID          string            `json:"run_id"`
+	WorkflowName   string            `json:"workflow_name,omitempty"`
+	StepID         string            `json:"step_id,omitempty"`
+	Task           string            `json:"task,omitempty"`
+	Attempt        int               `json:"attempt,omitempty"`
+	Iteration      int               `json:"iteration,omitempty"` // from 1, for the steps with foreach or loop
+	Params         map[string]string `json:"params,omitempty"`
+	Data           map[string]any    `json:"data,omitempty"`
+	Status         Status            `json:"status,omitempty"`
+	Error          string            `json:"error,omitempty"`
+	ErrorClass     string            `json:"error_class,omitempty"`
+	Branch         string            `json:"branch,omitempty"`
+	Steps          []string          `json:"steps,omitempty"`
+	ParentRunID    string            `json:"parent_run_id,omitempty"`
+	ChildRunID     string            `json:"child_run_id,omitempty"`
+	Depth          int               `json:"depth,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
+	Signal         string            `json:"signal,omitempty"`
+	WakeAt         time.Time         `json:"wake_at,omitzero"`
+	Duration       time.Duration     `json:"duration,omitempty"`
+}
+
+// Apply updates the state with an event of its run
+func (s *WorkflowState) Apply(event Event) {
+	if s.StepResults == nil {
+		s.StepResults = make(map[string]StepResult)
+	}
+	result := s.StepResults[event.StepID]
+
+	switch event.Type {
+	case EventRunStarted:
+		s.RunID = event.RunID
+		s.WorkflowName = event.WorkflowName
+		s.ParentRunID = event.ParentRunID
+		s.Depth = event.Depth
+		s.IdempotencyKey = event.IdempotencyKey
+		s.Inputs = event.Data
+		s.CompletedSteps = []string{}
+		s.StartTime = event.Time
+		s.Status = RunRunning
+		for _, id := range event.Steps {
+			s.StepResults[id] = StepResult{Status: StepPending}
+		}
+
+	case EventRunResumed:
+		s.Status = RunRunning
+		s.EndTime = time.Time{}
+
+	case EventStepScheduled:
+		s.CurrentStep = event.StepID
+		s.StepResults[event.StepID] = StepResult{Status: StepRunning, Params: event.Params, StartedAt: event.Time}
+
+	case EventAttemptStarted:
+		result.AttemptCount++
+		s.StepResults[event.StepID] = result
+
+	case EventStepSkipped:
+		s.CurrentStep = event.StepID
+		s.StepResults[event.StepID] = StepResult{Status: StepSkipped}
+		s.CompletedSteps = append(s.CompletedSteps, event.StepID)
+
+	case EventAttemptFailed:
+		result.Attempts = append(result.Attempts, Attempt{Number: event.Attempt, Iteration: event.Iteration, Error: event.Error, Duration: event.Duration})
+		if event.Iteration == 0 {
+			result.Error = event.Error
+		}
+		s.StepResults[event.StepID] = result
+
+	case EventTaskOutput, EventStepReconciled:
+		if event.Type == EventTaskOutput {
+			result.Attempts = append(result.Attempts, Attempt{Number: event.Attempt, Iteration: event.Iteration, Duration: event.Duration})
+		}
+		if event.Iteration > 0 {
+			result.setIteration(event.Iteration, func(it *Iteration) {
+				it.Status = StepSucceeded
+				it.Data = event.Data
+			})
+		} else {
+			result.Data = event.Data
+			result.Error = ""
+			result.ErrorClass = ""
+		}
+		s.StepResults[event.StepID] = result
+
+	case EventIterationStarted:
+		result.setIteration(event.Iteration, func(it *Iteration) {
+			*it = Iteration{Number: event.Iteration, Status: StepRunning, Item: event.Data["item"], Params: event.Params}
+		})
+		s.StepResults[event.StepID] = result
+
+	case EventIterationFailed:
+		result.setIteration(event.Iteration, func(it *Iteration) {
+			it.Status = StepFailed
+			it.Error = event.Error
+		})
+		s.StepResults[event.StepID] = result
+
+	case EventChildRunStarted:
+		result.ChildRunID = event.ChildRunID
+		s.StepResults[event.StepID] = result
+
+	case EventStepWaiting:
+		result.Status = StepWaiting
+		result.Signal = event.Signal
+		result.WakeAt = event.WakeAt
+		s.StepResults[event.StepID] = result
+
+	case EventSignalReceived:
+		result.Data = event.Data
+		s.StepResults[event.StepID] = result
+
+	case EventRunWaiting:
+		s.Status = RunWaiting
+
+	case EventLoopCompleted:
+		result.Data = event.Data
+		result.Error = ""
+		s.StepResults[event.StepID] = result
+
+	case EventStepCompleted:
+		result.Status = StepSucceeded
+		result.Success = true
+		result.Branch = event.Branch
+		result.end(event.Time)
+		s.StepResults[event.StepID] = result
+		s.CompletedSteps = append(s.CompletedSteps, event.StepID)
+
+	case EventStepFailed:
+		result.Status = event.Status
+		result.Success = false
+		result.Error = event.Error
+		result.ErrorClass = event.ErrorClass
+		result.end(event.Time)
+		s.StepResults[event.StepID] = result
+
+	case EventStepHandled:
+		s.CompletedSteps = append(s.CompletedSteps, event.StepID)
+
+	case EventRunCompensating:
+		s.Status = RunCompensating
+
+	case EventCompensationStarted:
+		result.Compensation = &CompensationResult{Status: StepRunning, Task: event.Task, Params: event.Params}
+		s.StepResults[event.StepID] = result
+
+	case EventCompensationCompleted, EventCompensationFailed:
+		// Compensation results are replaced rather than changed, since clones of the state share them
+		compensation := CompensationResult{}
+		if result.Compensation != nil {
+			compensation = *result.Compensation
+		}
+		compensation.Status = StepSucceeded
+		compensation.Data = event.Data
+		compensation.Error = event.Error
+		compensation.Duration = event.Duration
+		if event.Type == EventCompensationFailed {
+			compensation.Status = StepFailed
+		}
+		result.Compensation = &compensation
+		s.StepResults[event.StepID] = result
+
+	case EventRunFinished:
+		s.Status = event.Status
+		s.Outputs = event.Data
+		s.EndTime = event.Time
+	}
+}
+
+// end records when a step finished and how long it took
+func (r *StepResult) end(t time.Time) {
+	r.EndedAt = t
+	if !r.StartedAt.IsZero() {
+		r.Duration = t.Sub(r.StartedAt)
+	}
+}
+
+// setIteration changes an iteration of a step, adding it if needed. The
+// iterations are copied first, since clones of the state share them
+func (r *StepResult) setIteration(number int, change func(*Iteration)) {
+	iterations := make([]Iteration, max(number, len(r.Iterations)))
+	copy(iterations, r.Iterations)
+	iterations[number-1].Number = number
+	change(&iterations[number-1])
+	r.Iterations = iterations
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkflowStateApply(t *testing.T) {
+	started := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Seq: 1, Type: EventRunStarted, RunID: "run1", WorkflowName: "order_process", Time: started, Data: map[string]any{"amount": 42.5}},
+		{Seq: 2, Type: EventStepScheduled, RunID: "run1", StepID: "payment", Task: "process_payment", Params: map[string]string{"amount": "42.5"}},
+		{Seq: 3, Type: EventAttemptFailed, RunID: "run1", StepID: "payment", Attempt: 1, Error: "gateway down"},
+		{Seq: 4, Type: EventTaskOutput, RunID: "run1", StepID: "payment", Attempt: 2, Data: map[string]any{"paid": true}},
+		{Seq: 5, Type: EventStepCompleted, RunID: "run1", StepID: "payment"},
+		{Seq: 6, Type: EventStepSkipped, RunID: "run1", StepID: "refund"},
+		{Seq: 7, Type: EventStepScheduled, RunID: "run1", StepID: "ship", Task: "pack_items"},
+		{Seq: 8, Type: EventStepFailed, RunID: "run1", StepID: "ship", Status: StepTimedOut, Error: "step timed out after 1s"},
+		{Seq: 9, Type: EventRunFinished, RunID: "run1", Status: "failed", Time: started.Add(time.Minute)},
+	}
+
+	state := &WorkflowState{}
+	for _, event := range events {
+		state.Apply(event)
+	}
+
+	if state.RunID != "run1" || state.WorkflowName != "order_process" || state.Inputs["amount"] != 42.5 {
+		t.Errorf("Expected run_started to set the run, got %+v", state)
+	}
+
+	if !state.StartTime.Equal(started) || !state.EndTime.Equal(started.Add(time.Minute)) {
+		t.Errorf("Expected start and end time from the events, got %s and %s", state.StartTime, state.EndTime)
+	}
+
+	payment := state.StepResults["payment"]
+	if payment.Status != StepSucceeded || !payment.Success || payment.Error != "" || payment.Data["paid"] != true {
+		t.Errorf("Expected payment to succeed, got %+v", payment)
+	}
+
+	if len(payment.Attempts) != 2 || payment.Attempts[0].Error != "gateway down" || payment.Params["amount"] != "42.5" {
+		t.Errorf("Expected two attempts with params, got %+v", payment)
+	}
+
+	if ship := state.StepResults["ship"]; ship.Status != StepTimedOut || ship.Success {
+		t.Errorf("Expected ship to time out, got %+v", ship)
+	}
+
+	if refund := state.StepResults["refund"]; refund.Status != StepSkipped || refund.Success {
+		t.Errorf("Expected refund to be skipped, got %+v", refund)
+	}
+
+	if len(state.CompletedSteps) != 2 || state.CompletedSteps[1] != "refund" {
+		t.Errorf("Expected payment and refund to be completed, got %v", state.CompletedSteps)
+	}
+
+	if state.CurrentStep != "ship" || state.Status != "failed" {
+		t.Errorf("Expected current step ship and status failed, got %s and %s", state.CurrentStep, state.Status)
+	}
+}
+
+func TestWorkflowStateApplyCompensation(t *testing.T) {
+	state := &WorkflowState{}
+	state.Apply(Event{Type: EventRunStarted, RunID: "run1"})
+	state.Apply(Event{Type: EventStepCompleted, StepID: "payment"})
+	state.Apply(Event{Type: EventRunCompensating, Error: "out of stock"})
+
+	if state.Status != "compensating" {
+		t.Errorf("Expected status compensating, got %s", state.Status)
+	}
+
+	state.Apply(Event{Type: EventCompensationStarted, StepID: "payment", Task: "refund_payment", Params: map[string]string{"amount": "10"}})
+	started := state.Clone()
+
+	state.Apply(Event{Type: EventCompensationFailed, StepID: "payment", Error: "refund rejected", Duration: time.Second})
+
+	c := state.StepResults["payment"].Compensation
+	if c == nil || c.Status != StepFailed || c.Error != "refund rejected" || c.Task != "refund_payment" || c.Params["amount"] != "10" {
+		t.Errorf("Expected failed compensation with its task and params, got %+v", c)
+	}
+
+	// Clones taken before keep the compensation as it was
+	if c := started.StepResults["payment"].Compensation; c.Status != StepRunning {
+		t.Errorf("Expected clone to keep running compensation, got %+v", c)
+	}
+
+	state.Apply(Event{Type: EventCompensationStarted, StepID: "payment", Task: "refund_payment"})
+	state.Apply(Event{Type: EventCompensationCompleted, StepID: "payment", Data: map[string]any{"refunded": true}})
+
+	if c := state.StepResults["payment"].Compensation; c.Status != StepSucceeded || c.Error != "" || c.Data["refunded"] != true {
+		t.Errorf("Expected succeeded compensation, got %+v", c)
+	}
+
+	// The step itself still succeeded
+	if !state.StepResults["payment"].Success {
+		t.Error("Expected the compensated step to keep its success")
+	}
+}
+
+func TestWorkflowStateApplyStepStatus(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	state := &WorkflowState{}
+	state.Apply(Event{Type: EventRunStarted, RunID: "run1", Steps: []string{"payment", "ship"}, Time: started})
+
+	// Every step is pending until it is scheduled
+	for _, id := range []string{"payment", "ship"} {
+		if status := state.StepResults[id].Status; status != StepPending {
+			t.Errorf("Expected step %s to be pending, got %s", id, status)
+		}
+	}
+
+	if state.Status != RunRunning {
+		t.Errorf("Expected status running, got %s", state.Status)
+	}
+
+	state.Apply(Event{Type: EventStepScheduled, StepID: "payment", Params: map[string]string{"amount": "10"}, Time: started.Add(10 * time.Millisecond)})
+	state.Apply(Event{Type: EventAttemptStarted, StepID: "payment", Attempt: 1})
+	state.Apply(Event{Type: EventAttemptFailed, StepID: "payment", Attempt: 1, Error: "gateway down"})
+	state.Apply(Event{Type: EventAttemptStarted, StepID: "payment", Attempt: 2})
+
+	if result := state.StepResults["payment"]; result.Status != StepRunning || result.AttemptCount != 2 || len(result.Attempts) != 1 {
+		t.Errorf("Expected payment to be running its second attempt, got %+v", result)
+	}
+
+	state.Apply(Event{Type: EventTaskOutput, StepID: "payment", Attempt: 2})
+	state.Apply(Event{Type: EventStepCompleted, StepID: "payment", Time: started.Add(1510 * time.Millisecond)})
+
+	payment := state.StepResults["payment"]
+	if !payment.StartedAt.Equal(started.Add(10*time.Millisecond)) || !payment.EndedAt.Equal(started.Add(1510*time.Millisecond)) {
+		t.Errorf("Expected start and end times with sub-second precision, got %v and %v", payment.StartedAt, payment.EndedAt)
+	}
+
+	if payment.Duration != 1500*time.Millisecond {
+		t.Errorf("Expected duration 1.5s, got %s", payment.Duration)
+	}
+
+	if payment.Params["amount"] != "10" {
+		t.Errorf("Expected resolved params, got %v", payment.Params)
+	}
+
+	// A step that never ran is still pending
+	if status := state.StepResults["ship"].Status; status != StepPending {
+		t.Errorf("Expected ship to be pending, got %s", status)
+	}
+}
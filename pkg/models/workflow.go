@@ -1,34 +1,254 @@
 package models
 
+import (
+	"time"
+)
+
 // Workflow represents a complete workflow definition
 type Workflow struct {
-	Name  string `json:"name" yaml:"name"`
-	Steps []Step `json:"steps" yaml:"steps"`
+	Name     string            `json:"name" yaml:"name"`
+	Inputs   []Input           `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+	Outputs  map[string]string `json:"outputs,omitempty" yaml:"outputs,omitempty"` // output name -> expression
+	Timeout  string            `json:"timeout,omitempty" yaml:"timeout,omitempty"` // bounds the whole run, e.g. "5m"
+	Triggers []Trigger         `json:"triggers,omitempty" yaml:"triggers,omitempty"`
+	Steps    []Step            `json:"steps" yaml:"steps"`
+}
+
+// Input types
+const (
+	InputString  = "string"
+	InputNumber  = "number"
+	InputInteger = "integer"
+	InputBoolean = "boolean"
+	InputArray   = "array"
+	InputObject  = "object"
+	InputAny     = "any"
+)
+
+// Input declares a value that is passed to a workflow when it is run
+type Input struct {
+	Name        string `json:"name" yaml:"name"`
+	Type        string `json:"type,omitempty" yaml:"type,omitempty"` // defaults to "any"
+	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	Default     any    `json:"default,omitempty" yaml:"default,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// Trigger starts a run of a workflow when an HTTP request arrives. Its
+// expressions can use body, the request's JSON body, headers, by lower case
+// name, query and env
+type Trigger struct {
+	Path            string            `json:"path" yaml:"path"`                                             // served under /hooks, e.g. "/orders"
+	Method          string            `json:"method,omitempty" yaml:"method,omitempty"`                     // defaults to POST
+	SecretEnv       string            `json:"secret_env,omitempty" yaml:"secret_env,omitempty"`             // environment variable holding the HMAC-SHA256 key the body is signed with, which expressions cannot read
+	SignatureHeader string            `json:"signature_header,omitempty" yaml:"signature_header,omitempty"` // defaults to X-Signature-256
+	Inputs          map[string]string `json:"inputs,omitempty" yaml:"inputs,omitempty"`                     // input name -> expression, the body's fields that are inputs when empty
+	IdempotencyKey  string            `json:"idempotency_key,omitempty" yaml:"idempotency_key,omitempty"`   // expression, defaults to the Idempotency-Key header
+	Wait            string            `json:"wait,omitempty" yaml:"wait,omitempty"`                         // how long to wait for the run to end and return its outputs, e.g. "30s"
 }
 
 // Step represents a single step in a workflow
 type Step struct {
-	ID        string            `json:"id" yaml:"id"`
-	Task      string            `json:"task" yaml:"task"`
-	Next      []string          `json:"next" yaml:"next"`
-	Condition string            `json:"condition,omitempty" yaml:"condition,omitempty"`
-	Params    map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+	ID         string            `json:"id" yaml:"id"`
+	Task       string            `json:"task" yaml:"task"`
+	Next       []string          `json:"next" yaml:"next"`
+	Condition  string            `json:"condition,omitempty" yaml:"condition,omitempty"`
+	Branches   []Branch          `json:"branches,omitempty" yaml:"branches,omitempty"`       // the first whose expression holds picks the step to run next
+	ForEach    *ForEach          `json:"foreach,omitempty" yaml:"foreach,omitempty"`         // runs the task once per item of a list
+	Loop       *Loop             `json:"loop,omitempty" yaml:"loop,omitempty"`               // runs the task while or until an expression holds
+	Workflow   *SubWorkflow      `json:"workflow,omitempty" yaml:"workflow,omitempty"`       // runs another workflow instead of a task
+	WaitSignal *WaitSignal       `json:"wait_signal,omitempty" yaml:"wait_signal,omitempty"` // waits for a signal instead of running a task
+	Sleep      string            `json:"sleep,omitempty" yaml:"sleep,omitempty"`             // waits for a duration instead of running a task, e.g. "72h"
+	WaitUntil  string            `json:"wait_until,omitempty" yaml:"wait_until,omitempty"`   // waits until an RFC 3339 timestamp instead of running a task
+	Params     map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+	Retry      *RetryPolicy      `json:"retry,omitempty" yaml:"retry,omitempty"`
+	Timeout    string            `json:"timeout,omitempty" yaml:"timeout,omitempty"`       // bounds each attempt, e.g. "30s"
+	Idempotent bool              `json:"idempotent,omitempty" yaml:"idempotent,omitempty"` // safe to run again when a resumed run finds it interrupted
+	Compensate *Compensation     `json:"compensate,omitempty" yaml:"compensate,omitempty"` // undoes the step when a later step fails the run
+
+	OnFailure       []string     `json:"on_failure,omitempty" yaml:"on_failure,omitempty"`               // handler steps to run when the step fails
+	OnErrorMatch    []ErrorRoute `json:"on_error_match,omitempty" yaml:"on_error_match,omitempty"`       // handler steps by error class, checked before on_failure
+	ContinueOnError bool         `json:"continue_on_error,omitempty" yaml:"continue_on_error,omitempty"` // run the next steps even when the step fails
+}
+
+// ForEach runs the task of a step once per item of a list. Params can use
+// the item variable and loop.index, and the step's data is the list of the
+// iterations' data under "results"
+type ForEach struct {
+	Items          string `json:"items" yaml:"items"`                                         // expression that evaluates to a list
+	As             string `json:"as,omitempty" yaml:"as,omitempty"`                           // name of the item variable, defaults to "item"
+	MaxConcurrency int    `json:"max_concurrency,omitempty" yaml:"max_concurrency,omitempty"` // iterations run at once, defaults to 1
+}
+
+// Loop runs the task of a step repeatedly, at most MaxIterations times.
+// Expressions and params can use loop.index and loop.data, the data of the
+// previous iteration, and the step's data is the data of the last iteration
+type Loop struct {
+	While         string `json:"while,omitempty" yaml:"while,omitempty"` // checked before each iteration
+	Until         string `json:"until,omitempty" yaml:"until,omitempty"` // checked after each iteration
+	MaxIterations int    `json:"max_iterations" yaml:"max_iterations"`
+	Delay         string `json:"delay,omitempty" yaml:"delay,omitempty"` // wait between iterations, e.g. "5s"
+}
+
+// SubWorkflow runs another loaded workflow as a child run of the step's run.
+// The child's outputs become the step's data
+type SubWorkflow struct {
+	Name   string            `json:"name" yaml:"name"`
+	Inputs map[string]string `json:"inputs,omitempty" yaml:"inputs,omitempty"` // input name -> expression, evaluated against the parent run
+}
+
+// WaitSignal pauses a run until a signal with its name is sent with
+// Engine.Signal. The signal's payload becomes the step's data
+type WaitSignal struct {
+	Name      string   `json:"name" yaml:"name"`
+	Timeout   string   `json:"timeout,omitempty" yaml:"timeout,omitempty"`       // how long to wait, e.g. "48h", forever when empty
+	OnTimeout []string `json:"on_timeout,omitempty" yaml:"on_timeout,omitempty"` // steps to run instead of next when no signal arrives in time
+}
+
+// Branch routes a run to a step when its expression holds. A branch
+// without an expression is the default, taken when no other branch matches
+type Branch struct {
+	When string `json:"when,omitempty" yaml:"when,omitempty"`
+	Next string `json:"next" yaml:"next"`
+}
+
+// ErrorRoute sends the failures of a step whose error class matches a
+// pattern to handler steps. Patterns use path.Match syntax, e.g. "payment_*"
+type ErrorRoute struct {
+	Pattern string   `json:"pattern" yaml:"pattern"`
+	Next    []string `json:"next" yaml:"next"`
+}
+
+// Compensation is the task that undoes the effect of a completed step. Its
+// params can use the same placeholders as step params, e.g. the data the
+// step returned
+type Compensation struct {
+	Task   string            `json:"task" yaml:"task"`
+	Params map[string]string `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+// Backoff strategies
+const (
+	BackoffExponential = "exponential"
+	BackoffLinear      = "linear"
+	BackoffConstant    = "constant"
+)
+
+// RetryPolicy describes how a failed step is retried. Durations use Go
+// syntax, e.g. "500ms" or "1m"
+type RetryPolicy struct {
+	MaxAttempts  int      `json:"max_attempts" yaml:"max_attempts"`                       // including the first attempt
+	InitialDelay string   `json:"initial_delay,omitempty" yaml:"initial_delay,omitempty"` // delay before the second attempt
+	Backoff      string   `json:"backoff,omitempty" yaml:"backoff,omitempty"`             // defaults to "exponential"
+	Jitter       float64  `json:"jitter,omitempty" yaml:"jitter,omitempty"`               // fraction of each delay that is randomised, 0 to 1
+	MaxDelay     string   `json:"max_delay,omitempty" yaml:"max_delay,omitempty"`
+	RetryOn      []string `json:"retry_on,omitempty" yaml:"retry_on,omitempty"` // error classes to retry, all when empty
 }
 
 // WorkflowState represents the current state of a workflow execution
 type WorkflowState struct {
+	RunID          string                `json:"run_id"`
 	WorkflowName   string                `json:"workflow_name"`
+	ParentRunID    string                `json:"parent_run_id,omitempty"`   // run whose workflow step started this run
+	Depth          int                   `json:"depth,omitempty"`           // number of parent runs
+	IdempotencyKey string                `json:"idempotency_key,omitempty"` // key the run was started with, see Engine.StartOnce
+	Inputs         map[string]any        `json:"inputs,omitempty"`
 	CurrentStep    string                `json:"current_step"`
 	CompletedSteps []string              `json:"completed_steps"`
 	StepResults    map[string]StepResult `json:"step_results"`
-	StartTime      int64                 `json:"start_time"`
-	EndTime        int64                 `json:"end_time,omitempty"`
-	Status         string                `json:"status"` // "running", "completed", "failed"
+	Outputs        map[string]any        `json:"outputs,omitempty"`
+	StartTime      time.Time             `json:"start_time"`
+	EndTime        time.Time             `json:"end_time,omitzero"`
+	Status         Status                `json:"status"` // one of the run statuses
 }
 
+// Status is the status of a step or of a run
+type Status string
+
+// Step statuses. A step is pending until it is scheduled, and a step that
+// times out or is cancelled has failed
+const (
+	StepPending   Status = "pending"
+	StepRunning   Status = "running"
+	StepWaiting   Status = "waiting"
+	StepSkipped   Status = "skipped"
+	StepSucceeded Status = "succeeded"
+	StepFailed    Status = "failed"
+	StepTimedOut  Status = "timed_out"
+	StepCancelled Status = "cancelled"
+)
+
+// Run statuses
+const (
+	RunRunning            Status = "running"
+	RunWaiting            Status = "waiting"
+	RunCompleted          Status = "completed"
+	RunFailed             Status = "failed"
+	RunTimedOut           Status = "timed_out"
+	RunCancelled          Status = "cancelled"
+	RunCompensating       Status = "compensating"
+	RunCompensated        Status = "compensated"
+	RunCompensationFailed Status = "compensation_failed"
+)
+
 // StepResult represents the result of a step execution
 type StepResult struct {
-	Success bool           `json:"success"`
-	Data    map[string]any `json:"data,omitempty"`
-	Error   string         `json:"error,omitempty"`
+	Status     Status            `json:"status,omitempty"`
+	Success    bool              `json:"success"`
+	Data       map[string]any    `json:"data,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	ErrorClass string            `json:"error_class,omitempty"` // class of the error, see tasks.Error
+	Branch     string            `json:"branch,omitempty"`      // step picked by the step's branches
+	Params     map[string]string `json:"params,omitempty"`      // Params after placeholders were resolved, except those that read env
+	Attempts   []Attempt         `json:"attempts,omitempty"`
+	Iterations []Iteration       `json:"iterations,omitempty"`   // iterations of a foreach or loop step
+	ChildRunID string            `json:"child_run_id,omitempty"` // latest run started by a workflow step
+	Signal     string            `json:"signal,omitempty"`       // signal a wait_signal step waits for
+	WakeAt     time.Time         `json:"wake_at,omitzero"`       // when a waiting step stops waiting
+
+	AttemptCount int           `json:"attempt_count,omitempty"` // attempts started, including one that is running
+	StartedAt    time.Time     `json:"started_at,omitzero"`
+	EndedAt      time.Time     `json:"ended_at,omitzero"`
+	Duration     time.Duration `json:"duration,omitempty"` // from StartedAt to EndedAt
+
+	Compensation *CompensationResult `json:"compensation,omitempty"` // set once the step is compensated
+}
+
+// CompensationResult records the outcome of a step's compensation
+type CompensationResult struct {
+	Status   Status            `json:"status"` // StepRunning, StepSucceeded or StepFailed
+	Task     string            `json:"task"`
+	Params   map[string]string `json:"params,omitempty"`
+	Data     map[string]any    `json:"data,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Duration time.Duration     `json:"duration,omitempty"`
+}
+
+// Iteration records one iteration of a foreach or loop step
+type Iteration struct {
+	Number int               `json:"number"`
+	Status Status            `json:"status"`
+	Item   any               `json:"item,omitempty"` // item of a foreach step
+	Params map[string]string `json:"params,omitempty"`
+	Data   map[string]any    `json:"data,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// Attempt records a single execution of a step's task
+type Attempt struct {
+	Number    int           `json:"number"`
+	Iteration int           `json:"iteration,omitempty"` // iteration of a foreach or loop step the attempt belongs to
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Clone returns a copy of the state that can be read while the original keeps changing
+func (s *WorkflowState) Clone() *WorkflowState {
+	clone := *s
+	clone.CompletedSteps = append([]string(nil), s.CompletedSteps...)
+	clone.StepResults = make(map[string]StepResult, len(s.StepResults))
+	for id, result := range s.StepResults {
+		clone.StepResults[id] = result
+	}
+	return &clone
 }
@@ -3,6 +3,7 @@ package models
 import (
 	"encoding/json"
 	"testing"
+	"time"
 )
 
 func TestWorkflowSerialization(t *testing.T) {
@@ -83,7 +84,7 @@ func TestWorkflowState(t *testing.T) {
 		CurrentStep:    "step1",
 		CompletedSteps: []string{},
 		StepResults:    make(map[string]StepResult),
-		StartTime:      1234567890,
+		StartTime:      time.Date(2025, 3, 1, 12, 0, 0, 250_000_000, time.UTC),
 		Status:         "running",
 	}
 
@@ -122,6 +123,11 @@ func TestWorkflowState(t *testing.T) {
 		t.Errorf("Expected status %s, got %s", state.Status, deserializedState.Status)
 	}
 
+	// Timestamps keep sub-second precision
+	if !deserializedState.StartTime.Equal(state.StartTime) {
+		t.Errorf("Expected start time %s, got %s", state.StartTime, deserializedState.StartTime)
+	}
+
 	// Check the step result
 	result, ok := deserializedState.StepResults["step1"]
 	if !ok {
@@ -140,3 +146,33 @@ func TestWorkflowState(t *testing.T) {
 		t.Errorf("Expected key2 123, got %v", result.Data["key2"])
 	}
 }
+
+func TestWorkflowStateClone(t *testing.T) {
+	// Create a sample workflow state
+	state := &WorkflowState{
+		WorkflowName:   "test_workflow",
+		CompletedSteps: []string{"step1"},
+		StepResults: map[string]StepResult{
+			"step1": {Success: true},
+		},
+		Status: "running",
+	}
+
+	// Clone the state and change the original
+	clone := state.Clone()
+	state.CompletedSteps = append(state.CompletedSteps, "step2")
+	state.StepResults["step2"] = StepResult{Success: true}
+
+	// Verify the clone is not affected
+	if len(clone.CompletedSteps) != 1 {
+		t.Errorf("Expected 1 completed step in clone, got %d", len(clone.CompletedSteps))
+	}
+
+	if _, ok := clone.StepResults["step2"]; ok {
+		t.Error("Expected step2 result not to be in clone")
+	}
+
+	if clone.WorkflowName != "test_workflow" {
+		t.Errorf("Expected workflow name test_workflow, got %s", clone.WorkflowName)
+	}
+}
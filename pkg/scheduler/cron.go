@@ -0,0 +1,263 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression. It has five fields, minute, hour, day
+// of month, month and day of week, or six with the second first. Fields
+// take *, values, ranges, lists and steps, e.g. "*/15", "1-5" or "MON,WED".
+// When both day fields are restricted, a day matches either of them
+type Cron struct {
+	second, minute, hour, dom, month, dow uint64 // bit n is set when value n matches
+	domStar, dowStar                      bool   // the day field matches every day
+	loc                                   *time.Location
+}
+
+// cronField is a field of a cron expression
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	secondField = cronField{name: "second", min: 0, max: 59}
+	minuteField = cronField{name: "minute", min: 0, max: 59}
+	hourField   = cronField{name: "hour", min: 0, max: 23}
+	domField    = cronField{name: "day of month", min: 1, max: 31}
+	monthField  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// Sunday is both 0 and 7
+	dowField = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// cronMacros are the expressions that macros stand for
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// maxCronYears is how far ahead Next looks for a matching time, so an
+// expression like "0 0 30 2 *" that never matches does not search forever
+const maxCronYears = 5
+
+// ParseCron parses a cron expression whose times are wall clock times in a location
+func ParseCron(spec string, loc *time.Location) (*Cron, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron expression %q must have 5 or 6 fields, got %d", spec, len(fields))
+	}
+
+	c := &Cron{loc: loc}
+	var err error
+	parsers := []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{&c.second, secondField},
+		{&c.minute, minuteField},
+		{&c.hour, hourField},
+		{&c.dom, domField},
+		{&c.month, monthField},
+		{&c.dow, dowField},
+	}
+	for i, p := range parsers {
+		if *p.bits, err = parseCronField(fields[i], p.field); err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", spec, err)
+		}
+	}
+
+	// Sunday is matched as 0
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = fields[3] == "*" || fields[3] == "?"
+	c.dowStar = fields[5] == "*" || fields[5] == "?"
+
+	return c, nil
+}
+
+// parseCronField parses a field of a cron expression into the bits of the values it matches
+func parseCronField(text string, f cronField) (uint64, error) {
+	var bits uint64
+
+	for _, item := range strings.Split(text, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("%s: invalid step %q", f.name, stepText)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rng == "*" || rng == "?":
+			lo, hi = f.min, f.max
+		case strings.Contains(rng, "-"):
+			loText, hiText, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(loText); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(hiText); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("%s: range %q ends before it starts", f.name, rng)
+			}
+		default:
+			var err error
+			if lo, err = f.value(rng); err != nil {
+				return 0, err
+			}
+			// A value with a step runs to the end of the field, e.g. "5/15"
+			hi = lo
+			if hasStep {
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+// value parses a value of a field, which can be a name like "jan" or "mon"
+func (f cronField) value(text string) (int, error) {
+	if v, ok := f.names[strings.ToLower(text)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value %q", f.name, text)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s: %d is not between %d and %d", f.name, v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after the given time that the expression
+// matches, or the zero time if it matches none in the next years. Wall
+// clock times that are skipped when the clocks go forward fire when the
+// skipped hour ends, and times that repeat when the clocks go back fire once
+func (c *Cron) Next(after time.Time) time.Time {
+	// Search the wall clock times as UTC times, which have no gaps or repeats
+	wall := wallClock(after.In(c.loc))
+
+	for {
+		if wall = c.nextWall(wall); wall.IsZero() {
+			return time.Time{}
+		}
+
+		t := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, c.loc)
+
+		// A wall clock time that does not exist in the location is moved out
+		// of the gap by time.Date, so it fires when the gap ends instead
+		if shown := wallClock(t); !shown.Equal(wall) {
+			start, end := t.ZoneBounds()
+			if shown.Before(wall) {
+				t = end
+			} else {
+				t = start
+			}
+		}
+
+		if t.After(after) {
+			return t
+		}
+	}
+}
+
+// nextWall returns the first wall clock time after t that the expression
+// matches. Times are UTC times that hold wall clock times
+func (c *Cron) nextWall(t time.Time) time.Time {
+	t = t.Add(time.Second)
+	limit := t.Year() + maxCronYears
+
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+
+	for c.month&(1<<int(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !c.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for c.hour&(1<<t.Hour()) == 0 {
+		t = t.Truncate(time.Hour).Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for c.minute&(1<<t.Minute()) == 0 {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for c.second&(1<<t.Second()) == 0 {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t
+}
+
+// dayMatches reports whether the day fields match the day of t
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// wallClock returns the wall clock time t shows as a UTC time, truncated to the second
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// mustLocation loads a time zone or fails the test
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("Failed to load time zone %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestCronNext(t *testing.T) {
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC) // a Saturday
+
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"*/15 * * * *", time.Date(2025, 3, 1, 12, 15, 0, 0, time.UTC)},
+		{"30 * * * * *", time.Date(2025, 3, 1, 12, 0, 30, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2025, 3, 2, 2, 0, 0, 0, time.UTC)},
+		{"0 9 * * MON-FRI", time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 10/10 * *", time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, 3, 1, 13, 0, 0, 0, time.UTC)},
+		// Both day fields are restricted, so either matches
+		{"0 0 15 * MON", time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseCron(tt.spec, time.UTC)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", tt.spec, err)
+			continue
+		}
+		if next := c.Next(start); !next.Equal(tt.expected) {
+			t.Errorf("Expected %q to fire at %s, got %s", tt.spec, tt.expected, next)
+		}
+	}
+
+	// An expression that never matches has no next time
+	c, _ := ParseCron("0 0 30 2 *", time.UTC)
+	if next := c.Next(start); !next.IsZero() {
+		t.Errorf("Expected no next time, got %s", next)
+	}
+}
+
+func TestCronTimeZone(t *testing.T) {
+	istanbul := mustLocation(t, "Europe/Istanbul")
+
+	c, err := ParseCron("0 0 2 * * *", istanbul)
+	if err != nil {
+		t.Fatalf("Failed to parse cron expression: %v", err)
+	}
+
+	// 02:00 in Istanbul is 23:00 UTC the day before
+	next := c.Next(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	if expected := time.Date(2025, 3, 1, 23, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Errorf("Expected %s, got %s", expected, next.UTC())
+	}
+}
+
+func TestCronDST(t *testing.T) {
+	newYork := mustLocation(t, "America/New_York")
+
+	// The clocks go from 02:00 to 03:00 on 9 March 2025, so 02:30 fires at 03:00
+	c, _ := ParseCron("30 2 * * *", newYork)
+	next := c.Next(time.Date(2025, 3, 9, 0, 0, 0, 0, newYork))
+	if expected := time.Date(2025, 3, 9, 3, 0, 0, 0, newYork); !next.Equal(expected) {
+		t.Errorf("Expected the skipped time to fire at %s, got %s", expected, next)
+	}
+	if after := c.Next(next); !after.Equal(time.Date(2025, 3, 10, 2, 30, 0, 0, newYork)) {
+		t.Errorf("Expected the next day's time, got %s", after)
+	}
+
+	// The clocks go from 02:00 back to 01:00 on 2 November 2025, so 01:30 fires once
+	c, _ = ParseCron("30 1 * * *", newYork)
+	first := c.Next(time.Date(2025, 11, 2, 0, 0, 0, 0, newYork))
+	if first.Hour() != 1 || first.Minute() != 30 || first.Day() != 2 {
+		t.Errorf("Expected 01:30 on 2 November, got %s", first)
+	}
+	if next := c.Next(first); next.Day() != 3 {
+		t.Errorf("Expected the repeated time to fire once, got %s after %s", next, first)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := map[string]string{
+		"* * * *":       "must have 5 or 6 fields",
+		"60 * * * *":    "minute: 60 is not between 0 and 59",
+		"* * * foo *":   `month: invalid value "foo"`,
+		"*/0 * * * *":   `minute: invalid step "0"`,
+		"* 5-2 * * *":   `hour: range "5-2" ends before it starts`,
+		"* * 0 * *":     "day of month: 0 is not between 1 and 31",
+		"* * * * 1-sun": `day of week: range "1-sun" ends before it starts`,
+	}
+
+	for spec, message := range tests {
+		_, err := ParseCron(spec, time.UTC)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error %q for %q, got %v", message, spec, err)
+		}
+	}
+}
@@ -0,0 +1,511 @@
+// Package scheduler starts runs of workflows on cron expressions or at fixed
+// intervals, and remembers when its schedules last fired
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/clock"
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+	"github.com/mstgnz/goflow/pkg/workflow"
+)
+
+// Overlap policies, for a schedule that fires while its previous run is still running
+const (
+	OverlapSkip           = "skip"            // the fire is skipped
+	OverlapQueue          = "queue"           // the run starts once the previous runs end
+	OverlapCancelPrevious = "cancel_previous" // the previous run is cancelled, and the run starts once it ended
+)
+
+// Catch-up policies, for the fire times a schedule missed while its scheduler was not running
+const (
+	CatchUpNone = "none" // missed fire times are skipped
+	CatchUpLast = "last" // one run is started for the latest missed fire time
+	CatchUpAll  = "all"  // a run is started for every missed fire time, one after another
+)
+
+// MaxCatchUp is the most runs CatchUpAll starts, for the latest missed fire times
+const MaxCatchUp = 100
+
+// ErrOverlap is reported when a schedule with the skip policy fires while
+// its previous run is still running
+var ErrOverlap = errors.New("previous run is still running")
+
+// Schedule starts runs of a loaded workflow on a cron expression or at a
+// fixed interval
+type Schedule struct {
+	Name     string         `json:"name,omitempty" yaml:"name,omitempty"` // identifies the schedule, defaults to the workflow name
+	Workflow string         `json:"workflow" yaml:"workflow"`
+	Cron     string         `json:"cron,omitempty" yaml:"cron,omitempty"`           // e.g. "0 0 2 * * *", see ParseCron
+	Every    string         `json:"every,omitempty" yaml:"every,omitempty"`         // fixed interval instead of a cron expression, e.g. "15m"
+	TimeZone string         `json:"time_zone,omitempty" yaml:"time_zone,omitempty"` // IANA name of the zone of the cron expression, defaults to UTC
+	Inputs   map[string]any `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+	Overlap  string         `json:"overlap,omitempty" yaml:"overlap,omitempty"`   // defaults to skip
+	CatchUp  string         `json:"catch_up,omitempty" yaml:"catch_up,omitempty"` // defaults to none
+}
+
+// timing tells when a schedule fires next
+type timing interface {
+	Next(after time.Time) time.Time
+}
+
+// interval fires at a fixed interval
+type interval time.Duration
+
+func (d interval) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(d))
+}
+
+// Scheduler starts runs of workflows on an engine when their schedules fire.
+// It reads the time from the engine's clock, and saves when each schedule
+// last fired in a store, so a scheduler that was not running can catch up.
+// It is safe for concurrent use
+type Scheduler struct {
+	engine *workflow.Engine
+	store  store.ScheduleStore
+
+	mu        sync.Mutex
+	schedules map[string]*entry
+	ctx       context.Context // set while the scheduler is started
+	cancel    context.CancelFunc
+	stopped   chan struct{} // closed by Stop, so runs that wait are no longer followed
+	onRun     func(schedule string, state *models.WorkflowState, err error)
+	runs      sync.WaitGroup
+}
+
+// entry is a schedule with its timer and runs
+type entry struct {
+	schedule Schedule
+	timing   timing
+	next     time.Time
+	timer    clock.Timer
+	cancel   context.CancelFunc // cancels the run that is running, nil when none is
+	queued   int                // runs to start once the running run ends
+}
+
+// New creates a scheduler that starts runs on an engine
+func New(engine *workflow.Engine, s store.ScheduleStore) *Scheduler {
+	return &Scheduler{
+		engine:    engine,
+		store:     s,
+		schedules: make(map[string]*entry),
+	}
+}
+
+// SetRunHandler sets a function that is called when a run a schedule started
+// ends, with the run's state and error, and when a schedule fails to fire,
+// with a nil state, e.g. with ErrOverlap. A run that waits when the
+// scheduler stops is reported with the status waiting
+func (s *Scheduler) SetRunHandler(fn func(schedule string, state *models.WorkflowState, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onRun = fn
+}
+
+// Add adds a schedule. A schedule added to a started scheduler starts right away
+func (s *Scheduler) Add(schedule Schedule) error {
+	if schedule.Name == "" {
+		schedule.Name = schedule.Workflow
+	}
+
+	t, err := s.check(schedule)
+	if err != nil {
+		return fmt.Errorf("schedule %s: %w", schedule.Name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.schedules[schedule.Name]; ok {
+		return fmt.Errorf("schedule %s already exists", schedule.Name)
+	}
+
+	e := &entry{schedule: schedule, timing: t}
+	s.schedules[schedule.Name] = e
+	if s.ctx != nil {
+		return s.activate(e)
+	}
+	return nil
+}
+
+// Remove removes a schedule. A run it started keeps running
+func (s *Scheduler) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.schedules[name]
+	if !ok {
+		return false
+	}
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.queued = 0
+	delete(s.schedules, name)
+	return true
+}
+
+// Schedules returns the schedules, sorted by name
+func (s *Scheduler) Schedules() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var schedules []Schedule
+	for _, name := range s.names() {
+		schedules = append(schedules, s.schedules[name].schedule)
+	}
+	return schedules
+}
+
+// Next returns when a schedule fires next, or the zero time when it is not started
+func (s *Scheduler) Next(name string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.schedules[name]
+	if !ok {
+		return time.Time{}, false
+	}
+	return e.next, true
+}
+
+// Start starts firing the schedules. Schedules that fired before catch up on
+// the fire times they missed since, according to their catch-up policy.
+// Runs are started with a context derived from ctx, so cancelling it
+// cancels them
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx != nil {
+		return errors.New("scheduler already started")
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.stopped = make(chan struct{})
+
+	for _, name := range s.names() {
+		if err := s.activate(s.schedules[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop stops firing the schedules, drops the runs that are queued, and
+// waits for the runs that are running to end. Cancel the context passed to
+// Start to cancel them
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	for _, e := range s.schedules {
+		if e.timer != nil {
+			e.timer.Stop()
+			e.timer = nil
+		}
+		e.next = time.Time{}
+		e.queued = 0
+	}
+	if s.ctx != nil {
+		close(s.stopped)
+	}
+	s.ctx = nil
+	s.mu.Unlock()
+
+	s.runs.Wait()
+
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+		s.cancel = nil
+	}
+	s.mu.Unlock()
+}
+
+// check validates a schedule and returns when it fires
+func (s *Scheduler) check(schedule Schedule) (timing, error) {
+	if _, ok := s.engine.Workflow(schedule.Workflow); !ok {
+		return nil, fmt.Errorf("workflow not found: %s", schedule.Workflow)
+	}
+
+	if !slices.Contains([]string{"", OverlapSkip, OverlapQueue, OverlapCancelPrevious}, schedule.Overlap) {
+		return nil, fmt.Errorf("invalid overlap policy %q", schedule.Overlap)
+	}
+	if !slices.Contains([]string{"", CatchUpNone, CatchUpLast, CatchUpAll}, schedule.CatchUp) {
+		return nil, fmt.Errorf("invalid catch-up policy %q", schedule.CatchUp)
+	}
+
+	switch {
+	case schedule.Cron != "" && schedule.Every != "":
+		return nil, errors.New("cron and every cannot be combined")
+	case schedule.Every != "":
+		if schedule.TimeZone != "" {
+			return nil, errors.New("time_zone only applies to cron expressions")
+		}
+		d, err := time.ParseDuration(schedule.Every)
+		if err != nil {
+			return nil, fmt.Errorf("every: %w", err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("every must be at least 1s, got %s", schedule.Every)
+		}
+		return interval(d), nil
+	case schedule.Cron != "":
+		loc := time.UTC
+		if schedule.TimeZone != "" {
+			var err error
+			if loc, err = time.LoadLocation(schedule.TimeZone); err != nil {
+				return nil, fmt.Errorf("time_zone: %w", err)
+			}
+		}
+		return ParseCron(schedule.Cron, loc)
+	}
+	return nil, errors.New("needs cron or every")
+}
+
+// activate catches up on the fire times a schedule missed since it last
+// fired, and arms its timer. The lock must be held
+func (s *Scheduler) activate(e *entry) error {
+	name := e.schedule.Name
+	now := s.engine.Clock().Now()
+
+	last, err := s.store.LastFire(name)
+	if err != nil {
+		return fmt.Errorf("schedule %s: failed to load last fire time: %w", name, err)
+	}
+	if last.IsZero() {
+		s.arm(e, now.Truncate(time.Second))
+		return nil
+	}
+
+	// Collect the latest fire times that passed since the schedule last fired
+	var missed []time.Time
+	for t := e.timing.Next(catchUpStart(e.timing, last, now)); !t.IsZero() && !t.After(now); t = e.timing.Next(t) {
+		missed = append(missed, t)
+		if len(missed) > MaxCatchUp {
+			missed = missed[1:]
+		}
+	}
+
+	if len(missed) == 0 {
+		s.arm(e, last)
+		return nil
+	}
+
+	// The schedule keeps its rhythm, firing next after the latest missed fire time
+	latest := missed[len(missed)-1]
+	if e.schedule.CatchUp != "" && e.schedule.CatchUp != CatchUpNone {
+		if err := s.store.SaveLastFire(name, latest); err != nil {
+			return fmt.Errorf("schedule %s: failed to save last fire time: %w", name, err)
+		}
+
+		// Catch-up runs are queued whatever the overlap policy
+		n := 1
+		if e.schedule.CatchUp == CatchUpAll {
+			n = len(missed)
+		}
+		if e.cancel == nil {
+			s.start(e)
+			n--
+		}
+		e.queued += n
+	}
+
+	s.arm(e, latest)
+	return nil
+}
+
+// catchUpStart returns the time to look for the fire times a schedule missed
+// after, so the latest MaxCatchUp of them are found without walking every
+// fire time of a long outage, e.g. of a schedule that fires every second
+func catchUpStart(t timing, last, now time.Time) time.Time {
+	// Intervals fire at multiples of their duration after the last fire
+	if d, ok := t.(interval); ok {
+		if skip := int64(now.Sub(last)/time.Duration(d)) - MaxCatchUp; skip > 0 {
+			return last.Add(time.Duration(skip) * time.Duration(d))
+		}
+		return last
+	}
+
+	// Look back from now over a window that doubles until it holds enough
+	// fire times, or reaches the last fire
+	for window := time.Minute; window > 0 && window < now.Sub(last); window *= 2 {
+		from := now.Add(-window)
+		n := 0
+		for next := t.Next(from); !next.IsZero() && !next.After(now) && n < MaxCatchUp; next = t.Next(next) {
+			n++
+		}
+		if n >= MaxCatchUp {
+			return from
+		}
+	}
+	return last
+}
+
+// arm sets the timer of a schedule for its first fire time after the given
+// time. Fire times that already passed are skipped. The lock must be held
+func (s *Scheduler) arm(e *entry, after time.Time) {
+	c := s.engine.Clock()
+	now := c.Now()
+
+	next := e.timing.Next(after)
+	if !next.IsZero() && !next.After(now) {
+		next = e.timing.Next(now)
+	}
+
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	e.next = next
+	if next.IsZero() {
+		return
+	}
+
+	name := e.schedule.Name
+	e.timer = c.AfterFunc(next.Sub(now), func() { s.fire(name, next) })
+}
+
+// fire records that a schedule fired, arms it for its next fire time, and
+// starts a run according to its overlap policy
+func (s *Scheduler) fire(name string, at time.Time) {
+	s.mu.Lock()
+	e, ok := s.schedules[name]
+	if !ok || s.ctx == nil || !e.next.Equal(at) {
+		// The schedule was removed, stopped or armed again since
+		s.mu.Unlock()
+		return
+	}
+
+	saveErr := s.store.SaveLastFire(name, at)
+	s.arm(e, at)
+
+	skipped := false
+	switch {
+	case e.cancel == nil:
+		s.start(e)
+	case e.schedule.Overlap == OverlapQueue:
+		e.queued++
+	case e.schedule.Overlap == OverlapCancelPrevious:
+		e.queued = 1
+		e.cancel()
+	default:
+		skipped = true
+	}
+	s.mu.Unlock()
+
+	if saveErr != nil {
+		s.report(name, nil, fmt.Errorf("failed to save last fire time: %w", saveErr))
+	}
+	if skipped {
+		s.report(name, nil, fmt.Errorf("fire at %s skipped: %w", at.Format(time.RFC3339), ErrOverlap))
+	}
+}
+
+// start starts a run of a schedule, and the runs that are queued behind it
+// one after another. A run that stops to wait is followed until it ends, so
+// it counts as running for the overlap policy. The lock must be held
+func (s *Scheduler) start(e *entry) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	e.cancel = cancel
+
+	s.runs.Add(1)
+	go func() {
+		defer s.runs.Done()
+
+		for {
+			state, err := s.engine.RunContext(ctx, e.schedule.Workflow, e.schedule.Inputs)
+			if err == nil && state.Status == models.RunWaiting {
+				state, err = s.follow(ctx, state.RunID)
+			}
+			cancel()
+			s.report(e.schedule.Name, state, err)
+
+			s.mu.Lock()
+			if e.queued == 0 || s.ctx == nil {
+				e.cancel = nil
+				s.mu.Unlock()
+				return
+			}
+			e.queued--
+			ctx, cancel = context.WithCancel(s.ctx)
+			e.cancel = cancel
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// followInterval is how often the stored state of a run that waits is read,
+// in case another process that shares the engine's store resumes it
+const followInterval = 30 * time.Second
+
+// follow waits until a run that stopped to wait ends, and returns its state.
+// The engine reports the events of the runs it resumes, and the stored state
+// is read every followInterval too. Cancelling ctx cancels the run. Following
+// stops when the scheduler stops
+func (s *Scheduler) follow(ctx context.Context, runID string) (*models.WorkflowState, error) {
+	recorded, stop := s.engine.WatchEvents(runID)
+	defer stop()
+
+	s.mu.Lock()
+	stopped := s.stopped
+	s.mu.Unlock()
+
+	c := s.engine.Clock()
+	done := ctx.Done()
+	for {
+		state, err := s.engine.GetRun(runID)
+		if err != nil || ended(state.Status) {
+			return state, err
+		}
+
+		poll := make(chan struct{})
+		timer := c.AfterFunc(followInterval, func() { close(poll) })
+		select {
+		case <-recorded:
+		case <-poll:
+		case <-done:
+			// The engine cancels a run that waits right away, and one it resumed like any other
+			done = nil
+			_ = s.engine.Cancel(runID)
+		case <-stopped:
+			timer.Stop()
+			return state, nil
+		}
+		timer.Stop()
+	}
+}
+
+// ended reports whether a run with a status has ended
+func ended(status models.Status) bool {
+	switch status {
+	case models.RunRunning, models.RunWaiting, models.RunCompensating:
+		return false
+	}
+	return true
+}
+
+// report passes the outcome of a fire to the run handler
+func (s *Scheduler) report(name string, state *models.WorkflowState, err error) {
+	s.mu.Lock()
+	fn := s.onRun
+	s.mu.Unlock()
+
+	if fn != nil {
+		fn(name, state, err)
+	}
+}
+
+// names returns the names of the schedules, sorted. The lock must be held
+func (s *Scheduler) names() []string {
+	names := make([]string, 0, len(s.schedules))
+	for name := range s.schedules {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,478 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/clock"
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+	"github.com/mstgnz/goflow/pkg/workflow"
+)
+
+// HoldTask tells the test it has started, then holds its run until the test
+// releases it or the run's context is done
+type HoldTask struct {
+	started chan string
+	release chan struct{}
+}
+
+func (t *HoldTask) Name() string {
+	return "hold"
+}
+
+func (t *HoldTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	t.started <- params["label"]
+	select {
+	case <-t.release:
+		return map[string]any{"label": params["label"]}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// outcome is what the run handler of a scheduler received
+type outcome struct {
+	schedule string
+	state    *models.WorkflowState
+	err      error
+}
+
+// newTestScheduler returns a scheduler on a fake clock whose "report"
+// workflow holds its runs, along with the task, the clock and the outcomes
+func newTestScheduler(t *testing.T, st store.ScheduleStore) (*Scheduler, *HoldTask, *clock.Fake, <-chan outcome) {
+	t.Helper()
+
+	engine := workflow.NewEngine()
+	task := &HoldTask{started: make(chan string, MaxCatchUp), release: make(chan struct{})}
+	engine.RegisterTask(task)
+
+	fake := clock.NewFake(time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC))
+	engine.SetClock(fake)
+
+	definition := `
+name: report
+inputs:
+  - name: label
+    type: string
+    default: scheduled
+steps:
+  - id: hold
+    task: hold
+    params:
+      label: ${{ inputs.label }}
+`
+	if err := engine.LoadBytes([]byte(definition), workflow.FormatYAML); err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+
+	outcomes := make(chan outcome, MaxCatchUp)
+	s := New(engine, st)
+	s.SetRunHandler(func(schedule string, state *models.WorkflowState, err error) {
+		outcomes <- outcome{schedule, state, err}
+	})
+
+	t.Cleanup(s.Stop)
+	return s, task, fake, outcomes
+}
+
+// receive returns the next value of a channel, or fails the test when none arrives in time
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the scheduler")
+	}
+	var zero T
+	return zero
+}
+
+// expectNone fails the test when a channel receives a value
+func expectNone[T any](t *testing.T, ch <-chan T) {
+	t.Helper()
+	select {
+	case v := <-ch:
+		t.Errorf("Expected nothing, got %v", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestIntervalSchedule(t *testing.T) {
+	// Create a new scheduler that runs the workflow every hour with an input
+	st := store.NewMemoryStore()
+	s, task, fake, outcomes := newTestScheduler(t, st)
+	start := fake.Now()
+
+	err := s.Add(Schedule{Workflow: "report", Every: "1h", Inputs: map[string]any{"label": "hourly"}})
+	if err != nil {
+		t.Fatalf("Failed to add schedule: %v", err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start scheduler: %v", err)
+	}
+
+	if next, _ := s.Next("report"); !next.Equal(start.Add(time.Hour)) {
+		t.Errorf("Expected the first fire in 1h, got %s", next)
+	}
+
+	// Nothing runs before the first fire time
+	fake.Advance(59 * time.Minute)
+	expectNone(t, task.started)
+
+	fake.Advance(time.Minute)
+	if label := receive(t, task.started); label != "hourly" {
+		t.Errorf("Expected the schedule's input, got %s", label)
+	}
+	close(task.release)
+
+	result := receive(t, outcomes)
+	if result.err != nil || result.schedule != "report" {
+		t.Fatalf("Expected a run of report, got %s: %v", result.schedule, result.err)
+	}
+	if result.state.Status != models.RunCompleted {
+		t.Errorf("Expected status completed, got %s", result.state.Status)
+	}
+
+	// The fire time is stored and the next one is an hour later
+	if last, _ := st.LastFire("report"); !last.Equal(start.Add(time.Hour)) {
+		t.Errorf("Expected the last fire time to be stored, got %s", last)
+	}
+	if next, _ := s.Next("report"); !next.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("Expected the next fire in 2h, got %s", next)
+	}
+}
+
+func TestCronSchedule(t *testing.T) {
+	// Create a new scheduler that runs the workflow at 10:30 in Istanbul
+	s, task, fake, outcomes := newTestScheduler(t, store.NewMemoryStore())
+	close(task.release)
+
+	err := s.Add(Schedule{Name: "morning", Workflow: "report", Cron: "0 30 10 * * *", TimeZone: "Europe/Istanbul"})
+	if err != nil {
+		t.Fatalf("Failed to add schedule: %v", err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start scheduler: %v", err)
+	}
+
+	// 10:30 in Istanbul is 07:30 UTC the next day, as 09:00 UTC has passed
+	expected := time.Date(2025, 3, 2, 7, 30, 0, 0, time.UTC)
+	if next, _ := s.Next("morning"); !next.Equal(expected) {
+		t.Fatalf("Expected the first fire at %s, got %s", expected, next)
+	}
+
+	fake.Advance(expected.Sub(fake.Now()))
+	if result := receive(t, outcomes); result.schedule != "morning" || result.err != nil {
+		t.Errorf("Expected a run of morning, got %s: %v", result.schedule, result.err)
+	}
+	if next, _ := s.Next("morning"); !next.Equal(expected.AddDate(0, 0, 1)) {
+		t.Errorf("Expected the next fire a day later, got %s", next)
+	}
+}
+
+func TestOverlapSkip(t *testing.T) {
+	s, task, fake, outcomes := newTestScheduler(t, store.NewMemoryStore())
+	if err := s.Add(Schedule{Workflow: "report", Every: "1m"}); err != nil {
+		t.Fatalf("Failed to add schedule: %v", err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start scheduler: %v", err)
+	}
+
+	fake.Advance(time.Minute)
+	receive(t, task.started)
+
+	// The run is still holding, so the next fire is skipped
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	if result := receive(t, outcomes); !errors.Is(result.err, ErrOverlap) {
+		t.Errorf("Expected ErrOverlap, got %v", result.err)
+	}
+
+	close(task.release)
+	if result := receive(t, outcomes); result.err != nil {
+		t.Errorf("Expected the first run to complete, got %v", result.err)
+	}
+	expectNone(t, task.started)
+}
+
+func TestOverlapSkipWaitingRun(t *testing.T) {
+	s, _, fake, outcomes := newTestScheduler(t, store.NewMemoryStore())
+	definition := `
+name: approval
+steps:
+  - id: approve
+    wait_signal:
+      name: approve
+`
+	if err := s.engine.LoadBytes([]byte(definition), workflow.FormatYAML); err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+	if err := s.Add(Schedule{Workflow: "approval", Every: "1m"}); err != nil {
+		t.Fatalf("Failed to add schedule: %v", err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start scheduler: %v", err)
+	}
+
+	// The run waits for its signal, which counts as running, so the next fire is skipped
+	fake.Advance(time.Minute)
+	fake.BlockUntil(2)
+	runs, _ := s.engine.ListRuns(workflow.RunFilter{Status: models.RunWaiting})
+	if len(runs) != 1 {
+		t.Fatalf("Expected a waiting run, got %d", len(runs))
+	}
+	fake.Advance(time.Minute)
+	if result := receive(t, outcomes); !errors.Is(result.err, ErrOverlap) {
+		t.Errorf("Expected ErrOverlap, got %v", result.err)
+	}
+
+	// The run is reported once it ends, and the next fire starts a run
+	if err := s.engine.Signal(context.Background(), runs[0].RunID, "approve", nil); err != nil {
+		t.Fatalf("Failed to send signal: %v", err)
+	}
+	result := receive(t, outcomes)
+	if result.err != nil || result.state.RunID != runs[0].RunID || result.state.Status != models.RunCompleted {
+		t.Fatalf("Expected the waiting run to complete, got %+v (%v)", result.state, result.err)
+	}
+
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	fake.BlockUntil(2)
+	expectNone(t, outcomes)
+	if runs, _ := s.engine.ListRuns(workflow.RunFilter{Status: models.RunWaiting}); len(runs) != 1 || runs[0].RunID == result.state.RunID {
+		t.Errorf("Expected a new waiting run, got %d", len(runs))
+	}
+}
+
+func TestOverlapQueue(t *testing.T) {
+	s, task, fake, outcomes := newTestScheduler(t, store.NewMemoryStore())
+	if err := s.Add(Schedule{Workflow: "report", Every: "1m", Overlap: OverlapQueue}); err != nil {
+		t.Fatalf("Failed to add schedule: %v", err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start scheduler: %v", err)
+	}
+
+	fake.Advance(time.Minute)
+	receive(t, task.started)
+
+	// Two fires are queued behind the holding run
+	for range 2 {
+		fake.BlockUntil(1)
+		fake.Advance(time.Minute)
+	}
+	expectNone(t, task.started)
+
+	// The queued runs start one after another
+	for i := range 3 {
+		if i > 0 {
+			receive(t, task.started)
+		}
+		task.release <- struct{}{}
+		if result := receive(t, outcomes); result.err != nil {
+			t.Errorf("Expected run %d to complete, got %v", i+1, result.err)
+		}
+	}
+	expectNone(t, task.started)
+}
+
+func TestOverlapCancelPrevious(t *testing.T) {
+	s, task, fake, outcomes := newTestScheduler(t, store.NewMemoryStore())
+	if err := s.Add(Schedule{Workflow: "report", Every: "1m", Overlap: OverlapCancelPrevious}); err != nil {
+		t.Fatalf("Failed to add schedule: %v", err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start scheduler: %v", err)
+	}
+
+	fake.Advance(time.Minute)
+	receive(t, task.started)
+
+	// The next fire cancels the holding run, then starts its own
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+
+	result := receive(t, outcomes)
+	if result.state == nil || result.state.Status != models.RunCancelled {
+		t.Fatalf("Expected the previous run to be cancelled, got %v", result.err)
+	}
+
+	receive(t, task.started)
+	task.release <- struct{}{}
+	if result := receive(t, outcomes); result.err != nil || result.state.Status != models.RunCompleted {
+		t.Errorf("Expected the new run to complete, got %v", result.err)
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	tests := []struct {
+		catchUp  string
+		expected int
+	}{
+		{CatchUpNone, 0},
+		{CatchUpLast, 1},
+		{CatchUpAll, 3},
+	}
+
+	for _, tt := range tests {
+		// The schedule last fired 3 hours and a half ago, so it missed 3 fires
+		st := store.NewMemoryStore()
+		s, task, fake, outcomes := newTestScheduler(t, st)
+		close(task.release)
+
+		last := fake.Now().Add(-210 * time.Minute)
+		if err := st.SaveLastFire("report", last); err != nil {
+			t.Fatalf("Failed to save last fire time: %v", err)
+		}
+
+		if err := s.Add(Schedule{Workflow: "report", Every: "1h", CatchUp: tt.catchUp}); err != nil {
+			t.Fatalf("Failed to add schedule: %v", err)
+		}
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatalf("Failed to start scheduler: %v", err)
+		}
+
+		for range tt.expected {
+			if result := receive(t, outcomes); result.err != nil {
+				t.Errorf("%s: expected catch-up run to complete, got %v", tt.catchUp, result.err)
+			}
+		}
+		expectNone(t, outcomes)
+
+		// The schedule keeps its rhythm, firing an hour after the last missed fire
+		if next, _ := s.Next("report"); !next.Equal(last.Add(4 * time.Hour)) {
+			t.Errorf("%s: expected the next fire at %s, got %s", tt.catchUp, last.Add(4*time.Hour), next)
+		}
+
+		stored, _ := st.LastFire("report")
+		expectedLast := last
+		if tt.expected > 0 {
+			expectedLast = last.Add(3 * time.Hour)
+		}
+		if !stored.Equal(expectedLast) {
+			t.Errorf("%s: expected last fire time %s, got %s", tt.catchUp, expectedLast, stored)
+		}
+
+		s.Stop()
+	}
+}
+
+// countingTiming counts how often a timing is asked for a fire time
+type countingTiming struct {
+	timing
+	calls int
+}
+
+func (c *countingTiming) Next(after time.Time) time.Time {
+	c.calls++
+	return c.timing.Next(after)
+}
+
+func TestCatchUpStart(t *testing.T) {
+	everySecond, err := ParseCron("* * * * * *", time.UTC)
+	if err != nil {
+		t.Fatalf("Failed to parse cron: %v", err)
+	}
+	daily, err := ParseCron("0 12 * * *", time.UTC)
+	if err != nil {
+		t.Fatalf("Failed to parse cron: %v", err)
+	}
+
+	now := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		timing timing
+		last   time.Time
+		latest time.Time
+		missed int
+	}{
+		{"interval after a year", interval(time.Second), now.Add(-365 * 24 * time.Hour), now, MaxCatchUp},
+		{"cron after a year", everySecond, now.Add(-365 * 24 * time.Hour), now, MaxCatchUp},
+		{"interval after a few fires", interval(time.Hour), now.Add(-210 * time.Minute), now.Add(-30 * time.Minute), 3},
+		{"sparse cron", daily, now.Add(-72 * time.Hour), time.Date(2025, 2, 28, 12, 0, 0, 0, time.UTC), 3},
+	}
+
+	for _, tt := range tests {
+		// Walk the missed fire times the way activate does, counting the steps
+		c := &countingTiming{timing: tt.timing}
+		var missed []time.Time
+		for next := c.Next(catchUpStart(c, tt.last, now)); !next.IsZero() && !next.After(now); next = c.Next(next) {
+			missed = append(missed, next)
+		}
+
+		if len(missed) < tt.missed {
+			t.Errorf("%s: expected at least %d missed fire times, got %d", tt.name, tt.missed, len(missed))
+			continue
+		}
+		if latest := missed[len(missed)-1]; !latest.Equal(tt.latest) {
+			t.Errorf("%s: expected the latest missed fire at %s, got %s", tt.name, tt.latest, latest)
+		}
+		if c.calls > 10*MaxCatchUp {
+			t.Errorf("%s: expected a bounded walk, got %d calls", tt.name, c.calls)
+		}
+	}
+}
+
+func TestRemoveSchedule(t *testing.T) {
+	s, task, fake, _ := newTestScheduler(t, store.NewMemoryStore())
+	if err := s.Add(Schedule{Workflow: "report", Every: "1m"}); err != nil {
+		t.Fatalf("Failed to add schedule: %v", err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start scheduler: %v", err)
+	}
+
+	if !s.Remove("report") {
+		t.Fatal("Expected the schedule to be removed")
+	}
+	if s.Remove("report") {
+		t.Error("Expected removing an unknown schedule to fail")
+	}
+	if len(s.Schedules()) != 0 {
+		t.Errorf("Expected no schedules, got %d", len(s.Schedules()))
+	}
+
+	fake.Advance(time.Minute)
+	expectNone(t, task.started)
+}
+
+func TestScheduleValidation(t *testing.T) {
+	s, _, _, _ := newTestScheduler(t, store.NewMemoryStore())
+
+	tests := []struct {
+		schedule Schedule
+		message  string
+	}{
+		{Schedule{Workflow: "missing", Every: "1m"}, "workflow not found: missing"},
+		{Schedule{Workflow: "report"}, "needs cron or every"},
+		{Schedule{Workflow: "report", Cron: "* * * * *", Every: "1m"}, "cannot be combined"},
+		{Schedule{Workflow: "report", Every: "10ms"}, "every must be at least 1s"},
+		{Schedule{Workflow: "report", Every: "soon"}, "every: time: invalid duration"},
+		{Schedule{Workflow: "report", Every: "1m", TimeZone: "UTC"}, "time_zone only applies to cron expressions"},
+		{Schedule{Workflow: "report", Cron: "* * * *"}, "must have 5 or 6 fields"},
+		{Schedule{Workflow: "report", Cron: "* * * * *", TimeZone: "Mars/Olympus"}, "time_zone:"},
+		{Schedule{Workflow: "report", Every: "1m", Overlap: "wait"}, `invalid overlap policy "wait"`},
+		{Schedule{Workflow: "report", Every: "1m", CatchUp: "some"}, `invalid catch-up policy "some"`},
+	}
+
+	for _, tt := range tests {
+		err := s.Add(tt.schedule)
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("Expected error %q, got %v", tt.message, err)
+		}
+	}
+
+	// Schedule names are unique
+	if err := s.Add(Schedule{Workflow: "report", Every: "1m"}); err != nil {
+		t.Fatalf("Failed to add schedule: %v", err)
+	}
+	if err := s.Add(Schedule{Name: "report", Workflow: "report", Cron: "@daily"}); err == nil {
+		t.Error("Expected an error for a duplicate schedule name")
+	}
+}
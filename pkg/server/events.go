@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// handleEvents returns the event log of a run
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := s.engine.Events(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// handleEventStream streams the events of a run as Server-Sent Events, the
+// ones recorded so far first. Each event has its sequence number as ID, so a
+// client that reconnects with Last-Event-ID gets the events it missed. The
+// stream ends after the run_finished event, or when the server shuts down
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	runID := r.PathValue("id")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	last := 0
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		if last, err = strconv.Atoi(id); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID %q", id))
+			return
+		}
+	}
+
+	// Watch the run before reading its events, so none is missed in between
+	recorded, stop := s.engine.WatchEvents(runID)
+	defer stop()
+
+	events, err := s.engine.Events(runID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		for _, event := range events {
+			if event.Seq <= last {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			last = event.Seq
+		}
+		flusher.Flush()
+
+		if len(events) > 0 && events[len(events)-1].Type == models.EventRunFinished {
+			return
+		}
+
+		select {
+		case <-recorded:
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		}
+
+		if events, err = s.engine.Events(runID); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes an event of a run as a Server-Sent Event
+func writeEvent(w io.Writer, event models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+	return err
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// readStream reads the Server-Sent Events of a stream until it ends
+func readStream(t *testing.T, resp *http.Response) []models.Event {
+	t.Helper()
+
+	var events []models.Event
+	var id, name string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		field, value, _ := strings.Cut(scanner.Text(), ": ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			name = value
+		case "data":
+			var event models.Event
+			if err := json.Unmarshal([]byte(value), &event); err != nil {
+				t.Fatalf("Failed to decode event: %v", err)
+			}
+			if event.Type != name || id != strconv.Itoa(event.Seq) {
+				t.Errorf("Expected the event's type and seq as name and ID, got %s and %s", name, id)
+			}
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestEvents(t *testing.T) {
+	_, _, ts := newTestServer(t)
+
+	var state models.WorkflowState
+	request(t, ts, http.MethodPost, "/workflows/greet/runs", "", `{"inputs": {"who": "a"}}`, &state)
+	waitForRun(t, ts, state.RunID, models.RunRunning)
+
+	var events []models.Event
+	if status := request(t, ts, http.MethodGet, "/runs/"+state.RunID+"/events", "", "", &events); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if len(events) == 0 || events[0].Type != models.EventRunStarted || events[len(events)-1].Type != models.EventRunFinished {
+		t.Errorf("Expected the events from run_started to run_finished, got %d events", len(events))
+	}
+
+	if status := request(t, ts, http.MethodGet, "/runs/unknown/events", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", status)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	_, _, ts := newTestServer(t)
+
+	// Open a stream on a run that waits for a signal
+	var state models.WorkflowState
+	request(t, ts, http.MethodPost, "/workflows/approval/runs", "", "", &state)
+	waitForRun(t, ts, state.RunID, models.RunRunning)
+
+	resp, err := ts.Client().Get(ts.URL + "/runs/" + state.RunID + "/events/stream")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %s", contentType)
+	}
+
+	// The stream follows the run until it finishes
+	if status := request(t, ts, http.MethodPost, "/runs/"+state.RunID+"/signals/approve", "", "", nil); status != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+	events := readStream(t, resp)
+	if len(events) == 0 {
+		t.Fatal("Expected events")
+	}
+	for i, event := range events {
+		if event.Seq != i+1 {
+			t.Errorf("Expected event %d to have seq %d, got %d", i, i+1, event.Seq)
+		}
+	}
+	if last := events[len(events)-1]; last.Type != models.EventRunFinished || last.Status != models.RunCompleted {
+		t.Errorf("Expected the stream to end with the run finishing, got %s %s", last.Type, last.Status)
+	}
+
+	// Reconnecting with Last-Event-ID only sends the events after it
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/runs/"+state.RunID+"/events/stream", nil)
+	req.Header.Set("Last-Event-ID", "2")
+	resp, err = ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if again := readStream(t, resp); len(again) != len(events)-2 || again[0].Seq != 3 {
+		t.Errorf("Expected the events after seq 2, got %d events", len(again))
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/workflow"
+)
+
+// startRunRequest is the body of a request that starts a run
+type startRunRequest struct {
+	Inputs map[string]any `json:"inputs"`
+}
+
+// handleStartRun starts a run of a workflow in the background and returns
+// its state right after it started. A request with an Idempotency-Key header
+// whose key already started a run of the workflow returns that run instead
+func (s *Server) handleStartRun(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := s.engine.Workflow(name); !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("workflow not found: %s", name))
+		return
+	}
+
+	var req startRunRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// The run outlives the request, shutting down the server cancels it
+	runID, started, err := s.engine.StartOnce(context.Background(), name, req.Inputs, r.Header.Get("Idempotency-Key"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !started {
+		s.writeRun(w, http.StatusOK, runID)
+		return
+	}
+	s.writeRun(w, http.StatusAccepted, runID)
+}
+
+// handleListRuns lists the runs, filtered by the workflow, status and
+// parent_run_id query parameters, in the order they started
+func (s *Server) handleListRuns(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	runs, err := s.engine.ListRuns(workflow.RunFilter{
+		WorkflowName: query.Get("workflow"),
+		Status:       models.Status(query.Get("status")),
+		ParentRunID:  query.Get("parent_run_id"),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if runs == nil {
+		runs = []*models.WorkflowState{}
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// handleGetRun returns the state of a run
+func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	s.writeRun(w, http.StatusOK, r.PathValue("id"))
+}
+
+// handleCancelRun cancels a run. The run ends in the background, so the
+// returned state may still be running
+func (s *Server) handleCancelRun(w http.ResponseWriter, r *http.Request) {
+	runID := r.PathValue("id")
+	if _, err := s.engine.GetRun(runID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := s.engine.Cancel(runID); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	s.writeRun(w, http.StatusAccepted, runID)
+}
+
+// handleSignal sends a signal to a run, with the JSON object in the body as
+// its payload. The run goes on in the background once a step took the signal
+func (s *Server) handleSignal(w http.ResponseWriter, r *http.Request) {
+	runID, name := r.PathValue("id"), r.PathValue("name")
+	if _, err := s.engine.GetRun(runID); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	var payload map[string]any
+	if err := readJSON(w, r, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.engine.Signal(r.Context(), runID, name, payload); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	s.writeRun(w, http.StatusAccepted, runID)
+}
+
+// writeRun writes the state of a run
+func (s *Server) writeRun(w http.ResponseWriter, status int, runID string) {
+	state, err := s.engine.GetRun(runID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if status == http.StatusAccepted {
+		w.Header().Set("Location", "/runs/"+runID)
+	}
+	writeJSON(w, status, state)
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+)
+
+func TestStartRun(t *testing.T) {
+	_, _, ts := newTestServer(t)
+
+	// Start a run with inputs, then follow it until it completes
+	var state models.WorkflowState
+	status := request(t, ts, http.MethodPost, "/workflows/greet/runs", "application/json", `{"inputs": {"who": "world"}}`, &state)
+	if status != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+	if state.RunID == "" || state.WorkflowName != "greet" {
+		t.Fatalf("Expected a run of greet, got %+v", state)
+	}
+
+	final := waitForRun(t, ts, state.RunID, models.RunRunning)
+	if final.Status != models.RunCompleted {
+		t.Fatalf("Expected status completed, got %s", final.Status)
+	}
+	if final.Outputs["greeting"] != "hello world" {
+		t.Errorf("Expected greeting hello world, got %v", final.Outputs["greeting"])
+	}
+
+	// Runs of unknown workflows or with invalid inputs do not start
+	if status := request(t, ts, http.MethodPost, "/workflows/missing/runs", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown workflow, got %d", status)
+	}
+	if status := request(t, ts, http.MethodPost, "/workflows/greet/runs", "", `{}`, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a missing input, got %d", status)
+	}
+	if status := request(t, ts, http.MethodPost, "/workflows/greet/runs", "", `{"inputs": `, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid JSON, got %d", status)
+	}
+	if status := request(t, ts, http.MethodGet, "/runs/unknown", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown run, got %d", status)
+	}
+}
+
+func TestStartRunIdempotencyKey(t *testing.T) {
+	_, _, ts := newTestServer(t)
+
+	start := func(key string) (models.WorkflowState, int) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/workflows/greet/runs", strings.NewReader(`{"inputs": {"who": "world"}}`))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Idempotency-Key", key)
+
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var state models.WorkflowState
+		if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return state, resp.StatusCode
+	}
+
+	first, status := start("k-1")
+	if status != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+
+	// A retried request returns the run the key started
+	again, status := start("k-1")
+	if status != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", status)
+	}
+	if again.RunID != first.RunID {
+		t.Errorf("Expected run %s, got %s", first.RunID, again.RunID)
+	}
+
+	if other, status := start("k-2"); status != http.StatusAccepted || other.RunID == first.RunID {
+		t.Errorf("Expected a new run for another key, got %s with status %d", other.RunID, status)
+	}
+}
+
+func TestTriggers(t *testing.T) {
+	engine, _, ts := newTestServer(t)
+
+	// Triggers of uploaded workflows are served under /hooks
+	definition := `{"name": "hooked", "inputs": [{"name": "who", "type": "string"}],
+ "triggers": [{"path": "/hello", "wait": "5s"}], "steps": [{"id": "a", "task": "echo"}]}`
+	if status := request(t, ts, http.MethodPost, "/workflows", "application/json", definition, nil); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+
+	var resp struct {
+		RunID  string        `json:"run_id"`
+		Status models.Status `json:"status"`
+	}
+	if status := request(t, ts, http.MethodPost, "/hooks/hello", "application/json", `{"who": "world"}`, &resp); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if resp.Status != models.RunCompleted {
+		t.Errorf("Expected status completed, got %s", resp.Status)
+	}
+
+	state, err := engine.GetRun(resp.RunID)
+	if err != nil {
+		t.Fatalf("Failed to get run: %v", err)
+	}
+	if state.WorkflowName != "hooked" || state.Inputs["who"] != "world" {
+		t.Errorf("Expected a run of hooked with who world, got %s with %v", state.WorkflowName, state.Inputs)
+	}
+
+	if status := request(t, ts, http.MethodPost, "/hooks/missing", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", status)
+	}
+}
+
+// failingStore fails to record events
+type failingStore struct {
+	*store.MemoryStore
+}
+
+func (s failingStore) AppendEvent(event models.Event) error {
+	return errors.New("disk full")
+}
+
+func TestStartRunStoreError(t *testing.T) {
+	engine, _, ts := newTestServer(t)
+	engine.SetStateStore(failingStore{store.NewMemoryStore()})
+
+	// Runs that cannot be stored are the server's fault, not the client's
+	var body errorResponse
+	if status := request(t, ts, http.MethodPost, "/workflows/greet/runs", "", `{"inputs": {"who": "world"}}`, &body); status != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", status)
+	}
+	if !strings.Contains(body.Error, "disk full") {
+		t.Errorf("Expected the store's error, got %q", body.Error)
+	}
+}
+
+func TestTriggerSecretNotReadable(t *testing.T) {
+	engine, _, ts := newTestServer(t)
+	t.Setenv("TEST_HOOK_SECRET", "s3cret")
+
+	// A workflow verifies the deliveries to its trigger with the secret
+	hooked := `{"name": "hooked", "triggers": [{"path": "/orders", "secret_env": "TEST_HOOK_SECRET"}], "steps": [{"id": "a", "task": "echo"}]}`
+	if status := request(t, ts, http.MethodPost, "/workflows", "application/json", hooked, nil); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+
+	// Another uploaded workflow cannot read the secret, even when its
+	// variable is on the allowlist
+	leak := `{"name": "leak", "outputs": {"secret": "env.TEST_HOOK_SECRET"},
+ "steps": [{"id": "a", "task": "echo", "params": {"secret": "${{ default(env.TEST_HOOK_SECRET, \"none\") }}"}}]}`
+	if status := request(t, ts, http.MethodPost, "/workflows", "application/json", leak, nil); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+
+	for _, allowlist := range [][]string{nil, {"TEST_HOOK_SECRET"}} {
+		if allowlist != nil {
+			engine.SetEnvAllowlist(allowlist...)
+		}
+
+		var state models.WorkflowState
+		request(t, ts, http.MethodPost, "/workflows/leak/runs", "", "", &state)
+		final := waitForRun(t, ts, state.RunID, models.RunRunning)
+
+		if final.Outputs["secret"] != nil || final.StepResults["a"].Data["secret"] != "none" {
+			t.Errorf("Expected the secret not to be readable with allowlist %v, got %v and %v", allowlist, final.Outputs["secret"], final.StepResults["a"].Data["secret"])
+		}
+	}
+}
+
+func TestListRuns(t *testing.T) {
+	_, _, ts := newTestServer(t)
+
+	var greet, approval models.WorkflowState
+	request(t, ts, http.MethodPost, "/workflows/greet/runs", "", `{"inputs": {"who": "a"}}`, &greet)
+	request(t, ts, http.MethodPost, "/workflows/approval/runs", "", "", &approval)
+	waitForRun(t, ts, greet.RunID, models.RunRunning)
+	waitForRun(t, ts, approval.RunID, models.RunRunning)
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{greet.RunID, approval.RunID}},
+		{"?workflow=greet", []string{greet.RunID}},
+		{"?status=waiting", []string{approval.RunID}},
+		{"?workflow=greet&status=waiting", []string{}},
+	}
+	for _, tt := range tests {
+		var runs []models.WorkflowState
+		if status := request(t, ts, http.MethodGet, "/runs"+tt.query, "", "", &runs); status != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", status)
+		}
+		if len(runs) != len(tt.expected) {
+			t.Errorf("Expected %d runs for %q, got %d", len(tt.expected), tt.query, len(runs))
+			continue
+		}
+		for i, run := range runs {
+			if run.RunID != tt.expected[i] {
+				t.Errorf("Expected run %s for %q, got %s", tt.expected[i], tt.query, run.RunID)
+			}
+		}
+	}
+}
+
+func TestCancelRun(t *testing.T) {
+	_, block, ts := newTestServer(t)
+
+	var state models.WorkflowState
+	request(t, ts, http.MethodPost, "/workflows/block/runs", "", "", &state)
+	<-block.started
+
+	if status := request(t, ts, http.MethodPost, "/runs/"+state.RunID+"/cancel", "", "", nil); status != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+	if final := waitForRun(t, ts, state.RunID, models.RunRunning); final.Status != models.RunCancelled {
+		t.Errorf("Expected status cancelled, got %s", final.Status)
+	}
+
+	// A run that ended cannot be cancelled
+	if status := request(t, ts, http.MethodPost, "/runs/"+state.RunID+"/cancel", "", "", nil); status != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", status)
+	}
+	if status := request(t, ts, http.MethodPost, "/runs/unknown/cancel", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", status)
+	}
+}
+
+func TestSignal(t *testing.T) {
+	_, _, ts := newTestServer(t)
+
+	var state models.WorkflowState
+	request(t, ts, http.MethodPost, "/workflows/approval/runs", "", "", &state)
+	waitForRun(t, ts, state.RunID, models.RunRunning)
+
+	// A signal no step waits for is rejected
+	if status := request(t, ts, http.MethodPost, "/runs/"+state.RunID+"/signals/reject", "", "", nil); status != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", status)
+	}
+	if status := request(t, ts, http.MethodPost, "/runs/"+state.RunID+"/signals/approve", "", `[1]`, nil); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a payload that is not an object, got %d", status)
+	}
+
+	status := request(t, ts, http.MethodPost, "/runs/"+state.RunID+"/signals/approve", "application/json", `{"by": "alice"}`, nil)
+	if status != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+
+	final := waitForRun(t, ts, state.RunID, models.RunRunning)
+	if final.Status != models.RunCompleted {
+		t.Fatalf("Expected status completed, got %s", final.Status)
+	}
+	if by := final.StepResults["approval"].Data["by"]; by != "alice" {
+		t.Errorf("Expected the payload as the step's data, got %v", by)
+	}
+}
+
+func TestInvalidRunID(t *testing.T) {
+	fileStore, err := store.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+
+	for name, s := range map[string]store.StateStore{"memory": store.NewMemoryStore(), "file": fileStore} {
+		engine, _, ts := newTestServer(t)
+		engine.SetStateStore(s)
+
+		for _, tt := range []struct{ method, path string }{
+			{http.MethodGet, "/runs/bad.id"},
+			{http.MethodGet, "/runs/bad.id/events"},
+			{http.MethodGet, "/runs/bad.id/events/stream"},
+			{http.MethodPost, "/runs/bad.id/cancel"},
+			{http.MethodPost, "/runs/bad.id/signals/approve"},
+		} {
+			if status := request(t, ts, tt.method, tt.path, "", "", nil); status != http.StatusNotFound {
+				t.Errorf("%s store: expected status 404 for %s %s, got %d", name, tt.method, tt.path, status)
+			}
+		}
+	}
+}
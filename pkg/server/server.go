@@ -0,0 +1,245 @@
+// Package server serves an HTTP/JSON API for a workflow engine, to load
+// workflow definitions, start and follow runs, and send them signals. The
+// triggers the workflows declare are served under /hooks
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+	"github.com/mstgnz/goflow/pkg/trigger"
+	"github.com/mstgnz/goflow/pkg/workflow"
+)
+
+// DefaultShutdownTimeout is how long a shutdown waits for requests and runs
+// to end before it cancels the runs that are still running
+const DefaultShutdownTimeout = 30 * time.Second
+
+// maxBodySize bounds the request bodies, such as workflow definitions
+const maxBodySize = 4 << 20
+
+//go:embed openapi.json
+var openAPI []byte
+
+// Server serves the API of an engine. It implements http.Handler, and
+// ListenAndServe serves it until its context is cancelled
+type Server struct {
+	engine *workflow.Engine
+	mux    *http.ServeMux
+
+	mu              sync.Mutex
+	shutdownTimeout time.Duration
+	allowUpload     bool
+	token           string
+	closing         chan struct{} // closed when the server shuts down, which ends event streams
+	closeOnce       sync.Once
+}
+
+// New creates a server for an engine
+func New(engine *workflow.Engine) *Server {
+	s := &Server{
+		engine:          engine,
+		mux:             http.NewServeMux(),
+		shutdownTimeout: DefaultShutdownTimeout,
+		closing:         make(chan struct{}),
+	}
+
+	s.mux.HandleFunc("GET /openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc("GET /workflows", s.handleListWorkflows)
+	s.mux.HandleFunc("POST /workflows", s.handleUploadWorkflow)
+	s.mux.HandleFunc("GET /workflows/{name}", s.handleGetWorkflow)
+	s.mux.HandleFunc("POST /workflows/{name}/runs", s.handleStartRun)
+	s.mux.HandleFunc("GET /runs", s.handleListRuns)
+	s.mux.HandleFunc("GET /runs/{id}", s.handleGetRun)
+	s.mux.HandleFunc("POST /runs/{id}/cancel", s.handleCancelRun)
+	s.mux.HandleFunc("POST /runs/{id}/signals/{name}", s.handleSignal)
+	s.mux.HandleFunc("GET /runs/{id}/events", s.handleEvents)
+	s.mux.HandleFunc("GET /runs/{id}/events/stream", s.handleEventStream)
+	s.mux.Handle("/hooks/", http.StripPrefix("/hooks", trigger.NewHandler(engine)))
+
+	return s
+}
+
+// SetShutdownTimeout sets how long a shutdown waits for requests and runs to
+// end before it cancels the runs that are still running
+func (s *Server) SetShutdownTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdownTimeout = d
+}
+
+// SetAllowUpload sets whether clients can load workflow definitions with
+// POST /workflows. Uploads are not allowed by default: an uploaded workflow
+// runs with the engine's tasks, and its expressions can read the environment
+// variables the engine allows, see workflow.Engine.SetEnvAllowlist
+func (s *Server) SetAllowUpload(allow bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allowUpload = allow
+}
+
+// SetToken sets the bearer token that requests must send in their
+// Authorization header. Without a token, which is the default, every client
+// that can reach the server can use the API. Triggers under /hooks do not
+// need the token, since senders of webhooks sign their bodies instead
+func (s *Server) SetToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// ServeHTTP serves a request of the API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized reports whether a request may use the API, see SetToken
+func (s *Server) authorized(r *http.Request) bool {
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+
+	if token == "" || strings.HasPrefix(r.URL.Path, "/hooks/") {
+		return true
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// ListenAndServe serves the API on an address until ctx is cancelled, then
+// shuts down gracefully, see Serve
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, l)
+}
+
+// Serve serves the API on a listener until ctx is cancelled. It then stops
+// accepting requests, ends the event streams, and waits for the requests and
+// the runs that are still running to end. Runs that do not end within the
+// shutdown timeout are cancelled, so a file store can resume them later
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	timeout := s.shutdownTimeout
+	s.mu.Unlock()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	s.closeOnce.Do(func() { close(s.closing) })
+	err := srv.Shutdown(shutdownCtx)
+	if runsErr := s.drainRuns(shutdownCtx); err == nil {
+		err = runsErr
+	}
+
+	// Serve returns http.ErrServerClosed once Shutdown is called
+	<-served
+	return err
+}
+
+// drainRuns waits for the runs that are executing to end, and cancels the
+// ones that are still running when ctx is done
+func (s *Server) drainRuns(ctx context.Context) error {
+	runs, err := s.engine.ListRuns(workflow.RunFilter{})
+	if err != nil {
+		return err
+	}
+
+	var running []string
+	for _, state := range runs {
+		if state.Status == models.RunRunning || state.Status == models.RunCompensating {
+			running = append(running, state.RunID)
+		}
+	}
+
+	for _, runID := range running {
+		if _, err := s.engine.Wait(ctx, runID); err == nil {
+			continue
+		}
+
+		// The timeout passed, so cancel the run and wait for its grace period
+		_ = s.engine.Cancel(runID)
+		_, _ = s.engine.Wait(context.Background(), runID)
+	}
+	return nil
+}
+
+// handleOpenAPI serves the OpenAPI document of the API
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openAPI)
+}
+
+// errorResponse is the body of a response to a request that failed
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes a JSON error response. Errors about unknown runs are
+// reported as 404 Not Found, invalid run inputs as 400 Bad Request, and runs
+// that another process is executing as 409 Conflict
+func writeError(w http.ResponseWriter, status int, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, workflow.ErrInvalidInputs):
+		status = http.StatusBadRequest
+	case errors.Is(err, store.ErrLocked):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// readJSON decodes a JSON request body into v. An empty body leaves v as it is
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+	return nil
+}
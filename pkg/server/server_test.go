@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/workflow"
+)
+
+// EchoTask returns its params as data
+type EchoTask struct{}
+
+func (t *EchoTask) Name() string {
+	return "echo"
+}
+
+func (t *EchoTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	data := make(map[string]any, len(params))
+	for name, value := range params {
+		data[name] = value
+	}
+	return data, nil
+}
+
+// BlockTask blocks until its run is cancelled
+type BlockTask struct {
+	started chan struct{}
+}
+
+func (t *BlockTask) Name() string {
+	return "block"
+}
+
+func (t *BlockTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	t.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// testWorkflows are loaded by newTestServer: "greet" echoes its input,
+// "approval" waits for a signal and "block" blocks until it is cancelled
+const testWorkflows = `[
+{"name": "greet", "inputs": [{"name": "who", "type": "string", "required": true}],
+ "outputs": {"greeting": "steps.greet.data.text"},
+ "steps": [{"id": "greet", "task": "echo", "params": {"text": "hello ${{ inputs.who }}"}}]},
+{"name": "approval",
+ "steps": [{"id": "approval", "wait_signal": {"name": "approve"}, "next": ["done"]}, {"id": "done", "task": "echo"}]},
+{"name": "block", "steps": [{"id": "block", "task": "block"}]}
+]`
+
+// newTestServer returns an engine with the test workflows loaded, served by
+// a test HTTP server that allows uploads
+func newTestServer(t *testing.T) (*workflow.Engine, *BlockTask, *httptest.Server) {
+	t.Helper()
+
+	engine := workflow.NewEngine()
+	engine.RegisterTask(&EchoTask{})
+	block := &BlockTask{started: make(chan struct{}, 1)}
+	engine.RegisterTask(block)
+	engine.SetGracePeriod(0)
+
+	var definitions []json.RawMessage
+	if err := json.Unmarshal([]byte(testWorkflows), &definitions); err != nil {
+		t.Fatalf("Failed to decode test workflows: %v", err)
+	}
+	for _, definition := range definitions {
+		if err := engine.LoadBytes(definition, workflow.FormatJSON); err != nil {
+			t.Fatalf("Failed to load workflow: %v", err)
+		}
+	}
+
+	s := New(engine)
+	s.SetAllowUpload(true)
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return engine, block, ts
+}
+
+// request sends a request to the test server and decodes the JSON response
+// into out, if it is not nil. It returns the status code
+func request(t *testing.T, ts *httptest.Server, method, path, contentType, body string, out any) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Failed to decode response of %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// waitForRun polls a run until it leaves a status
+func waitForRun(t *testing.T, ts *httptest.Server, runID string, status models.Status) *models.WorkflowState {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var state models.WorkflowState
+		request(t, ts, http.MethodGet, "/runs/"+runID, "", "", &state)
+		if state.Status != status {
+			return &state
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("Expected run %s to leave status %s", runID, status)
+	return nil
+}
+
+func TestOpenAPI(t *testing.T) {
+	_, _, ts := newTestServer(t)
+
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	if status := request(t, ts, http.MethodGet, "/openapi.json", "", "", &doc); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("Expected an OpenAPI 3 document, got %q", doc.OpenAPI)
+	}
+
+	// Every route of the server is documented
+	routes := []string{
+		"GET /openapi.json", "GET /workflows", "POST /workflows", "GET /workflows/{name}",
+		"POST /workflows/{name}/runs", "GET /runs", "GET /runs/{id}", "POST /runs/{id}/cancel",
+		"POST /runs/{id}/signals/{name}", "GET /runs/{id}/events", "GET /runs/{id}/events/stream", "POST /hooks/{path}",
+	}
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("Expected %s to be documented", route)
+		}
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	// Create a new server with a run that blocks
+	engine := workflow.NewEngine()
+	block := &BlockTask{started: make(chan struct{}, 1)}
+	engine.RegisterTask(block)
+	engine.SetGracePeriod(0)
+	if err := engine.LoadBytes([]byte(`{"name": "block", "steps": [{"id": "block", "task": "block"}]}`), workflow.FormatJSON); err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+
+	s := New(engine)
+	s.SetShutdownTimeout(50 * time.Millisecond)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(ctx, l)
+	}()
+	base := "http://" + l.Addr().String()
+
+	resp, err := http.Post(base+"/workflows/block/runs", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Failed to start run: %v", err)
+	}
+	var state models.WorkflowState
+	_ = json.NewDecoder(resp.Body).Decode(&state)
+	resp.Body.Close()
+	<-block.started
+
+	// An event stream is open while the server shuts down
+	stream, err := http.Get(base + "/runs/" + state.RunID + "/events/stream")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer stream.Body.Close()
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the server to shut down")
+	}
+
+	// The stream ended, and the run that outlived the timeout was cancelled
+	if _, err := io.ReadAll(stream.Body); err != nil {
+		t.Errorf("Expected the stream to end, got %v", err)
+	}
+	final, err := engine.GetRun(state.RunID)
+	if err != nil {
+		t.Fatalf("Failed to get run: %v", err)
+	}
+	if final.Status != models.RunCancelled {
+		t.Errorf("Expected status cancelled, got %s", final.Status)
+	}
+}
+
+func TestToken(t *testing.T) {
+	engine, _, _ := newTestServer(t)
+	if err := engine.LoadBytes([]byte(`{"name": "hooked", "triggers": [{"path": "/orders"}], "steps": [{"id": "a", "task": "echo"}]}`), workflow.FormatJSON); err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+	s := New(engine)
+	s.SetToken("s3cret")
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	tests := []struct {
+		method        string
+		path          string
+		authorization string
+		status        int
+	}{
+		{http.MethodGet, "/workflows", "", http.StatusUnauthorized},
+		{http.MethodGet, "/workflows", "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodGet, "/workflows", "s3cret", http.StatusUnauthorized},
+		{http.MethodGet, "/workflows", "Bearer s3cret", http.StatusOK},
+		{http.MethodPost, "/runs/unknown/cancel", "", http.StatusUnauthorized},
+		// Triggers check their own signatures instead
+		{http.MethodPost, "/hooks/orders", "", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(`{}`))
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("Expected status %d for %s %s with %q, got %d", tt.status, tt.method, tt.path, tt.authorization, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/mstgnz/goflow/pkg/workflow"
+)
+
+// handleListWorkflows lists the loaded workflow definitions, sorted by name
+func (s *Server) handleListWorkflows(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.engine.Workflows())
+}
+
+// handleGetWorkflow returns a loaded workflow definition
+func (s *Server) handleGetWorkflow(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	wf, ok := s.engine.Workflow(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("workflow not found: %s", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, wf)
+}
+
+// handleUploadWorkflow loads a workflow definition from the body, in JSON or
+// in YAML when the content type says so. A definition with the name of a
+// loaded workflow replaces it, runs that already started keep their
+// definition. Uploads are forbidden unless the server allows them
+func (s *Server) handleUploadWorkflow(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	allowed := s.allowUpload
+	s.mu.Unlock()
+	if !allowed {
+		writeError(w, http.StatusForbidden, errors.New("workflow uploads are not allowed"))
+		return
+	}
+
+	format := workflow.FormatJSON
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); isYAML(mediaType) {
+		format = workflow.FormatYAML
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to read body: %w", err))
+		return
+	}
+
+	wf, err := workflow.Parse(data, format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.engine.LoadWorkflow(wf); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	w.Header().Set("Location", "/workflows/"+wf.Name)
+	writeJSON(w, http.StatusCreated, wf)
+}
+
+// isYAML reports whether a media type is one of the ones used for YAML
+func isYAML(mediaType string) bool {
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/workflow"
+)
+
+func TestListWorkflows(t *testing.T) {
+	_, _, ts := newTestServer(t)
+
+	var workflows []models.Workflow
+	if status := request(t, ts, http.MethodGet, "/workflows", "", "", &workflows); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+
+	var names []string
+	for _, wf := range workflows {
+		names = append(names, wf.Name)
+	}
+	if strings.Join(names, ",") != "approval,block,greet" {
+		t.Errorf("Expected workflows approval, block and greet, got %v", names)
+	}
+
+	var wf models.Workflow
+	if status := request(t, ts, http.MethodGet, "/workflows/greet", "", "", &wf); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if wf.Name != "greet" || len(wf.Steps) != 1 {
+		t.Errorf("Expected the greet definition, got %+v", wf)
+	}
+
+	if status := request(t, ts, http.MethodGet, "/workflows/missing", "", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", status)
+	}
+}
+
+func TestUploadWorkflow(t *testing.T) {
+	engine, _, ts := newTestServer(t)
+
+	// Upload a YAML definition
+	definition := `
+name: hello
+steps:
+  - id: hello
+    task: echo
+`
+	var wf models.Workflow
+	if status := request(t, ts, http.MethodPost, "/workflows", "application/yaml", definition, &wf); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+	if wf.Name != "hello" {
+		t.Errorf("Expected workflow hello, got %s", wf.Name)
+	}
+	if _, ok := engine.Workflow("hello"); !ok {
+		t.Error("Expected the workflow to be loaded")
+	}
+
+	// Definitions that cannot be parsed or are invalid are rejected
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"application/json", `{"name": `, http.StatusBadRequest},
+		{"application/json", `{"steps": []}`, http.StatusBadRequest},
+		{"application/yaml", "steps: []\n", http.StatusBadRequest},
+		{"application/json", `{"name": "broken", "steps": [{"id": "a", "task": "missing"}]}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		var body errorResponse
+		if status := request(t, ts, http.MethodPost, "/workflows", tt.contentType, tt.body, &body); status != tt.status {
+			t.Errorf("Expected status %d for %s, got %d", tt.status, tt.body, status)
+		}
+		if body.Error == "" {
+			t.Errorf("Expected an error message for %s", tt.body)
+		}
+	}
+}
+
+func TestUploadNotAllowed(t *testing.T) {
+	// Servers do not allow uploads unless they are told to
+	engine := workflow.NewEngine()
+	engine.RegisterTask(&EchoTask{})
+	ts := httptest.NewServer(New(engine))
+	defer ts.Close()
+
+	definition := `{"name": "hello", "steps": [{"id": "hello", "task": "echo"}]}`
+	var body errorResponse
+	if status := request(t, ts, http.MethodPost, "/workflows", "application/json", definition, &body); status != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", status)
+	}
+	if body.Error != "workflow uploads are not allowed" {
+		t.Errorf("Expected an error message, got %q", body.Error)
+	}
+	if _, ok := engine.Workflow("hello"); ok {
+		t.Error("Expected the workflow not to be loaded")
+	}
+}
+
+func TestUploadEnvAllowlist(t *testing.T) {
+	engine, _, ts := newTestServer(t)
+	t.Setenv("TEST_PUBLIC_REGION", "eu-west-1")
+	t.Setenv("TEST_DB_PASSWORD", "hunter2")
+	engine.SetEnvAllowlist("TEST_PUBLIC_REGION")
+
+	// An uploaded workflow reads only the environment variables that are allowed
+	definition := `{"name": "leak", "outputs": {"region": "env.TEST_PUBLIC_REGION", "password": "env.TEST_DB_PASSWORD"},
+ "steps": [{"id": "a", "task": "echo", "params": {"password": "${{ default(env.TEST_DB_PASSWORD, \"none\") }}"}}]}`
+	if status := request(t, ts, http.MethodPost, "/workflows", "application/json", definition, nil); status != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", status)
+	}
+
+	var state models.WorkflowState
+	request(t, ts, http.MethodPost, "/workflows/leak/runs", "", "", &state)
+	final := waitForRun(t, ts, state.RunID, models.RunRunning)
+
+	if final.Outputs["region"] != "eu-west-1" {
+		t.Errorf("Expected region eu-west-1, got %v", final.Outputs["region"])
+	}
+	if final.Outputs["password"] != nil || final.StepResults["a"].Data["password"] != "none" {
+		t.Errorf("Expected the password not to be readable, got %v and %v", final.Outputs["password"], final.StepResults["a"].Data["password"])
+	}
+}
@@ -0,0 +1,600 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// logExt is the extension of the state log of a run, eventsExt of its event
+// log, and lockExt of the file a process locks while it executes the run
+const (
+	logExt    = ".log"
+	eventsExt = ".events"
+	lockExt   = ".lock"
+)
+
+// schedulesFile holds the last fire times of the schedules of a scheduler
+const schedulesFile = "schedules.json"
+
+// DefaultCompactAfter is how many states a run's log holds before it is compacted
+const DefaultCompactAfter = 100
+
+// DefaultSnapshotEvery is how many events a run logs after its last saved
+// state before the state is saved again
+const DefaultSnapshotEvery = 50
+
+// FileStore keeps two append-only log files per run in a directory. Every
+// event is appended to the run's event log as a JSON line, and the file is
+// synced before AppendEvent returns, so a logged event survives a crash.
+// States are appended to the run's state log, along with the sequence number
+// of the last event they include. A state that the events logged since the
+// last state lead to is only saved when the run's status changes or once
+// the run logged SnapshotEvery events, and is not synced: Load applies the
+// events logged after the last state. Other states are saved and synced
+// right away. A line that was cut off by a crash is ignored when a log is
+// read. Once a state log holds CompactAfter states it is replaced with a
+// log of just the latest one, which is written to a temporary file first
+// and renamed over the old log, so the log is never left half written. A
+// process that executes a run locks the run's lock file, see LockRun
+type FileStore struct {
+	dir           string
+	CompactAfter  int
+	SnapshotEvery int
+
+	mu        sync.Mutex
+	logs      map[string]*runLogs // run ID -> what was written to its logs
+	recovered map[string]bool     // event logs that were checked for a cut off line
+	keys      map[runKey]string   // workflow and idempotency key -> run ID, read on first use
+}
+
+// runLogs is what a store knows about the logs of a run it saved a state of
+type runLogs struct {
+	lines    int           // states in the state log
+	seq      int           // sequence number of the last event in the event log
+	savedSeq int           // sequence number of the last event the last state includes
+	status   models.Status // status of the last state
+}
+
+// snapshot is a line of a state log. Seq is the sequence number of the last
+// event the state includes, and is missing from the states of older stores,
+// which include every event
+type snapshot struct {
+	*models.WorkflowState
+	Seq *int `json:"seq,omitempty"`
+}
+
+// NewFileStore creates a file store in a directory, creating the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	return &FileStore{
+		dir:           dir,
+		CompactAfter:  DefaultCompactAfter,
+		SnapshotEvery: DefaultSnapshotEvery,
+		logs:          make(map[string]*runLogs),
+		recovered:     make(map[string]bool),
+	}, nil
+}
+
+// Save appends the state to the state log of its run, unless Load can
+// rebuild it from the last saved state and the events logged since
+func (s *FileStore) Save(state *models.WorkflowState) error {
+	if err := checkRunID(state.RunID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logs, err := s.open(state.RunID)
+	if err != nil {
+		return err
+	}
+
+	// The event log already holds a state that events were logged for
+	logged := logs.seq > logs.savedSeq
+	if logged && state.Status == logs.status && logs.seq-logs.savedSeq < s.SnapshotEvery {
+		return nil
+	}
+
+	seq := logs.seq
+	line, err := json.Marshal(snapshot{WorkflowState: state, Seq: &seq})
+	if err != nil {
+		return fmt.Errorf("failed to encode state of run %s: %w", state.RunID, err)
+	}
+	line = append(line, '\n')
+
+	if logs.lines >= s.CompactAfter {
+		if err := s.replace(state.RunID, line); err != nil {
+			return err
+		}
+		logs.lines = 0
+	} else if err := s.appendLine(s.path(state.RunID), line, !logged); err != nil {
+		return err
+	}
+	logs.lines++
+	logs.savedSeq, logs.status = seq, state.Status
+	return nil
+}
+
+// open returns what the store knows about the logs of a run, which is read
+// from the logs the first time
+func (s *FileStore) open(runID string) (*runLogs, error) {
+	if logs, ok := s.logs[runID]; ok {
+		return logs, nil
+	}
+
+	lines, err := s.recover(s.path(runID))
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.readEvents(runID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	last, err := s.read(runID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	logs := &runLogs{lines: lines}
+	if len(events) > 0 {
+		logs.seq = events[len(events)-1].Seq
+	}
+	if last != nil {
+		logs.status = last.Status
+		logs.savedSeq = logs.seq
+		if last.Seq != nil {
+			logs.savedSeq = *last.Seq
+		}
+	}
+	s.logs[runID] = logs
+	return logs, nil
+}
+
+// AppendEvent appends an event to the event log of its run
+func (s *FileStore) AppendEvent(event models.Event) error {
+	if err := checkRunID(event.RunID); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event of run %s: %w", event.RunID, err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.eventsPath(event.RunID)
+	if !s.recovered[path] {
+		if _, err := s.recover(path); err != nil {
+			return err
+		}
+		s.recovered[path] = true
+	}
+	if err := s.appendLine(path, line, true); err != nil {
+		return err
+	}
+	if logs, ok := s.logs[event.RunID]; ok {
+		logs.seq = event.Seq
+	}
+
+	if k, ok := keyOf(event); ok && s.keys != nil && s.keys[k] == "" {
+		s.keys[k] = event.RunID
+	}
+	return nil
+}
+
+// RunByKey returns the ID of the first run of a workflow started with a
+// key. The index is built from the first event of every run the first time
+// it is used, and kept up to date as runs start
+func (s *FileStore) RunByKey(workflowName, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.keys == nil {
+		keys, err := s.readKeys()
+		if err != nil {
+			return "", err
+		}
+		s.keys = keys
+	}
+
+	runID, ok := s.keys[runKey{workflowName, key}]
+	if !ok {
+		return "", fmt.Errorf("%w: workflow %s with key %s", ErrNotFound, workflowName, key)
+	}
+	return runID, nil
+}
+
+// readKeys reads the run_started event of every run in the directory into
+// an index of idempotency keys. Runs that started earlier win when two runs
+// share a key
+func (s *FileStore) readKeys() (map[runKey]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state directory: %w", err)
+	}
+
+	keys := make(map[runKey]string)
+	for _, entry := range entries {
+		runID, ok := strings.CutSuffix(entry.Name(), eventsExt)
+		if !ok || entry.IsDir() || checkRunID(runID) != nil {
+			continue
+		}
+
+		event, err := firstEvent(s.eventsPath(runID))
+		if err != nil {
+			return nil, err
+		}
+		k, ok := keyOf(event)
+		if ok && (keys[k] == "" || runID < keys[k]) {
+			keys[k] = runID
+		}
+	}
+	return keys, nil
+}
+
+// Events returns the complete events in the event log of a run
+func (s *FileStore) Events(runID string) ([]models.Event, error) {
+	if err := checkRunID(runID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readEvents(runID)
+}
+
+// readEvents reads the complete events in the event log of a run
+func (s *FileStore) readEvents(runID string) ([]models.Event, error) {
+	data, err := os.ReadFile(s.eventsPath(runID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events of run %s: %w", runID, err)
+	}
+
+	var events []models.Event
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		if len(line) == 0 || !json.Valid(line) {
+			continue
+		}
+		var event models.Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("failed to decode events of run %s: %w", runID, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Load returns the last complete state in the state log of a run, with the
+// events logged after it applied
+func (s *FileStore) Load(runID string) (*models.WorkflowState, error) {
+	if err := checkRunID(runID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(runID)
+}
+
+// load returns the last state of a run, with the events logged after it
+// applied. A run whose state log has no complete state yet is rebuilt from
+// its events
+func (s *FileStore) load(runID string) (*models.WorkflowState, error) {
+	last, err := s.read(runID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if last != nil && last.Seq == nil {
+		return last.WorkflowState, nil
+	}
+
+	events, err := s.readEvents(runID)
+	if errors.Is(err, ErrNotFound) && last != nil {
+		return last.WorkflowState, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state, seq := &models.WorkflowState{}, 0
+	if last != nil {
+		state, seq = last.WorkflowState, *last.Seq
+	}
+	for _, event := range events {
+		if event.Seq > seq {
+			state.Apply(event)
+		}
+	}
+
+	// The first event of the run was cut off by a crash
+	if state.RunID == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, runID)
+	}
+	return state, nil
+}
+
+// List returns the latest state of every run in the directory
+func (s *FileStore) List() ([]*models.WorkflowState, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state directory: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A run that crashed before its first state was saved only has an event log
+	var states []*models.WorkflowState
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		runID, ok := strings.CutSuffix(entry.Name(), logExt)
+		if !ok {
+			runID, ok = strings.CutSuffix(entry.Name(), eventsExt)
+		}
+		if !ok || entry.IsDir() || seen[runID] || checkRunID(runID) != nil {
+			continue
+		}
+		seen[runID] = true
+
+		state, err := s.load(runID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// LastFire returns when a schedule last fired, from the schedules file
+func (s *FileStore) LastFire(schedule string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fires, err := s.readFires()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fires[schedule], nil
+}
+
+// SaveLastFire records when a schedule last fired, replacing the schedules
+// file so it is never left half written
+func (s *FileStore) SaveLastFire(schedule string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fires, err := s.readFires()
+	if err != nil {
+		return err
+	}
+	fires[schedule] = t
+
+	data, err := json.MarshalIndent(fires, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode schedules: %w", err)
+	}
+	if err := s.writeFile(filepath.Join(s.dir, schedulesFile), append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to save schedules: %w", err)
+	}
+	return nil
+}
+
+// readFires reads the last fire times of the schedules file
+func (s *FileStore) readFires() (map[string]time.Time, error) {
+	fires := make(map[string]time.Time)
+
+	data, err := os.ReadFile(filepath.Join(s.dir, schedulesFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fires, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedules: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &fires); err != nil {
+		return nil, fmt.Errorf("failed to decode schedules: %w", err)
+	}
+	return fires, nil
+}
+
+// path returns the state log of a run
+func (s *FileStore) path(runID string) string {
+	return filepath.Join(s.dir, runID+logExt)
+}
+
+// eventsPath returns the event log of a run
+func (s *FileStore) eventsPath(runID string) string {
+	return filepath.Join(s.dir, runID+eventsExt)
+}
+
+// lockPath returns the path of the lock file of a run
+func (s *FileStore) lockPath(runID string) string {
+	return filepath.Join(s.dir, runID+lockExt)
+}
+
+// appendLine appends a line to a log, and syncs it to disk if asked to
+func (s *FileStore) appendLine(path string, line []byte, sync bool) error {
+	_, statErr := os.Stat(path)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log %s: %w", path, err)
+	}
+
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write log %s: %w", path, err)
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to sync log %s: %w", path, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close log %s: %w", path, err)
+	}
+
+	// A new file is only durable once the directory entry is synced too
+	if sync && errors.Is(statErr, fs.ErrNotExist) {
+		return syncDir(s.dir)
+	}
+	return nil
+}
+
+// replace atomically replaces the log of a run with a log of a single line
+func (s *FileStore) replace(runID string, line []byte) error {
+	if err := s.writeFile(s.path(runID), line); err != nil {
+		return fmt.Errorf("failed to compact log of run %s: %w", runID, err)
+	}
+	return nil
+}
+
+// writeFile atomically replaces a file in the directory. The data is written
+// to a temporary file first, which is renamed over the file
+func (s *FileStore) writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+// recover prepares a log that this store has not written to yet. It counts
+// the lines in the log, and ends a line that a crash cut off so the next
+// line starts on its own
+func (s *FileStore) recover(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read log %s: %w", path, err)
+	}
+
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if err := s.appendLine(path, []byte{'\n'}, true); err != nil {
+			return 0, err
+		}
+	}
+
+	_, lines := lastLine(data)
+	return lines, nil
+}
+
+// read returns the last complete state in the state log of a run
+func (s *FileStore) read(runID string) (*snapshot, error) {
+	data, err := os.ReadFile(s.path(runID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, runID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log of run %s: %w", runID, err)
+	}
+
+	last, _ := lastLine(data)
+	if last == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, runID)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(last, &snap); err != nil {
+		return nil, fmt.Errorf("failed to decode log of run %s: %w", runID, err)
+	}
+	return &snap, nil
+}
+
+// firstEvent reads the first event of an event log, or returns the zero
+// event when the log has no complete first line
+func firstEvent(path string) (models.Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return models.Event{}, fmt.Errorf("failed to read log %s: %w", path, err)
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if errors.Is(err, io.EOF) {
+		return models.Event{}, nil
+	}
+	if err != nil {
+		return models.Event{}, fmt.Errorf("failed to read log %s: %w", path, err)
+	}
+
+	var event models.Event
+	if json.Unmarshal(line, &event) != nil {
+		return models.Event{}, nil
+	}
+	return event, nil
+}
+
+// syncDir syncs a directory, so that files created or renamed in it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to sync state directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync state directory: %w", err)
+	}
+	return nil
+}
+
+// lastLine returns the last complete JSON line of a log, and the number of complete lines
+func lastLine(data []byte) ([]byte, int) {
+	var last []byte
+	lines := 0
+	for _, line := range bytes.Split(data, []byte{'\n'}) {
+		// A line that a crash cut off is not valid JSON
+		if len(line) == 0 || !json.Valid(line) {
+			continue
+		}
+		last = line
+		lines++
+	}
+	return last, lines
+}
@@ -0,0 +1,318 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "runs")
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+
+	state := newState("run1", "running")
+	if err := s.Save(state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	state.Status = "completed"
+	if err := s.Save(state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	// Every save is appended to the run's log
+	data, err := os.ReadFile(filepath.Join(dir, "run1.log"))
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("Expected 2 lines in the log, got %d", lines)
+	}
+
+	// A new store on the same directory sees the latest state
+	reopened, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen file store: %v", err)
+	}
+
+	loaded, err := reopened.Load("run1")
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if loaded.Status != "completed" || loaded.StepResults["payment"].Data["amount"] != "42.50" {
+		t.Errorf("Expected the completed state with its step results, got %+v", loaded)
+	}
+
+	if _, err := reopened.Load("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := reopened.Load("../run1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an invalid run id, got %v", err)
+	}
+
+	reopened.Save(newState("run2", "running"))
+	states, err := reopened.List()
+	if err != nil || len(states) != 2 {
+		t.Errorf("Expected 2 states, got %d (%v)", len(states), err)
+	}
+}
+
+func TestFileStoreCrashedWrite(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewFileStore(dir)
+	s.Save(newState("run1", "running"))
+
+	// Simulate a crash in the middle of writing the next state
+	path := filepath.Join(dir, "run1.log")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	f.WriteString(`{"run_id":"run1","status":"comp`)
+	f.Close()
+
+	// The cut off line is ignored
+	reopened, _ := NewFileStore(dir)
+	loaded, err := reopened.Load("run1")
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if loaded.Status != "running" {
+		t.Errorf("Expected the last complete state, got status %s", loaded.Status)
+	}
+
+	// The next save starts on a line of its own
+	if err := reopened.Save(newState("run1", "completed")); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	loaded, err = reopened.Load("run1")
+	if err != nil || loaded.Status != "completed" {
+		t.Errorf("Expected status completed, got %+v (%v)", loaded, err)
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewFileStore(dir)
+	s.CompactAfter = 3
+
+	statuses := []models.Status{"a", "b", "c", "d", "e"}
+	for _, status := range statuses {
+		if err := s.Save(newState("run1", status)); err != nil {
+			t.Fatalf("Failed to save state: %v", err)
+		}
+	}
+
+	// The log was replaced by the fourth state, then the fifth was appended
+	data, _ := os.ReadFile(filepath.Join(dir, "run1.log"))
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("Expected 2 lines after compaction, got %d", lines)
+	}
+
+	loaded, err := s.Load("run1")
+	if err != nil || loaded.Status != "e" {
+		t.Errorf("Expected status e, got %+v (%v)", loaded, err)
+	}
+
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the log in the directory, got %d entries", len(entries))
+	}
+}
+
+func TestFileStoreSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewFileStore(dir)
+	s.SnapshotEvery = 3
+
+	// Log events and save the state they lead to after each, as the engine does
+	state := &models.WorkflowState{}
+	record := func(event models.Event) {
+		t.Helper()
+		event.RunID = "run1"
+		if err := s.AppendEvent(event); err != nil {
+			t.Fatalf("Failed to append event: %v", err)
+		}
+		state.Apply(event)
+		if err := s.Save(state); err != nil {
+			t.Fatalf("Failed to save state: %v", err)
+		}
+	}
+	record(models.Event{Seq: 1, Type: models.EventRunStarted, WorkflowName: "order", Steps: []string{"a"}})
+	for seq := 2; seq <= 8; seq++ {
+		record(models.Event{Seq: seq, Type: models.EventTaskOutput, StepID: "a", Data: map[string]any{"n": seq}})
+	}
+
+	// States are saved when the status changes and every 3 events: after events 1, 4 and 7
+	data, _ := os.ReadFile(filepath.Join(dir, "run1.log"))
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("Expected 3 states in the log, got %d", lines)
+	}
+
+	// The events logged after the last state are applied when the run is loaded
+	reopened, _ := NewFileStore(dir)
+	loaded, err := reopened.Load("run1")
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if n := loaded.StepResults["a"].Data["n"]; n != float64(8) || len(loaded.StepResults["a"].Attempts) != 7 {
+		t.Errorf("Expected the data of the last event and 7 attempts, got %+v", loaded.StepResults["a"])
+	}
+
+	record(models.Event{Seq: 9, Type: models.EventRunFinished, Status: models.RunCompleted})
+	if loaded, _ := reopened.Load("run1"); loaded.Status != models.RunCompleted {
+		t.Errorf("Expected status completed, got %s", loaded.Status)
+	}
+
+	// Without a state log, the run is rebuilt from its events
+	os.Remove(filepath.Join(dir, "run1.log"))
+	loaded, err = reopened.Load("run1")
+	if err != nil || loaded.Status != models.RunCompleted || len(loaded.StepResults["a"].Attempts) != 7 {
+		t.Errorf("Expected the run rebuilt from its events, got %+v (%v)", loaded, err)
+	}
+	if states, err := reopened.List(); err != nil || len(states) != 1 {
+		t.Errorf("Expected the run in the list, got %d (%v)", len(states), err)
+	}
+}
+
+func TestFileStoreEvents(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewFileStore(dir)
+
+	for i, eventType := range []string{models.EventRunStarted, models.EventStepScheduled} {
+		if err := s.AppendEvent(models.Event{Seq: i + 1, Type: eventType, RunID: "run1"}); err != nil {
+			t.Fatalf("Failed to append event: %v", err)
+		}
+	}
+
+	// Simulate a crash in the middle of writing the next event
+	f, _ := os.OpenFile(filepath.Join(dir, "run1.events"), os.O_WRONLY|os.O_APPEND, 0o644)
+	f.WriteString(`{"seq":3,"ty`)
+	f.Close()
+
+	reopened, _ := NewFileStore(dir)
+	if err := reopened.AppendEvent(models.Event{Seq: 3, Type: models.EventStepCompleted, RunID: "run1"}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+
+	events, err := reopened.Events("run1")
+	if err != nil {
+		t.Fatalf("Failed to read events: %v", err)
+	}
+
+	if len(events) != 3 || events[2].Type != models.EventStepCompleted {
+		t.Errorf("Expected 3 events ending in step_completed, got %+v", events)
+	}
+
+	if _, err := reopened.Events("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := reopened.Events("../run1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an invalid run id, got %v", err)
+	}
+}
+
+func TestFileStoreRunByKey(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewFileStore(dir)
+
+	s.AppendEvent(models.Event{Seq: 1, Type: models.EventRunStarted, RunID: "run1", WorkflowName: "order", IdempotencyKey: "d-1"})
+	s.AppendEvent(models.Event{Seq: 1, Type: models.EventRunStarted, RunID: "run2", WorkflowName: "order"})
+
+	// The index is read from the event logs, and kept up to date after that
+	if runID, err := s.RunByKey("order", "d-1"); err != nil || runID != "run1" {
+		t.Errorf("Expected run1, got %q (%v)", runID, err)
+	}
+	s.AppendEvent(models.Event{Seq: 1, Type: models.EventRunStarted, RunID: "run3", WorkflowName: "order", IdempotencyKey: "d-2"})
+	if runID, err := s.RunByKey("order", "d-2"); err != nil || runID != "run3" {
+		t.Errorf("Expected run3, got %q (%v)", runID, err)
+	}
+
+	// A run whose first event was cut off by a crash has no key
+	os.WriteFile(filepath.Join(dir, "run4.events"), []byte(`{"seq":1,"type":"run_st`), 0o644)
+
+	reopened, _ := NewFileStore(dir)
+	for key, expected := range map[string]string{"d-1": "run1", "d-2": "run3"} {
+		if runID, err := reopened.RunByKey("order", key); err != nil || runID != expected {
+			t.Errorf("Expected %s for key %s after reopening, got %q (%v)", expected, key, runID, err)
+		}
+	}
+	if _, err := reopened.RunByKey("order", "d-3"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFileStoreLastFire(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+
+	if last, err := s.LastFire("nightly"); err != nil || !last.IsZero() {
+		t.Errorf("Expected no last fire time, got %s (%v)", last, err)
+	}
+
+	fired := time.Date(2025, 3, 1, 2, 0, 0, 0, time.UTC)
+	if err := s.SaveLastFire("nightly", fired); err != nil {
+		t.Fatalf("Failed to save last fire time: %v", err)
+	}
+	if err := s.SaveLastFire("hourly", fired.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to save last fire time: %v", err)
+	}
+
+	// A new store on the same directory sees the fire times, and does not
+	// list the schedules file as a run
+	reopened, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen file store: %v", err)
+	}
+	if last, _ := reopened.LastFire("nightly"); !last.Equal(fired) {
+		t.Errorf("Expected last fire time %s, got %s", fired, last)
+	}
+	if states, err := reopened.List(); err != nil || len(states) != 0 {
+		t.Errorf("Expected no runs, got %d (%v)", len(states), err)
+	}
+}
+
+func TestFileStoreLockRun(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewFileStore(dir)
+	other, _ := NewFileStore(dir)
+
+	unlock, err := s.LockRun("run1")
+	if err != nil {
+		t.Fatalf("Failed to lock run: %v", err)
+	}
+
+	// Another store on the same directory cannot lock the run, but can lock others
+	if _, err := other.LockRun("run1"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked, got %v", err)
+	}
+	unlockOther, err := other.LockRun("run2")
+	if err != nil {
+		t.Fatalf("Failed to lock another run: %v", err)
+	}
+	unlockOther()
+
+	// Once unlocked, the run can be locked again
+	unlock()
+	unlock, err = other.LockRun("run1")
+	if err != nil {
+		t.Fatalf("Failed to lock run after unlock: %v", err)
+	}
+	unlock()
+
+	if _, err := s.LockRun("../run1"); err == nil {
+		t.Error("Expected error locking an invalid run id")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package store
+
+// LockRun does not lock runs on this platform: only one process may execute
+// the runs of a directory at a time
+func (s *FileStore) LockRun(runID string) (func(), error) {
+	if err := checkRunID(runID); err != nil {
+		return nil, err
+	}
+	return func() {}, nil
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package store
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// LockRun locks a run with an advisory lock on a file next to its logs. The
+// operating system releases the lock when the process exits, so a process
+// that crashed leaves no stale lock behind
+func (s *FileStore) LockRun(runID string) (func(), error) {
+	if err := checkRunID(runID); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(s.lockPath(runID), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+
+	// Closing the file releases the lock
+	return func() { f.Close() }, nil
+}
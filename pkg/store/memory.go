@@ -0,0 +1,127 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// MemoryStore keeps states in memory. It is the engine's default store, and
+// loses every run when the process exits
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]*models.WorkflowState
+	events map[string][]models.Event
+	fires  map[string]time.Time
+	keys   map[runKey]string // workflow and idempotency key -> run ID
+}
+
+// NewMemoryStore creates an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		states: make(map[string]*models.WorkflowState),
+		events: make(map[string][]models.Event),
+		fires:  make(map[string]time.Time),
+		keys:   make(map[runKey]string),
+	}
+}
+
+// Save stores a copy of the state
+func (s *MemoryStore) Save(state *models.WorkflowState) error {
+	if err := checkRunID(state.RunID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[state.RunID] = state.Clone()
+	return nil
+}
+
+// Load returns a copy of the state of a run
+func (s *MemoryStore) Load(runID string) (*models.WorkflowState, error) {
+	if err := checkRunID(runID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[runID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, runID)
+	}
+	return state.Clone(), nil
+}
+
+// List returns copies of all states
+func (s *MemoryStore) List() ([]*models.WorkflowState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make([]*models.WorkflowState, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state.Clone())
+	}
+	return states, nil
+}
+
+// AppendEvent appends an event to the log of its run
+func (s *MemoryStore) AppendEvent(event models.Event) error {
+	if err := checkRunID(event.RunID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[event.RunID] = append(s.events[event.RunID], event)
+	if k, ok := keyOf(event); ok && s.keys[k] == "" {
+		s.keys[k] = event.RunID
+	}
+	return nil
+}
+
+// RunByKey returns the ID of the first run of a workflow started with a key
+func (s *MemoryStore) RunByKey(workflowName, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	runID, ok := s.keys[runKey{workflowName, key}]
+	if !ok {
+		return "", fmt.Errorf("%w: workflow %s with key %s", ErrNotFound, workflowName, key)
+	}
+	return runID, nil
+}
+
+// Events returns a copy of the event log of a run
+func (s *MemoryStore) Events(runID string) ([]models.Event, error) {
+	if err := checkRunID(runID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events, ok := s.events[runID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, runID)
+	}
+	return append([]models.Event(nil), events...), nil
+}
+
+// LastFire returns when a schedule last fired
+func (s *MemoryStore) LastFire(schedule string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fires[schedule], nil
+}
+
+// SaveLastFire records when a schedule last fired
+func (s *MemoryStore) SaveLastFire(schedule string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fires[schedule] = t
+	return nil
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// newState returns a state of a run with one step result
+func newState(runID string, status models.Status) *models.WorkflowState {
+	return &models.WorkflowState{
+		RunID:          runID,
+		WorkflowName:   "order_process",
+		CurrentStep:    "payment",
+		CompletedSteps: []string{"payment"},
+		StepResults: map[string]models.StepResult{
+			"payment": {Status: models.StepSucceeded, Success: true, Data: map[string]any{"amount": "42.50"}},
+		},
+		Status: status,
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+
+	state := newState("run1", "running")
+	if err := s.Save(state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	// The store keeps a copy, so later changes need another save
+	state.Status = "completed"
+
+	loaded, err := s.Load("run1")
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if loaded.Status != "running" {
+		t.Errorf("Expected status running, got %s", loaded.Status)
+	}
+
+	if err := s.Save(state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	if loaded, _ := s.Load("run1"); loaded.Status != "completed" {
+		t.Errorf("Expected status completed, got %s", loaded.Status)
+	}
+
+	if _, err := s.Load("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.Load("../escape"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an invalid run id, got %v", err)
+	}
+
+	if err := s.Save(newState("../escape", "running")); err == nil {
+		t.Error("Expected error for an invalid run id")
+	}
+
+	s.Save(newState("run2", "running"))
+	states, err := s.List()
+	if err != nil || len(states) != 2 {
+		t.Errorf("Expected 2 states, got %d (%v)", len(states), err)
+	}
+}
+
+func TestMemoryStoreEvents(t *testing.T) {
+	s := NewMemoryStore()
+
+	if err := s.AppendEvent(models.Event{Seq: 1, Type: models.EventRunStarted, RunID: "run1"}); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+
+	events, err := s.Events("run1")
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d (%v)", len(events), err)
+	}
+
+	// The returned log is a copy
+	events[0].Type = "changed"
+	if events, _ := s.Events("run1"); events[0].Type != models.EventRunStarted {
+		t.Errorf("Expected stored event to be unchanged, got %s", events[0].Type)
+	}
+
+	if _, err := s.Events("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.Events("../escape"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an invalid run id, got %v", err)
+	}
+}
+
+func TestMemoryStoreRunByKey(t *testing.T) {
+	s := NewMemoryStore()
+
+	s.AppendEvent(models.Event{Seq: 1, Type: models.EventRunStarted, RunID: "run1", WorkflowName: "order", IdempotencyKey: "d-1"})
+	s.AppendEvent(models.Event{Seq: 1, Type: models.EventRunStarted, RunID: "run2", WorkflowName: "order"})
+
+	if runID, err := s.RunByKey("order", "d-1"); err != nil || runID != "run1" {
+		t.Errorf("Expected run1, got %q (%v)", runID, err)
+	}
+	if _, err := s.RunByKey("other", "d-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for another workflow, got %v", err)
+	}
+}
+
+func TestMemoryStoreLastFire(t *testing.T) {
+	s := NewMemoryStore()
+
+	fired := time.Date(2025, 3, 1, 2, 0, 0, 0, time.UTC)
+	if err := s.SaveLastFire("nightly", fired); err != nil {
+		t.Fatalf("Failed to save last fire time: %v", err)
+	}
+
+	if last, _ := s.LastFire("nightly"); !last.Equal(fired) {
+		t.Errorf("Expected last fire time %s, got %s", fired, last)
+	}
+	if last, _ := s.LastFire("hourly"); !last.IsZero() {
+		t.Errorf("Expected no last fire time, got %s", last)
+	}
+}
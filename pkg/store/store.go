@@ -0,0 +1,87 @@
+// Package store persists the state of workflow runs, so runs survive the
+// process that started them
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// ErrNotFound is returned when a store has no state for a run
+var ErrNotFound = errors.New("run not found")
+
+// ErrLocked is returned when another process holds the lock of a run
+var ErrLocked = errors.New("run is locked by another process")
+
+// StateStore saves and loads the state of workflow runs, including the
+// results of their steps, and the event log each state is built from. The
+// engine appends an event and saves the state after every step transition.
+// The state it saves is the one the run's events lead to, so a store may
+// skip a save and apply the events logged since its last state on load
+type StateStore interface {
+	// Save saves the state of a run, replacing the state saved before
+	Save(state *models.WorkflowState) error
+	// Load returns the latest saved state of a run, or ErrNotFound
+	Load(runID string) (*models.WorkflowState, error)
+	// List returns the latest saved state of every run, in no particular order
+	List() ([]*models.WorkflowState, error)
+	// AppendEvent appends an event to the log of its run
+	AppendEvent(event models.Event) error
+	// Events returns the event log of a run in order, or ErrNotFound
+	Events(runID string) ([]models.Event, error)
+}
+
+// KeyIndex finds the run a workflow was started with an idempotency key,
+// without reading every run. Stores add a run to the index when its
+// run_started event is appended
+type KeyIndex interface {
+	// RunByKey returns the ID of the first run of a workflow started with a key, or ErrNotFound
+	RunByKey(workflowName, key string) (string, error)
+}
+
+// RunLocker keeps processes that share a store from executing the same run
+// at the same time. The engine holds the lock of a run while it executes it
+type RunLocker interface {
+	// LockRun locks a run until unlock is called, or returns ErrLocked
+	LockRun(runID string) (unlock func(), err error)
+}
+
+// ScheduleStore saves when the schedules of a scheduler last fired, so a
+// scheduler that was not running knows which fire times it missed
+type ScheduleStore interface {
+	// LastFire returns when a schedule last fired, or the zero time if it never did
+	LastFire(schedule string) (time.Time, error)
+	// SaveLastFire records when a schedule last fired
+	SaveLastFire(schedule string, t time.Time) error
+}
+
+// runKey is the key of a run in a KeyIndex
+type runKey struct {
+	workflow string
+	key      string
+}
+
+// keyOf returns the index key of a run_started event, if it has an idempotency key
+func keyOf(event models.Event) (runKey, bool) {
+	if event.Type != models.EventRunStarted || event.IdempotencyKey == "" {
+		return runKey{}, false
+	}
+	return runKey{event.WorkflowName, event.IdempotencyKey}, true
+}
+
+// checkRunID makes sure a run ID can be used as a key, and as a file name.
+// Stores report lookups of invalid run IDs as ErrNotFound
+func checkRunID(runID string) error {
+	if runID == "" {
+		return errors.New("run id is required")
+	}
+	for _, r := range runID {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("invalid run id %q", runID)
+		}
+	}
+	return nil
+}
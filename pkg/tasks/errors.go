@@ -0,0 +1,61 @@
+package tasks
+
+import (
+	"errors"
+)
+
+// Error is an error returned by a task that carries information for the
+// engine: a class that retry policies and error routes can match on, and
+// whether retrying could ever help
+type Error struct {
+	Class     string
+	Permanent bool
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError returns an error of the given class, e.g. "network" or "rate_limited"
+func NewError(class string, err error) error {
+	return &Error{Class: class, Err: err}
+}
+
+// Permanent marks an error as non-retryable, so the engine skips the remaining
+// attempts of a retry policy. The class of the error is kept
+func Permanent(err error) error {
+	var taskErr *Error
+	if errors.As(err, &taskErr) {
+		return &Error{Class: taskErr.Class, Permanent: true, Err: err}
+	}
+	return &Error{Permanent: true, Err: err}
+}
+
+// ErrorClass returns the class of an error, or "" if it has none
+func ErrorClass(err error) string {
+	var taskErr *Error
+	if errors.As(err, &taskErr) {
+		return taskErr.Class
+	}
+	return ""
+}
+
+// IsPermanent reports whether an error was marked as non-retryable
+func IsPermanent(err error) bool {
+	var taskErr *Error
+	for err != nil {
+		if !errors.As(err, &taskErr) {
+			return false
+		}
+		if taskErr.Permanent {
+			return true
+		}
+		err = taskErr.Err
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorClass(t *testing.T) {
+	err := NewError("network", errors.New("connection reset"))
+
+	if ErrorClass(err) != "network" {
+		t.Errorf("Expected class network, got %s", ErrorClass(err))
+	}
+
+	// The class survives wrapping
+	wrapped := fmt.Errorf("charge failed: %w", err)
+	if ErrorClass(wrapped) != "network" {
+		t.Errorf("Expected class network for wrapped error, got %s", ErrorClass(wrapped))
+	}
+
+	if err.Error() != "connection reset" {
+		t.Errorf("Expected message connection reset, got %s", err.Error())
+	}
+
+	if ErrorClass(errors.New("plain")) != "" {
+		t.Error("Expected no class for a plain error")
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	base := errors.New("card declined")
+	err := Permanent(NewError("payment", base))
+
+	if !IsPermanent(err) {
+		t.Error("Expected error to be permanent")
+	}
+
+	if !IsPermanent(fmt.Errorf("step failed: %w", err)) {
+		t.Error("Expected wrapped error to be permanent")
+	}
+
+	// Permanent keeps the class and the original error
+	if ErrorClass(err) != "payment" {
+		t.Errorf("Expected class payment, got %s", ErrorClass(err))
+	}
+
+	if !errors.Is(err, base) {
+		t.Error("Expected permanent error to wrap the original error")
+	}
+
+	if IsPermanent(NewError("network", base)) || IsPermanent(base) {
+		t.Error("Expected errors not marked permanent to be retryable")
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
+	"github.com/mstgnz/goflow/pkg/clock"
 	"github.com/mstgnz/goflow/pkg/models"
 )
 
@@ -26,7 +28,9 @@ func (t *ValidateFileTask) Execute(ctx context.Context, params map[string]string
 	fmt.Printf("Validating file: %s\n", filePath)
 
 	// Simulate some work
-	time.Sleep(1 * time.Second)
+	if err := simulateWork(ctx, 1*time.Second); err != nil {
+		return nil, err
+	}
 
 	// Simulate validation (in a real implementation, this could fail)
 	valid := true
@@ -34,7 +38,7 @@ func (t *ValidateFileTask) Execute(ctx context.Context, params map[string]string
 	return map[string]any{
 		"valid":     valid,
 		"file_path": filePath,
-		"time":      time.Now().Format(time.RFC3339),
+		"time":      clock.FromContext(ctx).Now().Format(time.RFC3339),
 	}, nil
 }
 
@@ -55,7 +59,9 @@ func (t *ProcessFileTask) Execute(ctx context.Context, params map[string]string,
 	fmt.Printf("Processing file: %s\n", filePath)
 
 	// Simulate some work
-	time.Sleep(2 * time.Second)
+	if err := simulateWork(ctx, 2*time.Second); err != nil {
+		return nil, err
+	}
 
 	// Simulate processing (in a real implementation, this could fail)
 	processed := true
@@ -64,7 +70,7 @@ func (t *ProcessFileTask) Execute(ctx context.Context, params map[string]string,
 		"processed": processed,
 		"file_path": filePath,
 		"records":   100, // Simulated number of records processed
-		"time":      time.Now().Format(time.RFC3339),
+		"time":      clock.FromContext(ctx).Now().Format(time.RFC3339),
 	}, nil
 }
 
@@ -79,9 +85,16 @@ func (t *SaveToDatabaseTask) Execute(ctx context.Context, params map[string]stri
 	// In a real implementation, this would save data to a database
 	fmt.Println("Saving data to database")
 
-	// Get the number of records from the previous step
+	// Get the number of records from the records param, e.g.
+	// "${{ steps.process.data.records }}", or else from the process step
 	var records int
-	if result, ok := state.StepResults["process"]; ok {
+	if value, ok := params["records"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid records parameter: %w", err)
+		}
+		records = n
+	} else if result, ok := state.StepResults["process"]; ok {
 		if recordsVal, ok := result.Data["records"]; ok {
 			if recordsInt, ok := recordsVal.(int); ok {
 				records = recordsInt
@@ -90,11 +103,13 @@ func (t *SaveToDatabaseTask) Execute(ctx context.Context, params map[string]stri
 	}
 
 	// Simulate some work
-	time.Sleep(1500 * time.Millisecond)
+	if err := simulateWork(ctx, 1500*time.Millisecond); err != nil {
+		return nil, err
+	}
 
 	return map[string]any{
 		"saved":   true,
 		"records": records,
-		"time":    time.Now().Format(time.RFC3339),
+		"time":    clock.FromContext(ctx).Now().Format(time.RFC3339),
 	}, nil
 }
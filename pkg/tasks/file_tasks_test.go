@@ -35,7 +35,7 @@ func TestValidateFileTask(t *testing.T) {
 	params := map[string]string{
 		"file_path": "/path/to/file.csv",
 	}
-	result, err := task.Execute(context.Background(), params, state)
+	result, err := executeOnFakeClock(task, params, state)
 	if err != nil {
 		t.Fatalf("Failed to execute task: %v", err)
 	}
@@ -49,8 +49,8 @@ func TestValidateFileTask(t *testing.T) {
 		t.Errorf("Expected file_path /path/to/file.csv, got %v", result["file_path"])
 	}
 
-	if result["time"] == nil {
-		t.Error("Expected time to be set")
+	if result["time"] != testTaskTime {
+		t.Errorf("Expected time %s, got %v", testTaskTime, result["time"])
 	}
 }
 
@@ -82,7 +82,7 @@ func TestProcessFileTask(t *testing.T) {
 	params := map[string]string{
 		"file_path": "/path/to/file.csv",
 	}
-	result, err := task.Execute(context.Background(), params, state)
+	result, err := executeOnFakeClock(task, params, state)
 	if err != nil {
 		t.Fatalf("Failed to execute task: %v", err)
 	}
@@ -100,8 +100,8 @@ func TestProcessFileTask(t *testing.T) {
 		t.Errorf("Expected records 100, got %v", result["records"])
 	}
 
-	if result["time"] == nil {
-		t.Error("Expected time to be set")
+	if result["time"] != testTaskTime {
+		t.Errorf("Expected time %s, got %v", testTaskTime, result["time"])
 	}
 }
 
@@ -132,7 +132,7 @@ func TestSaveToDatabaseTask(t *testing.T) {
 	}
 
 	// Execute the task
-	result, err := task.Execute(context.Background(), map[string]string{}, state)
+	result, err := executeOnFakeClock(task, map[string]string{}, state)
 	if err != nil {
 		t.Fatalf("Failed to execute task: %v", err)
 	}
@@ -146,7 +146,23 @@ func TestSaveToDatabaseTask(t *testing.T) {
 		t.Errorf("Expected records 100, got %v", result["records"])
 	}
 
-	if result["time"] == nil {
-		t.Error("Expected time to be set")
+	if result["time"] != testTaskTime {
+		t.Errorf("Expected time %s, got %v", testTaskTime, result["time"])
+	}
+
+	// Execute the task with a resolved records param
+	result, err = executeOnFakeClock(task, map[string]string{"records": "42"}, state)
+	if err != nil {
+		t.Fatalf("Failed to execute task: %v", err)
+	}
+
+	if result["records"] != 42 {
+		t.Errorf("Expected records 42, got %v", result["records"])
+	}
+
+	// Test with an invalid records param
+	_, err = task.Execute(context.Background(), map[string]string{"records": "many"}, state)
+	if err == nil {
+		t.Error("Expected error for invalid records parameter")
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/mstgnz/goflow/pkg/clock"
 	"github.com/mstgnz/goflow/pkg/models"
 )
 
@@ -26,12 +27,14 @@ func (t *SendEmailTask) Execute(ctx context.Context, params map[string]string, s
 	fmt.Printf("Sending email with template: %s\n", template)
 
 	// Simulate some work
-	time.Sleep(500 * time.Millisecond)
+	if err := simulateWork(ctx, 500*time.Millisecond); err != nil {
+		return nil, err
+	}
 
 	return map[string]any{
 		"sent":     true,
 		"template": template,
-		"time":     time.Now().Format(time.RFC3339),
+		"time":     clock.FromContext(ctx).Now().Format(time.RFC3339),
 	}, nil
 }
 
@@ -52,7 +55,9 @@ func (t *ProcessPaymentTask) Execute(ctx context.Context, params map[string]stri
 	fmt.Printf("Processing payment of amount: %s\n", amount)
 
 	// Simulate some work
-	time.Sleep(1 * time.Second)
+	if err := simulateWork(ctx, 1*time.Second); err != nil {
+		return nil, err
+	}
 
 	// Simulate success (in a real implementation, this could fail)
 	success := true
@@ -60,7 +65,36 @@ func (t *ProcessPaymentTask) Execute(ctx context.Context, params map[string]stri
 	return map[string]any{
 		"success": success,
 		"amount":  amount,
-		"time":    time.Now().Format(time.RFC3339),
+		"time":    clock.FromContext(ctx).Now().Format(time.RFC3339),
+	}, nil
+}
+
+// RefundPaymentTask refunds a payment, e.g. to compensate a payment step
+// when a later step of the order fails
+type RefundPaymentTask struct{}
+
+func (t *RefundPaymentTask) Name() string {
+	return "refund_payment"
+}
+
+func (t *RefundPaymentTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	amount, ok := params["amount"]
+	if !ok {
+		return nil, errors.New("amount parameter is required")
+	}
+
+	// In a real implementation, this would refund an actual payment
+	fmt.Printf("Refunding payment of amount: %s\n", amount)
+
+	// Simulate some work
+	if err := simulateWork(ctx, 500*time.Millisecond); err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"refunded": true,
+		"amount":   amount,
+		"time":     clock.FromContext(ctx).Now().Format(time.RFC3339),
 	}, nil
 }
 
@@ -76,11 +110,13 @@ func (t *PackItemsTask) Execute(ctx context.Context, params map[string]string, s
 	fmt.Println("Packing items for order")
 
 	// Simulate some work
-	time.Sleep(1500 * time.Millisecond)
+	if err := simulateWork(ctx, 1500*time.Millisecond); err != nil {
+		return nil, err
+	}
 
 	return map[string]any{
 		"packed": true,
-		"time":   time.Now().Format(time.RFC3339),
+		"time":   clock.FromContext(ctx).Now().Format(time.RFC3339),
 	}, nil
 }
 
@@ -96,10 +132,12 @@ func (t *SendShippingNotificationTask) Execute(ctx context.Context, params map[s
 	fmt.Println("Sending shipping notification")
 
 	// Simulate some work
-	time.Sleep(500 * time.Millisecond)
+	if err := simulateWork(ctx, 500*time.Millisecond); err != nil {
+		return nil, err
+	}
 
 	return map[string]any{
 		"sent": true,
-		"time": time.Now().Format(time.RFC3339),
+		"time": clock.FromContext(ctx).Now().Format(time.RFC3339),
 	}, nil
 }
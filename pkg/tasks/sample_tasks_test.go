@@ -35,7 +35,7 @@ func TestSendEmailTask(t *testing.T) {
 	params := map[string]string{
 		"template": "test_template",
 	}
-	result, err := task.Execute(context.Background(), params, state)
+	result, err := executeOnFakeClock(task, params, state)
 	if err != nil {
 		t.Fatalf("Failed to execute task: %v", err)
 	}
@@ -49,8 +49,8 @@ func TestSendEmailTask(t *testing.T) {
 		t.Errorf("Expected template test_template, got %v", result["template"])
 	}
 
-	if result["time"] == nil {
-		t.Error("Expected time to be set")
+	if result["time"] != testTaskTime {
+		t.Errorf("Expected time %s, got %v", testTaskTime, result["time"])
 	}
 }
 
@@ -82,7 +82,7 @@ func TestProcessPaymentTask(t *testing.T) {
 	params := map[string]string{
 		"amount": "100.00",
 	}
-	result, err := task.Execute(context.Background(), params, state)
+	result, err := executeOnFakeClock(task, params, state)
 	if err != nil {
 		t.Fatalf("Failed to execute task: %v", err)
 	}
@@ -96,8 +96,48 @@ func TestProcessPaymentTask(t *testing.T) {
 		t.Errorf("Expected amount 100.00, got %v", result["amount"])
 	}
 
-	if result["time"] == nil {
-		t.Error("Expected time to be set")
+	if result["time"] != testTaskTime {
+		t.Errorf("Expected time %s, got %v", testTaskTime, result["time"])
+	}
+}
+
+func TestRefundPaymentTask(t *testing.T) {
+	// Create a task
+	task := &RefundPaymentTask{}
+
+	// Verify the task name
+	if task.Name() != "refund_payment" {
+		t.Errorf("Expected task name refund_payment, got %s", task.Name())
+	}
+
+	// Create a workflow state
+	state := &models.WorkflowState{
+		WorkflowName:   "test_workflow",
+		CurrentStep:    "step1",
+		CompletedSteps: []string{},
+		StepResults:    make(map[string]models.StepResult),
+		Status:         "failed",
+	}
+
+	// Test with missing amount parameter
+	_, err := task.Execute(context.Background(), map[string]string{}, state)
+	if err == nil {
+		t.Error("Expected error for missing amount parameter")
+	}
+
+	// Test with valid parameters
+	result, err := executeOnFakeClock(task, map[string]string{"amount": "100.00"}, state)
+	if err != nil {
+		t.Fatalf("Failed to execute task: %v", err)
+	}
+
+	// Verify the result
+	if result["refunded"] != true {
+		t.Errorf("Expected refunded true, got %v", result["refunded"])
+	}
+
+	if result["amount"] != "100.00" {
+		t.Errorf("Expected amount 100.00, got %v", result["amount"])
 	}
 }
 
@@ -120,7 +160,7 @@ func TestPackItemsTask(t *testing.T) {
 	}
 
 	// Execute the task
-	result, err := task.Execute(context.Background(), map[string]string{}, state)
+	result, err := executeOnFakeClock(task, map[string]string{}, state)
 	if err != nil {
 		t.Fatalf("Failed to execute task: %v", err)
 	}
@@ -130,8 +170,8 @@ func TestPackItemsTask(t *testing.T) {
 		t.Errorf("Expected packed true, got %v", result["packed"])
 	}
 
-	if result["time"] == nil {
-		t.Error("Expected time to be set")
+	if result["time"] != testTaskTime {
+		t.Errorf("Expected time %s, got %v", testTaskTime, result["time"])
 	}
 }
 
@@ -154,7 +194,7 @@ func TestSendShippingNotificationTask(t *testing.T) {
 	}
 
 	// Execute the task
-	result, err := task.Execute(context.Background(), map[string]string{}, state)
+	result, err := executeOnFakeClock(task, map[string]string{}, state)
 	if err != nil {
 		t.Fatalf("Failed to execute task: %v", err)
 	}
@@ -164,7 +204,7 @@ func TestSendShippingNotificationTask(t *testing.T) {
 		t.Errorf("Expected sent true, got %v", result["sent"])
 	}
 
-	if result["time"] == nil {
-		t.Error("Expected time to be set")
+	if result["time"] != testTaskTime {
+		t.Errorf("Expected time %s, got %v", testTaskTime, result["time"])
 	}
 }
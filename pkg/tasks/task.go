@@ -2,7 +2,10 @@ package tasks
 
 import (
 	"context"
+	"sync"
+	"time"
 
+	"github.com/mstgnz/goflow/pkg/clock"
 	"github.com/mstgnz/goflow/pkg/models"
 )
 
@@ -14,8 +17,18 @@ type Task interface {
 	Name() string
 }
 
-// Registry is a registry of all available tasks
+// Reconciler is implemented by tasks that can find out whether an execution
+// that was interrupted, e.g. by a crash, took effect. When a run is resumed,
+// the engine reconciles interrupted steps that are not idempotent instead of
+// running them again. Reconcile returns the task's result and true if the
+// interrupted execution completed, or false if the task should run again
+type Reconciler interface {
+	Reconcile(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, bool, error)
+}
+
+// Registry is a registry of all available tasks. It is safe for concurrent use
 type Registry struct {
+	mu    sync.RWMutex
 	tasks map[string]Task
 }
 
@@ -28,20 +41,33 @@ func NewRegistry() *Registry {
 
 // Register registers a task with the registry
 func (r *Registry) Register(task Task) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.tasks[task.Name()] = task
 }
 
 // Get returns a task by name
 func (r *Registry) Get(name string) (Task, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	task, ok := r.tasks[name]
 	return task, ok
 }
 
 // List returns a list of all registered task names
 func (r *Registry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	var names []string
 	for name := range r.tasks {
 		names = append(names, name)
 	}
 	return names
 }
+
+// simulateWork waits for the given duration on the clock of the context, like
+// a real task doing work would, but returns early with the context's error
+// once the run is cancelled or times out
+func simulateWork(ctx context.Context, d time.Duration) error {
+	return clock.Sleep(ctx, d)
+}
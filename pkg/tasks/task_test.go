@@ -2,11 +2,31 @@ package tasks
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/mstgnz/goflow/pkg/clock"
 	"github.com/mstgnz/goflow/pkg/models"
 )
 
+// testTaskTime is the time tasks executed by executeOnFakeClock see when they return
+const testTaskTime = "2025-03-01T12:01:00Z"
+
+// executeOnFakeClock executes a task on a fake clock, and moves the clock a
+// minute on once the task waits, so the work it simulates takes no time
+func executeOnFakeClock(task Task, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	fake := clock.NewFake(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	ctx := clock.NewContext(context.Background(), fake)
+
+	go func() {
+		fake.BlockUntil(1)
+		fake.Advance(time.Minute)
+	}()
+
+	return task.Execute(ctx, params, state)
+}
+
 // MockTask is a mock task for testing
 type MockTask struct {
 	name     string
@@ -132,3 +152,19 @@ func TestTaskExecution(t *testing.T) {
 		t.Errorf("Expected mock data, got %v", result["mock"])
 	}
 }
+
+func TestSimulateWorkCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A bundled task returns as soon as its context is done
+	started := time.Now()
+	_, err := (&PackItemsTask{}).Execute(ctx, map[string]string{}, &models.WorkflowState{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected the task to return immediately, took %v", elapsed)
+	}
+}
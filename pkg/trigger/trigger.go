@@ -0,0 +1,248 @@
+// Package trigger starts workflow runs from HTTP requests, such as webhook
+// deliveries, through the triggers the loaded workflows declare
+package trigger
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/workflow"
+)
+
+// DefaultSignatureHeader is the header that carries the HMAC signature of a
+// request body, unless a trigger names another one
+const DefaultSignatureHeader = "X-Signature-256"
+
+// MaxWait bounds how long the wait query parameter of a request to a
+// trigger without a wait of its own can hold the request open
+const MaxWait = 30 * time.Second
+
+// maxBodySize bounds the request bodies
+const maxBodySize = 4 << 20
+
+// Response is the body of a response to a request that started a run, or
+// found the run an earlier request with its idempotency key started
+type Response struct {
+	RunID     string         `json:"run_id"`
+	Status    models.Status  `json:"status"`
+	Duplicate bool           `json:"duplicate,omitempty"` // an earlier request with the idempotency key started the run
+	Outputs   map[string]any `json:"outputs,omitempty"`   // set once the run completed
+}
+
+// Handler starts runs for the requests that match the triggers of the
+// workflows loaded in an engine, looked up by the request's method and path.
+// Workflows loaded later are served too
+type Handler struct {
+	engine *workflow.Engine
+}
+
+// NewHandler creates a handler for the triggers of an engine's workflows
+func NewHandler(engine *workflow.Engine) *Handler {
+	return &Handler{engine: engine}
+}
+
+// ServeHTTP starts a run for a request. The body's signature is checked
+// when the trigger has a secret, and the run's inputs and idempotency key
+// are taken from the request. The response holds the run ID right away,
+// unless the trigger's wait, or the wait query parameter, asks to wait for
+// the run to end and return its outputs. A run that ended is returned with
+// 200 OK, and one that is still running or waiting with 202 Accepted
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wf, trigger, err := h.engine.FindTrigger(r.Method, r.URL.Path)
+	switch {
+	case errors.Is(err, workflow.ErrNoTrigger):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, workflow.ErrTriggerMethod):
+		writeError(w, http.StatusMethodNotAllowed, err)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("failed to read body: %w", err))
+		return
+	}
+
+	if trigger.SecretEnv != "" {
+		secret := os.Getenv(trigger.SecretEnv)
+		if secret == "" {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("secret %s is not set", trigger.SecretEnv))
+			return
+		}
+
+		header := trigger.SignatureHeader
+		if header == "" {
+			header = DefaultSignatureHeader
+		}
+		if !Verify(secret, data, r.Header.Get(header)) {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid signature in %s", header))
+			return
+		}
+	}
+
+	var body any
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %w", err))
+			return
+		}
+	}
+
+	wait, err := waitTime(trigger, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Map the request to the run's inputs and idempotency key
+	vars := h.engine.TriggerVars(body, headers(r), query(r))
+	inputs, err := h.engine.TriggerInputs(wf, trigger, vars)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	key, err := h.engine.TriggerKey(trigger, vars)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// The run outlives the request, even when the request waits for it
+	runID, started, err := h.engine.StartOnce(context.Background(), wf.Name, inputs, key)
+	if err != nil {
+		// Only invalid inputs are the client's fault, not e.g. a failing store
+		status := http.StatusInternalServerError
+		if errors.Is(err, workflow.ErrInvalidInputs) {
+			status = http.StatusBadRequest
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	if wait > 0 {
+		// A run that outlives the wait is returned as it is
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		_, _ = h.engine.Wait(ctx, runID)
+		cancel()
+	}
+
+	state, err := h.engine.GetRun(runID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := Response{RunID: runID, Status: state.Status, Duplicate: !started}
+	status := http.StatusAccepted
+	if ended(state.Status) {
+		resp.Outputs = state.Outputs
+		status = http.StatusOK
+	}
+	w.Header().Set("Location", "/runs/"+runID)
+	writeJSON(w, status, resp)
+}
+
+// Sign returns the signature of a body for a secret, as it is expected in
+// the signature header: "sha256=" followed by the hex HMAC-SHA256 of the body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether a signature of a body is valid for a secret. The
+// "sha256=" prefix is optional
+func Verify(secret string, body []byte, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// waitTime returns how long a request waits for its run to end, from the
+// wait query parameter or else the trigger. Zero does not wait. The query
+// parameter waits at most as long as the trigger's wait, or MaxWait when
+// the trigger has none
+func waitTime(trigger *models.Trigger, r *http.Request) (time.Duration, error) {
+	var declared time.Duration
+	if trigger.Wait != "" {
+		d, err := time.ParseDuration(trigger.Wait)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid wait %q", trigger.Wait)
+		}
+		declared = d
+	}
+
+	value := r.URL.Query().Get("wait")
+	if value == "" {
+		return declared, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid wait %q", value)
+	}
+	if trigger.Wait == "" {
+		return min(d, MaxWait), nil
+	}
+	return min(d, declared), nil
+}
+
+// ended reports whether a run with a status has ended
+func ended(status models.Status) bool {
+	switch status {
+	case models.RunRunning, models.RunWaiting, models.RunCompensating:
+		return false
+	}
+	return true
+}
+
+// headers returns the first value of each request header, by lower case name
+func headers(r *http.Request) map[string]string {
+	values := make(map[string]string, len(r.Header))
+	for name, v := range r.Header {
+		if len(v) > 0 {
+			values[strings.ToLower(name)] = v[0]
+		}
+	}
+	return values
+}
+
+// query returns the first value of each query parameter
+func query(r *http.Request) map[string]string {
+	values := make(map[string]string)
+	for name, v := range r.URL.Query() {
+		if len(v) > 0 {
+			values[name] = v[0]
+		}
+	}
+	return values
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
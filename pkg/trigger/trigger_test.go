@@ -0,0 +1,287 @@
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+	"github.com/mstgnz/goflow/pkg/workflow"
+)
+
+// EchoTask returns its params as data
+type EchoTask struct{}
+
+func (t *EchoTask) Name() string {
+	return "echo"
+}
+
+func (t *EchoTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	data := make(map[string]any, len(params))
+	for name, value := range params {
+		data[name] = value
+	}
+	return data, nil
+}
+
+// testWorkflow declares a signed trigger that maps the body to the inputs,
+// and an unsigned one that takes the body's fields and waits for the run
+const testWorkflow = `
+name: order_process
+inputs:
+  - name: order_id
+    type: string
+    required: true
+outputs:
+  confirmation: steps.confirm.data.text
+triggers:
+  - path: /orders
+    secret_env: TEST_TRIGGER_SECRET
+    signature_header: X-Shop-Signature
+    inputs:
+      order_id: body.order.id
+    idempotency_key: body.delivery_id
+  - path: /orders/sync
+    wait: 5s
+steps:
+  - id: confirm
+    task: echo
+    params:
+      text: "order ${{ inputs.order_id }}"
+`
+
+// newTestHandler returns an engine with the test workflow loaded, with its
+// triggers served by a test HTTP server
+func newTestHandler(t *testing.T) (*workflow.Engine, *httptest.Server) {
+	t.Helper()
+	t.Setenv("TEST_TRIGGER_SECRET", "s3cret")
+
+	engine := workflow.NewEngine()
+	engine.RegisterTask(&EchoTask{})
+	if err := engine.LoadBytes([]byte(testWorkflow), workflow.FormatYAML); err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+
+	ts := httptest.NewServer(NewHandler(engine))
+	t.Cleanup(ts.Close)
+	return engine, ts
+}
+
+// deliver sends a request to the test server with headers and decodes the
+// JSON response into out, if it is not nil. It returns the status code
+func deliver(t *testing.T, ts *httptest.Server, method, path, body string, headers map[string]string, out any) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Failed to decode response of %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"order": 1}`)
+	signature := Sign("secret", body)
+
+	if !strings.HasPrefix(signature, "sha256=") {
+		t.Errorf("Expected a sha256= prefix, got %s", signature)
+	}
+
+	tests := []struct {
+		secret    string
+		body      []byte
+		signature string
+		expected  bool
+	}{
+		{"secret", body, signature, true},
+		{"secret", body, strings.TrimPrefix(signature, "sha256="), true},
+		{"other", body, signature, false},
+		{"secret", []byte(`{"order": 2}`), signature, false},
+		{"secret", body, "", false},
+		{"secret", body, "sha256=not-hex", false},
+	}
+	for _, tt := range tests {
+		if got := Verify(tt.secret, tt.body, tt.signature); got != tt.expected {
+			t.Errorf("Expected Verify(%s, %s, %s) to be %v", tt.secret, tt.body, tt.signature, tt.expected)
+		}
+	}
+}
+
+func TestSignedTrigger(t *testing.T) {
+	engine, ts := newTestHandler(t)
+
+	body := `{"delivery_id": "d-1", "order": {"id": "A-1"}}`
+	signed := map[string]string{"X-Shop-Signature": Sign("s3cret", []byte(body))}
+
+	// A signed delivery starts a run with the mapped inputs
+	var resp Response
+	if status := deliver(t, ts, http.MethodPost, "/orders", body, signed, &resp); status != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", status)
+	}
+	if resp.RunID == "" || resp.Duplicate {
+		t.Fatalf("Expected a new run, got %+v", resp)
+	}
+
+	state, err := engine.Wait(context.Background(), resp.RunID)
+	if err != nil {
+		t.Fatalf("Failed to wait for run: %v", err)
+	}
+	if state.Inputs["order_id"] != "A-1" || state.IdempotencyKey != "d-1" {
+		t.Errorf("Expected order_id A-1 and key d-1, got %v and %q", state.Inputs["order_id"], state.IdempotencyKey)
+	}
+
+	// A retried delivery returns the same run
+	var retry Response
+	if status := deliver(t, ts, http.MethodPost, "/orders", body, signed, &retry); status != http.StatusOK {
+		t.Fatalf("Expected status 200 for a run that ended, got %d", status)
+	}
+	if retry.RunID != resp.RunID || !retry.Duplicate {
+		t.Errorf("Expected duplicate of run %s, got %+v", resp.RunID, retry)
+	}
+	if runs, _ := engine.ListRuns(workflow.RunFilter{WorkflowName: "order_process"}); len(runs) != 1 {
+		t.Errorf("Expected 1 run, got %d", len(runs))
+	}
+
+	// Deliveries without a valid signature are rejected
+	tests := []map[string]string{
+		nil,
+		{"X-Shop-Signature": Sign("wrong", []byte(body))},
+		{"X-Signature-256": Sign("s3cret", []byte(body))},
+	}
+	for _, headers := range tests {
+		if status := deliver(t, ts, http.MethodPost, "/orders", body, headers, nil); status != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for %v, got %d", headers, status)
+		}
+	}
+}
+
+func TestWaitingTrigger(t *testing.T) {
+	_, ts := newTestHandler(t)
+
+	// The trigger waits for the run and returns its outputs
+	var resp Response
+	headers := map[string]string{"Idempotency-Key": "k-1"}
+	if status := deliver(t, ts, http.MethodPost, "/orders/sync", `{"order_id": "B-2", "note": "ignored"}`, headers, &resp); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if resp.Status != models.RunCompleted || resp.Outputs["confirmation"] != "order B-2" {
+		t.Errorf("Expected completed run with confirmation order B-2, got %+v", resp)
+	}
+
+	// The Idempotency-Key header is the default key
+	var retry Response
+	deliver(t, ts, http.MethodPost, "/orders/sync", `{"order_id": "B-2"}`, headers, &retry)
+	if retry.RunID != resp.RunID || !retry.Duplicate {
+		t.Errorf("Expected duplicate of run %s, got %+v", resp.RunID, retry)
+	}
+
+	// A zero wait in the query returns right away
+	var quick Response
+	if status := deliver(t, ts, http.MethodPost, "/orders/sync?wait=0s", `{"order_id": "C-3"}`, nil, &quick); status != http.StatusAccepted && status != http.StatusOK {
+		t.Errorf("Expected status 202 or 200, got %d", status)
+	}
+	if quick.RunID == "" || quick.RunID == resp.RunID {
+		t.Errorf("Expected a new run, got %+v", quick)
+	}
+}
+
+func TestWaitTime(t *testing.T) {
+	tests := []struct {
+		wait     string
+		query    string
+		expected time.Duration
+	}{
+		{"", "", 0},
+		{"5s", "", 5 * time.Second},
+		{"5s", "?wait=2s", 2 * time.Second},
+		{"5s", "?wait=1h", 5 * time.Second},
+		{"", "?wait=10s", 10 * time.Second},
+		{"", "?wait=1000h", MaxWait},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/orders"+tt.query, nil)
+		d, err := waitTime(&models.Trigger{Path: "/orders", Wait: tt.wait}, r)
+		if err != nil || d != tt.expected {
+			t.Errorf("Expected wait %s for trigger wait %q and query %q, got %s (%v)", tt.expected, tt.wait, tt.query, d, err)
+		}
+	}
+}
+
+func TestTriggerErrors(t *testing.T) {
+	_, ts := newTestHandler(t)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/missing", `{}`, http.StatusNotFound},
+		{http.MethodGet, "/orders/sync", ``, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/orders/sync", `{"order_id": `, http.StatusBadRequest},
+		{http.MethodPost, "/orders/sync", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/orders/sync?wait=soon", `{"order_id": "A-1"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		var body map[string]string
+		if status := deliver(t, ts, tt.method, tt.path, tt.body, nil, &body); status != tt.status {
+			t.Errorf("Expected status %d for %s %s %s, got %d", tt.status, tt.method, tt.path, tt.body, status)
+		}
+		if body["error"] == "" {
+			t.Errorf("Expected an error message for %s %s", tt.method, tt.path)
+		}
+	}
+}
+
+// failingStore fails to record events
+type failingStore struct {
+	*store.MemoryStore
+}
+
+func (s failingStore) AppendEvent(event models.Event) error {
+	return errors.New("disk full")
+}
+
+func TestTriggerStoreError(t *testing.T) {
+	engine, ts := newTestHandler(t)
+	engine.SetStateStore(failingStore{store.NewMemoryStore()})
+
+	// Runs that cannot be stored are the server's fault, not the sender's
+	var body map[string]string
+	if status := deliver(t, ts, http.MethodPost, "/orders/sync", `{"order_id": "A-1"}`, nil, &body); status != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", status)
+	}
+	if !strings.Contains(body["error"], "disk full") {
+		t.Errorf("Expected the store's error, got %q", body["error"])
+	}
+}
+
+func TestTriggerSecretNotSet(t *testing.T) {
+	_, ts := newTestHandler(t)
+	t.Setenv("TEST_TRIGGER_SECRET", "")
+
+	if status := deliver(t, ts, http.MethodPost, "/orders", `{}`, nil, nil); status != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", status)
+	}
+}
@@ -0,0 +1,37 @@
+package workflow
+
+import (
+	"fmt"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// selectBranch returns the step picked by the branches of a step that
+// succeeded: the target of the first branch whose expression holds, or of
+// the default branch, or "" if none applies. The state must include the
+// step's result, so branches can route on it
+func (e *Engine) selectBranch(step *models.Step, state *models.WorkflowState) (string, error) {
+	for _, branch := range step.Branches {
+		// The default branch is the last one
+		if branch.When == "" {
+			return branch.Next, nil
+		}
+
+		ok, err := e.evaluateCondition(branch.When, state)
+		if err != nil {
+			return "", fmt.Errorf("branch to %s: %w", branch.Next, err)
+		}
+		if ok {
+			return branch.Next, nil
+		}
+	}
+	return "", nil
+}
+
+// completedState returns a copy of the state in which a step has completed,
+// to pick its branch before the completion is recorded
+func completedState(state *models.WorkflowState, stepID string) *models.WorkflowState {
+	preview := state.Clone()
+	preview.Apply(models.Event{Type: models.EventStepCompleted, StepID: stepID})
+	return preview
+}
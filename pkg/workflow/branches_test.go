@@ -0,0 +1,176 @@
+package workflow
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// newBranchEngine returns an engine with a workflow that routes on the score
+// of a fraud check, and joins the branches again in a notify step
+func newBranchEngine(score float64) *Engine {
+	engine := NewEngine()
+	engine.RegisterTask(&MockTask{name: "fraud_check", result: map[string]any{"score": score}})
+	engine.RegisterTask(&EchoTask{name: "echo"})
+
+	engine.workflows["fraud"] = &models.Workflow{
+		Name: "fraud",
+		Steps: []models.Step{
+			{ID: "check", Task: "fraud_check", Branches: []models.Branch{
+				{When: "check.score > 80", Next: "reject"},
+				{When: "check.score > 40", Next: "manual_review"},
+				{Next: "auto_approve"},
+			}},
+			{ID: "reject", Task: "echo", Next: []string{"notify"}},
+			{ID: "manual_review", Task: "echo", Next: []string{"notify"}},
+			{ID: "auto_approve", Task: "echo", Next: []string{"notify"}},
+			{ID: "notify", Task: "echo"},
+		},
+	}
+
+	return engine
+}
+
+func TestBranches(t *testing.T) {
+	tests := []struct {
+		score  float64
+		branch string
+	}{
+		{90, "reject"},
+		{50, "manual_review"},
+		{10, "auto_approve"},
+	}
+
+	for _, tt := range tests {
+		// Create a new engine
+		engine := newBranchEngine(tt.score)
+
+		state, err := engine.Run("fraud")
+		if err != nil {
+			t.Fatalf("Failed to run workflow: %v", err)
+		}
+
+		if branch := state.StepResults["check"].Branch; branch != tt.branch {
+			t.Errorf("Expected score %v to take branch %s, got %s", tt.score, tt.branch, branch)
+		}
+
+		// Only the branch taken runs, the others are skipped rather than succeeded
+		for _, id := range []string{"reject", "manual_review", "auto_approve"} {
+			result := state.StepResults[id]
+			if id == tt.branch && result.Status != models.StepSucceeded {
+				t.Errorf("Expected branch %s to succeed, got %s", id, result.Status)
+			}
+			if id != tt.branch && (result.Status != models.StepSkipped || result.Success) {
+				t.Errorf("Expected branch %s to be skipped, got %+v", id, result)
+			}
+		}
+
+		// The branch taken leads to the join step
+		if status := state.StepResults["notify"].Status; status != models.StepSucceeded {
+			t.Errorf("Expected notify to run after the branch, got %s", status)
+		}
+	}
+}
+
+func TestBranchesWithoutMatch(t *testing.T) {
+	// Create a new engine
+	engine := newBranchEngine(10)
+	steps := engine.workflows["fraud"].Steps
+	steps[0].Branches = steps[0].Branches[:2]
+
+	state, err := engine.Run("fraud")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	// Without a default branch nothing follows the step
+	for _, id := range []string{"reject", "manual_review", "notify"} {
+		if status := state.StepResults[id].Status; status != models.StepSkipped {
+			t.Errorf("Expected step %s to be skipped, got %s", id, status)
+		}
+	}
+
+	if state.Status != "completed" {
+		t.Errorf("Expected status completed, got %s", state.Status)
+	}
+}
+
+func TestBranchError(t *testing.T) {
+	// Create a new engine
+	engine := newBranchEngine(10)
+	engine.RegisterTask(&MockTask{name: "fraud_check", result: map[string]any{"score": "high"}})
+
+	// Comparing a string with a number fails when the branch is evaluated
+	state, err := engine.Run("fraud")
+	if err == nil || !strings.Contains(err.Error(), "failed to evaluate branches of step check") {
+		t.Fatalf("Expected branch error, got %v", err)
+	}
+
+	if status := state.StepResults["check"].Status; status != models.StepFailed {
+		t.Errorf("Expected check to fail, got %s", status)
+	}
+}
+
+func TestReplayBranchDrift(t *testing.T) {
+	// Create a new engine
+	engine := newBranchEngine(90)
+
+	state, err := engine.Run("fraud")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	events, _ := engine.Events(state.RunID)
+	if _, err := engine.Replay(events); err != nil {
+		t.Fatalf("Expected replay without drift, got %v", err)
+	}
+
+	// A score of 90 no longer leads to reject
+	engine.workflows["fraud"].Steps[0].Branches[0].When = "check.score > 95"
+
+	_, err = engine.Replay(events)
+	var drift *DriftError
+	if !errors.As(err, &drift) {
+		t.Fatalf("Expected DriftError, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), `step check took branch "reject" but now takes "manual_review"`) {
+		t.Errorf("Expected branch drift, got %v", err)
+	}
+}
+
+func TestBranchValidation(t *testing.T) {
+	registry := tasks.NewRegistry()
+	registry.Register(&MockTask{name: "task1"})
+
+	workflow := &models.Workflow{
+		Name: "branches",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Branches: []models.Branch{
+				{Next: "step2"},
+				{When: "step1.score", Next: "missing"},
+				{When: "nope.ok", Next: "step2"},
+			}},
+			{ID: "step2", Task: "task1"},
+		},
+	}
+
+	err := Validate(workflow, registry)
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	expected := []string{
+		"step step1: default branch to step2 must be the last branch",
+		"step step1: branch step missing does not exist",
+		`step step1: branch "nope.ok": column 1: unknown variable nope`,
+	}
+	for _, message := range expected {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error %q, got %v", message, err)
+		}
+	}
+}
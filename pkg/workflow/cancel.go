@@ -0,0 +1,100 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/store"
+)
+
+// DefaultGracePeriod is how long a cancelled run waits for its running tasks to stop
+const DefaultGracePeriod = 10 * time.Second
+
+// SetGracePeriod sets how long a cancelled run waits for its running tasks to
+// stop before it leaves them behind. Zero does not wait at all
+func (e *Engine) SetGracePeriod(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.gracePeriod = d
+}
+
+// Cancel stops a running workflow. No further steps are started, running
+// tasks see their context cancelled and get the grace period to stop, and
+// the run ends with the status "cancelled". Cancel does not wait for the run
+// to end. Cancelling a run also cancels the child runs of its workflow steps.
+// A run that stopped to wait for a signal is cancelled right away
+func (e *Engine) Cancel(runID string) error {
+	run, ok := e.activeRun(runID)
+	if !ok {
+		return e.cancelWaiting(runID)
+	}
+
+	run.cancel()
+	return nil
+}
+
+// grace returns the grace period of cancelled runs
+func (e *Engine) grace() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.gracePeriod
+}
+
+// activeRun is a run that is executing in this process
+type activeRun struct {
+	cancel  context.CancelFunc
+	signals chan signal   // signals for the run's waiting steps
+	done    chan struct{} // closed once the run stops executing
+	unlock  func()        // releases the run's lock in the state store, if it has one
+}
+
+// newActiveRun returns an active run that is stopped by cancel
+func newActiveRun(cancel context.CancelFunc) *activeRun {
+	return &activeRun{cancel: cancel, signals: make(chan signal), done: make(chan struct{})}
+}
+
+// activate registers a run that starts executing. A run can only execute
+// once at a time
+func (e *Engine) activate(runID string, run *activeRun) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.active[runID]; ok {
+		return fmt.Errorf("run %s is already running", runID)
+	}
+
+	// Another process on the same store may be executing the run
+	if locker, ok := e.stateStore.(store.RunLocker); ok {
+		unlock, err := locker.LockRun(runID)
+		if err != nil {
+			return fmt.Errorf("cannot execute run %s: %w", runID, err)
+		}
+		run.unlock = unlock
+	}
+	e.active[runID] = run
+	return nil
+}
+
+// deactivate removes a run that stopped executing
+func (e *Engine) deactivate(runID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if run, ok := e.active[runID]; ok {
+		if run.unlock != nil {
+			run.unlock()
+		}
+		close(run.done)
+		delete(e.active, runID)
+	}
+}
+
+// activeRun returns a run that is executing
+func (e *Engine) activeRun(runID string) (*activeRun, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	run, ok := e.active[runID]
+	return run, ok
+}
@@ -0,0 +1,186 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// BlockingTask tells the test it has started, then blocks until its context
+// is done. It takes cleanup to return afterwards, or never returns when stubborn
+type BlockingTask struct {
+	name     string
+	started  chan struct{}
+	cleanup  time.Duration
+	stubborn bool
+}
+
+func (t *BlockingTask) Name() string {
+	return t.name
+}
+
+func (t *BlockingTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	close(t.started)
+	<-ctx.Done()
+	if t.stubborn {
+		select {}
+	}
+	time.Sleep(t.cleanup)
+	return nil, errors.New("interrupted after cleanup")
+}
+
+// startBlockingRun runs a workflow whose first step blocks, and returns its
+// run ID once the step has started along with a channel for the run's result
+func startBlockingRun(t *testing.T, engine *Engine, task *BlockingTask) (string, <-chan error) {
+	t.Helper()
+
+	engine.RegisterTask(task)
+	engine.RegisterTask(&MockTask{name: "after"})
+	engine.workflows["blocking"] = &models.Workflow{
+		Name: "blocking",
+		Steps: []models.Step{
+			{ID: "block", Task: task.name, Next: []string{"after"}},
+			{ID: "after", Task: "after"},
+		},
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := engine.RunContext(context.Background(), "blocking", nil)
+		done <- err
+	}()
+
+	<-task.started
+	state, ok := engine.GetState("blocking")
+	if !ok || state.RunID == "" {
+		t.Fatal("Expected the running workflow to have a run id")
+	}
+	return state.RunID, done
+}
+
+func TestCancelRun(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	task := &BlockingTask{name: "block", started: make(chan struct{})}
+	runID, done := startBlockingRun(t, engine, task)
+
+	if err := engine.Cancel(runID); err != nil {
+		t.Fatalf("Failed to cancel run: %v", err)
+	}
+
+	err := <-done
+	if err == nil {
+		t.Fatal("Expected cancelled run to return an error")
+	}
+
+	if !strings.Contains(err.Error(), "workflow blocking was cancelled") {
+		t.Errorf("Expected cancellation error, got %v", err)
+	}
+
+	state, _ := engine.GetState("blocking")
+	if state.Status != "cancelled" {
+		t.Errorf("Expected workflow status cancelled, got %s", state.Status)
+	}
+
+	// The interrupted step is recorded, and no further steps run
+	if state.CurrentStep != "block" {
+		t.Errorf("Expected current step block, got %s", state.CurrentStep)
+	}
+
+	result := state.StepResults["block"]
+	if result.Status != models.StepCancelled {
+		t.Errorf("Expected step status %s, got %s", models.StepCancelled, result.Status)
+	}
+
+	if result.Error != "interrupted after cleanup" {
+		t.Errorf("Expected the task's own error, got %s", result.Error)
+	}
+
+	if status := state.StepResults["after"].Status; status != models.StepPending {
+		t.Errorf("Expected the next step not to run, got %s", status)
+	}
+
+	// The run is no longer active
+	if err := engine.Cancel(runID); err == nil {
+		t.Error("Expected error cancelling a finished run")
+	}
+}
+
+func TestCancelGracePeriod(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.SetGracePeriod(20 * time.Millisecond)
+	task := &BlockingTask{name: "block", started: make(chan struct{}), stubborn: true}
+	runID, done := startBlockingRun(t, engine, task)
+
+	started := time.Now()
+	if err := engine.Cancel(runID); err != nil {
+		t.Fatalf("Failed to cancel run: %v", err)
+	}
+
+	// A task that does not stop is left behind after the grace period
+	err := <-done
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Expected the run to end after the grace period, took %v", elapsed)
+	}
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected run error to wrap context.Canceled, got %v", err)
+	}
+
+	state, _ := engine.GetState("blocking")
+	result := state.StepResults["block"]
+	if result.Status != models.StepCancelled || !strings.Contains(result.Error, "task did not stop within 20ms") {
+		t.Errorf("Expected cancelled step that did not stop, got %+v", result)
+	}
+}
+
+func TestRunContextCancelled(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	task := &MockTask{name: "task1"}
+	engine.RegisterTask(task)
+	engine.workflows["cancelled"] = &models.Workflow{
+		Name:  "cancelled",
+		Steps: []models.Step{{ID: "step1", Task: "task1"}},
+	}
+
+	// A run whose context is already cancelled starts no steps
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	state, err := engine.RunContext(ctx, "cancelled", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected run error to wrap context.Canceled, got %v", err)
+	}
+
+	if state.Status != "cancelled" {
+		t.Errorf("Expected workflow status cancelled, got %s", state.Status)
+	}
+
+	if task.executed {
+		t.Error("Expected the task not to run")
+	}
+}
+
+func TestCancelUnknownRun(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.Cancel("missing"); err == nil || !strings.Contains(err.Error(), "run not found") {
+		t.Errorf("Expected run not found error, got %v", err)
+	}
+}
+
+func TestRunIDs(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := newRunID(time.Now())
+		if len(id) != 32 || seen[id] {
+			t.Fatalf("Expected unique 32 character run ids, got %s", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,107 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// compensating reports whether a run stopped while its compensations were
+// running, or after some of them failed, so resuming it retries them
+func compensating(state *models.WorkflowState) bool {
+	return state.Status == models.RunCompensating || state.Status == models.RunCompensationFailed
+}
+
+// needsCompensation reports whether any completed step of a run has a compensation
+func needsCompensation(g *graph, state *models.WorkflowState) bool {
+	for _, id := range state.CompletedSteps {
+		if step, ok := g.steps[id]; ok && step.Compensate != nil && state.StepResults[id].Success {
+			return true
+		}
+	}
+	return false
+}
+
+// compensate undoes a failed or timed out run by running the compensations
+// of its completed steps one at a time, in reverse completion order. A failed
+// compensation does not stop the others. Compensations that succeeded before
+// the run was resumed are not run again. The final status of the run is
+// returned with the error it failed with, joined with those of the failed
+// compensations. A run whose compensations are cancelled stays
+// "compensating", so it can be resumed
+func (e *Engine) compensate(ctx context.Context, workflow *models.Workflow, rec *recorder, runErr error) (models.Status, error) {
+	state := rec.state
+	g := newGraph(workflow)
+
+	if err := rec.record(models.Event{Type: models.EventRunCompensating, Error: runErr.Error()}); err != nil {
+		return models.RunCompensating, errors.Join(runErr, err)
+	}
+
+	var errs []error
+	for i := len(state.CompletedSteps) - 1; i >= 0; i-- {
+		id := state.CompletedSteps[i]
+
+		// Skipped steps have no result and nothing to undo
+		step, ok := g.steps[id]
+		if !ok || step.Compensate == nil || !state.StepResults[id].Success {
+			continue
+		}
+		if c := state.StepResults[id].Compensation; c != nil && c.Status == models.StepSucceeded {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return models.RunCompensating, errors.Join(runErr, fmt.Errorf("compensation was interrupted: %w", err))
+		}
+
+		// Resolve placeholders, e.g. from the data the step returned
+		params, err := e.resolveParams(step.Compensate.Params, state)
+		if err != nil {
+			err = fmt.Errorf("failed to resolve compensation params of step %s: %w", id, err)
+			errs = append(errs, err)
+			if recordErr := rec.record(models.Event{Type: models.EventCompensationFailed, StepID: id, Error: err.Error()}); recordErr != nil {
+				return models.RunCompensating, errors.Join(runErr, recordErr)
+			}
+			continue
+		}
+
+		if err := rec.record(models.Event{Type: models.EventCompensationStarted, StepID: id, Task: step.Compensate.Task, Params: e.recordedParams(step.Compensate.Params, params)}); err != nil {
+			return models.RunCompensating, errors.Join(runErr, err)
+		}
+
+		data, duration, err := e.executeCompensation(ctx, step.Compensate, params, state.Clone())
+		event := models.Event{Type: models.EventCompensationCompleted, StepID: id, Data: data, Duration: duration}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to compensate step %s: %w", id, err))
+			event = models.Event{Type: models.EventCompensationFailed, StepID: id, Error: err.Error(), Duration: duration}
+		}
+		if err := rec.record(event); err != nil {
+			return models.RunCompensating, errors.Join(runErr, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		// A compensation stopped by cancellation has not really failed
+		status := models.RunCompensationFailed
+		if ctx.Err() != nil {
+			status = models.RunCompensating
+		}
+		return status, errors.Join(append([]error{runErr}, errs...)...)
+	}
+	return models.RunCompensated, runErr
+}
+
+// executeCompensation runs the task of a compensation once
+func (e *Engine) executeCompensation(ctx context.Context, compensation *models.Compensation, params map[string]string, state *models.WorkflowState) (map[string]any, time.Duration, error) {
+	task, ok := e.taskRegistry.Get(compensation.Task)
+	if !ok {
+		return nil, 0, fmt.Errorf("task not found: %s", compensation.Task)
+	}
+
+	started := e.now()
+	data, err := executeAttempt(ctx, task, params, state, e.grace())
+	return data, e.now().Sub(started), err
+}
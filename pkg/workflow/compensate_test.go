@@ -0,0 +1,251 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// UndoTask records the order compensations run in, and fails while err is set
+type UndoTask struct {
+	name string
+	mu   *sync.Mutex
+	log  *[]string
+	err  error
+}
+
+func (t *UndoTask) Name() string {
+	return t.name
+}
+
+func (t *UndoTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*t.log = append(*t.log, t.name+":"+params["id"])
+	if t.err != nil {
+		return nil, t.err
+	}
+	return map[string]any{"undone": params["id"]}, nil
+}
+
+// newSagaEngine returns an engine with a workflow whose step c fails after
+// a and b completed and a step next to c was skipped, and the undo tasks of a and b
+func newSagaEngine() (*Engine, *UndoTask, *UndoTask, *[]string) {
+	engine := NewEngine()
+	engine.RegisterTask(&EchoTask{name: "echo"})
+	engine.RegisterTask(&MockTask{name: "fail", err: errors.New("out of stock")})
+
+	var mu sync.Mutex
+	var log []string
+	undoA := &UndoTask{name: "undo_a", mu: &mu, log: &log}
+	undoB := &UndoTask{name: "undo_b", mu: &mu, log: &log}
+	engine.RegisterTask(undoA)
+	engine.RegisterTask(undoB)
+
+	engine.workflows["saga"] = &models.Workflow{
+		Name: "saga",
+		Steps: []models.Step{
+			{ID: "a", Task: "echo", Next: []string{"b"}, Params: map[string]string{"id": "1"},
+				Compensate: &models.Compensation{Task: "undo_a", Params: map[string]string{"id": "${{ steps.a.data.id }}"}}},
+			{ID: "b", Task: "echo", Next: []string{"skipped", "c"}, Params: map[string]string{"id": "2"},
+				Compensate: &models.Compensation{Task: "undo_b", Params: map[string]string{"id": "${{ b.id }}"}}},
+			{ID: "skipped", Task: "echo", Condition: "false",
+				Compensate: &models.Compensation{Task: "undo_b", Params: map[string]string{"id": "never"}}},
+			{ID: "c", Task: "fail"},
+		},
+	}
+
+	return engine, undoA, undoB, &log
+}
+
+func TestCompensation(t *testing.T) {
+	// Create a new engine
+	engine, _, _, log := newSagaEngine()
+
+	state, err := engine.Run("saga")
+	if err == nil || !strings.Contains(err.Error(), "out of stock") {
+		t.Fatalf("Expected the run to fail with the error of step c, got %v", err)
+	}
+
+	if state.Status != "compensated" {
+		t.Errorf("Expected status compensated, got %s", state.Status)
+	}
+
+	// Completed steps are compensated in reverse order, skipped steps are not
+	if !slices.Equal(*log, []string{"undo_b:2", "undo_a:1"}) {
+		t.Errorf("Expected compensations undo_b:2, undo_a:1, got %v", *log)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		c := state.StepResults[id].Compensation
+		if c == nil || c.Status != models.StepSucceeded || c.Data["undone"] == nil {
+			t.Errorf("Expected step %s to be compensated, got %+v", id, c)
+		}
+	}
+
+	if c := state.StepResults["c"].Compensation; c != nil {
+		t.Errorf("Expected the failed step not to be compensated, got %+v", c)
+	}
+
+	// The compensations are part of the run's history
+	events, _ := engine.Events(state.RunID)
+	var types []string
+	for _, event := range events[len(events)-6:] {
+		types = append(types, event.Type)
+	}
+	expected := []string{
+		models.EventRunCompensating,
+		models.EventCompensationStarted, models.EventCompensationCompleted,
+		models.EventCompensationStarted, models.EventCompensationCompleted,
+		models.EventRunFinished,
+	}
+	if !slices.Equal(types, expected) {
+		t.Errorf("Expected events %v, got %v", expected, types)
+	}
+}
+
+func TestCompensationFailed(t *testing.T) {
+	// Create a new engine
+	engine, _, undoB, log := newSagaEngine()
+	undoB.err = errors.New("refund rejected")
+
+	state, err := engine.Run("saga")
+	if err == nil || !strings.Contains(err.Error(), "failed to compensate step b: refund rejected") {
+		t.Fatalf("Expected compensation error, got %v", err)
+	}
+
+	if state.Status != "compensation_failed" {
+		t.Errorf("Expected status compensation_failed, got %s", state.Status)
+	}
+
+	// A failed compensation does not stop the others
+	if !slices.Equal(*log, []string{"undo_b:2", "undo_a:1"}) {
+		t.Errorf("Expected compensations undo_b:2, undo_a:1, got %v", *log)
+	}
+
+	if c := state.StepResults["b"].Compensation; c == nil || c.Status != models.StepFailed || c.Error != "refund rejected" {
+		t.Errorf("Expected failed compensation of step b, got %+v", c)
+	}
+
+	// Resuming the run retries only the compensation that failed
+	undoB.err = nil
+	*log = nil
+	state, err = engine.Resume(context.Background(), state.RunID)
+	if err == nil {
+		t.Error("Expected the resumed run to still report its failure")
+	}
+
+	if state.Status != "compensated" {
+		t.Errorf("Expected status compensated, got %s", state.Status)
+	}
+
+	if !slices.Equal(*log, []string{"undo_b:2"}) {
+		t.Errorf("Expected only undo_b to run again, got %v", *log)
+	}
+
+	if _, err := engine.Resume(context.Background(), state.RunID); err == nil || !strings.Contains(err.Error(), "already been compensated") {
+		t.Errorf("Expected error resuming a compensated run, got %v", err)
+	}
+}
+
+func TestFailedRunWithoutCompensation(t *testing.T) {
+	// Create a new engine
+	engine, _, _, log := newSagaEngine()
+	for i := range engine.workflows["saga"].Steps {
+		engine.workflows["saga"].Steps[i].Compensate = nil
+	}
+
+	state, err := engine.Run("saga")
+	if err == nil {
+		t.Fatal("Expected the run to fail")
+	}
+
+	if state.Status != "failed" {
+		t.Errorf("Expected status failed, got %s", state.Status)
+	}
+
+	if len(*log) != 0 {
+		t.Errorf("Expected no compensations, got %v", *log)
+	}
+}
+
+func TestTimedOutRunCompensation(t *testing.T) {
+	// Create a new engine whose step c outlasts the workflow's timeout
+	engine, _, _, log := newSagaEngine()
+	engine.RegisterTask(&SlowTask{name: "slow", delay: 2 * time.Second})
+	saga := engine.workflows["saga"]
+	saga.Timeout = "30ms"
+	saga.Steps[3].Task = "slow"
+
+	state, err := engine.Run("saga")
+	if err == nil || !strings.Contains(err.Error(), "workflow saga timed out after 30ms") {
+		t.Fatalf("Expected the run to time out, got %v", err)
+	}
+
+	if state.Status != "compensated" {
+		t.Errorf("Expected status compensated, got %s", state.Status)
+	}
+
+	if !slices.Equal(*log, []string{"undo_b:2", "undo_a:1"}) {
+		t.Errorf("Expected compensations undo_b:2, undo_a:1, got %v", *log)
+	}
+}
+
+func TestCancelledRunNotCompensated(t *testing.T) {
+	// Create a new engine whose step c blocks until the run is cancelled
+	engine, _, _, log := newSagaEngine()
+	task := &BlockingTask{name: "block", started: make(chan struct{})}
+	engine.RegisterTask(task)
+	engine.workflows["saga"].Steps[3].Task = "block"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-task.started
+		cancel()
+	}()
+
+	state, err := engine.RunContext(ctx, "saga", nil)
+	if err == nil || !strings.Contains(err.Error(), "workflow saga was cancelled") {
+		t.Fatalf("Expected the run to be cancelled, got %v", err)
+	}
+
+	// A cancelled run stops where it is, so it can be resumed rather than undone
+	if state.Status != "cancelled" {
+		t.Errorf("Expected status cancelled, got %s", state.Status)
+	}
+
+	if len(*log) != 0 {
+		t.Errorf("Expected no compensations, got %v", *log)
+	}
+}
+
+func TestCompensationValidation(t *testing.T) {
+	registry := tasks.NewRegistry()
+	registry.Register(&MockTask{name: "task1"})
+
+	workflow := &models.Workflow{
+		Name: "invalid",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Next: []string{"step2"}, Compensate: &models.Compensation{Task: "missing"}},
+			{ID: "step2", Task: "task1", Compensate: &models.Compensation{Task: "task1", Params: map[string]string{"id": "${{ nope.id }}"}}},
+		},
+	}
+
+	err := Validate(workflow, registry)
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	for _, expected := range []string{"step step1: compensate: unknown task missing", "step step2: compensate param id"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error %q, got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// graph is the step graph of a workflow, rooted at its first step
+type graph struct {
+	entry    string
+	steps    map[string]*models.Step
+	inDegree map[string]int
+}
+
+// newGraph indexes the steps of a workflow by ID. When IDs are duplicated the first step wins
+func newGraph(workflow *models.Workflow) *graph {
+	g := &graph{
+		steps:    make(map[string]*models.Step),
+		inDegree: make(map[string]int),
+	}
+
+	if len(workflow.Steps) > 0 {
+		g.entry = workflow.Steps[0].ID
+	}
+
+	for i := range workflow.Steps {
+		if _, ok := g.steps[workflow.Steps[i].ID]; !ok {
+			g.steps[workflow.Steps[i].ID] = &workflow.Steps[i]
+		}
+	}
+
+	return g
+}
+
+// buildGraph builds the step graph of a workflow and counts, for every step
+// reachable from the entry step, how many reachable steps point at it
+func buildGraph(workflow *models.Workflow) (*graph, error) {
+	if len(workflow.Steps) == 0 {
+		return nil, errors.New("workflow has no steps")
+	}
+
+	g := newGraph(workflow)
+	for id := range g.reachable() {
+		for _, next := range successors(g.steps[id]) {
+			if _, ok := g.steps[next]; !ok {
+				return nil, fmt.Errorf("step not found: %s", next)
+			}
+			g.inDegree[next]++
+		}
+	}
+
+	if cycle := g.findCycle(); cycle != nil {
+		return nil, fmt.Errorf("workflow contains a cycle: %v", cycle)
+	}
+
+	return g, nil
+}
+
+// reachable returns the IDs of the steps that can be reached from the entry step
+func (g *graph) reachable() map[string]bool {
+	visited := make(map[string]bool)
+	if _, ok := g.steps[g.entry]; !ok {
+		return visited
+	}
+
+	visited[g.entry] = true
+	queue := []string{g.entry}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		for _, next := range successors(g.steps[id]) {
+			if _, ok := g.steps[next]; ok && !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return visited
+}
+
+// findCycle returns the step IDs of a cycle reachable from the entry step, or nil
+func (g *graph) findCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+
+	if _, ok := g.steps[g.entry]; !ok {
+		return nil
+	}
+
+	color := make(map[string]int)
+	var path []string
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		color[id] = visiting
+		path = append(path, id)
+
+		for _, next := range successors(g.steps[id]) {
+			if _, ok := g.steps[next]; !ok {
+				continue
+			}
+
+			switch color[next] {
+			case visiting:
+				// Return the part of the path that loops back to next
+				for i, p := range path {
+					if p == next {
+						return append(append([]string{}, path[i:]...), next)
+					}
+				}
+			case unvisited:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		color[id] = done
+		return nil
+	}
+
+	return visit(g.entry)
+}
+
+// successors returns the steps a step can lead to: its next steps, the
+// targets of its branches and the handlers of its failures, each once
+func successors(step *models.Step) []string {
+	var ids []string
+	add := func(next []string) {
+		for _, id := range next {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	add(step.Next)
+	for _, branch := range step.Branches {
+		add([]string{branch.Next})
+	}
+	add(step.OnFailure)
+	for _, route := range step.OnErrorMatch {
+		add(route.Next)
+	}
+	if step.WaitSignal != nil {
+		add(step.WaitSignal.OnTimeout)
+	}
+	return ids
+}
+
+// failureHandlers returns the handler steps for a failure with the given
+// error class: the on_timeout steps of a wait that timed out, those of the
+// first matching on_error_match route, otherwise those of on_failure
+func failureHandlers(step *models.Step, class string) []string {
+	if w := step.WaitSignal; w != nil && class == errorClassSignalTimeout && len(w.OnTimeout) > 0 {
+		return w.OnTimeout
+	}
+	for _, route := range step.OnErrorMatch {
+		if ok, _ := path.Match(route.Pattern, class); ok {
+			return route.Next
+		}
+	}
+	return step.OnFailure
+}
+
+// handlesFailure reports whether a failure with the given error class lets
+// the run go on, because it is routed to handlers or ignored
+func handlesFailure(step *models.Step, class string) bool {
+	return step.ContinueOnError || len(failureHandlers(step, class)) > 0
+}
+
+// activatedSuccessors returns the successors that a finished step activates.
+// A step that succeeded activates its next steps and the branch it picked.
+// One that failed activates the handlers of its failure, and also its next
+// steps if it may continue on error. A skipped step activates none
+func activatedSuccessors(step *models.Step, result models.StepResult) []string {
+	if result.Status == models.StepSkipped {
+		return nil
+	}
+
+	if result.Success {
+		if result.Branch == "" {
+			return step.Next
+		}
+		return append(slices.Clone(step.Next), result.Branch)
+	}
+
+	var ids []string
+	if step.ContinueOnError {
+		ids = append(ids, step.Next...)
+	}
+	return append(ids, failureHandlers(step, result.ErrorClass)...)
+}
+
+// stepIDs returns the IDs of a workflow's steps in definition order
+func stepIDs(workflow *models.Workflow) []string {
+	ids := make([]string, len(workflow.Steps))
+	for i, step := range workflow.Steps {
+		ids[i] = step.ID
+	}
+	return ids
+}
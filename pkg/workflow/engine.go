@@ -2,22 +2,37 @@ package workflow
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"strings"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/mstgnz/goflow/pkg/clock"
 	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
 	"github.com/mstgnz/goflow/pkg/tasks"
 )
 
-// Engine is the core workflow engine
+// Engine is the core workflow engine. It is safe for concurrent use, and
+// the same workflow can be run several times at once
 type Engine struct {
 	taskRegistry *tasks.Registry
+	programs     programCache
+	onceMu       sync.Mutex // makes StartOnce look up and start runs as one step
+
+	mu           sync.Mutex
 	workflows    map[string]*models.Workflow
-	states       map[string]*models.WorkflowState
+	stateStore   store.StateStore
+	active       map[string]*activeRun      // run ID -> run executing in this process
+	wakeTimers   map[string]clock.Timer     // run ID -> resumes a waiting run when a wait ends
+	watchers     map[string][]chan struct{} // run ID -> notified when events of the run are recorded
+	clock        clock.Clock
+	gracePeriod  time.Duration
+	envAllowlist []string        // environment variables expressions can read, all of them when nil
+	secretEnv    map[string]bool // environment variables trigger secrets are read from, see updateSecretEnv
+	maxDepth     int
 }
 
 // NewEngine creates a new workflow engine
@@ -25,7 +40,13 @@ func NewEngine() *Engine {
 	return &Engine{
 		taskRegistry: tasks.NewRegistry(),
 		workflows:    make(map[string]*models.Workflow),
-		states:       make(map[string]*models.WorkflowState),
+		stateStore:   store.NewMemoryStore(),
+		active:       make(map[string]*activeRun),
+		wakeTimers:   make(map[string]clock.Timer),
+		watchers:     make(map[string][]chan struct{}),
+		clock:        clock.Real,
+		gracePeriod:  DefaultGracePeriod,
+		maxDepth:     DefaultMaxDepth,
 	}
 }
 
@@ -38,6 +59,7 @@ func (e *Engine) RegisterTask(task tasks.Task) {
 func (e *Engine) RegisterDefaultTasks() {
 	e.RegisterTask(&tasks.SendEmailTask{})
 	e.RegisterTask(&tasks.ProcessPaymentTask{})
+	e.RegisterTask(&tasks.RefundPaymentTask{})
 	e.RegisterTask(&tasks.PackItemsTask{})
 	e.RegisterTask(&tasks.SendShippingNotificationTask{})
 	e.RegisterTask(&tasks.ValidateFileTask{})
@@ -45,201 +67,671 @@ func (e *Engine) RegisterDefaultTasks() {
 	e.RegisterTask(&tasks.SaveToDatabaseTask{})
 }
 
-// Load loads a workflow from a file
+// Load loads a workflow from a JSON or YAML file
 func (e *Engine) Load(filePath string) error {
+	format, err := FormatFromPath(filePath)
+	if err != nil {
+		return err
+	}
+
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read workflow file: %w", err)
 	}
 
-	var workflow models.Workflow
-	if strings.HasSuffix(filePath, ".json") {
-		err = json.Unmarshal(data, &workflow)
-	} else {
-		return fmt.Errorf("unsupported file format: %s", filePath)
+	return e.LoadBytes(data, format)
+}
+
+// Run runs a workflow by name without inputs
+func (e *Engine) Run(workflowName string) (*models.WorkflowState, error) {
+	return e.RunContext(context.Background(), workflowName, nil)
+}
+
+// RunWithInputs runs a workflow by name with inputs, like RunContext
+func (e *Engine) RunWithInputs(ctx context.Context, workflowName string, inputs map[string]any) (*models.WorkflowState, error) {
+	return e.RunContext(ctx, workflowName, inputs)
+}
+
+// RunContext runs a workflow by name with inputs, and returns without an error when the run stops to wait
+func (e *Engine) RunContext(ctx context.Context, workflowName string, inputs map[string]any) (*models.WorkflowState, error) {
+	return e.startRun(ctx, workflowName, inputs, newRunID(e.now()), "", nil)
+}
+
+// Start starts a run of a workflow in the background, like RunContext, and
+// returns its run ID once the run is stored, so GetRun, Cancel and Events
+// find it. A run that cannot start, e.g. because of invalid inputs, returns
+// the error instead. The run is cancelled when ctx is, so pass a context that
+// outlives the caller, such as the one of a server rather than a request
+func (e *Engine) Start(ctx context.Context, workflowName string, inputs map[string]any) (string, error) {
+	return e.start(ctx, workflowName, inputs, "")
+}
+
+// StartOnce is like Start, but starts at most one run of a workflow per
+// idempotency key, e.g. for a webhook delivery that is retried. When a run
+// of the workflow was already started with the key, its ID is returned with
+// started false. Keys are stored on the runs, so they hold across restarts
+// with a file store. An empty key always starts a run
+func (e *Engine) StartOnce(ctx context.Context, workflowName string, inputs map[string]any, key string) (runID string, started bool, err error) {
+	if key == "" {
+		runID, err = e.Start(ctx, workflowName, inputs)
+		return runID, err == nil, err
 	}
 
+	e.onceMu.Lock()
+	defer e.onceMu.Unlock()
+
+	runID, err = e.runByKey(workflowName, key)
 	if err != nil {
-		return fmt.Errorf("failed to parse workflow file: %w", err)
+		return "", false, err
+	}
+	if runID != "" {
+		return runID, false, nil
 	}
 
-	if workflow.Name == "" {
-		return errors.New("workflow must have a name")
+	runID, err = e.start(ctx, workflowName, inputs, key)
+	return runID, err == nil, err
+}
+
+// runByKey returns the ID of the first run of a workflow that was started
+// with an idempotency key, or an empty ID. Stores without a key index are
+// searched run by run
+func (e *Engine) runByKey(workflowName, key string) (string, error) {
+	if index, ok := e.store().(store.KeyIndex); ok {
+		runID, err := index.RunByKey(workflowName, key)
+		if errors.Is(err, store.ErrNotFound) {
+			return "", nil
+		}
+		return runID, err
 	}
 
-	e.workflows[workflow.Name] = &workflow
-	return nil
+	runs, err := e.ListRuns(RunFilter{WorkflowName: workflowName, IdempotencyKey: key})
+	if err != nil || len(runs) == 0 {
+		return "", err
+	}
+	return runs[0].RunID, nil
 }
 
-// Run runs a workflow by name
-func (e *Engine) Run(workflowName string) (*models.WorkflowState, error) {
-	workflow, ok := e.workflows[workflowName]
+// start starts a run in the background with an idempotency key, see Start
+func (e *Engine) start(ctx context.Context, workflowName string, inputs map[string]any, key string) (string, error) {
+	runID := newRunID(e.now())
+
+	// Watch the run before it starts, so its first event is not missed
+	recorded, stop := e.WatchEvents(runID)
+	defer stop()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := e.startRun(ctx, workflowName, inputs, runID, key, nil)
+		result <- err
+	}()
+
+	select {
+	case <-recorded:
+		return runID, nil
+	case err := <-result:
+		// A run that recorded events was stored, even if it failed right away
+		select {
+		case <-recorded:
+			return runID, nil
+		default:
+			return "", err
+		}
+	}
+}
+
+// startRun starts a run of a workflow with the given run ID and idempotency
+// key, as a child of the parent run if there is one
+func (e *Engine) startRun(ctx context.Context, workflowName string, inputs map[string]any, runID, key string, parent *models.WorkflowState) (*models.WorkflowState, error) {
+	workflow, ok := e.Workflow(workflowName)
 	if !ok {
 		return nil, fmt.Errorf("workflow not found: %s", workflowName)
 	}
 
-	// Create a new workflow state
-	state := &models.WorkflowState{
-		WorkflowName:   workflowName,
-		CompletedSteps: []string{},
-		StepResults:    make(map[string]models.StepResult),
-		StartTime:      time.Now().Unix(),
-		Status:         "running",
+	resolvedInputs, err := resolveInputs(workflow, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := parseTimeout(workflow.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout for workflow %s: %w", workflowName, err)
+	}
+
+	// Create a new workflow state, which the run_started event fills in
+	rec := e.newRecorder(&models.WorkflowState{RunID: runID}, 0)
+	started := models.Event{Type: models.EventRunStarted, WorkflowName: workflowName, Data: resolvedInputs, Steps: stepIDs(workflow), IdempotencyKey: key}
+	if parent != nil {
+		started.ParentRunID = parent.RunID
+		started.Depth = parent.Depth + 1
+	}
+
+	return e.run(ctx, workflow, rec, started, timeout, nil)
+}
+
+// run executes a run until it ends or waits, starting with the given event.
+// A pending signal is delivered to the steps that were waiting for it
+func (e *Engine) run(ctx context.Context, workflow *models.Workflow, rec *recorder, first models.Event, timeout time.Duration, pending *signal) (*models.WorkflowState, error) {
+	state := rec.state
+	resumingCompensation := compensating(state)
+
+	// A run that stops to wait is resumed when its first wait times out, once
+	// it no longer executes
+	var wakeAt time.Time
+	defer func() {
+		if !wakeAt.IsZero() {
+			e.scheduleWake(state.RunID, wakeAt)
+		}
+	}()
+
+	// Tasks read the time from the engine's clock
+	ctx = clock.NewContext(ctx, e.Clock())
+
+	// Let Cancel stop the run and Signal reach it, and bound its steps by the
+	// workflow's timeout. Compensations are not bound by the timeout, which
+	// may have expired
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	stepsCtx, cancel := withTimeout(runCtx, timeout)
+	defer cancel()
+	active := newActiveRun(cancelRun)
+	if err := e.activate(state.RunID, active); err != nil {
+		return nil, err
+	}
+	defer e.deactivate(state.RunID)
+	e.stopWake(state.RunID)
+	if err := rec.checkLatest(); err != nil {
+		return nil, err
+	}
+
+	if err := rec.record(first); err != nil {
+		return nil, err
+	}
+
+	// Start the workflow execution, unless the run already failed and was
+	// being compensated
+	var status models.Status
+	var outputs map[string]any
+	var err error
+	if resumingCompensation {
+		status, err = models.RunFailed, fmt.Errorf("workflow %s failed before run %s was resumed", workflow.Name, state.RunID)
+	} else {
+		err = e.executeWorkflow(stepsCtx, workflow, rec, active.signals, pending)
+		if errors.Is(err, errWaiting) {
+			wakeAt = nextWake(state)
+			return state, rec.record(models.Event{Type: models.EventRunWaiting})
+		}
+		status, outputs, err = e.finishRun(ctx, stepsCtx, workflow, state, err)
+	}
+
+	// Undo the completed steps of a failed or timed out run
+	if (status == models.RunFailed || status == models.RunTimedOut) && needsCompensation(newGraph(workflow), state) {
+		status, err = e.compensate(runCtx, workflow, rec, err)
+	}
+
+	finished := models.Event{Type: models.EventRunFinished, Status: status, Data: outputs}
+	if err != nil {
+		finished.Error = err.Error()
+	}
+	if recordErr := rec.record(finished); recordErr != nil && err == nil {
+		err = recordErr
 	}
+	return state, err
+}
 
-	// Store the state
-	e.states[workflowName] = state
+// finishRun returns the final status of a run from the error its execution
+// ended with, and computes the outputs of a run that completed
+func (e *Engine) finishRun(ctx, runCtx context.Context, workflow *models.Workflow, state *models.WorkflowState, err error) (models.Status, map[string]any, error) {
+	if err != nil {
+		switch {
+		case ctx.Err() != nil || errors.Is(runCtx.Err(), context.Canceled):
+			return models.RunCancelled, nil, fmt.Errorf("workflow %s was cancelled: %w", workflow.Name, err)
+		case isTimeout(runCtx.Err()):
+			return models.RunTimedOut, nil, fmt.Errorf("workflow %s timed out after %s: %w", workflow.Name, workflow.Timeout, err)
+		}
+		return models.RunFailed, nil, err
+	}
 
-	// Start the workflow execution
-	ctx := context.Background()
-	err := e.executeWorkflow(ctx, workflow, state)
+	outputs, err := e.computeOutputs(workflow, state)
 	if err != nil {
-		state.Status = "failed"
-		return state, err
+		return models.RunFailed, nil, err
 	}
 
-	state.Status = "completed"
-	state.EndTime = time.Now().Unix()
-	return state, nil
+	return models.RunCompleted, outputs, nil
 }
 
-// executeWorkflow executes a workflow
-func (e *Engine) executeWorkflow(ctx context.Context, workflow *models.Workflow, state *models.WorkflowState) error {
-	// Find the first step
-	if len(workflow.Steps) == 0 {
-		return errors.New("workflow has no steps")
+// stepOutcome is the result of a step that was executed concurrently
+type stepOutcome struct {
+	step   *models.Step
+	status models.Status
+	err    error
+}
+
+// executeWorkflow executes the steps of a workflow as a DAG, returning errWaiting when only waiting steps are left
+func (e *Engine) executeWorkflow(ctx context.Context, workflow *models.Workflow, rec *recorder, signals <-chan signal, pending *signal) error {
+	state := rec.state
+
+	g, err := buildGraph(workflow)
+	if err != nil {
+		return err
 	}
 
-	// Start with the first step
-	currentStep := workflow.Steps[0]
-	state.CurrentStep = currentStep.ID
+	completed := make(map[string]bool, len(state.CompletedSteps))
+	for _, id := range state.CompletedSteps {
+		completed[id] = true
+	}
 
-	// Execute steps until there are no more steps to execute
-	for {
-		// Execute the current step
-		err := e.executeStep(ctx, workflow, currentStep, state)
-		if err != nil {
-			return fmt.Errorf("failed to execute step %s: %w", currentStep.ID, err)
+	// Remaining predecessors of each step, and whether any of them activated it
+	remaining := make(map[string]int, len(g.inDegree))
+	for id, n := range g.inDegree {
+		remaining[id] = n
+	}
+	activated := make(map[string]bool)
+
+	// Running steps send their attempts as events, then their outcome
+	events := make(chan models.Event)
+	outcomes := make(chan stepOutcome)
+	emit := func(event models.Event) { events <- event }
+	running := 0
+	var runErr error
+
+	// Steps that wait for a signal, which do not count as running
+	parked := make(map[string]*models.Step)
+	delivered := false
+
+	// record records an event of the run. A run whose events cannot be
+	// stored starts no further steps, since it could not be resumed
+	record := func(event models.Event) {
+		if err := rec.record(event); err != nil && runErr == nil {
+			runErr = err
 		}
+	}
 
-		// Mark the step as completed
-		state.CompletedSteps = append(state.CompletedSteps, currentStep.ID)
+	var start func(step *models.Step)
+	var resolve func(step *models.Step, activate []string)
+	var finish func(outcome stepOutcome)
+	var wait func(step *models.Step, resumed bool)
+
+	// resolve records that a step has finished, then releases its successors.
+	// Successors the step does not activate, like those of a skipped step or
+	// the failure handlers of a step that succeeded, are only run if another
+	// predecessor activates them
+	resolve = func(step *models.Step, activate []string) {
+		for _, next := range successors(step) {
+			remaining[next]--
+			if slices.Contains(activate, next) {
+				activated[next] = true
+			}
+			if remaining[next] > 0 {
+				continue
+			}
 
-		// Find the next step to execute
-		nextStepID := e.findNextStep(workflow, currentStep, state)
-		if nextStepID == "" {
-			// No more steps to execute
-			break
+			nextStep := g.steps[next]
+			if activated[next] {
+				start(nextStep)
+				continue
+			}
+
+			// No predecessor led to the step, e.g. it is on a branch that was not taken
+			if !completed[next] {
+				record(models.Event{Type: models.EventStepSkipped, StepID: next})
+			}
+			resolve(nextStep, nil)
+		}
+	}
+
+	// start runs a step, either inline when its condition skips it or in its own goroutine
+	start = func(step *models.Step) {
+		if runErr != nil {
+			return
+		}
+
+		// A step that completed before the run was resumed only releases the
+		// successors it activated
+		if completed[step.ID] {
+			resolve(step, activatedSuccessors(step, state.StepResults[step.ID]))
+			return
 		}
 
-		// Find the next step
-		var found bool
-		for _, step := range workflow.Steps {
-			if step.ID == nextStepID {
-				currentStep = step
-				state.CurrentStep = currentStep.ID
-				found = true
-				break
+		// Do not start new steps once the run has timed out or was cancelled
+		if err := ctx.Err(); err != nil {
+			runErr = err
+			return
+		}
+
+		// A step that was interrupted when the run stopped is recovered
+		// instead, with the params it was started with. A waiting step that
+		// had not started to wait simply starts again
+		if prior, ok := state.StepResults[step.ID]; ok && interrupted(prior) && !waits(step) {
+			params, err := e.restoreParams(step.Params, prior.Params, state)
+			if err != nil {
+				runErr = fmt.Errorf("failed to resolve params of step %s: %w", step.ID, err)
+				record(models.Event{Type: models.EventStepFailed, StepID: step.ID, Status: models.StepFailed, Error: err.Error()})
+				return
 			}
+
+			snapshot := state.Clone()
+			record(models.Event{Type: models.EventStepScheduled, StepID: step.ID, Task: step.Task, Params: prior.Params})
+			running++
+			go func() {
+				status, err := e.recoverStep(ctx, step, params, snapshot, emit)
+				outcomes <- stepOutcome{step: step, status: status, err: err}
+			}()
+			return
 		}
 
-		if !found {
-			return fmt.Errorf("step not found: %s", nextStepID)
+		// A step that was waiting when the run stopped waits again
+		if prior, ok := state.StepResults[step.ID]; ok && prior.Status == models.StepWaiting {
+			wait(step, true)
+			return
 		}
-	}
 
-	return nil
-}
+		// Check if the step has a condition
+		if step.Condition != "" {
+			ok, err := e.evaluateCondition(step.Condition, state)
+			if err != nil {
+				runErr = fmt.Errorf("failed to evaluate condition of step %s: %w", step.ID, err)
+				record(models.Event{Type: models.EventStepFailed, StepID: step.ID, Status: models.StepFailed, Error: err.Error()})
+				return
+			}
+
+			if !ok {
+				// Condition not met, skip this step
+				record(models.Event{Type: models.EventStepSkipped, StepID: step.ID})
+				resolve(step, nil)
+				return
+			}
+		}
+
+		// A wait_signal step runs no task, it waits until its signal arrives
+		// or its timeout passes
+		if w := step.WaitSignal; w != nil {
+			timeout, err := parseTimeout(w.Timeout)
+			if err != nil {
+				runErr = fmt.Errorf("invalid timeout for step %s: %w", step.ID, err)
+				record(models.Event{Type: models.EventStepFailed, StepID: step.ID, Status: models.StepFailed, Error: err.Error()})
+				return
+			}
+
+			waiting := models.Event{Type: models.EventStepWaiting, StepID: step.ID, Signal: w.Name}
+			if timeout > 0 {
+				waiting.WakeAt = e.now().Add(timeout)
+			}
+			record(models.Event{Type: models.EventStepScheduled, StepID: step.ID})
+			record(waiting)
+			wait(step, false)
+			return
+		}
+
+		// A sleep or wait_until step runs no task, it waits until its wake-up
+		// time, which is stored so the wait survives a restart
+		if isSleep(step) {
+			wakeAt, err := e.wakeTime(step, state)
+			if err != nil {
+				runErr = fmt.Errorf("failed to compute wake-up time of step %s: %w", step.ID, err)
+				record(models.Event{Type: models.EventStepFailed, StepID: step.ID, Status: models.StepFailed, Error: err.Error()})
+				return
+			}
 
-// executeStep executes a single step in a workflow
-func (e *Engine) executeStep(ctx context.Context, workflow *models.Workflow, step models.Step, state *models.WorkflowState) error {
-	// Check if the step has a condition
-	if step.Condition != "" {
-		// Evaluate the condition
-		if !e.evaluateCondition(step.Condition, state) {
-			// Condition not met, skip this step
-			return nil
+			record(models.Event{Type: models.EventStepScheduled, StepID: step.ID})
+			record(models.Event{Type: models.EventStepWaiting, StepID: step.ID, WakeAt: wakeAt})
+			wait(step, false)
+			return
 		}
+
+		// Resolve placeholders from the results of earlier steps. Loop steps
+		// resolve their params once per iteration
+		var params map[string]string
+		if !isLoop(step) {
+			var err error
+			if params, err = e.resolveParams(step.Params, state); err != nil {
+				runErr = fmt.Errorf("failed to resolve params of step %s: %w", step.ID, err)
+				record(models.Event{Type: models.EventStepFailed, StepID: step.ID, Status: models.StepFailed, Error: err.Error()})
+				return
+			}
+		}
+
+		// Tasks get their own copy of the state so they can read it while other
+		// steps finish. The step is stored as running, so a resumed run knows
+		// it was in flight
+		snapshot := state.Clone()
+		record(models.Event{Type: models.EventStepScheduled, StepID: step.ID, Task: step.Task, Params: e.recordedParams(step.Params, params)})
+		running++
+		go func() {
+			status, err := e.executeStep(ctx, step, params, snapshot, emit)
+			outcomes <- stepOutcome{step: step, status: status, err: err}
+		}()
 	}
 
-	// Get the task
-	task, ok := e.taskRegistry.Get(step.Task)
-	if !ok {
-		return fmt.Errorf("task not found: %s", step.Task)
+	// wait parks a waiting step, unless its wait has ended, or it was waiting
+	// for the signal the run was resumed with. A sleeping step succeeds when
+	// its wait ends, and a step waiting for a signal times out
+	wait = func(step *models.Step, resumed bool) {
+		result := state.StepResults[step.ID]
+		switch {
+		case !result.WakeAt.IsZero() && !e.now().Before(result.WakeAt):
+			delete(parked, step.ID)
+			if step.WaitSignal == nil {
+				finish(stepOutcome{step: step, status: models.StepSucceeded})
+				return
+			}
+			err := fmt.Errorf("signal %s was not received within %s", step.WaitSignal.Name, step.WaitSignal.Timeout)
+			finish(stepOutcome{step: step, status: models.StepTimedOut, err: tasks.NewError(errorClassSignalTimeout, err)})
+		case resumed && pending != nil && step.WaitSignal != nil && pending.name == step.WaitSignal.Name:
+			delivered = true
+			record(models.Event{Type: models.EventSignalReceived, StepID: step.ID, Signal: pending.name, Data: pending.payload})
+			finish(stepOutcome{step: step, status: models.StepSucceeded})
+		default:
+			parked[step.ID] = step
+		}
 	}
 
-	// Execute the task
-	result, err := task.Execute(ctx, step.Params, state)
-	if err != nil {
-		// Store the result
-		state.StepResults[step.ID] = models.StepResult{
-			Success: false,
-			Error:   err.Error(),
+	// receive completes the parked steps that wait for a signal
+	receive := func(sig signal) error {
+		var ids []string
+		for id, step := range parked {
+			if step.WaitSignal != nil && step.WaitSignal.Name == sig.name {
+				ids = append(ids, id)
+			}
 		}
-		return err
+		if len(ids) == 0 {
+			return fmt.Errorf("run %s has no step waiting for signal %s", state.RunID, sig.name)
+		}
+
+		slices.Sort(ids)
+		for _, id := range ids {
+			delete(parked, id)
+			record(models.Event{Type: models.EventSignalReceived, StepID: id, Signal: sig.name, Data: sig.payload})
+			finish(stepOutcome{step: g.steps[id], status: models.StepSucceeded})
+		}
+		return nil
 	}
 
-	// Store the result
-	state.StepResults[step.ID] = models.StepResult{
-		Success: true,
-		Data:    result,
+	// finish records the outcome of a step and starts the steps it leads to
+	finish = func(outcome stepOutcome) {
+		if outcome.err != nil {
+			class := tasks.ErrorClass(outcome.err)
+			record(models.Event{Type: models.EventStepFailed, StepID: outcome.step.ID, Status: outcome.status, Error: outcome.err.Error(), ErrorClass: class})
+
+			// A failure that is routed to handlers or ignored lets the run go
+			// on, unless the run itself was stopped
+			if runErr == nil && ctx.Err() == nil && outcome.status != models.StepCancelled && handlesFailure(outcome.step, class) {
+				record(models.Event{Type: models.EventStepHandled, StepID: outcome.step.ID})
+				if runErr == nil {
+					resolve(outcome.step, activatedSuccessors(outcome.step, state.StepResults[outcome.step.ID]))
+				}
+				return
+			}
+
+			// Stop scheduling new steps and wait for the ones already running
+			if runErr == nil {
+				runErr = fmt.Errorf("failed to execute step %s: %w", outcome.step.ID, outcome.err)
+			}
+			return
+		}
+
+		// Pick the branch to follow, which can depend on the step's result
+		branch, err := e.selectBranch(outcome.step, completedState(state, outcome.step.ID))
+		if err != nil {
+			if runErr == nil {
+				runErr = fmt.Errorf("failed to evaluate branches of step %s: %w", outcome.step.ID, err)
+			}
+			record(models.Event{Type: models.EventStepFailed, StepID: outcome.step.ID, Status: models.StepFailed, Error: err.Error()})
+			return
+		}
+
+		// Mark the step as completed
+		record(models.Event{Type: models.EventStepCompleted, StepID: outcome.step.ID, Branch: branch})
+
+		if runErr == nil {
+			resolve(outcome.step, activatedSuccessors(outcome.step, state.StepResults[outcome.step.ID]))
+		}
 	}
 
-	return nil
-}
+	start(g.steps[g.entry])
 
-// findNextStep finds the next step to execute
-func (e *Engine) findNextStep(workflow *models.Workflow, currentStep models.Step, state *models.WorkflowState) string {
-	// If there are no next steps, we're done
-	if len(currentStep.Next) == 0 {
-		return ""
+	// Tell Signal whether the signal the run was resumed with was taken
+	if pending != nil {
+		var err error
+		if !delivered {
+			err = fmt.Errorf("run %s has no step waiting for signal %s", state.RunID, pending.name)
+		}
+		pending.reply <- err
 	}
 
-	// Get the result of the current step
-	result, ok := state.StepResults[currentStep.ID]
-	if !ok || !result.Success {
-		// Step failed or no result, don't continue
-		return ""
+	for {
+		if running == 0 {
+			// Stop the run until a signal or a wake-up resumes it
+			if len(parked) > 0 && runErr == nil && ctx.Err() == nil {
+				return errWaiting
+			}
+			break
+		}
+
+		// Wake the first parked step whose wait ends while others run
+		var wakes chan struct{}
+		var timer clock.Timer
+		if wakeAt := nextWake(state); !wakeAt.IsZero() && len(parked) > 0 {
+			woke := make(chan struct{}, 1)
+			timer = e.Clock().AfterFunc(wakeAt.Sub(e.now()), func() { woke <- struct{}{} })
+			wakes = woke
+		}
+
+		select {
+		case event := <-events:
+			record(event)
+		case outcome := <-outcomes:
+			running--
+			finish(outcome)
+		case sig := <-signals:
+			sig.reply <- receive(sig)
+		case <-wakes:
+			for _, id := range sortedKeys(parked) {
+				wait(parked[id], false)
+			}
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
 	}
 
-	// Return the first next step
-	return currentStep.Next[0]
+	// Waiting steps are left waiting when the run fails or is stopped
+	if len(parked) > 0 && runErr == nil {
+		runErr = ctx.Err()
+	}
+	return runErr
 }
 
-// evaluateCondition evaluates a condition
-func (e *Engine) evaluateCondition(condition string, state *models.WorkflowState) bool {
-	// Parse the condition (format: "step.field")
-	parts := strings.Split(condition, ".")
-	if len(parts) != 2 {
-		return false
+// executeStep executes the task of a single step with its resolved params,
+// or once per iteration for a foreach or loop step, and returns the step's
+// final status: a step interrupted by cancellation gets the status "cancelled"
+func (e *Engine) executeStep(ctx context.Context, step *models.Step, params map[string]string, state *models.WorkflowState, emit func(models.Event)) (models.Status, error) {
+	switch {
+	case step.ForEach != nil:
+		return e.executeForEach(ctx, step, state, emit)
+	case step.Loop != nil:
+		return e.executeLoop(ctx, step, state, emit)
 	}
 
-	stepID := parts[0]
-	field := parts[1]
+	_, status, err := e.executeTask(ctx, step, 0, params, state, emit)
+	return status, err
+}
 
-	// Get the step result
-	result, ok := state.StepResults[stepID]
-	if !ok {
-		return false
+// executeTask executes the task of a step, or of one iteration of it,
+// retrying failed attempts according to the step's retry policy. Each attempt
+// is bounded by the step's timeout. Attempts are reported as events, and the
+// task's data is returned with the status it ended with
+func (e *Engine) executeTask(ctx context.Context, step *models.Step, iteration int, params map[string]string, state *models.WorkflowState, emit func(models.Event)) (map[string]any, models.Status, error) {
+	// Get the task, or the child run of a workflow step
+	task, err := e.stepTask(step, emit)
+	if err != nil {
+		return nil, models.StepFailed, err
 	}
 
-	// Check if the field exists and is true
-	value, ok := result.Data[field]
-	if !ok {
-		return false
+	policy, err := parseRetryPolicy(step.Retry)
+	if err != nil {
+		return nil, models.StepFailed, fmt.Errorf("invalid retry policy: %w", err)
 	}
 
-	// Convert to bool
-	boolValue, ok := value.(bool)
-	if !ok {
-		return false
+	timeout, err := parseTimeout(step.Timeout)
+	if err != nil {
+		return nil, models.StepFailed, fmt.Errorf("invalid timeout: %w", err)
 	}
 
-	return boolValue
+	for attempt := 1; ; attempt++ {
+		emit(models.Event{Type: models.EventAttemptStarted, StepID: step.ID, Attempt: attempt, Iteration: iteration})
+
+		// Execute the task
+		started := e.now()
+		attemptCtx, cancel := withTimeout(ctx, timeout)
+		data, err := executeAttempt(attemptCtx, task, params, state, e.grace())
+		cancel()
+		duration := e.now().Sub(started)
+		if err == nil {
+			emit(models.Event{Type: models.EventTaskOutput, StepID: step.ID, Attempt: attempt, Iteration: iteration, Data: data, Duration: duration})
+			return data, models.StepSucceeded, nil
+		}
+
+		// The step's own timeout can be retried, the run's cannot
+		if ctx.Err() == nil && isTimeout(attemptCtx.Err()) {
+			err = tasks.NewError(errorClassTimeout, fmt.Errorf("step timed out after %s: %w", timeout, context.DeadlineExceeded))
+		}
+		emit(models.Event{Type: models.EventAttemptFailed, StepID: step.ID, Attempt: attempt, Iteration: iteration, Error: err.Error(), Duration: duration})
+
+		status := models.StepFailed
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			status = models.StepCancelled
+		case isTimeout(err) || isTimeout(attemptCtx.Err()):
+			status = models.StepTimedOut
+		}
+
+		if ctx.Err() != nil || !policy.shouldRetry(attempt, err) {
+			return nil, status, err
+		}
+
+		// Wait before the next attempt, unless the run is cancelled
+		if clock.Sleep(ctx, policy.delay(attempt)) != nil {
+			return nil, status, err
+		}
+	}
 }
 
-// GetState returns the state of a workflow
+// evaluateCondition evaluates a condition expression against the results of earlier steps
+func (e *Engine) evaluateCondition(condition string, state *models.WorkflowState) (bool, error) {
+	program, err := e.program(condition)
+	if err != nil {
+		return false, err
+	}
+
+	return e.evalBool(program, e.expressionVars(state))
+}
+
+// GetState returns a snapshot of the latest run of a workflow
 func (e *Engine) GetState(workflowName string) (*models.WorkflowState, bool) {
-	state, ok := e.states[workflowName]
-	return state, ok
+	runs, err := e.ListRuns(RunFilter{WorkflowName: workflowName})
+	if err != nil || len(runs) == 0 {
+		return nil, false
+	}
+	return runs[len(runs)-1], true
 }
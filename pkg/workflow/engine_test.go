@@ -2,10 +2,16 @@ package workflow
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
 )
 
 // MockTask is a mock task for testing
@@ -44,9 +50,9 @@ func TestEngineCreation(t *testing.T) {
 		t.Error("Expected workflows map to be initialized")
 	}
 
-	// Verify the engine has an empty state map
-	if engine.states == nil {
-		t.Error("Expected states map to be initialized")
+	// Verify the engine has a state store
+	if engine.stateStore == nil {
+		t.Error("Expected state store to be initialized")
 	}
 }
 
@@ -74,6 +80,10 @@ func TestWorkflowLoading(t *testing.T) {
 	// Create a new engine
 	engine := NewEngine()
 
+	// Tasks must be registered before loading so the workflow can be validated
+	engine.RegisterTask(&MockTask{name: "task1"})
+	engine.RegisterTask(&MockTask{name: "task2"})
+
 	// Create a temporary workflow file
 	workflowJSON := `{
 		"name": "test_workflow",
@@ -227,6 +237,7 @@ func TestConditionEvaluation(t *testing.T) {
 
 	// Create a workflow state
 	state := &models.WorkflowState{
+		RunID:          "run1",
 		WorkflowName:   "test_workflow",
 		CurrentStep:    "step1",
 		CompletedSteps: []string{},
@@ -245,31 +256,114 @@ func TestConditionEvaluation(t *testing.T) {
 
 	// Test a condition that should evaluate to true
 	condition := "step1.success"
-	result := engine.evaluateCondition(condition, state)
-	if !result {
+	result, err := engine.evaluateCondition(condition, state)
+	if err != nil || !result {
 		t.Errorf("Expected condition %s to evaluate to true", condition)
 	}
 
 	// Test a condition that should evaluate to false
 	condition = "step1.failure"
-	result = engine.evaluateCondition(condition, state)
-	if result {
+	result, err = engine.evaluateCondition(condition, state)
+	if err != nil || result {
 		t.Errorf("Expected condition %s to evaluate to false", condition)
 	}
 
 	// Test a condition with a non-existent step
 	condition = "non_existent.success"
-	result = engine.evaluateCondition(condition, state)
-	if result {
+	result, err = engine.evaluateCondition(condition, state)
+	if err != nil || result {
 		t.Errorf("Expected condition %s to evaluate to false", condition)
 	}
 
 	// Test a condition with an invalid format
 	condition = "invalid_condition"
-	result = engine.evaluateCondition(condition, state)
-	if result {
+	result, err = engine.evaluateCondition(condition, state)
+	if err != nil || result {
 		t.Errorf("Expected condition %s to evaluate to false", condition)
 	}
+
+	// Test expressions over step data and results
+	for _, condition := range []string{
+		"step1.value > 100",
+		"steps.step1.success && steps.step1.error == \"\"",
+		"steps.step1.data.value in [123, 456]",
+	} {
+		result, err = engine.evaluateCondition(condition, state)
+		if err != nil || !result {
+			t.Errorf("Expected condition %s to evaluate to true, got %v (%v)", condition, result, err)
+		}
+	}
+
+	// Test a condition that cannot be evaluated
+	condition = "step1.missing > 100"
+	if _, err = engine.evaluateCondition(condition, state); err == nil {
+		t.Errorf("Expected condition %s to fail", condition)
+	}
+}
+
+func TestConditionExpressions(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+
+	engine.RegisterTask(&MockTask{name: "pay", result: map[string]any{"amount": "150.00", "currency": "EUR"}})
+	review := &MockTask{name: "review"}
+	ship := &MockTask{name: "ship"}
+	engine.RegisterTask(review)
+	engine.RegisterTask(ship)
+
+	err := engine.LoadBytes([]byte(`{
+		"name": "expressions",
+		"steps": [
+			{"id": "payment", "task": "pay", "next": ["manual_review", "auto_ship"]},
+			{"id": "manual_review", "task": "review", "condition": "payment.amount > 1000"},
+			{"id": "auto_ship", "task": "ship", "condition": "steps.payment.success && lower(payment.currency) in [\"eur\", \"usd\"]"}
+		]
+	}`), FormatJSON)
+	if err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+
+	if _, err := engine.Run("expressions"); err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	if review.executed {
+		t.Error("Expected manual_review to be skipped")
+	}
+
+	if !ship.executed {
+		t.Error("Expected auto_ship to be executed")
+	}
+
+	// A condition with a syntax error fails the load
+	err = engine.LoadBytes([]byte(`{
+		"name": "broken",
+		"steps": [
+			{"id": "payment", "task": "pay", "next": ["ship"]},
+			{"id": "ship", "task": "ship", "condition": "payment.amount >"}
+		]
+	}`), FormatJSON)
+	if err == nil {
+		t.Error("Expected load to fail for an invalid condition")
+	}
+
+	// A condition that fails at runtime fails the run
+	engine.workflows["runtime"] = &models.Workflow{
+		Name: "runtime",
+		Steps: []models.Step{
+			{ID: "payment", Task: "pay", Next: []string{"ship"}},
+			{ID: "ship", Task: "ship", Condition: "payment.missing > 100"},
+		},
+	}
+
+	state, err := engine.Run("runtime")
+	if err == nil {
+		t.Fatal("Expected run to fail")
+	}
+
+	if result := state.StepResults["ship"]; result.Success || result.Error == "" {
+		t.Errorf("Expected ship to record the condition error, got %+v", result)
+	}
 }
 
 func TestGetState(t *testing.T) {
@@ -278,6 +372,7 @@ func TestGetState(t *testing.T) {
 
 	// Create a workflow state
 	state := &models.WorkflowState{
+		RunID:          "run1",
 		WorkflowName:   "test_workflow",
 		CurrentStep:    "step1",
 		CompletedSteps: []string{},
@@ -286,7 +381,9 @@ func TestGetState(t *testing.T) {
 	}
 
 	// Add the state to the engine
-	engine.states["test_workflow"] = state
+	if err := engine.saveState(state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
 
 	// Get the state
 	retrievedState, ok := engine.GetState("test_workflow")
@@ -313,3 +410,335 @@ func TestGetState(t *testing.T) {
 		t.Error("Expected non-existent state to not be found")
 	}
 }
+
+// BarrierTask is a task that blocks until every task sharing its barrier has started
+type BarrierTask struct {
+	name    string
+	barrier *sync.WaitGroup
+	calls   atomic.Int32
+}
+
+func (t *BarrierTask) Name() string {
+	return t.name
+}
+
+func (t *BarrierTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	t.calls.Add(1)
+	t.barrier.Done()
+
+	done := make(chan struct{})
+	go func() {
+		t.barrier.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return map[string]any{"done": true}, nil
+	case <-time.After(2 * time.Second):
+		return nil, errors.New("sibling steps did not run concurrently")
+	}
+}
+
+func TestParallelExecution(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+
+	// pack and reserve only finish once both of them have started
+	barrier := &sync.WaitGroup{}
+	barrier.Add(2)
+	pack := &BarrierTask{name: "pack", barrier: barrier}
+	reserve := &BarrierTask{name: "reserve", barrier: barrier}
+	start := &MockTask{name: "start", result: map[string]any{"ok": true}}
+	join := &MockTask{name: "join", result: map[string]any{"ok": true}}
+	engine.RegisterTask(start)
+	engine.RegisterTask(pack)
+	engine.RegisterTask(reserve)
+	engine.RegisterTask(join)
+
+	// Create a diamond shaped workflow
+	engine.workflows["parallel"] = &models.Workflow{
+		Name: "parallel",
+		Steps: []models.Step{
+			{ID: "payment", Task: "start", Next: []string{"pack_items", "reserve_shipping"}},
+			{ID: "pack_items", Task: "pack", Next: []string{"ship"}},
+			{ID: "reserve_shipping", Task: "reserve", Next: []string{"ship"}},
+			{ID: "ship", Task: "join"},
+		},
+	}
+
+	// Run the workflow
+	state, err := engine.Run("parallel")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	if state.Status != "completed" {
+		t.Errorf("Expected status completed, got %s", state.Status)
+	}
+
+	// Verify every step ran exactly once
+	if pack.calls.Load() != 1 || reserve.calls.Load() != 1 {
+		t.Errorf("Expected parallel steps to run once, got %d and %d", pack.calls.Load(), reserve.calls.Load())
+	}
+
+	if len(state.CompletedSteps) != 4 {
+		t.Fatalf("Expected 4 completed steps, got %v", state.CompletedSteps)
+	}
+
+	// The join step must come last
+	if state.CompletedSteps[0] != "payment" || state.CompletedSteps[3] != "ship" {
+		t.Errorf("Expected payment first and ship last, got %v", state.CompletedSteps)
+	}
+
+	for _, id := range []string{"payment", "pack_items", "reserve_shipping", "ship"} {
+		if result, ok := state.StepResults[id]; !ok || !result.Success {
+			t.Errorf("Expected step %s to succeed, got %+v", id, result)
+		}
+	}
+}
+
+func TestParallelExecutionFailure(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+
+	engine.RegisterTask(&MockTask{name: "ok", result: map[string]any{"ok": true}})
+	engine.RegisterTask(&MockTask{name: "fail", err: errors.New("out of stock")})
+	join := &MockTask{name: "join"}
+	engine.RegisterTask(join)
+
+	engine.workflows["parallel"] = &models.Workflow{
+		Name: "parallel",
+		Steps: []models.Step{
+			{ID: "payment", Task: "ok", Next: []string{"pack_items", "reserve_shipping"}},
+			{ID: "pack_items", Task: "fail", Next: []string{"ship"}},
+			{ID: "reserve_shipping", Task: "ok", Next: []string{"ship"}},
+			{ID: "ship", Task: "join"},
+		},
+	}
+
+	// Run the workflow
+	state, err := engine.Run("parallel")
+	if err == nil {
+		t.Fatal("Expected workflow to fail")
+	}
+
+	if state.Status != "failed" {
+		t.Errorf("Expected status failed, got %s", state.Status)
+	}
+
+	// The join step must not run once a predecessor failed
+	if join.executed {
+		t.Error("Expected join step not to be executed")
+	}
+
+	if result := state.StepResults["pack_items"]; result.Success || result.Error != "out of stock" {
+		t.Errorf("Expected pack_items to fail with out of stock, got %+v", result)
+	}
+}
+
+// newFailureEngine returns an engine with a workflow whose charge step fails
+// with the given error and has failure handlers
+func newFailureEngine(err error) *Engine {
+	engine := NewEngine()
+	engine.RegisterTask(&EchoTask{name: "echo"})
+	engine.RegisterTask(&MockTask{name: "fail", err: err})
+
+	engine.workflows["failure"] = &models.Workflow{
+		Name:    "failure",
+		Outputs: map[string]string{"notified": "steps.notify_ops.success"},
+		Steps: []models.Step{
+			{ID: "charge", Task: "fail", Next: []string{"ship"}, OnFailure: []string{"notify_ops"},
+				OnErrorMatch: []models.ErrorRoute{{Pattern: "payment_*", Next: []string{"refund"}}}},
+			{ID: "ship", Task: "echo"},
+			{ID: "notify_ops", Task: "echo", Params: map[string]string{"error": "${{ steps.charge.error }}"}},
+			{ID: "refund", Task: "echo", Params: map[string]string{"class": "${{ steps.charge.error_class }}"}},
+		},
+	}
+
+	return engine
+}
+
+func TestOnFailure(t *testing.T) {
+	// Create a new engine
+	engine := newFailureEngine(errors.New("card declined"))
+
+	state, err := engine.Run("failure")
+	if err != nil {
+		t.Fatalf("Expected the handled failure not to fail the run, got %v", err)
+	}
+
+	if state.Status != "completed" || state.Outputs["notified"] != true {
+		t.Errorf("Expected the run to complete after notify_ops, got %s and %v", state.Status, state.Outputs)
+	}
+
+	// The failed step keeps its error, and the handler can see it
+	if result := state.StepResults["charge"]; result.Success || result.Status != models.StepFailed || result.Error != "card declined" {
+		t.Errorf("Expected charge to fail, got %+v", result)
+	}
+
+	if data := state.StepResults["notify_ops"].Data; data["error"] != "card declined" {
+		t.Errorf("Expected notify_ops to get the error, got %v", data)
+	}
+
+	// Neither the next step nor the other handler runs
+	for _, id := range []string{"ship", "refund"} {
+		if status := state.StepResults[id].Status; status != models.StepSkipped {
+			t.Errorf("Expected step %s to be skipped, got %s", id, status)
+		}
+	}
+
+	// The decisions replay without drift
+	events, _ := engine.Events(state.RunID)
+	if _, err := engine.Replay(events); err != nil {
+		t.Errorf("Expected replay without drift, got %v", err)
+	}
+}
+
+func TestOnErrorMatch(t *testing.T) {
+	// Create a new engine
+	engine := newFailureEngine(tasks.NewError("payment_declined", errors.New("card declined")))
+
+	state, err := engine.Run("failure")
+	if err != nil {
+		t.Fatalf("Expected the handled failure not to fail the run, got %v", err)
+	}
+
+	// A matching route takes precedence over on_failure
+	if status := state.StepResults["notify_ops"].Status; status != models.StepSkipped {
+		t.Errorf("Expected notify_ops to be skipped, got %s", status)
+	}
+
+	if data := state.StepResults["refund"].Data; data["class"] != "payment_declined" {
+		t.Errorf("Expected refund to get the error class, got %v", data)
+	}
+
+	if class := state.StepResults["charge"].ErrorClass; class != "payment_declined" {
+		t.Errorf("Expected error class payment_declined, got %s", class)
+	}
+}
+
+func TestFailureHandlersSkippedOnSuccess(t *testing.T) {
+	// Create a new engine
+	engine := newFailureEngine(nil)
+
+	state, err := engine.Run("failure")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	if _, ok := state.StepResults["ship"]; !ok {
+		t.Error("Expected ship to run")
+	}
+
+	for _, id := range []string{"notify_ops", "refund"} {
+		if status := state.StepResults[id].Status; status != models.StepSkipped {
+			t.Errorf("Expected handler %s to be skipped, got %s", id, status)
+		}
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.RegisterTask(&EchoTask{name: "echo"})
+	engine.RegisterTask(&MockTask{name: "fail", err: errors.New("smtp unavailable")})
+
+	engine.workflows["continue"] = &models.Workflow{
+		Name: "continue",
+		Steps: []models.Step{
+			{ID: "pack", Task: "echo", Next: []string{"email", "ship"}},
+			{ID: "email", Task: "fail", Next: []string{"done"}, ContinueOnError: true},
+			{ID: "ship", Task: "echo", Next: []string{"done"}},
+			{ID: "done", Task: "echo"},
+		},
+	}
+
+	state, err := engine.Run("continue")
+	if err != nil {
+		t.Fatalf("Expected the non-critical failure not to fail the run, got %v", err)
+	}
+
+	if state.Status != "completed" {
+		t.Errorf("Expected status completed, got %s", state.Status)
+	}
+
+	if result := state.StepResults["email"]; result.Success || result.Error != "smtp unavailable" {
+		t.Errorf("Expected email to fail, got %+v", result)
+	}
+
+	if _, ok := state.StepResults["done"]; !ok {
+		t.Error("Expected done to run after the failed step")
+	}
+
+	// Without the flag the failure stops the run
+	engine.workflows["continue"].Steps[1].ContinueOnError = false
+	if state, err := engine.Run("continue"); err == nil || state.Status != "failed" {
+		t.Errorf("Expected the run to fail, got %v", err)
+	}
+}
+
+func TestInvalidGraph(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	task := &MockTask{name: "task1"}
+	engine.RegisterTask(task)
+
+	// A next reference to a missing step
+	engine.workflows["dangling"] = &models.Workflow{
+		Name:  "dangling",
+		Steps: []models.Step{{ID: "step1", Task: "task1", Next: []string{"missing"}}},
+	}
+
+	if _, err := engine.Run("dangling"); err == nil {
+		t.Error("Expected error for missing next step")
+	}
+
+	// A cycle between two steps
+	engine.workflows["cycle"] = &models.Workflow{
+		Name: "cycle",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Next: []string{"step2"}},
+			{ID: "step2", Task: "task1", Next: []string{"step1"}},
+		},
+	}
+
+	if _, err := engine.Run("cycle"); err == nil {
+		t.Error("Expected error for cyclic workflow")
+	}
+
+	// Nothing should run for an invalid graph
+	if task.executed {
+		t.Error("Expected no step to be executed")
+	}
+}
+
+func TestProgramCache(t *testing.T) {
+	engine := NewEngine()
+
+	first, err := engine.program("inputs.n > 0")
+	if err != nil {
+		t.Fatalf("Failed to compile expression: %v", err)
+	}
+
+	// The cache keeps the expressions used most recently, up to its size
+	for i := range maxPrograms {
+		if _, err := engine.program(fmt.Sprintf("inputs.n > %d", i+1)); err != nil {
+			t.Fatalf("Failed to compile expression: %v", err)
+		}
+		if i == maxPrograms/2 {
+			if again, _ := engine.program("inputs.n > 0"); again != first {
+				t.Fatal("Expected the cached program")
+			}
+		}
+	}
+	if n := engine.programs.order.Len(); n != maxPrograms {
+		t.Errorf("Expected %d cached programs, got %d", maxPrograms, n)
+	}
+	if _, ok := engine.programs.get("inputs.n > 0"); !ok {
+		t.Error("Expected a recently used program to stay cached")
+	}
+	if _, ok := engine.programs.get("inputs.n > 1"); ok {
+		t.Error("Expected the least recently used program to be dropped")
+	}
+}
@@ -0,0 +1,120 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+)
+
+// recorder records the events of a run. Each event is stored, then applied
+// to the run's state, which is stored too, so the stored state is always the
+// projection of the stored events. It is only used by the goroutine that
+// coordinates the run
+type recorder struct {
+	e     *Engine
+	state *models.WorkflowState
+	seq   int
+}
+
+// newRecorder returns a recorder for a run whose log already holds seq events
+func (e *Engine) newRecorder(state *models.WorkflowState, seq int) *recorder {
+	return &recorder{e: e, state: state, seq: seq}
+}
+
+// continueRecorder returns a recorder that continues the event log of a
+// stored run, if it has one
+func (e *Engine) continueRecorder(state *models.WorkflowState) (*recorder, error) {
+	seq := 0
+	events, err := e.Events(state.RunID)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if len(events) > 0 {
+		seq = events[len(events)-1].Seq
+	}
+	return e.newRecorder(state, seq), nil
+}
+
+// checkLatest makes sure the event log of the run has not grown since the
+// recorder was made, which happens when another process executed the run in
+// the meantime. A run resumed from a stale state could run steps twice
+func (r *recorder) checkLatest() error {
+	if r.seq == 0 {
+		return nil
+	}
+
+	events, err := r.e.Events(r.state.RunID)
+	if err != nil {
+		return err
+	}
+	if len(events) > 0 && events[len(events)-1].Seq != r.seq {
+		return fmt.Errorf("run %s changed while it was being resumed", r.state.RunID)
+	}
+	return nil
+}
+
+// record numbers and stores an event, and applies it to the state
+func (r *recorder) record(event models.Event) error {
+	r.seq++
+	event.Seq = r.seq
+	event.RunID = r.state.RunID
+	event.Time = r.e.now()
+
+	if err := r.e.store().AppendEvent(event); err != nil {
+		return fmt.Errorf("failed to save event of run %s: %w", r.state.RunID, err)
+	}
+
+	r.state.Apply(event)
+	if err := r.e.saveState(r.state); err != nil {
+		return err
+	}
+
+	r.e.notify(r.state.RunID)
+	return nil
+}
+
+// WatchEvents returns a channel that receives a value when events of a run
+// are recorded, until stop is called. Values do not queue up, so read the
+// events that are new since with Events after each one
+func (e *Engine) WatchEvents(runID string) (recorded <-chan struct{}, stop func()) {
+	ch := make(chan struct{}, 1)
+
+	e.mu.Lock()
+	e.watchers[runID] = append(e.watchers[runID], ch)
+	e.mu.Unlock()
+
+	stop = func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		watchers := slices.DeleteFunc(e.watchers[runID], func(w chan struct{}) bool { return w == ch })
+		if len(watchers) == 0 {
+			delete(e.watchers, runID)
+		} else {
+			e.watchers[runID] = watchers
+		}
+	}
+	return ch, stop
+}
+
+// notify tells the watchers of a run that events were recorded
+func (e *Engine) notify(runID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, ch := range e.watchers[runID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Events returns the event log of a run. Unknown runs return an error that
+// wraps store.ErrNotFound
+func (e *Engine) Events(runID string) ([]models.Event, error) {
+	return e.store().Events(runID)
+}
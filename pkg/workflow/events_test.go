@@ -0,0 +1,199 @@
+package workflow
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// newEventWorkflow returns a workflow with a retried step, a skipped step and an output
+func newEventWorkflow() *models.Workflow {
+	return &models.Workflow{
+		Name:    "events",
+		Outputs: map[string]string{"ok": "steps.fetch.data.ok"},
+		Steps: []models.Step{
+			{ID: "fetch", Task: "flaky", Next: []string{"notify", "archive"}, Retry: &models.RetryPolicy{MaxAttempts: 2, InitialDelay: "1ms"}},
+			{ID: "notify", Task: "echo", Params: map[string]string{"ok": "${{ steps.fetch.data.ok }}"}},
+			{ID: "archive", Task: "echo", Condition: "not steps.fetch.data.ok"},
+		},
+	}
+}
+
+// newEventEngine returns an engine with the workflow of newEventWorkflow loaded
+func newEventEngine() *Engine {
+	engine := NewEngine()
+	engine.RegisterTask(&FlakyTask{name: "flaky", errs: []error{errors.New("connection reset")}})
+	engine.RegisterTask(&EchoTask{name: "echo"})
+	engine.workflows["events"] = newEventWorkflow()
+	return engine
+}
+
+func TestEventLog(t *testing.T) {
+	// Create a new engine
+	engine := newEventEngine()
+	engine.RegisterTask(&MockTask{name: "flaky", result: map[string]any{"ok": true}})
+	engine.workflows["events"].Steps[0].Task = "flaky"
+
+	state, err := engine.Run("events")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	events, err := engine.Events(state.RunID)
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+
+	var types []string
+	for i, event := range events {
+		if event.Seq != i+1 || event.RunID != state.RunID || event.Time.IsZero() {
+			t.Errorf("Expected event %d of run %s with a time, got %+v", i+1, state.RunID, event)
+		}
+		types = append(types, event.Type)
+	}
+
+	// notify and archive are started one after the other, so their order is fixed
+	expected := []string{
+		models.EventRunStarted,
+		models.EventStepScheduled, models.EventAttemptStarted, models.EventTaskOutput, models.EventStepCompleted,
+		models.EventStepScheduled, models.EventStepSkipped,
+		models.EventAttemptStarted, models.EventTaskOutput, models.EventStepCompleted,
+		models.EventRunFinished,
+	}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("Expected events %v, got %v", expected, types)
+	}
+
+	if last := events[len(events)-1]; last.Status != "completed" || last.Data["ok"] != true {
+		t.Errorf("Expected run_finished with status and outputs, got %+v", last)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	// Create a new engine
+	engine := newEventEngine()
+
+	state, err := engine.Run("events")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	events, err := engine.Events(state.RunID)
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+
+	// Replaying the log gives the state the run ended with, without running tasks
+	replayed, err := engine.Replay(events)
+	if err != nil {
+		t.Fatalf("Failed to replay events: %v", err)
+	}
+
+	if !reflect.DeepEqual(replayed, state) {
+		t.Errorf("Expected replayed state %+v, got %+v", state, replayed)
+	}
+
+	if attempts := replayed.StepResults["fetch"].Attempts; len(attempts) != 2 || attempts[0].Error != "connection reset" {
+		t.Errorf("Expected both attempts of fetch, got %+v", attempts)
+	}
+}
+
+func TestReplayDrift(t *testing.T) {
+	// Record a run of the original workflow
+	engine := newEventEngine()
+	state, err := engine.Run("events")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+	events, _ := engine.Events(state.RunID)
+
+	tests := []struct {
+		name     string
+		change   func(wf *models.Workflow)
+		expected string
+	}{
+		{"task", func(wf *models.Workflow) { wf.Steps[1].Task = "flaky" }, "step notify ran task echo but now runs flaky"},
+		{"params", func(wf *models.Workflow) { wf.Steps[1].Params["ok"] = "yes" }, "params of step notify resolve to map[ok:yes] but were map[ok:true]"},
+		{"condition", func(wf *models.Workflow) { wf.Steps[2].Condition = "true" }, "step archive was skipped but its condition now holds"},
+		{"removed", func(wf *models.Workflow) { wf.Steps = wf.Steps[:2]; wf.Steps[0].Next = []string{"notify"} }, "step archive no longer exists"},
+		{"moved", func(wf *models.Workflow) {
+			wf.Steps[0].Next = []string{"archive"}
+			wf.Steps[2].Next = []string{"notify"}
+		}, "step notify no longer follows a step that ran before it"},
+	}
+
+	for _, tt := range tests {
+		wf := newEventWorkflow()
+		tt.change(wf)
+		engine.workflows["events"] = wf
+
+		_, err := engine.Replay(events)
+		var drift *DriftError
+		if !errors.As(err, &drift) {
+			t.Errorf("%s: expected a drift error, got %v", tt.name, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestReplayInvalidLog(t *testing.T) {
+	engine := newEventEngine()
+	state, _ := engine.Run("events")
+	events, _ := engine.Events(state.RunID)
+
+	if _, err := engine.Replay(nil); err == nil {
+		t.Error("Expected error for an empty log")
+	}
+
+	if _, err := engine.Replay(events[1:]); err == nil {
+		t.Error("Expected error for a log without run_started")
+	}
+
+	swapped := append([]models.Event(nil), events...)
+	swapped[2], swapped[3] = swapped[3], swapped[2]
+	if _, err := engine.Replay(swapped); err == nil || !strings.Contains(err.Error(), "out of order") {
+		t.Errorf("Expected out of order error, got %v", err)
+	}
+}
+
+func TestStepStatus(t *testing.T) {
+	// Create a new engine
+	engine := newEventEngine()
+	engine.workflows["events"].Steps = append(engine.workflows["events"].Steps,
+		models.Step{ID: "report", Task: "echo", Params: map[string]string{"status": "${{ steps.fetch.status }}"}})
+	engine.workflows["events"].Steps[0].Next = append(engine.workflows["events"].Steps[0].Next, "report")
+
+	state, err := engine.Run("events")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	if state.Status != models.RunCompleted {
+		t.Errorf("Expected status completed, got %s", state.Status)
+	}
+
+	// The retried step records both attempts and how long it took
+	fetch := state.StepResults["fetch"]
+	if fetch.Status != models.StepSucceeded || fetch.AttemptCount != 2 {
+		t.Errorf("Expected fetch to succeed after 2 attempts, got %s after %d", fetch.Status, fetch.AttemptCount)
+	}
+
+	if fetch.StartedAt.IsZero() || fetch.EndedAt.Before(fetch.StartedAt) || fetch.Duration != fetch.EndedAt.Sub(fetch.StartedAt) {
+		t.Errorf("Expected start and end times with a duration, got %v, %v and %s", fetch.StartedAt, fetch.EndedAt, fetch.Duration)
+	}
+
+	if archive := state.StepResults["archive"]; archive.Status != models.StepSkipped || archive.AttemptCount != 0 {
+		t.Errorf("Expected archive to be skipped, got %+v", archive)
+	}
+
+	// Expressions can read the status of a step
+	if status := state.StepResults["report"].Params["status"]; status != "succeeded" {
+		t.Errorf("Expected report to get status succeeded, got %s", status)
+	}
+}
@@ -0,0 +1,228 @@
+package workflow
+
+import (
+	"container/list"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mstgnz/goflow/pkg/expr"
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// Expressions can use these variables:
+//
+//	steps.<id>.success      whether the step succeeded
+//	steps.<id>.status       its status, e.g. "succeeded" or "skipped"
+//	steps.<id>.error        the error of a failed step
+//	steps.<id>.error_class  the class of that error, e.g. "timeout"
+//	steps.<id>.data         the data returned by the step's task
+//	inputs.<name>           a workflow input
+//	env.<name>              an environment variable
+//	<id>.<field>            shorthand for steps.<id>.data.<field>
+const (
+	stepsVar  = "steps"
+	inputsVar = "inputs"
+	envVar    = "env"
+)
+
+// expressionScope returns the variables that expressions in a workflow may use
+func expressionScope(workflow *models.Workflow) expr.Scope {
+	steps := &expr.Schema{Type: expr.Map, Fields: make(map[string]*expr.Schema)}
+	scope := expr.Scope{
+		stepsVar:  steps,
+		inputsVar: inputsSchema(workflow.Inputs),
+		envVar:    expr.OpenMap(),
+	}
+
+	for _, step := range workflow.Steps {
+		steps.Fields[step.ID] = &expr.Schema{
+			Type: expr.Map,
+			Fields: map[string]*expr.Schema{
+				"success":     expr.Of(expr.Bool),
+				"status":      expr.Of(expr.String),
+				"error":       expr.Of(expr.String),
+				"error_class": expr.Of(expr.String),
+				"data":        expr.OpenMap(),
+			},
+		}
+
+		// Reserved names take precedence over the step shorthand
+		if _, ok := scope[step.ID]; !ok {
+			scope[step.ID] = expr.OpenMap()
+		}
+	}
+
+	return scope
+}
+
+// expressionVars returns the values of the expression variables for a
+// workflow state. The environment is added by eval when an expression reads env
+func (e *Engine) expressionVars(state *models.WorkflowState) map[string]any {
+	inputs := state.Inputs
+	if inputs == nil {
+		inputs = map[string]any{}
+	}
+
+	steps := make(map[string]any, len(state.StepResults))
+	vars := map[string]any{
+		stepsVar:  steps,
+		inputsVar: inputs,
+	}
+
+	for id, result := range state.StepResults {
+		steps[id] = map[string]any{
+			"success":     result.Success,
+			"status":      string(result.Status),
+			"error":       result.Error,
+			"error_class": result.ErrorClass,
+			"data":        result.Data,
+		}
+
+		if _, ok := vars[id]; !ok && id != envVar {
+			vars[id] = result.Data
+		}
+	}
+
+	return vars
+}
+
+// eval evaluates a program against expression variables. The environment
+// is only read for a program that uses env, and is then kept in vars for
+// the programs evaluated against them after it
+func (e *Engine) eval(program *expr.Program, vars map[string]any) (any, error) {
+	if _, ok := vars[envVar]; !ok && slices.Contains(program.Vars(), envVar) {
+		vars[envVar] = e.environ()
+	}
+	return program.Eval(vars)
+}
+
+// evalBool evaluates a program as a condition, see eval and expr.Program.EvalBool
+func (e *Engine) evalBool(program *expr.Program, vars map[string]any) (bool, error) {
+	value, err := e.eval(program, vars)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := value.(bool)
+	return ok && b, nil
+}
+
+// program returns the compiled form of an expression. Expressions are
+// type-checked when their workflow is loaded, so here they are only parsed
+func (e *Engine) program(source string) (*expr.Program, error) {
+	if p, ok := e.programs.get(source); ok {
+		return p, nil
+	}
+
+	p, err := expr.Compile(source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	e.programs.add(source, p)
+	return p, nil
+}
+
+// maxPrograms is how many compiled expressions an engine keeps. Clients can
+// load workflows with any number of expressions, so the ones used least
+// recently are dropped and compiled again when they are used
+const maxPrograms = 1000
+
+// programCache keeps the compiled expressions used most recently
+type programCache struct {
+	mu      sync.Mutex
+	order   list.List                // *expr.Program, most recently used first
+	entries map[string]*list.Element // expression source -> element in order
+}
+
+// get returns the compiled form of an expression, if it is cached
+func (c *programCache) get(source string) (*expr.Program, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[source]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*expr.Program), true
+}
+
+// add caches the compiled form of an expression, dropping the least recently
+// used one when the cache is full
+func (c *programCache) add(source string, p *expr.Program) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+	if _, ok := c.entries[source]; ok {
+		return
+	}
+
+	c.entries[source] = c.order.PushFront(p)
+	if c.order.Len() > maxPrograms {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*expr.Program).Source())
+	}
+}
+
+// SetEnvAllowlist limits the environment variables expressions can read as
+// env.<name> to the named ones, e.g. when clients that are not trusted with
+// the environment can load workflows. Without names, expressions read none.
+// By default expressions read the whole environment of the process. The
+// variables that triggers read their secrets from are never readable.
+//
+// Only params that read env are kept out of stored runs, see recordedParams.
+// A value read from env that a task returns in its data, or that an error or
+// a workflow output contains, is stored, and returned by the API and event
+// streams, like any other value. Allow only variables that clients may see,
+// or that no task echoes
+func (e *Engine) SetEnvAllowlist(names ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.envAllowlist = append([]string{}, names...)
+}
+
+// environ returns the environment variables expressions can read
+func (e *Engine) environ() map[string]any {
+	e.mu.Lock()
+	allowlist := e.envAllowlist
+	secrets := e.secretEnv
+	e.mu.Unlock()
+
+	env := make(map[string]any)
+	if allowlist == nil {
+		for _, kv := range os.Environ() {
+			if name, value, ok := strings.Cut(kv, "="); ok && !secrets[name] {
+				env[name] = value
+			}
+		}
+		return env
+	}
+
+	for _, name := range allowlist {
+		if value, ok := os.LookupEnv(name); ok && !secrets[name] {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// updateSecretEnv collects the variables that the triggers of the loaded
+// workflows read their secrets from. The lock must be held
+func (e *Engine) updateSecretEnv() {
+	secrets := make(map[string]bool)
+	for _, workflow := range e.workflows {
+		for _, trigger := range workflow.Triggers {
+			if trigger.SecretEnv != "" {
+				secrets[trigger.SecretEnv] = true
+			}
+		}
+	}
+	e.secretEnv = secrets
+}
@@ -0,0 +1,241 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/mstgnz/goflow/pkg/expr"
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// ErrInvalidInputs is returned when a run is started with inputs that do not
+// match the workflow's input declarations
+var ErrInvalidInputs = errors.New("invalid inputs")
+
+// inputSchemas maps input types to the expression types they are checked as
+var inputSchemas = map[string]func() *expr.Schema{
+	models.InputString:  func() *expr.Schema { return expr.Of(expr.String) },
+	models.InputNumber:  func() *expr.Schema { return expr.Of(expr.Number) },
+	models.InputInteger: func() *expr.Schema { return expr.Of(expr.Number) },
+	models.InputBoolean: func() *expr.Schema { return expr.Of(expr.Bool) },
+	models.InputArray:   func() *expr.Schema { return &expr.Schema{Type: expr.List, Elem: expr.AnySchema} },
+	models.InputObject:  expr.OpenMap,
+	models.InputAny:     func() *expr.Schema { return expr.AnySchema },
+	"":                  func() *expr.Schema { return expr.AnySchema },
+}
+
+// inputsSchema returns the schema of the inputs variable for a workflow's input declarations
+func inputsSchema(inputs []models.Input) *expr.Schema {
+	schema := &expr.Schema{Type: expr.Map, Fields: make(map[string]*expr.Schema)}
+	for _, input := range inputs {
+		if newSchema, ok := inputSchemas[input.Type]; ok {
+			schema.Fields[input.Name] = newSchema()
+		} else {
+			schema.Fields[input.Name] = expr.AnySchema
+		}
+	}
+	return schema
+}
+
+// checkInputDeclarations validates the input declarations of a workflow
+func checkInputDeclarations(inputs []models.Input) []error {
+	var errs []error
+	seen := make(map[string]bool)
+
+	for i, input := range inputs {
+		if input.Name == "" {
+			errs = append(errs, fmt.Errorf("input %d has no name", i+1))
+			continue
+		}
+		if seen[input.Name] {
+			errs = append(errs, fmt.Errorf("duplicate input %s", input.Name))
+			continue
+		}
+		seen[input.Name] = true
+
+		if _, ok := inputSchemas[input.Type]; !ok {
+			errs = append(errs, fmt.Errorf("input %s has unknown type %s", input.Name, input.Type))
+			continue
+		}
+
+		if input.Default != nil {
+			if _, err := convertInput(input, input.Default); err != nil {
+				errs = append(errs, fmt.Errorf("default of input %s: %w", input.Name, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// resolveInputs checks the inputs given to a run against the workflow's
+// declarations. Missing optional inputs get their default, strings are
+// converted to numbers and booleans where declared, and every problem is
+// reported at once
+func resolveInputs(workflow *models.Workflow, given map[string]any) (map[string]any, error) {
+	var errs []error
+	resolved := make(map[string]any, len(workflow.Inputs))
+	declared := make(map[string]bool, len(workflow.Inputs))
+
+	for _, input := range workflow.Inputs {
+		declared[input.Name] = true
+
+		value, ok := given[input.Name]
+		if !ok || value == nil {
+			switch {
+			case input.Default != nil:
+				value = input.Default
+			case input.Required:
+				errs = append(errs, fmt.Errorf("missing required input %s", input.Name))
+				continue
+			default:
+				continue
+			}
+		}
+
+		converted, err := convertInput(input, value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("input %s: %w", input.Name, err))
+			continue
+		}
+		resolved[input.Name] = converted
+	}
+
+	for _, name := range sortedKeys(given) {
+		if !declared[name] {
+			errs = append(errs, fmt.Errorf("unknown input %s", name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w for workflow %s: %w", ErrInvalidInputs, workflow.Name, errors.Join(errs...))
+	}
+	return resolved, nil
+}
+
+// maxInteger bounds integer inputs to the range a float64, e.g. a JSON
+// number, holds exactly
+const maxInteger = 1 << 53
+
+// convertInput checks a value against the declared type of an input
+func convertInput(input models.Input, value any) (any, error) {
+	switch input.Type {
+	case models.InputString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+
+	case models.InputNumber, models.InputInteger:
+		f, ok := expr.ToNumber(value)
+		if s, isString := value.(string); isString {
+			parsed, err := strconv.ParseFloat(s, 64)
+			f, ok = parsed, err == nil
+		}
+		if ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return nil, fmt.Errorf("expected finite %s, got %v", input.Type, value)
+		}
+		if ok && input.Type == models.InputInteger {
+			if f != math.Trunc(f) {
+				return nil, fmt.Errorf("expected integer, got %v", value)
+			}
+			if math.Abs(f) > maxInteger {
+				return nil, fmt.Errorf("integer %v is out of range", value)
+			}
+			return int(f), nil
+		}
+		if ok {
+			return f, nil
+		}
+
+	case models.InputBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+
+	case models.InputArray:
+		if kind := reflect.ValueOf(value).Kind(); kind == reflect.Slice || kind == reflect.Array {
+			return value, nil
+		}
+
+	case models.InputObject:
+		if reflect.ValueOf(value).Kind() == reflect.Map {
+			return value, nil
+		}
+
+	default:
+		return value, nil
+	}
+
+	return nil, fmt.Errorf("expected %s, got %T", input.Type, value)
+}
+
+// computeOutputs evaluates the output expressions of a workflow against its final state
+func (e *Engine) computeOutputs(workflow *models.Workflow, state *models.WorkflowState) (map[string]any, error) {
+	if len(workflow.Outputs) == 0 {
+		return nil, nil
+	}
+
+	outputs, err := e.evaluateAll("output", workflow.Outputs, state)
+	if err != nil {
+		return nil, err
+	}
+
+	// Infinite and NaN numbers cannot be stored as JSON
+	for _, name := range sortedKeys(outputs) {
+		if !finite(outputs[name]) {
+			return nil, fmt.Errorf("output %s is not a finite number: %v", name, outputs[name])
+		}
+	}
+	return outputs, nil
+}
+
+// finite reports whether a value holds no infinite or NaN numbers
+func finite(value any) bool {
+	switch v := value.(type) {
+	case float64:
+		return !math.IsNaN(v) && !math.IsInf(v, 0)
+	case []any:
+		for _, item := range v {
+			if !finite(item) {
+				return false
+			}
+		}
+	case map[string]any:
+		for _, item := range v {
+			if !finite(item) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// evaluateAll evaluates a map of named expressions, like outputs, against a
+// state, and names the first expression that fails in the error
+func (e *Engine) evaluateAll(kind string, expressions map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	vars := e.expressionVars(state)
+	values := make(map[string]any, len(expressions))
+
+	for _, name := range sortedKeys(expressions) {
+		program, err := e.program(expressions[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", kind, name, err)
+		}
+
+		value, err := e.eval(program, vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", kind, name, err)
+		}
+		values[name] = value
+	}
+
+	return values, nil
+}
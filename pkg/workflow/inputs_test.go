@@ -0,0 +1,248 @@
+package workflow
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+func TestResolveInputs(t *testing.T) {
+	workflow := &models.Workflow{
+		Name: "order_process",
+		Inputs: []models.Input{
+			{Name: "order_id", Type: models.InputString, Required: true},
+			{Name: "amount", Type: models.InputNumber, Default: 10},
+			{Name: "quantity", Type: models.InputInteger},
+			{Name: "express", Type: models.InputBoolean, Default: false},
+			{Name: "items", Type: models.InputArray},
+			{Name: "customer", Type: models.InputObject},
+			{Name: "note"},
+		},
+	}
+
+	inputs, err := resolveInputs(workflow, map[string]any{
+		"order_id": "A-100",
+		"quantity": "3",
+		"express":  "true",
+		"items":    []any{"book"},
+		"customer": map[string]any{"name": "Ada"},
+		"note":     42,
+	})
+	if err != nil {
+		t.Fatalf("Failed to resolve inputs: %v", err)
+	}
+
+	expected := map[string]any{
+		"order_id": "A-100",
+		"amount":   float64(10),
+		"quantity": 3,
+		"express":  true,
+		"note":     42,
+	}
+
+	for name, value := range expected {
+		if inputs[name] != value {
+			t.Errorf("Expected input %s to be %v (%T), got %v (%T)", name, value, value, inputs[name], inputs[name])
+		}
+	}
+
+	if _, ok := inputs["items"].([]any); !ok {
+		t.Errorf("Expected items to be a list, got %T", inputs["items"])
+	}
+
+	// Every problem is reported at once
+	_, err = resolveInputs(workflow, map[string]any{
+		"amount":   "lots",
+		"quantity": 1.5,
+		"items":    "book",
+		"coupon":   "FREE",
+	})
+	if err == nil {
+		t.Fatal("Expected invalid inputs to be rejected")
+	}
+
+	for _, message := range []string{
+		"missing required input order_id",
+		"input amount: expected number, got string",
+		"input quantity: expected integer, got 1.5",
+		"input items: expected array, got string",
+		"unknown input coupon",
+	} {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error to contain %q, got %v", message, err)
+		}
+	}
+}
+
+func TestNumberInputRange(t *testing.T) {
+	number := models.Input{Name: "amount", Type: models.InputNumber}
+	integer := models.Input{Name: "count", Type: models.InputInteger}
+
+	tests := []struct {
+		input    models.Input
+		value    any
+		expected any
+		err      string
+	}{
+		{number, "1e300", 1e300, ""},
+		{number, "NaN", nil, "expected finite number, got NaN"},
+		{number, "-Inf", nil, "expected finite number, got -Inf"},
+		{number, math.Inf(1), nil, "expected finite number, got +Inf"},
+		{integer, "9007199254740992", 1 << 53, ""},
+		{integer, -9007199254740992.0, -1 << 53, ""},
+		{integer, "9007199254740994", nil, "integer 9007199254740994 is out of range"},
+		{integer, "1e300", nil, "integer 1e300 is out of range"},
+		{integer, "Inf", nil, "expected finite integer, got Inf"},
+		{integer, math.NaN(), nil, "expected finite integer, got NaN"},
+	}
+	for _, tt := range tests {
+		value, err := convertInput(tt.input, tt.value)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Expected error %q for %v, got %v", tt.err, tt.value, err)
+			}
+			continue
+		}
+		if err != nil || value != tt.expected {
+			t.Errorf("Expected %v for %v, got %v (%v)", tt.expected, tt.value, value, err)
+		}
+	}
+}
+
+func TestInputDeclarationValidation(t *testing.T) {
+	workflow := &models.Workflow{
+		Name: "invalid_inputs",
+		Inputs: []models.Input{
+			{Name: "amount", Type: "money"},
+			{Name: "count", Type: models.InputInteger, Default: "many"},
+			{Name: "count", Type: models.InputInteger},
+			{Type: models.InputString},
+		},
+		Outputs: map[string]string{
+			"total": "steps.missing.data.total",
+		},
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Condition: "inputs.undeclared == 1"},
+		},
+	}
+
+	err := Validate(workflow, nil)
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	for _, message := range []string{
+		"input amount has unknown type money",
+		"default of input count: expected integer, got string",
+		"duplicate input count",
+		"input 4 has no name",
+		"output total: column 7: unknown field missing",
+		"unknown field undeclared",
+	} {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error to contain %q, got %v", message, err)
+		}
+	}
+}
+
+func TestRunWithInputs(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+
+	pay := &MockTask{name: "pay", result: map[string]any{"charged": true, "reference": "PAY-1"}}
+	engine.RegisterTask(pay)
+
+	err := engine.LoadBytes([]byte(`
+name: order_process
+inputs:
+  - name: order_id
+    type: string
+    required: true
+  - name: amount
+    type: number
+    default: 100
+outputs:
+  order: inputs.order_id
+  reference: steps.payment.data.reference
+  charged: payment.charged && inputs.amount > 50
+steps:
+  - id: payment
+    task: pay
+    params:
+      order: ${{ inputs.order_id }}
+      amount: ${{ inputs.amount }}
+`), FormatYAML)
+	if err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+
+	// Run the same definition for different orders
+	for _, orderID := range []string{"A-1", "A-2"} {
+		state, err := engine.RunWithInputs(context.Background(), "order_process", map[string]any{"order_id": orderID})
+		if err != nil {
+			t.Fatalf("Failed to run workflow: %v", err)
+		}
+
+		if pay.params["order"] != orderID || pay.params["amount"] != "100" {
+			t.Errorf("Expected params for %s, got %v", orderID, pay.params)
+		}
+
+		if state.Inputs["order_id"] != orderID {
+			t.Errorf("Expected input order_id %s on state, got %v", orderID, state.Inputs["order_id"])
+		}
+
+		expected := map[string]any{"order": orderID, "reference": "PAY-1", "charged": true}
+		for name, value := range expected {
+			if state.Outputs[name] != value {
+				t.Errorf("Expected output %s to be %v, got %v", name, value, state.Outputs[name])
+			}
+		}
+	}
+
+	// Invalid inputs are rejected before anything runs
+	pay.executed = false
+	if _, err := engine.RunWithInputs(context.Background(), "order_process", nil); err == nil {
+		t.Error("Expected missing required input to be rejected")
+	}
+
+	if pay.executed {
+		t.Error("Expected no step to run with invalid inputs")
+	}
+}
+
+func TestNonFiniteOutput(t *testing.T) {
+	engine := NewEngine()
+	engine.RegisterTask(&MockTask{name: "task1"})
+
+	err := engine.LoadBytes([]byte(`
+name: square
+inputs:
+  - name: x
+    type: number
+outputs:
+  square: inputs.x * inputs.x
+  list: "[inputs.x, inputs.x * inputs.x]"
+steps:
+  - id: step1
+    task: task1
+`), FormatYAML)
+	if err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+
+	state, err := engine.RunContext(context.Background(), "square", map[string]any{"x": 3})
+	if err != nil || state.Outputs["square"] != float64(9) {
+		t.Fatalf("Expected output 9, got %v (%v)", state.Outputs["square"], err)
+	}
+
+	state, err = engine.RunContext(context.Background(), "square", map[string]any{"x": 1e300})
+	if err == nil || !strings.Contains(err.Error(), "is not a finite number") {
+		t.Fatalf("Expected a non-finite output to fail the run, got %v", err)
+	}
+	if state.Status != models.RunFailed {
+		t.Errorf("Expected run to fail, got %s", state.Status)
+	}
+}
@@ -3,8 +3,58 @@ package workflow
 import (
 	"os"
 	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/clock"
+	"github.com/mstgnz/goflow/pkg/models"
 )
 
+// integrationStart is the time integration runs start at on their fake clock
+var integrationStart = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// runOnFakeClock runs a workflow on a fake clock that moves a minute on
+// whenever a task waits, so the work the default tasks simulate takes no time
+func runOnFakeClock(engine *Engine, workflowName string) (*models.WorkflowState, error) {
+	fake := clock.NewFake(integrationStart)
+	engine.SetClock(fake)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			if fake.Timers() > 0 {
+				fake.Advance(time.Minute)
+			}
+		}
+	}()
+
+	return engine.Run(workflowName)
+}
+
+// checkTimings checks that a run of steps that each took a minute on the fake
+// clock recorded their timings
+func checkTimings(t *testing.T, state *models.WorkflowState, steps []string) {
+	t.Helper()
+
+	if !state.StartTime.Equal(integrationStart) {
+		t.Errorf("Expected start time %s, got %s", integrationStart, state.StartTime)
+	}
+	if end := integrationStart.Add(time.Duration(len(steps)) * time.Minute); !state.EndTime.Equal(end) {
+		t.Errorf("Expected end time %s, got %s", end, state.EndTime)
+	}
+
+	for _, step := range steps {
+		if duration := state.StepResults[step].Duration; duration != time.Minute {
+			t.Errorf("Expected step %s to take 1m0s, got %s", step, duration)
+		}
+	}
+}
+
 func TestIntegrationOrderProcess(t *testing.T) {
 	// Create a new engine
 	engine := NewEngine()
@@ -65,7 +115,7 @@ func TestIntegrationOrderProcess(t *testing.T) {
 	}
 
 	// Run the workflow
-	state, err := engine.Run("order_process")
+	state, err := runOnFakeClock(engine, "order_process")
 	if err != nil {
 		t.Fatalf("Failed to run workflow: %v", err)
 	}
@@ -99,6 +149,8 @@ func TestIntegrationOrderProcess(t *testing.T) {
 			t.Errorf("Expected step %s to succeed, but it failed: %s", step, result.Error)
 		}
 	}
+
+	checkTimings(t, state, expectedSteps)
 }
 
 func TestIntegrationFileProcessing(t *testing.T) {
@@ -165,7 +217,7 @@ func TestIntegrationFileProcessing(t *testing.T) {
 	}
 
 	// Run the workflow
-	state, err := engine.Run("file_processing")
+	state, err := runOnFakeClock(engine, "file_processing")
 	if err != nil {
 		t.Fatalf("Failed to run workflow: %v", err)
 	}
@@ -200,6 +252,8 @@ func TestIntegrationFileProcessing(t *testing.T) {
 		}
 	}
 
+	checkTimings(t, state, expectedSteps)
+
 	// Verify the records were processed and saved
 	processResult, ok := state.StepResults["process"]
 	if !ok {
@@ -0,0 +1,141 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the encoding of a workflow definition
+type Format string
+
+const (
+	// FormatJSON is a JSON workflow definition
+	FormatJSON Format = "json"
+	// FormatYAML is a YAML workflow definition
+	FormatYAML Format = "yaml"
+)
+
+// FormatFromPath returns the format of a workflow file based on its extension
+func FormatFromPath(filePath string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	default:
+		return "", fmt.Errorf("unsupported file format: %s", filePath)
+	}
+}
+
+// errNoName is returned for a workflow definition without a name
+var errNoName = errors.New("workflow must have a name")
+
+// Parse decodes a workflow definition and checks that it has a name
+func Parse(data []byte, format Format) (*models.Workflow, error) {
+	var workflow models.Workflow
+
+	switch format {
+	case FormatJSON:
+		if err := json.Unmarshal(data, &workflow); err != nil {
+			return nil, fmt.Errorf("failed to parse workflow: %w", jsonError(data, err))
+		}
+	case FormatYAML:
+		// yaml.v3 errors already carry line numbers
+		if err := yaml.Unmarshal(data, &workflow); err != nil {
+			return nil, fmt.Errorf("failed to parse workflow: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported workflow format: %s", format)
+	}
+
+	if workflow.Name == "" {
+		return nil, errNoName
+	}
+
+	return &workflow, nil
+}
+
+// jsonError adds the line and column of the offending input to a JSON decoding error
+func jsonError(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		// The offset points just past the offending character
+		offset = max(syntaxErr.Offset-1, 0)
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		offset = int64(len(data))
+	default:
+		return err
+	}
+
+	line, column := 1, 1
+	for _, b := range data[:min(offset, int64(len(data)))] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+// LoadBytes loads a workflow definition held in memory. The workflow is
+// validated against the registered tasks, so tasks must be registered first
+func (e *Engine) LoadBytes(data []byte, format Format) error {
+	workflow, err := Parse(data, format)
+	if err != nil {
+		return err
+	}
+
+	return e.LoadWorkflow(workflow)
+}
+
+// LoadWorkflow loads a parsed workflow definition, see Parse. The workflow is
+// validated against the registered tasks, and replaces a loaded workflow
+// with its name
+func (e *Engine) LoadWorkflow(workflow *models.Workflow) error {
+	if workflow.Name == "" {
+		return errNoName
+	}
+
+	if err := Validate(workflow, e.taskRegistry); err != nil {
+		return fmt.Errorf("invalid workflow %s: %w", workflow.Name, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkTriggerConflicts(workflow); err != nil {
+		return fmt.Errorf("invalid workflow %s: %w", workflow.Name, err)
+	}
+	if err := e.checkChildWaits(workflow); err != nil {
+		return fmt.Errorf("invalid workflow %s: %w", workflow.Name, err)
+	}
+	e.workflows[workflow.Name] = workflow
+	e.updateSecretEnv()
+	return nil
+}
+
+// LoadReader loads a workflow definition from a reader, such as an embedded file or an HTTP body
+func (e *Engine) LoadReader(r io.Reader, format Format) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read workflow: %w", err)
+	}
+
+	return e.LoadBytes(data, format)
+}
@@ -0,0 +1,170 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatFromPath(t *testing.T) {
+	tests := map[string]Format{
+		"workflow.json": FormatJSON,
+		"workflow.yaml": FormatYAML,
+		"workflow.yml":  FormatYAML,
+		"WORKFLOW.YML":  FormatYAML,
+	}
+
+	for path, expected := range tests {
+		format, err := FormatFromPath(path)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", path, err)
+			continue
+		}
+
+		if format != expected {
+			t.Errorf("Expected format %s for %s, got %s", expected, path, format)
+		}
+	}
+
+	// Test with an unsupported extension
+	if _, err := FormatFromPath("workflow.txt"); err == nil {
+		t.Error("Expected error for unsupported file format")
+	}
+}
+
+func TestWorkflowLoadingYAML(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.RegisterTask(&MockTask{name: "task1"})
+	engine.RegisterTask(&MockTask{name: "task2"})
+
+	// Create a temporary workflow file
+	workflowYAML := `name: test_workflow
+steps:
+  - id: step1
+    task: task1
+    next: [step2]
+    params:
+      template: welcome
+  - id: step2
+    task: task2
+    condition: step1.sent
+`
+
+	path := filepath.Join(t.TempDir(), "workflow.yml")
+	if err := os.WriteFile(path, []byte(workflowYAML), 0644); err != nil {
+		t.Fatalf("Failed to write workflow file: %v", err)
+	}
+
+	// Load the workflow
+	if err := engine.Load(path); err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+
+	// Verify the workflow is loaded
+	workflow, ok := engine.workflows["test_workflow"]
+	if !ok {
+		t.Fatal("Failed to get loaded workflow")
+	}
+
+	if len(workflow.Steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(workflow.Steps))
+	}
+
+	if workflow.Steps[0].Params["template"] != "welcome" {
+		t.Errorf("Expected template welcome, got %s", workflow.Steps[0].Params["template"])
+	}
+
+	if len(workflow.Steps[0].Next) != 1 || workflow.Steps[0].Next[0] != "step2" {
+		t.Errorf("Expected next step step2, got %v", workflow.Steps[0].Next)
+	}
+
+	if workflow.Steps[1].Condition != "step1.sent" {
+		t.Errorf("Expected condition step1.sent, got %s", workflow.Steps[1].Condition)
+	}
+}
+
+func TestLoadBytes(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.RegisterTask(&MockTask{name: "task1"})
+
+	// Load a JSON definition from memory
+	err := engine.LoadBytes([]byte(`{"name": "json_workflow", "steps": [{"id": "step1", "task": "task1"}]}`), FormatJSON)
+	if err != nil {
+		t.Fatalf("Failed to load JSON workflow: %v", err)
+	}
+
+	// Load a YAML definition from a reader
+	err = engine.LoadReader(strings.NewReader("name: yaml_workflow\nsteps:\n  - id: step1\n    task: task1\n"), FormatYAML)
+	if err != nil {
+		t.Fatalf("Failed to load YAML workflow: %v", err)
+	}
+
+	for _, name := range []string{"json_workflow", "yaml_workflow"} {
+		if _, ok := engine.workflows[name]; !ok {
+			t.Errorf("Expected workflow %s to be loaded", name)
+		}
+	}
+
+	// Test with an unsupported format
+	if err := engine.LoadBytes([]byte(`{}`), Format("toml")); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+
+	tests := []struct {
+		name     string
+		data     string
+		format   Format
+		expected string
+	}{
+		{
+			name:     "json without name",
+			data:     `{"steps": []}`,
+			format:   FormatJSON,
+			expected: "workflow must have a name",
+		},
+		{
+			name:     "yaml without name",
+			data:     "steps: []\n",
+			format:   FormatYAML,
+			expected: "workflow must have a name",
+		},
+		{
+			name:     "json syntax error",
+			data:     "{\n  \"name\": \"test\",\n  \"steps\": [\n}",
+			format:   FormatJSON,
+			expected: "line 4, column 1",
+		},
+		{
+			name:     "json type error",
+			data:     "{\n  \"name\": \"test\",\n  \"steps\": {}\n}",
+			format:   FormatJSON,
+			expected: "line 3",
+		},
+		{
+			name:     "yaml syntax error",
+			data:     "name: test\nsteps:\n  - id: step1\n   task: task1\n",
+			format:   FormatYAML,
+			expected: "yaml: line 2",
+		},
+	}
+
+	for _, tt := range tests {
+		err := engine.LoadBytes([]byte(tt.data), tt.format)
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error containing %q, got %q", tt.name, tt.expected, err.Error())
+		}
+	}
+}
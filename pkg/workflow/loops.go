@@ -0,0 +1,329 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/clock"
+	"github.com/mstgnz/goflow/pkg/expr"
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// Loop steps can use these variables in their params, and loop steps in
+// their while and until expressions:
+//
+//	loop.index  index of the iteration, from 0
+//	loop.item   item of a foreach iteration, also available under its "as" name
+//	loop.data   data of the previous iteration of a loop, or of the current
+//	            one in an until expression
+const (
+	loopVar        = "loop"
+	defaultItemVar = "item"
+
+	// errorClassLoopLimit is the class of the error of a loop that did not
+	// finish within its max iterations
+	errorClassLoopLimit = "loop_limit"
+)
+
+// identifierPattern matches the names that can be used as variables in expressions
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// isLoop reports whether a step runs its task once per iteration
+func isLoop(step *models.Step) bool {
+	return step.ForEach != nil || step.Loop != nil
+}
+
+// itemVar returns the name of the item variable of a foreach step
+func itemVar(forEach *models.ForEach) string {
+	if forEach.As != "" {
+		return forEach.As
+	}
+	return defaultItemVar
+}
+
+// loopScope returns the scope of a step's params and loop expressions, which
+// includes the loop variables for foreach and loop steps
+func loopScope(scope expr.Scope, step *models.Step) expr.Scope {
+	if !isLoop(step) {
+		return scope
+	}
+
+	s := maps.Clone(scope)
+	s[loopVar] = &expr.Schema{
+		Type: expr.Map,
+		Fields: map[string]*expr.Schema{
+			"index": expr.Of(expr.Number),
+			"item":  expr.AnySchema,
+			"data":  expr.OpenMap(),
+		},
+	}
+	if step.ForEach != nil {
+		s[itemVar(step.ForEach)] = expr.AnySchema
+	}
+	return s
+}
+
+// loopVars returns the expression variables of an iteration of a step
+func (e *Engine) loopVars(state *models.WorkflowState, step *models.Step, index int, item any, data map[string]any) map[string]any {
+	vars := e.expressionVars(state)
+	vars[loopVar] = map[string]any{"index": index, "item": item, "data": data}
+	if step.ForEach != nil {
+		vars[itemVar(step.ForEach)] = item
+	}
+	return vars
+}
+
+// checkLoop validates the foreach or loop block of a step
+func checkLoop(step *models.Step, scope expr.Scope) []error {
+	var errs []error
+
+	if step.ForEach != nil && step.Loop != nil {
+		errs = append(errs, errors.New("foreach and loop cannot be combined"))
+	}
+
+	if f := step.ForEach; f != nil {
+		if f.Items == "" {
+			errs = append(errs, errors.New("foreach: no items"))
+		} else if _, err := expr.Compile(f.Items, scope); err != nil {
+			errs = append(errs, fmt.Errorf("foreach items %q: %w", f.Items, err))
+		}
+
+		// A custom name must not hide other variables, like a step's shorthand
+		name := itemVar(f)
+		_, taken := scope[name]
+		if !identifierPattern.MatchString(name) || name == loopVar || taken && f.As != "" {
+			errs = append(errs, fmt.Errorf("foreach: invalid item variable %q", name))
+		}
+
+		if f.MaxConcurrency < 0 {
+			errs = append(errs, fmt.Errorf("foreach: max_concurrency must not be negative, got %d", f.MaxConcurrency))
+		}
+	}
+
+	if l := step.Loop; l != nil {
+		if l.While == "" && l.Until == "" {
+			errs = append(errs, errors.New("loop: needs while or until"))
+		}
+
+		for _, source := range []string{l.While, l.Until} {
+			if source == "" {
+				continue
+			}
+			if err := checkCondition(source, loopScope(scope, step)); err != nil {
+				errs = append(errs, fmt.Errorf("loop %q: %w", source, err))
+			}
+		}
+
+		if l.MaxIterations < 1 {
+			errs = append(errs, fmt.Errorf("loop: max_iterations must be at least 1, got %d", l.MaxIterations))
+		}
+
+		if l.Delay != "" {
+			if _, err := time.ParseDuration(l.Delay); err != nil {
+				errs = append(errs, fmt.Errorf("loop: invalid delay: %w", err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// executeForEach runs the task of a step once per item of its list, with at
+// most max_concurrency iterations at once. After an iteration fails no new
+// ones are started, and the step fails once the running ones have finished.
+// The step's data holds the data of all iterations under "results", in the
+// order of the items
+func (e *Engine) executeForEach(ctx context.Context, step *models.Step, state *models.WorkflowState, emit func(models.Event)) (models.Status, error) {
+	program, err := e.program(step.ForEach.Items)
+	if err != nil {
+		return models.StepFailed, fmt.Errorf("foreach items: %w", err)
+	}
+
+	value, err := e.eval(program, e.expressionVars(state))
+	if err != nil {
+		return models.StepFailed, fmt.Errorf("foreach items: %w", err)
+	}
+
+	items, ok := toList(value)
+	if !ok {
+		return models.StepFailed, fmt.Errorf("foreach items %s: expected a list, got %T", step.ForEach.Items, value)
+	}
+
+	results := make([]any, len(items))
+	slots := make(chan struct{}, max(step.ForEach.MaxConcurrency, 1))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var status models.Status
+	var firstErr error
+
+	for i, item := range items {
+		slots <- struct{}{}
+
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			data, iterationStatus, err := e.runIteration(ctx, step, i+1, state, e.loopVars(state, step, i, item, nil), item, emit)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					status, firstErr = iterationStatus, fmt.Errorf("iteration %d: %w", i+1, err)
+				}
+				return
+			}
+			results[i] = data
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return status, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return stoppedStatus(ctx), err
+	}
+
+	emit(models.Event{Type: models.EventLoopCompleted, StepID: step.ID, Data: map[string]any{"results": results}})
+	return models.StepSucceeded, nil
+}
+
+// executeLoop runs the task of a step while its while expression holds, or
+// until its until expression does, waiting the loop's delay between
+// iterations. A loop that has not finished after max_iterations fails the
+// step with the error class "loop_limit". The step's data is the data of the
+// last iteration
+func (e *Engine) executeLoop(ctx context.Context, step *models.Step, state *models.WorkflowState, emit func(models.Event)) (models.Status, error) {
+	loop := step.Loop
+
+	var delay time.Duration
+	if loop.Delay != "" {
+		var err error
+		if delay, err = time.ParseDuration(loop.Delay); err != nil {
+			return models.StepFailed, fmt.Errorf("invalid delay: %w", err)
+		}
+	}
+
+	var data map[string]any
+	for i := 0; ; i++ {
+		vars := e.loopVars(state, step, i, nil, data)
+
+		if loop.While != "" {
+			ok, err := e.evaluateLoopCondition(loop.While, vars)
+			if err != nil {
+				return models.StepFailed, fmt.Errorf("while: %w", err)
+			}
+			if !ok {
+				break
+			}
+		}
+
+		if i == loop.MaxIterations {
+			return models.StepFailed, tasks.NewError(errorClassLoopLimit, fmt.Errorf("loop did not finish within %d iterations", loop.MaxIterations))
+		}
+
+		if i > 0 && delay > 0 && clock.Sleep(ctx, delay) != nil {
+			return stoppedStatus(ctx), ctx.Err()
+		}
+
+		next, status, err := e.runIteration(ctx, step, i+1, state, vars, nil, emit)
+		if err != nil {
+			return status, fmt.Errorf("iteration %d: %w", i+1, err)
+		}
+		data = next
+
+		if loop.Until != "" {
+			ok, err := e.evaluateLoopCondition(loop.Until, e.loopVars(state, step, i, nil, data))
+			if err != nil {
+				return models.StepFailed, fmt.Errorf("until: %w", err)
+			}
+			if ok {
+				break
+			}
+		}
+	}
+
+	emit(models.Event{Type: models.EventLoopCompleted, StepID: step.ID, Data: data})
+	return models.StepSucceeded, nil
+}
+
+// runIteration resolves the params of one iteration of a step and runs its task
+func (e *Engine) runIteration(ctx context.Context, step *models.Step, number int, state *models.WorkflowState, vars map[string]any, item any, emit func(models.Event)) (map[string]any, models.Status, error) {
+	started := models.Event{Type: models.EventIterationStarted, StepID: step.ID, Iteration: number}
+	if step.ForEach != nil {
+		started.Data = map[string]any{"item": item}
+	}
+
+	params, err := e.resolveParamsWith(step.Params, func() map[string]any { return vars })
+	if err != nil {
+		err = fmt.Errorf("failed to resolve params: %w", err)
+		emit(started)
+		emit(models.Event{Type: models.EventIterationFailed, StepID: step.ID, Iteration: number, Error: err.Error()})
+		return nil, models.StepFailed, err
+	}
+	if params == nil {
+		params = make(map[string]string)
+	}
+
+	started.Params = e.recordedParams(step.Params, params)
+	emit(started)
+
+	data, status, err := e.executeTask(ctx, step, number, params, state, emit)
+	if err != nil {
+		emit(models.Event{Type: models.EventIterationFailed, StepID: step.ID, Iteration: number, Error: err.Error()})
+	}
+	return data, status, err
+}
+
+// evaluateLoopCondition evaluates the while or until expression of a loop
+func (e *Engine) evaluateLoopCondition(source string, vars map[string]any) (bool, error) {
+	program, err := e.program(source)
+	if err != nil {
+		return false, err
+	}
+	return e.evalBool(program, vars)
+}
+
+// stoppedStatus returns the status of a step that stopped because its context is done
+func stoppedStatus(ctx context.Context) models.Status {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return models.StepCancelled
+	}
+	return models.StepTimedOut
+}
+
+// toList converts a list value of any element type to []any
+func toList(value any) ([]any, bool) {
+	if list, ok := value.([]any); ok {
+		return list, true
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+
+	list := make([]any, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
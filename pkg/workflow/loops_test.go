@@ -0,0 +1,248 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// CounterTask counts its calls and how many of them run at once, and returns
+// the count with its params
+type CounterTask struct {
+	name    string
+	delay   time.Duration
+	mu      sync.Mutex
+	calls   int
+	running int
+	peak    int
+}
+
+func (t *CounterTask) Name() string {
+	return t.name
+}
+
+func (t *CounterTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	t.mu.Lock()
+	t.calls++
+	count := t.calls
+	t.running++
+	t.peak = max(t.peak, t.running)
+	t.mu.Unlock()
+
+	time.Sleep(t.delay)
+
+	t.mu.Lock()
+	t.running--
+	t.mu.Unlock()
+
+	data := map[string]any{"count": count}
+	for name, value := range params {
+		data[name] = value
+	}
+	return data, nil
+}
+
+// newLoopEngine returns an engine with a counter task and a workflow with a single step
+func newLoopEngine(step models.Step) (*Engine, *CounterTask) {
+	engine := NewEngine()
+	counter := &CounterTask{name: "counter"}
+	engine.RegisterTask(counter)
+
+	engine.workflows["loop"] = &models.Workflow{
+		Name:   "loop",
+		Inputs: []models.Input{{Name: "orders", Type: models.InputArray}},
+		Steps:  []models.Step{step},
+	}
+
+	return engine, counter
+}
+
+func TestForEach(t *testing.T) {
+	// Create a new engine
+	engine, counter := newLoopEngine(models.Step{
+		ID:      "ship",
+		Task:    "counter",
+		ForEach: &models.ForEach{Items: "inputs.orders", As: "order", MaxConcurrency: 2},
+		Params:  map[string]string{"order": "${{ order }}", "index": "${{ loop.index }}"},
+	})
+	counter.delay = 20 * time.Millisecond
+
+	state, err := engine.RunWithInputs(context.Background(), "loop", map[string]any{"orders": []any{"a", "b", "c", "d"}})
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	if counter.peak != 2 {
+		t.Errorf("Expected 2 iterations to run at once, got %d", counter.peak)
+	}
+
+	// The results keep the order of the items, whatever order they finished in
+	result := state.StepResults["ship"]
+	results, _ := result.Data["results"].([]any)
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %v", result.Data)
+	}
+	for i, order := range []string{"a", "b", "c", "d"} {
+		data, _ := results[i].(map[string]any)
+		if data["order"] != order {
+			t.Errorf("Expected result %d to be for order %s, got %v", i, order, data)
+		}
+	}
+
+	if len(result.Iterations) != 4 {
+		t.Fatalf("Expected 4 iterations, got %d", len(result.Iterations))
+	}
+	for i, iteration := range result.Iterations {
+		if iteration.Number != i+1 || iteration.Status != models.StepSucceeded {
+			t.Errorf("Expected iteration %d to succeed, got %+v", i+1, iteration)
+		}
+		if iteration.Params["index"] != strconv.Itoa(i) {
+			t.Errorf("Expected iteration %d to have index %d, got %s", i+1, i, iteration.Params["index"])
+		}
+	}
+}
+
+func TestForEachFailure(t *testing.T) {
+	// Create a new engine
+	engine, _ := newLoopEngine(models.Step{
+		ID:      "ship",
+		Task:    "fail",
+		ForEach: &models.ForEach{Items: "inputs.orders"},
+	})
+	engine.RegisterTask(&MockTask{name: "fail", err: errors.New("no courier")})
+
+	state, err := engine.RunWithInputs(context.Background(), "loop", map[string]any{"orders": []any{"a", "b", "c"}})
+	if err == nil || !strings.Contains(err.Error(), "iteration 1: no courier") {
+		t.Fatalf("Expected the first iteration to fail, got %v", err)
+	}
+
+	// No iterations start after one failed
+	result := state.StepResults["ship"]
+	if result.Status != models.StepFailed {
+		t.Errorf("Expected status failed, got %s", result.Status)
+	}
+	if len(result.Iterations) != 1 || result.Iterations[0].Status != models.StepFailed {
+		t.Errorf("Expected a single failed iteration, got %+v", result.Iterations)
+	}
+}
+
+func TestLoopUntil(t *testing.T) {
+	// Create a new engine
+	engine, counter := newLoopEngine(models.Step{
+		ID:   "poll",
+		Task: "counter",
+		Loop: &models.Loop{Until: "loop.data.count >= 3", MaxIterations: 5, Delay: "1ms"},
+	})
+
+	state, err := engine.Run("loop")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	if counter.calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", counter.calls)
+	}
+
+	// The step's data is that of the last iteration
+	result := state.StepResults["poll"]
+	if result.Data["count"] != 3 {
+		t.Errorf("Expected count 3, got %v", result.Data["count"])
+	}
+	if len(result.Iterations) != 3 || result.AttemptCount != 3 {
+		t.Errorf("Expected 3 iterations and attempts, got %d and %d", len(result.Iterations), result.AttemptCount)
+	}
+}
+
+func TestLoopWhile(t *testing.T) {
+	// Create a new engine
+	engine, counter := newLoopEngine(models.Step{
+		ID:     "page",
+		Task:   "counter",
+		Loop:   &models.Loop{While: "loop.index == 0 || loop.data.cursor != \"2\"", MaxIterations: 5},
+		Params: map[string]string{"cursor": "${{ loop.index }}"},
+	})
+
+	state, err := engine.Run("loop")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	if counter.calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", counter.calls)
+	}
+
+	if cursor := state.StepResults["page"].Data["cursor"]; cursor != "2" {
+		t.Errorf("Expected cursor 2, got %v", cursor)
+	}
+}
+
+func TestLoopLimit(t *testing.T) {
+	// Create a new engine
+	engine, counter := newLoopEngine(models.Step{
+		ID:   "poll",
+		Task: "counter",
+		Loop: &models.Loop{Until: "loop.data.count > 10", MaxIterations: 3},
+	})
+
+	state, err := engine.Run("loop")
+	if err == nil || !strings.Contains(err.Error(), "loop did not finish within 3 iterations") {
+		t.Fatalf("Expected loop limit error, got %v", err)
+	}
+
+	if counter.calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", counter.calls)
+	}
+
+	if class := state.StepResults["poll"].ErrorClass; class != "loop_limit" {
+		t.Errorf("Expected error class loop_limit, got %s", class)
+	}
+}
+
+func TestLoopValidation(t *testing.T) {
+	registry := tasks.NewRegistry()
+	registry.Register(&MockTask{name: "task1"})
+
+	workflow := &models.Workflow{
+		Name: "loops",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Next: []string{"step2"},
+				ForEach: &models.ForEach{Items: "nope", As: "inputs", MaxConcurrency: -1}},
+			{ID: "step2", Task: "task1", Next: []string{"step3"},
+				Loop: &models.Loop{Delay: "soon"}},
+			{ID: "step3", Task: "task1",
+				Loop:   &models.Loop{Until: "loop.data.done", MaxIterations: 3},
+				Params: map[string]string{"page": "${{ loop.index }}", "item": "${{ item }}"}},
+		},
+	}
+
+	err := Validate(workflow, registry)
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	expected := []string{
+		`step step1: foreach items "nope": column 1: unknown variable nope`,
+		`step step1: foreach: invalid item variable "inputs"`,
+		"step step1: foreach: max_concurrency must not be negative, got -1",
+		"step step2: loop: needs while or until",
+		"step step2: loop: max_iterations must be at least 1, got 0",
+		"step step2: loop: invalid delay",
+		"step step3: param item: ${{ item }}: column 1: unknown variable item",
+	}
+	for _, message := range expected {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error %q, got %v", message, err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "param page") {
+		t.Errorf("Expected loop.index to be valid in params, got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// DriftError reports where a workflow definition no longer matches the
+// recorded history of a run
+type DriftError struct {
+	WorkflowName string
+	RunID        string
+	Problems     []string
+}
+
+func (e *DriftError) Error() string {
+	return fmt.Sprintf("workflow %s has drifted from the history of run %s: %s", e.WorkflowName, e.RunID, strings.Join(e.Problems, "; "))
+}
+
+// Replay rebuilds the state of a run from its event log without running any
+// tasks, e.g. to inspect a production run locally. Each recorded decision is
+// checked against the loaded definition of the workflow: scheduled and
+// skipped steps must still exist and run the same task, follow a step that
+// ran, have conditions with the same outcome and params that resolve to the
+// recorded values, and completed steps must pick the same branch. The state
+// is returned together with a *DriftError if the definition has drifted from
+// the history
+func (e *Engine) Replay(events []models.Event) (*models.WorkflowState, error) {
+	if len(events) == 0 || events[0].Type != models.EventRunStarted {
+		return nil, errors.New("event log must start with a run_started event")
+	}
+
+	workflow, ok := e.Workflow(events[0].WorkflowName)
+	if !ok {
+		return nil, fmt.Errorf("workflow not found: %s", events[0].WorkflowName)
+	}
+
+	g := newGraph(workflow)
+	predecessors := make(map[string][]string)
+	for _, step := range workflow.Steps {
+		for _, next := range successors(&step) {
+			predecessors[next] = append(predecessors[next], step.ID)
+		}
+	}
+
+	runID := events[0].RunID
+	drift := &DriftError{WorkflowName: workflow.Name, RunID: runID}
+	state := &models.WorkflowState{}
+
+	for i, event := range events {
+		if event.RunID != runID {
+			return nil, fmt.Errorf("event %d belongs to run %s, not %s", event.Seq, event.RunID, runID)
+		}
+		if event.Seq != i+1 {
+			return nil, fmt.Errorf("event %d is out of order, expected event %d", event.Seq, i+1)
+		}
+
+		// Decisions are checked against the state the run had when it made them
+		switch event.Type {
+		case models.EventStepScheduled, models.EventStepSkipped:
+			drift.Problems = append(drift.Problems, e.checkDecision(g, predecessors, state, event)...)
+		case models.EventStepCompleted:
+			drift.Problems = append(drift.Problems, e.checkBranch(g, state, event)...)
+		}
+		state.Apply(event)
+	}
+
+	if len(drift.Problems) > 0 {
+		return state, drift
+	}
+	return state, nil
+}
+
+// checkDecision checks that the workflow would still schedule or skip a step
+// like the recorded event did, given the state before the event
+func (e *Engine) checkDecision(g *graph, predecessors map[string][]string, state *models.WorkflowState, event models.Event) []string {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	step, ok := g.steps[event.StepID]
+	if !ok {
+		add("step %s no longer exists", event.StepID)
+		return problems
+	}
+
+	// A step that is scheduled again after its run was resumed was checked the first time
+	if prior, ok := state.StepResults[step.ID]; ok && prior.Status != models.StepPending && event.Type == models.EventStepScheduled {
+		return problems
+	}
+
+	// A step is activated by a finished predecessor that leads to it
+	activated := step.ID == g.entry
+	for _, id := range predecessors[step.ID] {
+		result, ok := state.StepResults[id]
+		finished := result.Success || slices.Contains(state.CompletedSteps, id)
+		if ok && finished && slices.Contains(activatedSuccessors(g.steps[id], result), step.ID) {
+			activated = true
+		}
+	}
+
+	if event.Type == models.EventStepSkipped {
+		// Steps no predecessor leads to are skipped without checking their condition
+		if !activated {
+			return problems
+		}
+		if step.Condition == "" {
+			add("step %s was skipped but no longer has a condition", step.ID)
+		} else if ok, err := e.evaluateCondition(step.Condition, state); err != nil {
+			add("condition of step %s: %v", step.ID, err)
+		} else if ok {
+			add("step %s was skipped but its condition now holds", step.ID)
+		}
+		return problems
+	}
+
+	if !activated {
+		add("step %s no longer follows a step that ran before it", step.ID)
+	}
+
+	if step.Task != event.Task {
+		add("step %s ran task %s but now runs %s", step.ID, event.Task, step.Task)
+	}
+
+	if step.Condition != "" {
+		if ok, err := e.evaluateCondition(step.Condition, state); err != nil {
+			add("condition of step %s: %v", step.ID, err)
+		} else if !ok {
+			add("step %s ran but its condition no longer holds", step.ID)
+		}
+	}
+
+	// The params of loop steps are resolved per iteration, from data the
+	// replay does not have
+	if isLoop(step) {
+		return problems
+	}
+
+	params, err := e.resolveParams(step.Params, state)
+	if err != nil {
+		add("params of step %s: %v", step.ID, err)
+	} else if params = e.recordedParams(step.Params, params); !maps.Equal(params, event.Params) {
+		add("params of step %s resolve to %v but were %v", step.ID, params, event.Params)
+	}
+
+	return problems
+}
+
+// checkBranch checks that a completed step would still pick the branch it
+// picked, given the state before its completion
+func (e *Engine) checkBranch(g *graph, state *models.WorkflowState, event models.Event) []string {
+	step, ok := g.steps[event.StepID]
+	if !ok {
+		return nil
+	}
+
+	branch, err := e.selectBranch(step, completedState(state, step.ID))
+	switch {
+	case err != nil:
+		return []string{fmt.Sprintf("branches of step %s: %v", step.ID, err)}
+	case branch != event.Branch:
+		return []string{fmt.Sprintf("step %s took branch %q but now takes %q", step.ID, event.Branch, branch)}
+	}
+	return nil
+}
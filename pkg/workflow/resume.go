@@ -0,0 +1,102 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// Resume continues a stored run that did not complete, e.g. because the
+// process running it crashed or it was cancelled. Completed steps are not run
+// again, and failed steps run again. A step that was interrupted runs again
+// if it is idempotent, is otherwise reconciled by its task, see
+// tasks.Reconciler, and fails the run if it is neither. An interrupted
+// workflow step resumes its child run. A run that stopped while it was being
+// compensated, or whose compensations failed, only retries the compensations
+// that did not succeed. A run that waits for a signal waits again, unless its
+// wait has timed out. The workflow of the run must be loaded
+func (e *Engine) Resume(ctx context.Context, runID string) (*models.WorkflowState, error) {
+	return e.resume(ctx, runID, nil)
+}
+
+// resume continues a stored run, delivering a pending signal to its waiting steps
+func (e *Engine) resume(ctx context.Context, runID string, pending *signal) (*models.WorkflowState, error) {
+	state, err := e.GetRun(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch state.Status {
+	case models.RunCompleted:
+		return nil, fmt.Errorf("run %s has already completed", runID)
+	case models.RunCompensated:
+		return nil, fmt.Errorf("run %s has already been compensated", runID)
+	}
+
+	workflow, ok := e.Workflow(state.WorkflowName)
+	if !ok {
+		return nil, fmt.Errorf("workflow not found: %s", state.WorkflowName)
+	}
+
+	timeout, err := parseTimeout(workflow.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout for workflow %s: %w", workflow.Name, err)
+	}
+
+	rec, err := e.continueRecorder(state)
+	if err != nil {
+		return nil, err
+	}
+	return e.run(ctx, workflow, rec, models.Event{Type: models.EventRunResumed}, timeout, pending)
+}
+
+// interrupted reports whether a step was stopped before its task returned,
+// so its task may or may not have taken effect
+func interrupted(result models.StepResult) bool {
+	switch result.Status {
+	case models.StepRunning, models.StepCancelled, models.StepTimedOut:
+		return true
+	}
+	return false
+}
+
+// recoverStep runs a step that was interrupted when its run stopped, with
+// the params it was started with
+func (e *Engine) recoverStep(ctx context.Context, step *models.Step, params map[string]string, state *models.WorkflowState, emit func(models.Event)) (models.Status, error) {
+	if params == nil {
+		params = make(map[string]string)
+	}
+
+	if step.Workflow != nil {
+		return e.recoverChild(ctx, step, params, state, emit)
+	}
+
+	if step.Idempotent {
+		return e.executeStep(ctx, step, params, state, emit)
+	}
+
+	task, ok := e.taskRegistry.Get(step.Task)
+	if !ok {
+		return models.StepFailed, fmt.Errorf("task not found: %s", step.Task)
+	}
+
+	reconciler, ok := task.(tasks.Reconciler)
+	if !ok {
+		return models.StepFailed, fmt.Errorf("step was interrupted and is not idempotent, and task %s cannot reconcile it", step.Task)
+	}
+
+	data, done, err := reconciler.Reconcile(ctx, params, state)
+	if err != nil {
+		return models.StepFailed, fmt.Errorf("failed to reconcile: %w", err)
+	}
+
+	// The interrupted execution did not take effect, so it is safe to run again
+	if !done {
+		return e.executeStep(ctx, step, params, state, emit)
+	}
+
+	emit(models.Event{Type: models.EventStepReconciled, StepID: step.ID, Data: data})
+	return models.StepSucceeded, nil
+}
@@ -0,0 +1,255 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+)
+
+// ReconcilingTask can tell whether an interrupted execution took effect
+type ReconcilingTask struct {
+	MockTask
+	done       bool
+	reconciled map[string]string
+}
+
+func (t *ReconcilingTask) Reconcile(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, bool, error) {
+	t.reconciled = params
+	return map[string]any{"reconciled": true}, t.done, nil
+}
+
+// newInterruptedRun stores a run of a -> b -> c that stopped while b was
+// running. Step b reads a token from the environment, which is not recorded
+func newInterruptedRun(t *testing.T, engine *Engine, idempotent bool) string {
+	t.Helper()
+	t.Setenv("GOFLOW_TEST_TOKEN", "hunter2")
+
+	engine.workflows["resumable"] = &models.Workflow{
+		Name: "resumable",
+		Steps: []models.Step{
+			{ID: "a", Task: "a", Next: []string{"b"}},
+			{ID: "b", Task: "b", Next: []string{"c"}, Idempotent: idempotent, Params: map[string]string{
+				"order": "${{ steps.a.data.order }}",
+				"token": "${{ env.GOFLOW_TEST_TOKEN }}",
+			}},
+			{ID: "c", Task: "c"},
+		},
+	}
+
+	state := &models.WorkflowState{
+		RunID:          newRunID(time.Now()),
+		WorkflowName:   "resumable",
+		CurrentStep:    "b",
+		CompletedSteps: []string{"a"},
+		StepResults: map[string]models.StepResult{
+			"a": {Status: models.StepSucceeded, Success: true, Data: map[string]any{"order": "42"}},
+			"b": {Status: models.StepRunning, Params: map[string]string{"order": "42", "token": "${{ env.GOFLOW_TEST_TOKEN }}"}},
+		},
+		Status: "running",
+	}
+
+	if err := engine.saveState(state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	return state.RunID
+}
+
+func TestResumeIdempotentStep(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	a, b, c := &MockTask{name: "a"}, &MockTask{name: "b"}, &MockTask{name: "c"}
+	engine.RegisterTask(a)
+	engine.RegisterTask(b)
+	engine.RegisterTask(c)
+	runID := newInterruptedRun(t, engine, true)
+
+	state, err := engine.Resume(context.Background(), runID)
+	if err != nil {
+		t.Fatalf("Failed to resume run: %v", err)
+	}
+
+	if state.RunID != runID || state.Status != "completed" {
+		t.Errorf("Expected run %s to complete, got %s with status %s", runID, state.RunID, state.Status)
+	}
+
+	// The completed step is not run again, the interrupted one is
+	if a.executed {
+		t.Error("Expected completed step a not to run again")
+	}
+
+	if !b.executed || b.params["order"] != "42" || b.params["token"] != "hunter2" {
+		t.Errorf("Expected step b to run again with its params, got %v", b.params)
+	}
+
+	if !c.executed {
+		t.Error("Expected step c to run")
+	}
+
+	if strings.Join(state.CompletedSteps, ",") != "a,b,c" {
+		t.Errorf("Expected completed steps a,b,c, got %v", state.CompletedSteps)
+	}
+
+	// The stored run is completed too
+	stored, _ := engine.GetRun(runID)
+	if stored.Status != "completed" {
+		t.Errorf("Expected stored status completed, got %s", stored.Status)
+	}
+
+	if _, err := engine.Resume(context.Background(), runID); err == nil || !strings.Contains(err.Error(), "already completed") {
+		t.Errorf("Expected error resuming a completed run, got %v", err)
+	}
+}
+
+func TestResumeReconciledStep(t *testing.T) {
+	for _, done := range []bool{true, false} {
+		// Create a new engine
+		engine := NewEngine()
+		b := &ReconcilingTask{MockTask: MockTask{name: "b", result: map[string]any{"ran": true}}, done: done}
+		c := &MockTask{name: "c"}
+		engine.RegisterTask(&MockTask{name: "a"})
+		engine.RegisterTask(b)
+		engine.RegisterTask(c)
+		runID := newInterruptedRun(t, engine, false)
+
+		state, err := engine.Resume(context.Background(), runID)
+		if err != nil {
+			t.Fatalf("Failed to resume run: %v", err)
+		}
+
+		if b.reconciled["order"] != "42" {
+			t.Errorf("Expected step b to be reconciled with its params, got %v", b.reconciled)
+		}
+
+		// A step that took effect keeps the reconciled result, otherwise it runs again
+		if b.executed == done {
+			t.Errorf("Expected step b to run again: %v, got %v", !done, b.executed)
+		}
+
+		data := state.StepResults["b"].Data
+		if done && data["reconciled"] != true || !done && data["ran"] != true {
+			t.Errorf("Unexpected result of step b: %v", data)
+		}
+
+		if !c.executed {
+			t.Error("Expected step c to run")
+		}
+	}
+}
+
+func TestResumeNotReconcilable(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	b, c := &MockTask{name: "b"}, &MockTask{name: "c"}
+	engine.RegisterTask(&MockTask{name: "a"})
+	engine.RegisterTask(b)
+	engine.RegisterTask(c)
+	runID := newInterruptedRun(t, engine, false)
+
+	// A step that is not idempotent is never run twice by accident
+	state, err := engine.Resume(context.Background(), runID)
+	if err == nil || !strings.Contains(err.Error(), "not idempotent") {
+		t.Errorf("Expected error for a step that cannot be reconciled, got %v", err)
+	}
+
+	if b.executed || c.executed {
+		t.Error("Expected steps b and c not to run")
+	}
+
+	if state.Status != "failed" {
+		t.Errorf("Expected status failed, got %s", state.Status)
+	}
+}
+
+func TestResumeParallelSteps(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	tasks := map[string]*MockTask{}
+	for _, name := range []string{"start", "left", "right", "skipped", "join"} {
+		tasks[name] = &MockTask{name: name}
+		engine.RegisterTask(tasks[name])
+	}
+
+	engine.workflows["parallel"] = &models.Workflow{
+		Name: "parallel",
+		Steps: []models.Step{
+			{ID: "start", Task: "start", Next: []string{"left", "right", "skipped"}},
+			{ID: "left", Task: "left", Next: []string{"join"}},
+			{ID: "right", Task: "right", Next: []string{"join"}, Idempotent: true},
+			{ID: "skipped", Task: "skipped", Condition: "false", Next: []string{"join"}},
+			{ID: "join", Task: "join"},
+		},
+	}
+
+	// The run stopped after left finished and skipped was skipped, while right was running
+	state := &models.WorkflowState{
+		RunID:          newRunID(time.Now()),
+		WorkflowName:   "parallel",
+		CompletedSteps: []string{"start", "skipped", "left"},
+		StepResults: map[string]models.StepResult{
+			"start": {Status: models.StepSucceeded, Success: true},
+			"left":  {Status: models.StepSucceeded, Success: true},
+			"right": {Status: models.StepRunning},
+		},
+		Status: "cancelled",
+	}
+	engine.saveState(state)
+
+	resumed, err := engine.Resume(context.Background(), state.RunID)
+	if err != nil {
+		t.Fatalf("Failed to resume run: %v", err)
+	}
+
+	for name, task := range tasks {
+		expected := name == "right" || name == "join"
+		if task.executed != expected {
+			t.Errorf("Expected step %s executed: %v, got %v", name, expected, task.executed)
+		}
+	}
+
+	if _, ok := resumed.StepResults["skipped"]; ok {
+		t.Error("Expected the skipped step to stay skipped")
+	}
+}
+
+func TestResumeInAnotherProcess(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, _ := store.NewFileStore(dir)
+	otherStore, _ := store.NewFileStore(dir)
+
+	// Two engines on the same directory stand for two processes
+	engine := NewEngine()
+	engine.SetStateStore(fileStore)
+	other := NewEngine()
+	other.SetStateStore(otherStore)
+
+	task := &BlockingTask{name: "block", started: make(chan struct{})}
+	runID, done := startBlockingRun(t, engine, task)
+	other.workflows["blocking"] = engine.workflows["blocking"]
+
+	// The other engine cannot execute the run while the first one does
+	if _, err := other.Resume(context.Background(), runID); !errors.Is(err, store.ErrLocked) {
+		t.Errorf("Expected ErrLocked resuming a run executing elsewhere, got %v", err)
+	}
+
+	engine.Cancel(runID)
+	<-done
+
+	// A recorder made before the run moved on does not resume it
+	state, _ := other.GetRun(runID)
+	rec, err := other.continueRecorder(state)
+	if err != nil {
+		t.Fatalf("Failed to continue recorder: %v", err)
+	}
+	moved, _ := engine.continueRecorder(state)
+	if err := moved.record(models.Event{Type: models.EventRunResumed}); err != nil {
+		t.Fatalf("Failed to record event: %v", err)
+	}
+	if err := rec.checkLatest(); err == nil || !strings.Contains(err.Error(), "changed while it was being resumed") {
+		t.Errorf("Expected stale state error, got %v", err)
+	}
+}
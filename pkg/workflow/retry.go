@@ -0,0 +1,112 @@
+package workflow
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// retryPolicy is a parsed models.RetryPolicy
+type retryPolicy struct {
+	maxAttempts  int
+	initialDelay time.Duration
+	backoff      string
+	jitter       float64
+	maxDelay     time.Duration
+	retryOn      []string
+}
+
+// noRetry is the policy of steps without a retry block
+var noRetry = &retryPolicy{maxAttempts: 1}
+
+// parseRetryPolicy parses and checks a step's retry block
+func parseRetryPolicy(p *models.RetryPolicy) (*retryPolicy, error) {
+	if p == nil {
+		return noRetry, nil
+	}
+
+	policy := &retryPolicy{
+		maxAttempts: p.MaxAttempts,
+		backoff:     p.Backoff,
+		jitter:      p.Jitter,
+		retryOn:     p.RetryOn,
+	}
+
+	if policy.maxAttempts < 1 {
+		return nil, fmt.Errorf("max_attempts must be at least 1, got %d", p.MaxAttempts)
+	}
+
+	if policy.backoff == "" {
+		policy.backoff = models.BackoffExponential
+	}
+	switch policy.backoff {
+	case models.BackoffExponential, models.BackoffLinear, models.BackoffConstant:
+	default:
+		return nil, fmt.Errorf("unknown backoff %s", p.Backoff)
+	}
+
+	if policy.jitter < 0 || policy.jitter > 1 {
+		return nil, fmt.Errorf("jitter must be between 0 and 1, got %v", p.Jitter)
+	}
+
+	var err error
+	if p.InitialDelay != "" {
+		if policy.initialDelay, err = time.ParseDuration(p.InitialDelay); err != nil {
+			return nil, fmt.Errorf("invalid initial_delay: %w", err)
+		}
+	}
+	if p.MaxDelay != "" {
+		if policy.maxDelay, err = time.ParseDuration(p.MaxDelay); err != nil {
+			return nil, fmt.Errorf("invalid max_delay: %w", err)
+		}
+	}
+
+	return policy, nil
+}
+
+// shouldRetry reports whether another attempt should follow a failed one
+func (p *retryPolicy) shouldRetry(attempt int, err error) bool {
+	if attempt >= p.maxAttempts || tasks.IsPermanent(err) {
+		return false
+	}
+	return len(p.retryOn) == 0 || slices.Contains(p.retryOn, tasks.ErrorClass(err))
+}
+
+// delay returns how long to wait after the given failed attempt. Without a
+// max_delay, a delay too long for a time.Duration is clamped to the longest one
+func (p *retryPolicy) delay(attempt int) time.Duration {
+	if p.initialDelay <= 0 {
+		return 0
+	}
+
+	d := float64(p.initialDelay)
+	switch p.backoff {
+	case models.BackoffExponential:
+		d *= math.Pow(2, float64(attempt-1))
+	case models.BackoffLinear:
+		d *= float64(attempt)
+	}
+
+	if p.maxDelay > 0 && d > float64(p.maxDelay) {
+		d = float64(p.maxDelay)
+	}
+	if d > math.MaxInt64 {
+		d = math.MaxInt64
+	}
+
+	// Jitter takes a random part of the delay off, so retries of parallel steps spread out
+	if p.jitter > 0 {
+		d -= d * p.jitter * rand.Float64()
+	}
+
+	// float64(math.MaxInt64) rounds up, and converting it overflows to a negative delay
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(d)
+}
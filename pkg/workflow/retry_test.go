@@ -0,0 +1,234 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// FlakyTask fails with the given errors before it succeeds
+type FlakyTask struct {
+	name  string
+	errs  []error
+	calls atomic.Int32
+}
+
+func (t *FlakyTask) Name() string {
+	return t.name
+}
+
+func (t *FlakyTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	call := int(t.calls.Add(1))
+	if call <= len(t.errs) {
+		return nil, t.errs[call-1]
+	}
+	return map[string]any{"ok": true}, nil
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	policy, err := parseRetryPolicy(&models.RetryPolicy{MaxAttempts: 3, InitialDelay: "10ms", MaxDelay: "1s"})
+	if err != nil {
+		t.Fatalf("Failed to parse retry policy: %v", err)
+	}
+
+	if policy.backoff != models.BackoffExponential {
+		t.Errorf("Expected exponential backoff by default, got %s", policy.backoff)
+	}
+
+	// A step without a retry block is attempted once
+	policy, err = parseRetryPolicy(nil)
+	if err != nil || policy.maxAttempts != 1 {
+		t.Errorf("Expected a single attempt without a retry block, got %+v (%v)", policy, err)
+	}
+
+	invalid := map[string]*models.RetryPolicy{
+		"max_attempts must be at least 1": {MaxAttempts: 0},
+		"unknown backoff fibonacci":       {MaxAttempts: 2, Backoff: "fibonacci"},
+		"jitter must be between 0 and 1":  {MaxAttempts: 2, Jitter: 1.5},
+		"invalid initial_delay":           {MaxAttempts: 2, InitialDelay: "soon"},
+		"invalid max_delay":               {MaxAttempts: 2, MaxDelay: "10"},
+	}
+
+	for expected, p := range invalid {
+		if _, err := parseRetryPolicy(p); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		backoff  string
+		expected []time.Duration
+	}{
+		{models.BackoffExponential, []time.Duration{100, 200, 400, 500}},
+		{models.BackoffLinear, []time.Duration{100, 200, 300, 400}},
+		{models.BackoffConstant, []time.Duration{100, 100, 100, 100}},
+	}
+
+	for _, tt := range tests {
+		policy := &retryPolicy{maxAttempts: 5, initialDelay: 100 * time.Millisecond, maxDelay: 500 * time.Millisecond, backoff: tt.backoff}
+		for i, expected := range tt.expected {
+			if d := policy.delay(i + 1); d != expected*time.Millisecond {
+				t.Errorf("%s: expected delay %v after attempt %d, got %v", tt.backoff, expected*time.Millisecond, i+1, d)
+			}
+		}
+	}
+
+	// Jitter only ever shortens the delay, by at most the jitter fraction
+	policy := &retryPolicy{maxAttempts: 5, initialDelay: 100 * time.Millisecond, backoff: models.BackoffConstant, jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if d := policy.delay(1); d < 50*time.Millisecond || d > 100*time.Millisecond {
+			t.Fatalf("Expected jittered delay between 50ms and 100ms, got %v", d)
+		}
+	}
+}
+
+func TestRetryDelayOverflow(t *testing.T) {
+	// Without a max_delay, delays of late attempts grow past what a time.Duration holds
+	policy := &retryPolicy{maxAttempts: 2000, initialDelay: time.Second, backoff: models.BackoffExponential}
+
+	previous := time.Duration(0)
+	for _, attempt := range []int{30, 35, 40, 64, 100, 1100} {
+		d := policy.delay(attempt)
+		if d < previous {
+			t.Errorf("Expected delay after attempt %d to be at least %v, got %v", attempt, previous, d)
+		}
+		previous = d
+	}
+	if d := policy.delay(100); d != math.MaxInt64 {
+		t.Errorf("Expected delay to be clamped to %v, got %v", time.Duration(math.MaxInt64), d)
+	}
+
+	// With jitter the delay stays clamped too
+	policy.jitter = 0.5
+	if d := policy.delay(1100); d <= 0 {
+		t.Errorf("Expected a positive delay, got %v", d)
+	}
+
+	// Zero delays stay zero however many attempts failed
+	policy = &retryPolicy{maxAttempts: 2000, backoff: models.BackoffExponential}
+	if d := policy.delay(1100); d != 0 {
+		t.Errorf("Expected no delay, got %v", d)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	policy := &retryPolicy{maxAttempts: 3, retryOn: []string{"network"}}
+
+	if !policy.shouldRetry(1, tasks.NewError("network", errors.New("connection reset"))) {
+		t.Error("Expected network errors to be retried")
+	}
+
+	if policy.shouldRetry(3, tasks.NewError("network", errors.New("connection reset"))) {
+		t.Error("Expected no retry after the last attempt")
+	}
+
+	if policy.shouldRetry(1, errors.New("invalid card")) {
+		t.Error("Expected errors without a listed class not to be retried")
+	}
+
+	if policy.shouldRetry(1, tasks.Permanent(tasks.NewError("network", errors.New("host not allowed")))) {
+		t.Error("Expected permanent errors not to be retried")
+	}
+}
+
+func TestStepRetries(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+
+	flaky := &FlakyTask{name: "flaky", errs: []error{errors.New("timeout"), errors.New("timeout")}}
+	engine.RegisterTask(flaky)
+
+	engine.workflows["retry"] = &models.Workflow{
+		Name: "retry",
+		Steps: []models.Step{
+			{ID: "call", Task: "flaky", Retry: &models.RetryPolicy{MaxAttempts: 3, InitialDelay: "1ms", Backoff: models.BackoffLinear}},
+		},
+	}
+
+	state, err := engine.Run("retry")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	result := state.StepResults["call"]
+	if !result.Success {
+		t.Fatalf("Expected step to succeed after retries, got %+v", result)
+	}
+
+	// Every attempt is recorded with its error
+	if len(result.Attempts) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(result.Attempts))
+	}
+
+	for i, attempt := range result.Attempts {
+		if attempt.Number != i+1 {
+			t.Errorf("Expected attempt number %d, got %d", i+1, attempt.Number)
+		}
+	}
+
+	if result.Attempts[0].Error != "timeout" || result.Attempts[2].Error != "" {
+		t.Errorf("Expected failed attempts to record their error, got %+v", result.Attempts)
+	}
+
+	if result.Error != "" {
+		t.Errorf("Expected no error on the successful result, got %s", result.Error)
+	}
+}
+
+func TestStepRetriesExhausted(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+
+	declined := tasks.Permanent(errors.New("card declined"))
+	flaky := &FlakyTask{name: "pay", errs: []error{errors.New("gateway down"), declined, errors.New("never")}}
+	engine.RegisterTask(flaky)
+
+	engine.workflows["retry"] = &models.Workflow{
+		Name: "retry",
+		Steps: []models.Step{
+			{ID: "payment", Task: "pay", Retry: &models.RetryPolicy{MaxAttempts: 5, InitialDelay: "1ms"}},
+		},
+	}
+
+	state, err := engine.Run("retry")
+	if err == nil {
+		t.Fatal("Expected workflow to fail")
+	}
+
+	// The permanent error skips the remaining attempts
+	if calls := flaky.calls.Load(); calls != 2 {
+		t.Errorf("Expected 2 calls, got %d", calls)
+	}
+
+	result := state.StepResults["payment"]
+	if len(result.Attempts) != 2 || result.Error != "card declined" {
+		t.Errorf("Expected 2 attempts ending in card declined, got %+v", result)
+	}
+
+	if !errors.Is(err, declined) {
+		t.Errorf("Expected run error to wrap the task error, got %v", err)
+	}
+}
+
+func TestRetryPolicyValidation(t *testing.T) {
+	workflow := &models.Workflow{
+		Name: "invalid_retry",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Retry: &models.RetryPolicy{MaxAttempts: 3, Backoff: "random"}},
+		},
+	}
+
+	err := Validate(workflow, nil)
+	if err == nil || !strings.Contains(err.Error(), "step step1: retry: unknown backoff random") {
+		t.Errorf("Expected invalid retry policy to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package workflow
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+)
+
+// RunFilter selects runs in ListRuns. Empty fields match every run
+type RunFilter struct {
+	WorkflowName   string
+	Status         models.Status
+	ParentRunID    string // child runs started by the workflow steps of a run
+	IdempotencyKey string // runs started with the key, see StartOnce
+}
+
+// matches reports whether a run passes the filter
+func (f RunFilter) matches(state *models.WorkflowState) bool {
+	if f.WorkflowName != "" && state.WorkflowName != f.WorkflowName {
+		return false
+	}
+	if f.Status != "" && state.Status != f.Status {
+		return false
+	}
+	if f.ParentRunID != "" && state.ParentRunID != f.ParentRunID {
+		return false
+	}
+	if f.IdempotencyKey != "" && state.IdempotencyKey != f.IdempotencyKey {
+		return false
+	}
+	return true
+}
+
+// SetStateStore sets where the engine stores the state of runs. The default
+// is a store.MemoryStore, use a store.FileStore to keep runs across restarts
+func (e *Engine) SetStateStore(s store.StateStore) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stateStore = s
+}
+
+// GetRun returns a snapshot of a run by its ID. The run keeps going, so call
+// it again to see later steps. Unknown runs return an error that wraps
+// store.ErrNotFound
+func (e *Engine) GetRun(runID string) (*models.WorkflowState, error) {
+	return e.store().Load(runID)
+}
+
+// ListRuns returns snapshots of the runs that match the filter, in the order they started
+func (e *Engine) ListRuns(filter RunFilter) ([]*models.WorkflowState, error) {
+	states, err := e.store().List()
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []*models.WorkflowState
+	for _, state := range states {
+		if filter.matches(state) {
+			runs = append(runs, state)
+		}
+	}
+
+	// Run IDs start with the time the run started
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].RunID < runs[j].RunID
+	})
+	return runs, nil
+}
+
+// Workflow returns a loaded workflow by name
+func (e *Engine) Workflow(name string) (*models.Workflow, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	workflow, ok := e.workflows[name]
+	return workflow, ok
+}
+
+// Workflows returns the loaded workflows, sorted by name
+func (e *Engine) Workflows() []*models.Workflow {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	workflows := make([]*models.Workflow, 0, len(e.workflows))
+	for _, workflow := range e.workflows {
+		workflows = append(workflows, workflow)
+	}
+	sort.Slice(workflows, func(i, j int) bool {
+		return workflows[i].Name < workflows[j].Name
+	})
+	return workflows
+}
+
+// store returns the engine's state store
+func (e *Engine) store() store.StateStore {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stateStore
+}
+
+// saveState stores a snapshot of a run after one of its steps changed, so
+// readers never see the state the run is still writing to
+func (e *Engine) saveState(state *models.WorkflowState) error {
+	if err := e.store().Save(state); err != nil {
+		return fmt.Errorf("failed to save state of run %s: %w", state.RunID, err)
+	}
+	return nil
+}
+
+// lastRunTime is the time in the latest run ID, see newRunID
+var (
+	runIDMu     sync.Mutex
+	lastRunTime int64
+)
+
+// newRunID returns a unique ID for a workflow run started at a time. It
+// starts with the time in nanoseconds, so IDs sort in the order the runs
+// started, followed by random bytes so IDs from different processes do not
+// collide
+func newRunID(t time.Time) string {
+	runIDMu.Lock()
+	now := t.UnixNano()
+	if now <= lastRunTime {
+		now = lastRunTime + 1
+	}
+	lastRunTime = now
+	runIDMu.Unlock()
+
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, uint64(now))
+	if _, err := rand.Read(b[8:]); err != nil {
+		panic(fmt.Sprintf("failed to generate run id: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
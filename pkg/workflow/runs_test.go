@@ -0,0 +1,312 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+)
+
+// EchoTask returns its params as data, and keeps no state so it can run concurrently
+type EchoTask struct {
+	name string
+}
+
+func (t *EchoTask) Name() string {
+	return t.name
+}
+
+func (t *EchoTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	data := make(map[string]any, len(params))
+	for name, value := range params {
+		data[name] = value
+	}
+	return data, nil
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.RegisterTask(&EchoTask{name: "echo"})
+
+	engine.workflows["echo"] = &models.Workflow{
+		Name:    "echo",
+		Inputs:  []models.Input{{Name: "n", Type: models.InputInteger, Required: true}},
+		Outputs: map[string]string{"n": "steps.second.data.value"},
+		Steps: []models.Step{
+			{ID: "first", Task: "echo", Params: map[string]string{"value": "${{ inputs.n }}"}, Next: []string{"second"}},
+			{ID: "second", Task: "echo", Params: map[string]string{"value": "${{ steps.first.data.value }}"}},
+		},
+	}
+
+	// Run the same workflow many times at once, reading runs while they go
+	const runs = 20
+	states := make([]*models.WorkflowState, runs)
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state, err := engine.RunContext(context.Background(), "echo", map[string]any{"n": i})
+			if err != nil {
+				t.Errorf("Failed to run workflow: %v", err)
+				return
+			}
+			states[i] = state
+			if _, err := engine.ListRuns(RunFilter{WorkflowName: "echo"}); err != nil {
+				t.Errorf("Failed to list runs: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every run has its own ID and its own results
+	seen := make(map[string]bool)
+	for i, state := range states {
+		if state == nil {
+			t.FailNow()
+		}
+		if seen[state.RunID] {
+			t.Errorf("Expected unique run ids, got %s twice", state.RunID)
+		}
+		seen[state.RunID] = true
+
+		if state.Outputs["n"] != fmt.Sprint(i) {
+			t.Errorf("Expected output %d, got %v", i, state.Outputs["n"])
+		}
+
+		stored, err := engine.GetRun(state.RunID)
+		if err != nil {
+			t.Fatalf("Failed to get run %s: %v", state.RunID, err)
+		}
+		if stored.Status != "completed" || stored.Outputs["n"] != fmt.Sprint(i) {
+			t.Errorf("Expected completed run with output %d, got %s with %v", i, stored.Status, stored.Outputs["n"])
+		}
+	}
+
+	if listed, _ := engine.ListRuns(RunFilter{}); len(listed) != runs {
+		t.Errorf("Expected %d runs, got %d", runs, len(listed))
+	}
+}
+
+func TestListRuns(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.RegisterTask(&MockTask{name: "ok"})
+	engine.RegisterTask(&MockTask{name: "fail", err: fmt.Errorf("boom")})
+
+	engine.workflows["good"] = &models.Workflow{Name: "good", Steps: []models.Step{{ID: "step1", Task: "ok"}}}
+	engine.workflows["bad"] = &models.Workflow{Name: "bad", Steps: []models.Step{{ID: "step1", Task: "fail"}}}
+
+	first, _ := engine.Run("good")
+	engine.Run("bad")
+	last, _ := engine.Run("good")
+
+	good, err := engine.ListRuns(RunFilter{WorkflowName: "good"})
+	if err != nil {
+		t.Fatalf("Failed to list runs: %v", err)
+	}
+	if len(good) != 2 {
+		t.Fatalf("Expected 2 runs of good, got %d", len(good))
+	}
+
+	// Runs are listed in the order they started
+	if good[0].RunID != first.RunID || good[1].RunID != last.RunID {
+		t.Errorf("Expected runs %s and %s, got %s and %s", first.RunID, last.RunID, good[0].RunID, good[1].RunID)
+	}
+
+	failed, _ := engine.ListRuns(RunFilter{Status: "failed"})
+	if len(failed) != 1 || failed[0].WorkflowName != "bad" {
+		t.Errorf("Expected the failed run of bad, got %+v", failed)
+	}
+
+	// GetState returns the latest run of a workflow
+	latest, ok := engine.GetState("good")
+	if !ok || latest.RunID != last.RunID {
+		t.Errorf("Expected latest run %s, got %+v", last.RunID, latest)
+	}
+
+	if _, err := engine.GetRun("missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected store.ErrNotFound for an unknown run, got %v", err)
+	}
+}
+
+func TestGetRunReturnsCopy(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.RegisterTask(&MockTask{name: "ok"})
+	engine.workflows["good"] = &models.Workflow{Name: "good", Steps: []models.Step{{ID: "step1", Task: "ok"}}}
+
+	state, err := engine.Run("good")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	// Changing a returned state does not change the stored run
+	snapshot, err := engine.GetRun(state.RunID)
+	if err != nil {
+		t.Fatalf("Failed to get run: %v", err)
+	}
+	snapshot.Status = "changed"
+	delete(snapshot.StepResults, "step1")
+
+	stored, _ := engine.GetRun(state.RunID)
+	if stored.Status != "completed" {
+		t.Errorf("Expected status completed, got %s", stored.Status)
+	}
+	if _, ok := stored.StepResults["step1"]; !ok {
+		t.Error("Expected stored step result to be kept")
+	}
+}
+
+// PeekTask loads its own run from a store while it runs
+type PeekTask struct {
+	store store.StateStore
+	seen  *models.WorkflowState
+}
+
+func (t *PeekTask) Name() string {
+	return "peek"
+}
+
+func (t *PeekTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	seen, err := t.store.Load(state.RunID)
+	t.seen = seen
+	return nil, err
+}
+
+func TestFileStateStore(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := store.NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create file store: %v", err)
+	}
+
+	// Create a new engine that stores runs in files
+	engine := NewEngine()
+	engine.SetStateStore(fileStore)
+	peek := &PeekTask{store: fileStore}
+	engine.RegisterTask(&MockTask{name: "first", result: map[string]any{"records": 3}})
+	engine.RegisterTask(peek)
+
+	engine.workflows["stored"] = &models.Workflow{
+		Name: "stored",
+		Steps: []models.Step{
+			{ID: "first", Task: "first", Next: []string{"peek"}},
+			{ID: "peek", Task: "peek"},
+		},
+	}
+
+	state, err := engine.Run("stored")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	// The state was saved after the first step and when the second one started
+	if peek.seen == nil || peek.seen.CurrentStep != "peek" || len(peek.seen.CompletedSteps) != 1 {
+		t.Errorf("Expected the stored state to show the first step completed, got %+v", peek.seen)
+	}
+
+	// Another engine on the same directory sees the finished run
+	reopened, _ := store.NewFileStore(dir)
+	other := NewEngine()
+	other.SetStateStore(reopened)
+
+	stored, err := other.GetRun(state.RunID)
+	if err != nil {
+		t.Fatalf("Failed to get run: %v", err)
+	}
+	if stored.Status != "completed" || stored.StepResults["first"].Data["records"] != float64(3) {
+		t.Errorf("Expected the completed run with its step results, got %+v", stored)
+	}
+}
+
+func TestStart(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.RegisterTask(&EchoTask{name: "echo"})
+	engine.workflows["echo"] = &models.Workflow{
+		Name:   "echo",
+		Inputs: []models.Input{{Name: "n", Type: models.InputInteger, Required: true}},
+		Steps:  []models.Step{{ID: "first", Task: "echo", Params: map[string]string{"value": "${{ inputs.n }}"}}},
+	}
+
+	// The run is stored once Start returns
+	runID, err := engine.Start(context.Background(), "echo", map[string]any{"n": 1})
+	if err != nil {
+		t.Fatalf("Failed to start run: %v", err)
+	}
+	if _, err := engine.GetRun(runID); err != nil {
+		t.Fatalf("Expected the run to be stored, got %v", err)
+	}
+
+	state, err := engine.Wait(context.Background(), runID)
+	if err != nil {
+		t.Fatalf("Failed to wait for run: %v", err)
+	}
+	if state.Status != models.RunCompleted {
+		t.Errorf("Expected status completed, got %s", state.Status)
+	}
+
+	// A run that cannot start returns an error instead of a run ID
+	if runID, err := engine.Start(context.Background(), "echo", nil); err == nil || runID != "" {
+		t.Errorf("Expected an error for a missing input, got run %q", runID)
+	}
+	if _, err := engine.Start(context.Background(), "missing", nil); err == nil {
+		t.Error("Expected an error for an unknown workflow")
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	// Create a new engine whose run waits for a signal
+	engine := newApprovalEngine()
+	state, err := engine.Run("approval")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+	events, _ := engine.Events(state.RunID)
+
+	recorded, stop := engine.WatchEvents(state.RunID)
+	select {
+	case <-recorded:
+		t.Error("Expected no notification before events are recorded")
+	default:
+	}
+
+	// The signal resumes the run, and the watcher is told about its new events
+	if err := engine.Signal(context.Background(), state.RunID, "approve", map[string]any{"approved": true}); err != nil {
+		t.Fatalf("Failed to send signal: %v", err)
+	}
+	select {
+	case <-recorded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a notification")
+	}
+	if _, err := engine.Wait(context.Background(), state.RunID); err != nil {
+		t.Fatalf("Failed to wait for run: %v", err)
+	}
+	if newEvents, _ := engine.Events(state.RunID); len(newEvents) <= len(events) {
+		t.Errorf("Expected new events, got %d after %d", len(newEvents), len(events))
+	}
+
+	stop()
+	if len(engine.watchers) != 0 {
+		t.Errorf("Expected no watchers after stop, got %d", len(engine.watchers))
+	}
+}
+
+func TestWorkflows(t *testing.T) {
+	engine := NewEngine()
+	engine.workflows["b"] = &models.Workflow{Name: "b"}
+	engine.workflows["a"] = &models.Workflow{Name: "a"}
+
+	workflows := engine.Workflows()
+	if len(workflows) != 2 || workflows[0].Name != "a" || workflows[1].Name != "b" {
+		t.Errorf("Expected workflows a and b, got %v", workflows)
+	}
+}
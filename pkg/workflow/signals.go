@@ -0,0 +1,155 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// errorClassSignalTimeout is the class of the error of a wait_signal step
+// whose signal did not arrive in time
+const errorClassSignalTimeout = "signal_timeout"
+
+// errWaiting stops the execution of a run whose remaining steps all wait
+var errWaiting = errors.New("run is waiting")
+
+// signal is a signal sent to a run. The run replies whether a step took it
+type signal struct {
+	name    string
+	payload map[string]any
+	reply   chan error
+}
+
+// Signal sends a signal to a run. Every step of the run that waits for the
+// signal completes with the payload as its data, and the run goes on. A run
+// that is executing gets the signal right away, and one that stopped to wait
+// is resumed in the background: use Wait to wait until it stops again. An
+// error is returned when no step of the run waits for the signal
+func (e *Engine) Signal(ctx context.Context, runID, name string, payload map[string]any) error {
+	sig := signal{name: name, payload: payload, reply: make(chan error, 1)}
+
+	// A run that stops executing before it takes the signal may have stopped to wait
+	for {
+		run, ok := e.activeRun(runID)
+		if !ok {
+			break
+		}
+
+		select {
+		case run.signals <- sig:
+			return <-sig.reply
+		case <-run.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	state, err := e.GetRun(runID)
+	if err != nil {
+		return err
+	}
+	if state.Status != models.RunWaiting || !waitsFor(state, name) {
+		return fmt.Errorf("run %s has no step waiting for signal %s", runID, name)
+	}
+
+	// The run replies once its waiting steps took the signal, and keeps going
+	// after Signal returns
+	go func() {
+		_, err := e.resume(context.Background(), runID, &sig)
+		if err == nil {
+			err = fmt.Errorf("run %s did not take signal %s", runID, name)
+		}
+		select {
+		case sig.reply <- err:
+		default:
+		}
+	}()
+
+	select {
+	case err := <-sig.reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wait waits until a run stops executing, because it ended or stopped to
+// wait, and returns its state. The state of a run that is not executing is
+// returned right away
+func (e *Engine) Wait(ctx context.Context, runID string) (*models.WorkflowState, error) {
+	if run, ok := e.activeRun(runID); ok {
+		select {
+		case <-run.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return e.GetRun(runID)
+}
+
+// waitsFor reports whether a step of a run waits for a signal
+func waitsFor(state *models.WorkflowState, name string) bool {
+	for _, result := range state.StepResults {
+		if result.Status == models.StepWaiting && result.Signal == name {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelWaiting cancels a run that stopped to wait for a signal
+func (e *Engine) cancelWaiting(runID string) error {
+	// Keep the run from being resumed while it is cancelled
+	if err := e.activate(runID, newActiveRun(func() {})); err != nil {
+		return err
+	}
+	defer e.deactivate(runID)
+
+	state, err := e.GetRun(runID)
+	if err != nil || state.Status != models.RunWaiting {
+		return fmt.Errorf("run not found or not running: %s", runID)
+	}
+	e.stopWake(runID)
+
+	rec, err := e.continueRecorder(state)
+	if err != nil {
+		return err
+	}
+	return rec.record(models.Event{
+		Type:   models.EventRunFinished,
+		Status: models.RunCancelled,
+		Error:  fmt.Sprintf("workflow %s was cancelled while waiting", state.WorkflowName),
+	})
+}
+
+// checkWaitSignal validates the wait_signal block of a step
+func checkWaitSignal(step *models.Step, g *graph) []error {
+	var errs []error
+	w := step.WaitSignal
+
+	if step.Task != "" || step.Workflow != nil {
+		errs = append(errs, errors.New("wait_signal steps cannot have a task or workflow"))
+	}
+
+	if step.ForEach != nil || step.Loop != nil {
+		errs = append(errs, errors.New("wait_signal steps cannot have foreach or loop"))
+	}
+
+	if w.Name == "" {
+		errs = append(errs, errors.New("wait_signal: no name"))
+	}
+
+	if _, err := parseTimeout(w.Timeout); err != nil {
+		errs = append(errs, fmt.Errorf("wait_signal: timeout: %w", err))
+	}
+
+	for _, handler := range w.OnTimeout {
+		if _, ok := g.steps[handler]; !ok {
+			errs = append(errs, fmt.Errorf("on_timeout step %s does not exist", handler))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,283 @@
+package workflow
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// GateTask tells the test it has started, then blocks until the test opens its gate
+type GateTask struct {
+	name    string
+	started chan struct{}
+	gate    chan struct{}
+}
+
+func (t *GateTask) Name() string {
+	return t.name
+}
+
+func (t *GateTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	close(t.started)
+	<-t.gate
+	return map[string]any{"done": true}, nil
+}
+
+// newApprovalEngine returns an engine with a workflow that waits for an
+// approval signal, then pays or rejects depending on its payload
+func newApprovalEngine() *Engine {
+	engine := NewEngine()
+	engine.RegisterTask(&EchoTask{name: "echo"})
+
+	engine.workflows["approval"] = &models.Workflow{
+		Name: "approval",
+		Steps: []models.Step{
+			{ID: "order", Task: "echo", Next: []string{"approval"}},
+			{ID: "approval", WaitSignal: &models.WaitSignal{Name: "approve", OnTimeout: []string{"escalate"}}, Branches: []models.Branch{
+				{When: "approval.approved", Next: "pay"},
+				{Next: "reject"},
+			}},
+			{ID: "pay", Task: "echo", Params: map[string]string{"by": "${{ approval.by }}"}},
+			{ID: "reject", Task: "echo"},
+			{ID: "escalate", Task: "echo"},
+		},
+	}
+
+	return engine
+}
+
+// waitForStatus polls a run until it leaves a status
+func waitForStatus(t *testing.T, engine *Engine, runID string, status models.Status) *models.WorkflowState {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		state, err := engine.GetRun(runID)
+		if err != nil {
+			t.Fatalf("Failed to get run: %v", err)
+		}
+		if state.Status != status {
+			return state
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("Expected run %s to leave status %s", runID, status)
+	return nil
+}
+
+func TestWaitSignal(t *testing.T) {
+	// Create a new engine
+	engine := newApprovalEngine()
+
+	state, err := engine.Run("approval")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	// The run stops until the signal arrives
+	if state.Status != models.RunWaiting {
+		t.Fatalf("Expected status waiting, got %s", state.Status)
+	}
+	if result := state.StepResults["approval"]; result.Status != models.StepWaiting || result.Signal != "approve" {
+		t.Errorf("Expected approval to wait for signal approve, got %+v", result)
+	}
+	if status := state.StepResults["pay"].Status; status != models.StepPending {
+		t.Errorf("Expected pay to be pending, got %s", status)
+	}
+
+	// A new engine on the same store picks the run up, like after a restart
+	restarted := newApprovalEngine()
+	restarted.SetStateStore(engine.store())
+
+	ctx := context.Background()
+	if err := restarted.Signal(ctx, state.RunID, "approve", map[string]any{"approved": true, "by": "jane"}); err != nil {
+		t.Fatalf("Failed to send signal: %v", err)
+	}
+
+	state, err = restarted.Wait(ctx, state.RunID)
+	if err != nil {
+		t.Fatalf("Failed to wait for run: %v", err)
+	}
+
+	if state.Status != models.RunCompleted {
+		t.Fatalf("Expected status completed, got %s", state.Status)
+	}
+
+	// The payload is the step's data
+	if by := state.StepResults["pay"].Data["by"]; by != "jane" {
+		t.Errorf("Expected pay to read the payload, got %v", by)
+	}
+	if status := state.StepResults["reject"].Status; status != models.StepSkipped {
+		t.Errorf("Expected reject to be skipped, got %s", status)
+	}
+
+	events, _ := restarted.Events(state.RunID)
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	for _, expected := range []string{models.EventStepWaiting, models.EventRunWaiting, models.EventRunResumed, models.EventSignalReceived} {
+		if !slices.Contains(types, expected) {
+			t.Errorf("Expected event %s, got %v", expected, types)
+		}
+	}
+}
+
+func TestSignalErrors(t *testing.T) {
+	// Create a new engine
+	engine := newApprovalEngine()
+
+	state, err := engine.Run("approval")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := engine.Signal(ctx, state.RunID, "cancel", nil); err == nil || !strings.Contains(err.Error(), "has no step waiting for signal cancel") {
+		t.Errorf("Expected error for a signal no step waits for, got %v", err)
+	}
+
+	if err := engine.Signal(ctx, "missing", "approve", nil); err == nil {
+		t.Error("Expected error for an unknown run")
+	}
+
+	// A rejected signal leaves the run waiting
+	if state, _ := engine.GetRun(state.RunID); state.Status != models.RunWaiting {
+		t.Errorf("Expected status waiting, got %s", state.Status)
+	}
+}
+
+func TestSignalWhileRunning(t *testing.T) {
+	// Create a new engine whose run waits for the signal while another step runs
+	engine := newApprovalEngine()
+	gate := &GateTask{name: "gate", started: make(chan struct{}), gate: make(chan struct{})}
+	engine.RegisterTask(gate)
+	steps := engine.workflows["approval"].Steps
+	steps[0].Next = []string{"approval", "pack"}
+	engine.workflows["approval"].Steps = append(steps, models.Step{ID: "pack", Task: "gate"})
+
+	done := make(chan *models.WorkflowState, 1)
+	go func() {
+		state, _ := engine.Run("approval")
+		done <- state
+	}()
+	<-gate.started
+
+	runs, _ := engine.ListRuns(RunFilter{WorkflowName: "approval"})
+	if len(runs) != 1 {
+		t.Fatalf("Expected one run, got %d", len(runs))
+	}
+
+	// The executing run takes the signal right away
+	if err := engine.Signal(context.Background(), runs[0].RunID, "approve", map[string]any{"approved": false}); err != nil {
+		t.Fatalf("Failed to send signal: %v", err)
+	}
+	close(gate.gate)
+
+	state := <-done
+	if state.Status != models.RunCompleted {
+		t.Fatalf("Expected status completed, got %s", state.Status)
+	}
+	if status := state.StepResults["reject"].Status; status != models.StepSucceeded {
+		t.Errorf("Expected reject to run, got %s", status)
+	}
+}
+
+func TestWaitSignalTimeout(t *testing.T) {
+	// Create a new engine
+	engine := newApprovalEngine()
+	engine.workflows["approval"].Steps[1].WaitSignal.Timeout = "20ms"
+
+	state, err := engine.Run("approval")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	wakeAt := state.StepResults["approval"].WakeAt
+	if wakeAt.IsZero() {
+		t.Error("Expected the waiting step to record when it times out")
+	}
+
+	// The run wakes up by itself and follows on_timeout
+	state = waitForStatus(t, engine, state.RunID, models.RunWaiting)
+	state, _ = engine.Wait(context.Background(), state.RunID)
+	if state.Status != models.RunCompleted {
+		t.Fatalf("Expected status completed, got %s", state.Status)
+	}
+
+	result := state.StepResults["approval"]
+	if result.Status != models.StepTimedOut || result.ErrorClass != "signal_timeout" {
+		t.Errorf("Expected approval to time out, got %s and %s", result.Status, result.ErrorClass)
+	}
+	if status := state.StepResults["escalate"].Status; status != models.StepSucceeded {
+		t.Errorf("Expected escalate to run, got %s", status)
+	}
+
+	// Without on_timeout the timeout fails the run
+	engine.workflows["approval"].Steps[1].WaitSignal.OnTimeout = nil
+	state, _ = engine.Run("approval")
+	state = waitForStatus(t, engine, state.RunID, models.RunWaiting)
+	state, _ = engine.Wait(context.Background(), state.RunID)
+	if state.Status != models.RunFailed {
+		t.Errorf("Expected status failed, got %s", state.Status)
+	}
+}
+
+func TestCancelWaitingRun(t *testing.T) {
+	// Create a new engine
+	engine := newApprovalEngine()
+
+	state, err := engine.Run("approval")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	if err := engine.Cancel(state.RunID); err != nil {
+		t.Fatalf("Failed to cancel run: %v", err)
+	}
+
+	state, _ = engine.GetRun(state.RunID)
+	if state.Status != models.RunCancelled {
+		t.Errorf("Expected status cancelled, got %s", state.Status)
+	}
+
+	if err := engine.Signal(context.Background(), state.RunID, "approve", nil); err == nil {
+		t.Error("Expected error signalling a cancelled run")
+	}
+}
+
+func TestWaitSignalValidation(t *testing.T) {
+	registry := tasks.NewRegistry()
+	registry.Register(&MockTask{name: "task1"})
+
+	workflow := &models.Workflow{
+		Name: "signals",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", WaitSignal: &models.WaitSignal{Name: "go"}, Next: []string{"step2"}},
+			{ID: "step2", WaitSignal: &models.WaitSignal{Timeout: "soon", OnTimeout: []string{"missing"}}},
+		},
+	}
+
+	err := Validate(workflow, registry)
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	expected := []string{
+		"step step1: wait_signal steps cannot have a task or workflow",
+		"step step2: wait_signal: no name",
+		"step step2: wait_signal: timeout",
+		"step step2: on_timeout step missing does not exist",
+	}
+	for _, message := range expected {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error %q, got %v", message, err)
+		}
+	}
+}
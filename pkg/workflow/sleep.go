@@ -0,0 +1,199 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/clock"
+	"github.com/mstgnz/goflow/pkg/expr"
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+)
+
+// SetClock sets the clock the engine reads the time from and waits with,
+// e.g. a clock.Fake in tests
+func (e *Engine) SetClock(c clock.Clock) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.clock = c
+}
+
+// Clock returns the clock the engine reads the time from
+func (e *Engine) Clock() clock.Clock {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.clock
+}
+
+// now returns the time on the engine's clock
+func (e *Engine) now() time.Time {
+	return e.Clock().Now()
+}
+
+// isSleep reports whether a step waits until a point in time instead of running a task
+func isSleep(step *models.Step) bool {
+	return step.Sleep != "" || step.WaitUntil != ""
+}
+
+// waits reports whether a step waits instead of running a task
+func waits(step *models.Step) bool {
+	return step.WaitSignal != nil || isSleep(step)
+}
+
+// wakeTime returns when a sleep or wait_until step stops waiting, with the
+// placeholders in its duration or timestamp resolved against the run
+func (e *Engine) wakeTime(step *models.Step, state *models.WorkflowState) (time.Time, error) {
+	newVars := func() map[string]any { return e.expressionVars(state) }
+
+	if step.WaitUntil != "" {
+		value, err := e.resolveTemplate(step.WaitUntil, newVars)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("wait_until: %w", err)
+		}
+		return parseWakeTime(value)
+	}
+
+	value, err := e.resolveTemplate(step.Sleep, newVars)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("sleep: %w", err)
+	}
+	d, err := parseSleep(value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return e.now().Add(d), nil
+}
+
+// parseSleep parses the duration of a sleep step
+func parseSleep(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("sleep: %w", err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("sleep: must not be negative, got %s", value)
+	}
+	return d, nil
+}
+
+// parseWakeTime parses the timestamp of a wait_until step
+func parseWakeTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("wait_until: %q is not an RFC 3339 timestamp", value)
+	}
+	return t, nil
+}
+
+// nextWake returns when the first wait of a run ends, or the zero time
+func nextWake(state *models.WorkflowState) time.Time {
+	var next time.Time
+	for _, result := range state.StepResults {
+		if result.Status == models.StepWaiting && !result.WakeAt.IsZero() && (next.IsZero() || result.WakeAt.Before(next)) {
+			next = result.WakeAt
+		}
+	}
+	return next
+}
+
+// RestoreWakeUps schedules the wake-ups of the stored runs that wait for a
+// point in time, e.g. after the process restarted. A run whose wake-up time
+// passed while nothing ran it is resumed right away. The workflows of the
+// runs must be loaded. It returns the number of wake-ups it scheduled
+func (e *Engine) RestoreWakeUps() (int, error) {
+	runs, err := e.ListRuns(RunFilter{Status: models.RunWaiting})
+	if err != nil {
+		return 0, err
+	}
+
+	scheduled := 0
+	for _, state := range runs {
+		if wakeAt := nextWake(state); !wakeAt.IsZero() {
+			e.scheduleWake(state.RunID, wakeAt)
+			scheduled++
+		}
+	}
+	return scheduled, nil
+}
+
+// wakeRetry is how long a wake-up waits for another process to stop
+// executing the run before it tries again
+const wakeRetry = time.Minute
+
+// scheduleWake resumes a waiting run at the given time, so its wait ends
+func (e *Engine) scheduleWake(runID string, at time.Time) {
+	c := e.Clock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if timer, ok := e.wakeTimers[runID]; ok {
+		timer.Stop()
+	}
+	e.wakeTimers[runID] = c.AfterFunc(at.Sub(c.Now()), func() {
+		e.mu.Lock()
+		delete(e.wakeTimers, runID)
+		e.mu.Unlock()
+
+		// The run's state records how the wake-up went. A run that another
+		// process is executing is tried again later, as that process may
+		// exit before the run's next wake-up
+		if _, err := e.Resume(context.Background(), runID); errors.Is(err, store.ErrLocked) {
+			e.scheduleWake(runID, c.Now().Add(wakeRetry))
+		}
+	})
+}
+
+// stopWake stops the wake-up of a run that is executing again
+func (e *Engine) stopWake(runID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if timer, ok := e.wakeTimers[runID]; ok {
+		timer.Stop()
+		delete(e.wakeTimers, runID)
+	}
+}
+
+// checkSleep validates the sleep or wait_until of a step. A duration or
+// timestamp without placeholders is parsed right away
+func checkSleep(step *models.Step, scope expr.Scope) []error {
+	var errs []error
+
+	kind, value := "sleep", step.Sleep
+	if step.WaitUntil != "" {
+		kind, value = "wait_until", step.WaitUntil
+	}
+
+	if step.Sleep != "" && step.WaitUntil != "" {
+		errs = append(errs, errors.New("sleep and wait_until cannot be combined"))
+	}
+
+	if step.Task != "" || step.Workflow != nil || step.WaitSignal != nil {
+		errs = append(errs, fmt.Errorf("%s steps cannot have a task, workflow or wait_signal", kind))
+	}
+
+	if step.ForEach != nil || step.Loop != nil {
+		errs = append(errs, fmt.Errorf("%s steps cannot have foreach or loop", kind))
+	}
+
+	switch {
+	case strings.Contains(value, templateOpen):
+		if err := checkTemplate(value, scope); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", kind, err))
+		}
+	case kind == "sleep":
+		if _, err := parseSleep(value); err != nil {
+			errs = append(errs, err)
+		}
+	default:
+		if _, err := parseWakeTime(value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
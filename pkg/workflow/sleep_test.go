@@ -0,0 +1,175 @@
+package workflow
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/clock"
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// newFollowUpEngine returns an engine on a fake clock with a workflow that
+// ships an order, waits, then sends a follow-up
+func newFollowUpEngine(wait models.Step) (*Engine, *clock.Fake) {
+	engine := NewEngine()
+	engine.RegisterTask(&EchoTask{name: "echo"})
+
+	fake := clock.NewFake(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+	engine.SetClock(fake)
+
+	wait.ID = "wait"
+	wait.Next = []string{"follow_up"}
+	engine.workflows["follow_up"] = &models.Workflow{
+		Name:   "follow_up",
+		Inputs: []models.Input{{Name: "at", Type: models.InputString}},
+		Steps: []models.Step{
+			{ID: "ship", Task: "echo", Next: []string{"wait"}, Params: map[string]string{"delay": "72h"}},
+			wait,
+			{ID: "follow_up", Task: "echo"},
+		},
+	}
+
+	return engine, fake
+}
+
+func TestSleep(t *testing.T) {
+	// Create a new engine whose sleep reads its duration from an earlier step
+	engine, fake := newFollowUpEngine(models.Step{Sleep: "${{ ship.delay }}"})
+	start := fake.Now()
+
+	state, err := engine.Run("follow_up")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	// The run stops and stores when it wakes up
+	if state.Status != models.RunWaiting {
+		t.Fatalf("Expected status waiting, got %s", state.Status)
+	}
+	if wakeAt := state.StepResults["wait"].WakeAt; !wakeAt.Equal(start.Add(72 * time.Hour)) {
+		t.Errorf("Expected wake-up in 72h, got %s", wakeAt)
+	}
+	if fake.Timers() != 1 {
+		t.Fatalf("Expected a wake-up timer, got %d timers", fake.Timers())
+	}
+
+	// Nothing happens before the wake-up time
+	fake.Advance(71 * time.Hour)
+	if state, _ := engine.GetRun(state.RunID); state.Status != models.RunWaiting {
+		t.Errorf("Expected status waiting, got %s", state.Status)
+	}
+
+	fake.Advance(time.Hour)
+	state = waitForStatus(t, engine, state.RunID, models.RunWaiting)
+	state, _ = engine.Wait(context.Background(), state.RunID)
+	if state.Status != models.RunCompleted {
+		t.Fatalf("Expected status completed, got %s", state.Status)
+	}
+	if status := state.StepResults["wait"].Status; status != models.StepSucceeded {
+		t.Errorf("Expected wait to succeed, got %s", status)
+	}
+	if status := state.StepResults["follow_up"].Status; status != models.StepSucceeded {
+		t.Errorf("Expected follow_up to run, got %s", status)
+	}
+}
+
+func TestWaitUntilAfterRestart(t *testing.T) {
+	// Create a new engine whose wait ends at a time given as input
+	engine, fake := newFollowUpEngine(models.Step{WaitUntil: "${{ inputs.at }}"})
+	wakeAt := fake.Now().Add(2 * time.Hour)
+
+	state, err := engine.RunContext(context.Background(), "follow_up", map[string]any{"at": wakeAt.Format(time.RFC3339)})
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+	if state.Status != models.RunWaiting {
+		t.Fatalf("Expected status waiting, got %s", state.Status)
+	}
+
+	// A new engine on the same store restores the wake-up, like after a restart
+	restarted, _ := newFollowUpEngine(models.Step{WaitUntil: "${{ inputs.at }}"})
+	restarted.SetStateStore(engine.store())
+	restartedClock := clock.NewFake(fake.Now().Add(time.Hour))
+	restarted.SetClock(restartedClock)
+
+	scheduled, err := restarted.RestoreWakeUps()
+	if err != nil {
+		t.Fatalf("Failed to restore wake-ups: %v", err)
+	}
+	if scheduled != 1 || restartedClock.Timers() != 1 {
+		t.Fatalf("Expected one wake-up, got %d and %d timers", scheduled, restartedClock.Timers())
+	}
+
+	restartedClock.Advance(time.Hour)
+	state = waitForStatus(t, restarted, state.RunID, models.RunWaiting)
+	state, _ = restarted.Wait(context.Background(), state.RunID)
+	if state.Status != models.RunCompleted {
+		t.Fatalf("Expected status completed, got %s", state.Status)
+	}
+}
+
+func TestWaitUntilPast(t *testing.T) {
+	// Create a new engine whose wait ends before it starts
+	engine, fake := newFollowUpEngine(models.Step{WaitUntil: "${{ inputs.at }}"})
+	at := fake.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	state, err := engine.RunContext(context.Background(), "follow_up", map[string]any{"at": at})
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	if state.Status != models.RunCompleted {
+		t.Errorf("Expected status completed, got %s", state.Status)
+	}
+}
+
+func TestSleepInvalidDuration(t *testing.T) {
+	// Create a new engine whose sleep resolves to something that is not a duration
+	engine, _ := newFollowUpEngine(models.Step{Sleep: "${{ ship.task }}"})
+	engine.workflows["follow_up"].Steps[0].Params = map[string]string{"task": "soon"}
+
+	_, err := engine.Run("follow_up")
+	if err == nil || !strings.Contains(err.Error(), "failed to compute wake-up time of step wait: sleep") {
+		t.Errorf("Expected wake-up time error, got %v", err)
+	}
+}
+
+func TestSleepValidation(t *testing.T) {
+	registry := tasks.NewRegistry()
+	registry.Register(&MockTask{name: "task1"})
+
+	workflow := &models.Workflow{
+		Name: "sleeps",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Sleep: "1h", Next: []string{"step2"}},
+			{ID: "step2", Sleep: "-1h", Next: []string{"step3"}},
+			{ID: "step3", WaitUntil: "tomorrow", Next: []string{"step4"}},
+			{ID: "step4", WaitUntil: "${{ nope.at }}", Next: []string{"step5"}},
+			{ID: "step5", Sleep: "${{ step1.delay }}"},
+		},
+	}
+
+	err := Validate(workflow, registry)
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	expected := []string{
+		"step step1: sleep steps cannot have a task, workflow or wait_signal",
+		"step step2: sleep: must not be negative, got -1h",
+		`step step3: wait_until: "tomorrow" is not an RFC 3339 timestamp`,
+		"step step4: wait_until: ${{ nope.at }}: column 1: unknown variable nope",
+	}
+	for _, message := range expected {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error %q, got %v", message, err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "step step5") {
+		t.Errorf("Expected a sleep with placeholders to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mstgnz/goflow/pkg/expr"
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/store"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// DefaultMaxDepth is how many levels of child runs workflow steps can start
+const DefaultMaxDepth = 10
+
+// errorClassMaxDepth is the class of the error of a workflow step whose
+// child run would nest deeper than the engine allows
+const errorClassMaxDepth = "max_depth"
+
+// SetMaxDepth sets how many levels of child runs workflow steps can start,
+// which stops a workflow that runs itself. Zero allows no child runs
+func (e *Engine) SetMaxDepth(depth int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.maxDepth = depth
+}
+
+// depthLimit returns how many levels of child runs can be started
+func (e *Engine) depthLimit() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.maxDepth
+}
+
+// stepTask returns the task that runs a step: a registered task, or the
+// child run of a workflow step
+func (e *Engine) stepTask(step *models.Step, emit func(models.Event)) (tasks.Task, error) {
+	if step.Workflow != nil {
+		return &childRunTask{engine: e, step: step, emit: emit}, nil
+	}
+
+	task, ok := e.taskRegistry.Get(step.Task)
+	if !ok {
+		return nil, fmt.Errorf("task not found: %s", step.Task)
+	}
+	return task, nil
+}
+
+// childRunTask runs the workflow of a workflow step as a child run, so the
+// step gets the retries and timeout of any other step
+type childRunTask struct {
+	engine *Engine
+	step   *models.Step
+	emit   func(models.Event)
+}
+
+func (t *childRunTask) Name() string {
+	return t.step.Workflow.Name
+}
+
+// Execute starts a child run with the inputs mapped from the parent run's
+// state and returns the child's outputs. The child runs with the step's
+// context, so cancelling the parent run cancels it too. A child run that
+// fails fails the step with its error, and so does one that stops to wait,
+// see childWaiting
+func (t *childRunTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	sub := t.step.Workflow
+
+	if limit := t.engine.depthLimit(); state.Depth >= limit {
+		return nil, tasks.Permanent(tasks.NewError(errorClassMaxDepth, fmt.Errorf("workflow %s would nest child runs more than %d levels deep", sub.Name, limit)))
+	}
+
+	if _, ok := t.engine.Workflow(sub.Name); !ok {
+		return nil, tasks.Permanent(fmt.Errorf("workflow not found: %s", sub.Name))
+	}
+
+	inputs, err := t.engine.evaluateAll("input", sub.Inputs, state)
+	if err != nil {
+		return nil, tasks.Permanent(err)
+	}
+
+	// Link the child to the step before it starts, so a resumed parent finds it
+	runID := newRunID(t.engine.now())
+	t.emit(models.Event{Type: models.EventChildRunStarted, StepID: t.step.ID, ChildRunID: runID})
+
+	child, err := t.engine.startRun(ctx, sub.Name, inputs, runID, "", state)
+	if err != nil {
+		err = fmt.Errorf("child run %s: %w", runID, err)
+		// A child run that was cancelled on its own is not retried
+		if child != nil && child.Status == models.RunCancelled && ctx.Err() == nil {
+			err = tasks.Permanent(err)
+		}
+		return nil, err
+	}
+	if child.Status == models.RunWaiting {
+		return nil, t.engine.childWaiting(runID)
+	}
+
+	return child.Outputs, nil
+}
+
+// childWaiting returns the error of a workflow step whose child run stopped
+// to wait, e.g. for a signal or a sleep. The step cannot wait for its child,
+// so the child is cancelled rather than left to go on after the parent.
+// Loading rejects such workflows, see checkChildWaits, so this only happens
+// to runs of workflows that were replaced while they ran
+func (e *Engine) childWaiting(childID string) error {
+	_ = e.Cancel(childID)
+	return tasks.Permanent(fmt.Errorf("child run %s is waiting, which workflow steps do not support", childID))
+}
+
+// checkChildWaits reports a workflow step whose child run could stop to
+// wait, because the workflow it runs has a step that waits. Workflow steps
+// cannot wait for their child, see childWaiting. Loaded workflows are
+// checked with the given one in place of the one with its name. The lock
+// must be held
+func (e *Engine) checkChildWaits(workflow *models.Workflow) error {
+	workflows := make(map[string]*models.Workflow, len(e.workflows)+1)
+	for name, loaded := range e.workflows {
+		workflows[name] = loaded
+	}
+	workflows[workflow.Name] = workflow
+
+	for _, name := range sortedKeys(workflows) {
+		for _, step := range workflows[name].Steps {
+			if step.Workflow == nil || workflows[step.Workflow.Name] == nil {
+				continue
+			}
+			for _, child := range workflows[step.Workflow.Name].Steps {
+				if waits(&child) {
+					return fmt.Errorf("step %s of workflow %s runs workflow %s, whose step %s waits, which workflow steps do not support", step.ID, name, step.Workflow.Name, child.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkSubWorkflow validates the workflow block of a step. The workflow it
+// runs may be loaded later, so it is only looked up when the step runs
+func checkSubWorkflow(step *models.Step, scope expr.Scope) []error {
+	var errs []error
+	sub := step.Workflow
+
+	if sub.Name == "" {
+		errs = append(errs, errors.New("workflow: no name"))
+	}
+
+	if step.ForEach != nil || step.Loop != nil {
+		errs = append(errs, errors.New("workflow steps cannot have foreach or loop"))
+	}
+
+	if len(step.Params) > 0 {
+		errs = append(errs, errors.New("workflow steps take inputs, not params"))
+	}
+
+	for _, name := range sortedKeys(sub.Inputs) {
+		if _, err := expr.Compile(sub.Inputs[name], scope); err != nil {
+			errs = append(errs, fmt.Errorf("workflow input %s: %w", name, err))
+		}
+	}
+
+	return errs
+}
+
+// recoverChild recovers a workflow step that was interrupted. A child run
+// that completed gives the step its outputs, and one that did not is
+// resumed. A step whose child run never started runs again
+func (e *Engine) recoverChild(ctx context.Context, step *models.Step, params map[string]string, state *models.WorkflowState, emit func(models.Event)) (models.Status, error) {
+	childID := state.StepResults[step.ID].ChildRunID
+	if childID == "" {
+		return e.executeStep(ctx, step, params, state, emit)
+	}
+
+	child, err := e.GetRun(childID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return e.executeStep(ctx, step, params, state, emit)
+	case err != nil:
+		return models.StepFailed, fmt.Errorf("failed to load child run %s: %w", childID, err)
+	}
+
+	if child.Status != models.RunCompleted {
+		if child, err = e.Resume(ctx, childID); err != nil {
+			status := models.StepFailed
+			if ctx.Err() != nil {
+				status = stoppedStatus(ctx)
+			}
+			return status, fmt.Errorf("failed to resume child run %s: %w", childID, err)
+		}
+		if child.Status == models.RunWaiting {
+			return models.StepFailed, e.childWaiting(childID)
+		}
+	}
+
+	emit(models.Event{Type: models.EventStepReconciled, StepID: step.ID, Data: child.Outputs})
+	return models.StepSucceeded, nil
+}
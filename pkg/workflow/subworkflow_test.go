@@ -0,0 +1,268 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// newSubWorkflowEngine returns an engine with a notify workflow, and an order
+// workflow that runs it from a workflow step
+func newSubWorkflowEngine() *Engine {
+	engine := NewEngine()
+	engine.RegisterTask(&EchoTask{name: "echo"})
+
+	engine.workflows["notify"] = &models.Workflow{
+		Name:   "notify",
+		Inputs: []models.Input{{Name: "email", Type: models.InputString, Required: true}},
+		Steps: []models.Step{
+			{ID: "send", Task: "echo", Params: map[string]string{"to": "${{ inputs.email }}"}},
+		},
+		Outputs: map[string]string{"sent_to": "send.to"},
+	}
+
+	engine.workflows["order"] = &models.Workflow{
+		Name: "order",
+		Steps: []models.Step{
+			{ID: "customer", Task: "echo", Next: []string{"notify"}, Params: map[string]string{"email": "jane@example.com"}},
+			{ID: "notify", Workflow: &models.SubWorkflow{Name: "notify", Inputs: map[string]string{"email": "customer.email"}}, Next: []string{"audit"}},
+			{ID: "audit", Task: "echo", Params: map[string]string{"notified": "${{ notify.sent_to }}"}},
+		},
+	}
+
+	return engine
+}
+
+func TestSubWorkflow(t *testing.T) {
+	// Create a new engine
+	engine := newSubWorkflowEngine()
+
+	state, err := engine.Run("order")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	// The child's outputs are the step's data
+	result := state.StepResults["notify"]
+	if result.Data["sent_to"] != "jane@example.com" {
+		t.Errorf("Expected sent_to jane@example.com, got %v", result.Data)
+	}
+	if notified := state.StepResults["audit"].Data["notified"]; notified != "jane@example.com" {
+		t.Errorf("Expected the next step to read the child's outputs, got %v", notified)
+	}
+
+	// The runs are linked both ways
+	child, err := engine.GetRun(result.ChildRunID)
+	if err != nil {
+		t.Fatalf("Failed to get child run: %v", err)
+	}
+	if child.ParentRunID != state.RunID || child.Depth != 1 || child.Status != models.RunCompleted {
+		t.Errorf("Expected a completed child run of %s at depth 1, got %+v", state.RunID, child)
+	}
+
+	children, _ := engine.ListRuns(RunFilter{ParentRunID: state.RunID})
+	if len(children) != 1 || children[0].RunID != child.RunID {
+		t.Errorf("Expected the child run to be listed under its parent, got %d runs", len(children))
+	}
+}
+
+func TestSubWorkflowFailure(t *testing.T) {
+	// Create a new engine
+	engine := newSubWorkflowEngine()
+	engine.RegisterTask(&MockTask{name: "fail", err: tasks.NewError("smtp_down", errors.New("mail server unavailable"))})
+	engine.workflows["notify"].Steps[0].Task = "fail"
+
+	state, err := engine.Run("order")
+	if err == nil || !strings.Contains(err.Error(), "mail server unavailable") {
+		t.Fatalf("Expected the child's error, got %v", err)
+	}
+
+	// The failure keeps the class of the child's error, so it can be routed
+	result := state.StepResults["notify"]
+	if result.Status != models.StepFailed || result.ErrorClass != "smtp_down" {
+		t.Errorf("Expected the step to fail with class smtp_down, got %s and %s", result.Status, result.ErrorClass)
+	}
+
+	if child, _ := engine.GetRun(result.ChildRunID); child == nil || child.Status != models.RunFailed {
+		t.Errorf("Expected the child run to fail, got %+v", child)
+	}
+}
+
+func TestSubWorkflowCancelAndResume(t *testing.T) {
+	// Create a new engine whose child run blocks
+	engine := newSubWorkflowEngine()
+	task := &BlockingTask{name: "block", started: make(chan struct{})}
+	engine.RegisterTask(task)
+	engine.workflows["notify"].Steps[0].Task = "block"
+	engine.workflows["notify"].Steps[0].Idempotent = true
+
+	done := make(chan *models.WorkflowState, 1)
+	go func() {
+		state, _ := engine.Run("order")
+		done <- state
+	}()
+	<-task.started
+
+	// Cancelling the parent cancels the child
+	runs, _ := engine.ListRuns(RunFilter{WorkflowName: "order"})
+	if len(runs) != 1 {
+		t.Fatalf("Expected one order run, got %d", len(runs))
+	}
+	if err := engine.Cancel(runs[0].RunID); err != nil {
+		t.Fatalf("Failed to cancel run: %v", err)
+	}
+
+	state := <-done
+	if state.Status != models.RunCancelled {
+		t.Errorf("Expected status cancelled, got %s", state.Status)
+	}
+
+	childID := state.StepResults["notify"].ChildRunID
+	if child, _ := engine.GetRun(childID); child == nil || child.Status != models.RunCancelled {
+		t.Errorf("Expected the child run to be cancelled, got %+v", child)
+	}
+
+	// Resuming the parent resumes the same child run rather than starting another
+	engine.RegisterTask(&EchoTask{name: "block"})
+	state, err := engine.Resume(context.Background(), state.RunID)
+	if err != nil {
+		t.Fatalf("Failed to resume run: %v", err)
+	}
+
+	if state.Status != models.RunCompleted || state.StepResults["notify"].Data["sent_to"] != "jane@example.com" {
+		t.Errorf("Expected the resumed run to complete with the child's outputs, got %s and %v", state.Status, state.StepResults["notify"].Data)
+	}
+
+	children, _ := engine.ListRuns(RunFilter{ParentRunID: state.RunID})
+	if len(children) != 1 || children[0].RunID != childID {
+		t.Errorf("Expected only the original child run, got %d runs", len(children))
+	}
+}
+
+func TestSubWorkflowWaiting(t *testing.T) {
+	// Create a new engine whose child run waits for a signal
+	engine := newSubWorkflowEngine()
+	engine.workflows["notify"].Steps = []models.Step{
+		{ID: "approval", WaitSignal: &models.WaitSignal{Name: "approve"}, Next: []string{"send"}},
+		{ID: "send", Task: "echo", Params: map[string]string{"to": "${{ inputs.email }}"}},
+	}
+
+	state, err := engine.Run("order")
+	if err == nil || !strings.Contains(err.Error(), "is waiting, which workflow steps do not support") {
+		t.Fatalf("Expected the step to fail on the waiting child run, got %v", err)
+	}
+
+	// The parent does not go on without the child's outputs
+	if state.Status != models.RunFailed {
+		t.Errorf("Expected status failed, got %s", state.Status)
+	}
+	if status := state.StepResults["notify"].Status; status != models.StepFailed {
+		t.Errorf("Expected the workflow step to fail, got %s", status)
+	}
+	if status := state.StepResults["audit"].Status; status != models.StepPending {
+		t.Errorf("Expected the next step not to run, got %s", status)
+	}
+
+	// The child is cancelled rather than left waiting
+	child, err := engine.GetRun(state.StepResults["notify"].ChildRunID)
+	if err != nil {
+		t.Fatalf("Failed to get child run: %v", err)
+	}
+	if child.Status != models.RunCancelled {
+		t.Errorf("Expected the child run to be cancelled, got %s", child.Status)
+	}
+}
+
+func TestSubWorkflowWaitingRejected(t *testing.T) {
+	approval := &models.Workflow{
+		Name:  "approval",
+		Steps: []models.Step{{ID: "approve", WaitSignal: &models.WaitSignal{Name: "approve"}}},
+	}
+	review := &models.Workflow{
+		Name:  "review",
+		Steps: []models.Step{{ID: "approval", Workflow: &models.SubWorkflow{Name: "approval"}}},
+	}
+
+	// A workflow step that runs a waiting workflow is rejected in whichever
+	// order the workflows are loaded
+	for _, order := range [][]*models.Workflow{{approval, review}, {review, approval}} {
+		engine := newSubWorkflowEngine()
+		if err := engine.LoadWorkflow(order[0]); err != nil {
+			t.Fatalf("Failed to load workflow: %v", err)
+		}
+		err := engine.LoadWorkflow(order[1])
+		if err == nil || !strings.Contains(err.Error(), "step approval of workflow review runs workflow approval, whose step approve waits, which workflow steps do not support") {
+			t.Errorf("Expected workflow %s to be rejected, got %v", order[1].Name, err)
+		}
+	}
+
+	// A waiting workflow that no workflow step runs is loaded
+	sleeper := &models.Workflow{
+		Name:  "approval",
+		Steps: []models.Step{{ID: "pause", Sleep: "1h"}},
+	}
+	if err := newSubWorkflowEngine().LoadWorkflow(sleeper); err != nil {
+		t.Errorf("Failed to load workflow: %v", err)
+	}
+
+	// Replacing a workflow that workflow steps run with one that waits is rejected
+	sleeper.Name = "notify"
+	if err := newSubWorkflowEngine().LoadWorkflow(sleeper); err == nil || !strings.Contains(err.Error(), "whose step pause waits") {
+		t.Errorf("Expected the waiting notify workflow to be rejected, got %v", err)
+	}
+}
+
+func TestSubWorkflowDepth(t *testing.T) {
+	// Create a new engine with a workflow that runs itself
+	engine := NewEngine()
+	engine.SetMaxDepth(3)
+	engine.workflows["recursive"] = &models.Workflow{
+		Name:  "recursive",
+		Steps: []models.Step{{ID: "again", Workflow: &models.SubWorkflow{Name: "recursive"}}},
+	}
+
+	_, err := engine.Run("recursive")
+	if err == nil || !strings.Contains(err.Error(), "workflow recursive would nest child runs more than 3 levels deep") {
+		t.Fatalf("Expected depth error, got %v", err)
+	}
+
+	runs, _ := engine.ListRuns(RunFilter{WorkflowName: "recursive"})
+	if len(runs) != 4 {
+		t.Errorf("Expected 4 runs, got %d", len(runs))
+	}
+}
+
+func TestSubWorkflowValidation(t *testing.T) {
+	registry := tasks.NewRegistry()
+	registry.Register(&MockTask{name: "task1"})
+
+	workflow := &models.Workflow{
+		Name: "parent",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Workflow: &models.SubWorkflow{Name: "child"}, Next: []string{"step2"}},
+			{ID: "step2", Workflow: &models.SubWorkflow{Inputs: map[string]string{"id": "nope.id"}},
+				Params: map[string]string{"id": "1"}},
+		},
+	}
+
+	err := Validate(workflow, registry)
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	expected := []string{
+		"step step1: task and workflow cannot be combined",
+		"step step2: workflow: no name",
+		"step step2: workflow steps take inputs, not params",
+		"step step2: workflow input id: column 1: unknown variable nope",
+	}
+	for _, message := range expected {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error %q, got %v", message, err)
+		}
+	}
+}
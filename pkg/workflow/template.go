@@ -0,0 +1,210 @@
+package workflow
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mstgnz/goflow/pkg/expr"
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+const (
+	templateOpen  = "${{"
+	templateClose = "}}"
+)
+
+// templatePart is a piece of a parameter template: either literal text or an expression
+type templatePart struct {
+	text string
+	expr string
+}
+
+// parseTemplate splits a parameter value into literal text and ${{ expression }} placeholders
+func parseTemplate(value string) ([]templatePart, error) {
+	var parts []templatePart
+
+	for {
+		start := strings.Index(value, templateOpen)
+		if start < 0 {
+			if value != "" {
+				parts = append(parts, templatePart{text: value})
+			}
+			return parts, nil
+		}
+
+		if start > 0 {
+			parts = append(parts, templatePart{text: value[:start]})
+		}
+
+		rest := value[start+len(templateOpen):]
+		end := strings.Index(rest, templateClose)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated %s in %q", templateOpen, value)
+		}
+
+		source := strings.TrimSpace(rest[:end])
+		if source == "" {
+			return nil, fmt.Errorf("empty %s %s in %q", templateOpen, templateClose, value)
+		}
+
+		parts = append(parts, templatePart{expr: source})
+		value = rest[end+len(templateClose):]
+	}
+}
+
+// checkTemplate compiles the placeholders of a parameter template against a scope
+func checkTemplate(value string, scope expr.Scope) error {
+	parts, err := parseTemplate(value)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		if part.expr == "" {
+			continue
+		}
+		if _, err := expr.Compile(part.expr, scope); err != nil {
+			return fmt.Errorf("%s %s %s: %w", templateOpen, part.expr, templateClose, err)
+		}
+	}
+	return nil
+}
+
+// resolveParams replaces the placeholders in a step's parameters with values
+// from earlier steps, workflow inputs and the environment. A placeholder that
+// resolves to null is an error
+func (e *Engine) resolveParams(params map[string]string, state *models.WorkflowState) (map[string]string, error) {
+	return e.resolveParamsWith(params, func() map[string]any { return e.expressionVars(state) })
+}
+
+// resolveParamsWith replaces the placeholders in a step's parameters with
+// values from the given variables, which are only built if a param needs them
+func (e *Engine) resolveParamsWith(params map[string]string, newVars func() map[string]any) (map[string]string, error) {
+	if len(params) == 0 {
+		return params, nil
+	}
+
+	var vars map[string]any
+	resolved := make(map[string]string, len(params))
+
+	// Resolve in a stable order so the first error is always the same
+	for _, name := range sortedKeys(params) {
+		value, err := e.resolveTemplate(params[name], func() map[string]any {
+			if vars == nil {
+				vars = newVars()
+			}
+			return vars
+		})
+		if err != nil {
+			return nil, fmt.Errorf("param %s: %w", name, err)
+		}
+		resolved[name] = value
+	}
+
+	return resolved, nil
+}
+
+// recordedParams returns resolved params as they are recorded in a run's
+// events and state. Params whose template reads env keep their template, so
+// secrets such as API tokens stay out of stored runs and API responses
+func (e *Engine) recordedParams(templates, params map[string]string) map[string]string {
+	if params == nil {
+		return nil
+	}
+
+	recorded := make(map[string]string, len(params))
+	for name, value := range params {
+		if e.readsEnv(templates[name]) {
+			value = templates[name]
+		}
+		recorded[name] = value
+	}
+	return recorded
+}
+
+// restoreParams resolves the recorded params that kept their template again,
+// see recordedParams
+func (e *Engine) restoreParams(templates, recorded map[string]string, state *models.WorkflowState) (map[string]string, error) {
+	envParams := make(map[string]string)
+	for name := range recorded {
+		if e.readsEnv(templates[name]) {
+			envParams[name] = templates[name]
+		}
+	}
+	if len(envParams) == 0 {
+		return recorded, nil
+	}
+
+	resolved, err := e.resolveParams(envParams, state)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := maps.Clone(recorded)
+	maps.Copy(restored, resolved)
+	return restored, nil
+}
+
+// readsEnv reports whether a template has a placeholder that reads env
+func (e *Engine) readsEnv(template string) bool {
+	if !strings.Contains(template, templateOpen) {
+		return false
+	}
+
+	parts, err := parseTemplate(template)
+	if err != nil {
+		return false
+	}
+
+	for _, part := range parts {
+		if part.expr == "" {
+			continue
+		}
+		if program, err := e.program(part.expr); err == nil && slices.Contains(program.Vars(), envVar) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveTemplate replaces the placeholders in a template with values from
+// the given variables, which are only built if there is a placeholder
+func (e *Engine) resolveTemplate(value string, newVars func() map[string]any) (string, error) {
+	if !strings.Contains(value, templateOpen) {
+		return value, nil
+	}
+
+	parts, err := parseTemplate(value)
+	if err != nil {
+		return "", err
+	}
+
+	vars := newVars()
+
+	var b strings.Builder
+	for _, part := range parts {
+		if part.expr == "" {
+			b.WriteString(part.text)
+			continue
+		}
+
+		program, err := e.program(part.expr)
+		if err != nil {
+			return "", err
+		}
+
+		v, err := e.eval(program, vars)
+		if err != nil {
+			return "", fmt.Errorf("%s %s %s: %w", templateOpen, part.expr, templateClose, err)
+		}
+		if v == nil {
+			return "", fmt.Errorf("%s %s %s could not be resolved", templateOpen, part.expr, templateClose)
+		}
+
+		b.WriteString(expr.ToString(v))
+	}
+
+	return b.String(), nil
+}
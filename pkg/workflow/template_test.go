@@ -0,0 +1,266 @@
+package workflow
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/goflow/pkg/expr"
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+func TestParseTemplate(t *testing.T) {
+	parts, err := parseTemplate("order ${{ inputs.order_id }} has ${{steps.process.data.records}} records")
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+
+	expected := []templatePart{
+		{text: "order "},
+		{expr: "inputs.order_id"},
+		{text: " has "},
+		{expr: "steps.process.data.records"},
+		{text: " records"},
+	}
+
+	if len(parts) != len(expected) {
+		t.Fatalf("Expected %d parts, got %v", len(expected), parts)
+	}
+
+	for i := range expected {
+		if parts[i] != expected[i] {
+			t.Errorf("Expected part %d to be %+v, got %+v", i, expected[i], parts[i])
+		}
+	}
+
+	// Test invalid templates
+	for _, value := range []string{"${{ steps.x", "${{ }}"} {
+		if _, err := parseTemplate(value); err == nil {
+			t.Errorf("Expected error for template %q", value)
+		}
+	}
+}
+
+func TestResolveParams(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	t.Setenv("GOFLOW_TEST_REGION", "eu-west-1")
+
+	// Create a workflow state with a previous step result
+	state := &models.WorkflowState{
+		WorkflowName: "test_workflow",
+		StepResults: map[string]models.StepResult{
+			"process": {
+				Success: true,
+				Data: map[string]any{
+					"records": 100,
+					"files":   []any{"a.csv", "b.csv"},
+				},
+			},
+		},
+	}
+
+	params := map[string]string{
+		"records": "${{ steps.process.data.records }}",
+		"first":   "${{ process.files[0] }}",
+		"files":   "${{ process.files }}",
+		"summary": "saved ${{ process.records * 2 }} rows in ${{ env.GOFLOW_TEST_REGION }}",
+		"static":  "unchanged",
+	}
+
+	resolved, err := engine.resolveParams(params, state)
+	if err != nil {
+		t.Fatalf("Failed to resolve params: %v", err)
+	}
+
+	expected := map[string]string{
+		"records": "100",
+		"first":   "a.csv",
+		"files":   `["a.csv","b.csv"]`,
+		"summary": "saved 200 rows in eu-west-1",
+		"static":  "unchanged",
+	}
+
+	for name, value := range expected {
+		if resolved[name] != value {
+			t.Errorf("Expected param %s to be %q, got %q", name, value, resolved[name])
+		}
+	}
+
+	// The original params must not be changed
+	if params["records"] != "${{ steps.process.data.records }}" {
+		t.Errorf("Expected original params to be unchanged, got %q", params["records"])
+	}
+
+	// An unresolvable reference is an error naming the param
+	_, err = engine.resolveParams(map[string]string{"id": "${{ inputs.order_id }}"}, state)
+	if err == nil || !strings.Contains(err.Error(), "param id: ${{ inputs.order_id }} could not be resolved") {
+		t.Errorf("Expected unresolved reference error, got %v", err)
+	}
+}
+
+func TestEnvReadLazily(t *testing.T) {
+	engine := NewEngine()
+	t.Setenv("GOFLOW_TEST_REGION", "eu-west-1")
+	state := &models.WorkflowState{
+		Inputs:      map[string]any{"order_id": "A-1"},
+		StepResults: map[string]models.StepResult{"env": {Data: map[string]any{"x": 1}}},
+	}
+	vars := engine.expressionVars(state)
+
+	// The environment is only read for an expression that uses it
+	if _, err := engine.eval(expr.MustCompile("inputs.order_id", nil), vars); err != nil {
+		t.Fatalf("Failed to evaluate: %v", err)
+	}
+	if _, ok := vars[envVar]; ok {
+		t.Error("Expected the environment not to be read")
+	}
+
+	// A step named env does not hide the environment
+	value, err := engine.eval(expr.MustCompile("env.GOFLOW_TEST_REGION", nil), vars)
+	if err != nil || value != "eu-west-1" {
+		t.Errorf("Expected eu-west-1, got %v (%v)", value, err)
+	}
+}
+
+func TestEnvAllowlist(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	t.Setenv("GOFLOW_TEST_REGION", "eu-west-1")
+	t.Setenv("GOFLOW_TEST_SECRET", "hunter2")
+	state := &models.WorkflowState{WorkflowName: "test_workflow"}
+	params := map[string]string{
+		"region": `${{ default(env.GOFLOW_TEST_REGION, "none") }}`,
+		"secret": `${{ default(env.GOFLOW_TEST_SECRET, "none") }}`,
+	}
+
+	tests := []struct {
+		allowlist []string
+		region    string
+		secret    string
+	}{
+		{nil, "eu-west-1", "hunter2"},
+		{[]string{"GOFLOW_TEST_REGION"}, "eu-west-1", "none"},
+		{[]string{}, "none", "none"},
+	}
+	for _, tt := range tests {
+		if tt.allowlist != nil {
+			engine.SetEnvAllowlist(tt.allowlist...)
+		}
+
+		resolved, err := engine.resolveParams(params, state)
+		if err != nil {
+			t.Fatalf("Failed to resolve params: %v", err)
+		}
+		if resolved["region"] != tt.region || resolved["secret"] != tt.secret {
+			t.Errorf("Expected region %s and secret %s with allowlist %v, got %s and %s", tt.region, tt.secret, tt.allowlist, resolved["region"], resolved["secret"])
+		}
+	}
+}
+
+func TestEnvParamsNotRecorded(t *testing.T) {
+	// Create a new engine whose step reads a secret from the environment
+	engine := NewEngine()
+	t.Setenv("GOFLOW_TEST_TOKEN", "hunter2")
+	call := &MockTask{name: "call"}
+	engine.RegisterTask(call)
+	engine.workflows["secret"] = &models.Workflow{
+		Name: "secret",
+		Steps: []models.Step{{ID: "call", Task: "call", Params: map[string]string{
+			"auth": "Bearer ${{ env.GOFLOW_TEST_TOKEN }}",
+			"url":  "https://example.com/${{ lower(\"API\") }}",
+		}}},
+	}
+
+	state, err := engine.Run("secret")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	// The task gets the secret, but the run records the template
+	if call.params["auth"] != "Bearer hunter2" {
+		t.Errorf("Expected task param auth Bearer hunter2, got %q", call.params["auth"])
+	}
+
+	params := state.StepResults["call"].Params
+	if params["auth"] != "Bearer ${{ env.GOFLOW_TEST_TOKEN }}" || params["url"] != "https://example.com/api" {
+		t.Errorf("Expected the auth template and the resolved url, got %v", params)
+	}
+
+	events, err := engine.Events(state.RunID)
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+	data, err := json.Marshal(events)
+	if err != nil {
+		t.Fatalf("Failed to encode events: %v", err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("Expected the events not to contain the secret, got %s", data)
+	}
+}
+
+func TestParamTemplatesInWorkflow(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+
+	engine.RegisterTask(&MockTask{name: "process", result: map[string]any{"records": 7}})
+	save := &MockTask{name: "save", result: map[string]any{"saved": true}}
+	engine.RegisterTask(save)
+
+	err := engine.LoadBytes([]byte(`{
+		"name": "templates",
+		"steps": [
+			{"id": "process", "task": "process", "next": ["save"]},
+			{"id": "save", "task": "save", "params": {"records": "${{ steps.process.data.records }}"}}
+		]
+	}`), FormatJSON)
+	if err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+
+	state, err := engine.Run("templates")
+	if err != nil {
+		t.Fatalf("Failed to run workflow: %v", err)
+	}
+
+	// The task gets the resolved value, and the step result records it
+	if save.params["records"] != "7" {
+		t.Errorf("Expected task param records 7, got %q", save.params["records"])
+	}
+
+	if state.StepResults["save"].Params["records"] != "7" {
+		t.Errorf("Expected recorded param records 7, got %v", state.StepResults["save"].Params)
+	}
+
+	// Placeholders referring to unknown steps fail the load
+	err = engine.LoadBytes([]byte(`{
+		"name": "broken",
+		"steps": [
+			{"id": "save", "task": "save", "params": {"records": "${{ steps.proces.data.records }}"}}
+		]
+	}`), FormatJSON)
+	if err == nil || !strings.Contains(err.Error(), "unknown field proces") {
+		t.Errorf("Expected load to fail for an unknown step, got %v", err)
+	}
+
+	// Placeholders that cannot be resolved fail the step before its task runs
+	save.executed = false
+	engine.workflows["missing"] = &models.Workflow{
+		Name:  "missing",
+		Steps: []models.Step{{ID: "save", Task: "save", Params: map[string]string{"records": "${{ inputs.records }}"}}},
+	}
+
+	state, err = engine.Run("missing")
+	if err == nil {
+		t.Fatal("Expected run to fail")
+	}
+
+	if save.executed {
+		t.Error("Expected save task not to be executed")
+	}
+
+	if result := state.StepResults["save"]; result.Success || !strings.Contains(result.Error, "could not be resolved") {
+		t.Errorf("Expected save to record the resolution error, got %+v", result)
+	}
+}
@@ -0,0 +1,84 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/clock"
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// errorClassTimeout is the error class of attempts that ran out of time, so
+// retry policies can retry them with retry_on: [timeout]
+const errorClassTimeout = "timeout"
+
+// parseTimeout parses the timeout of a step or workflow. An empty timeout means none
+func parseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive, got %s", timeout)
+	}
+	return d, nil
+}
+
+// withTimeout returns a context with the given timeout on the context's
+// clock, or the context itself if there is none
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return clock.WithTimeout(ctx, timeout)
+}
+
+// executeAttempt runs a task once. It returns as soon as the context times
+// out, even if the task does not watch it, so a timeout always bounds the
+// step. A cancelled task gets the grace period, on the context's clock, to
+// stop before it is left behind. A task that is left behind only sees its own copy of the state
+func executeAttempt(ctx context.Context, task tasks.Task, params map[string]string, state *models.WorkflowState, grace time.Duration) (map[string]any, error) {
+	type attemptResult struct {
+		data map[string]any
+		err  error
+	}
+
+	done := make(chan attemptResult, 1)
+	go func() {
+		data, err := task.Execute(ctx, params, state)
+		done <- attemptResult{data: data, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.data, result.err
+	case <-ctx.Done():
+	}
+
+	if !errors.Is(ctx.Err(), context.Canceled) || grace <= 0 {
+		return nil, ctx.Err()
+	}
+
+	expired := make(chan struct{})
+	timer := clock.FromContext(ctx).AfterFunc(grace, func() { close(expired) })
+	defer timer.Stop()
+
+	select {
+	case result := <-done:
+		return result.data, result.err
+	case <-expired:
+		return nil, fmt.Errorf("task did not stop within %s: %w", grace, ctx.Err())
+	}
+}
+
+// isTimeout reports whether an error is the result of a step or workflow timeout
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,269 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mstgnz/goflow/pkg/clock"
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// SlowTask waits for its delay or until its context is done. A stubborn task
+// ignores the context, like a task blocked on a call without a deadline
+type SlowTask struct {
+	name     string
+	delay    time.Duration
+	stubborn bool
+}
+
+func (t *SlowTask) Name() string {
+	return t.name
+}
+
+func (t *SlowTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	if t.stubborn {
+		time.Sleep(t.delay)
+		return map[string]any{"done": true}, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(t.delay):
+		return map[string]any{"done": true}, nil
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	if d, err := parseTimeout(""); err != nil || d != 0 {
+		t.Errorf("Expected no timeout for an empty value, got %v (%v)", d, err)
+	}
+
+	if d, err := parseTimeout("1m30s"); err != nil || d != 90*time.Second {
+		t.Errorf("Expected 1m30s, got %v (%v)", d, err)
+	}
+
+	for _, invalid := range []string{"soon", "10", "-5s", "0s"} {
+		if _, err := parseTimeout(invalid); err == nil {
+			t.Errorf("Expected error for timeout %q", invalid)
+		}
+	}
+}
+
+func TestStepTimeout(t *testing.T) {
+	for _, stubborn := range []bool{false, true} {
+		// Create a new engine
+		engine := NewEngine()
+		engine.RegisterTask(&SlowTask{name: "slow", delay: 2 * time.Second, stubborn: stubborn})
+		engine.RegisterTask(&MockTask{name: "after"})
+
+		engine.workflows["timeout"] = &models.Workflow{
+			Name: "timeout",
+			Steps: []models.Step{
+				{ID: "slow", Task: "slow", Timeout: "20ms", Next: []string{"after"}},
+				{ID: "after", Task: "after"},
+			},
+		}
+
+		started := time.Now()
+		state, err := engine.Run("timeout")
+		if err == nil {
+			t.Fatal("Expected workflow to fail")
+		}
+
+		// The engine does not wait for the task, even if it ignores the context
+		if elapsed := time.Since(started); elapsed > time.Second {
+			t.Errorf("Expected the timeout to end the step early, took %v", elapsed)
+		}
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected run error to wrap context.DeadlineExceeded, got %v", err)
+		}
+
+		result := state.StepResults["slow"]
+		if result.Status != models.StepTimedOut {
+			t.Errorf("Expected step status %s, got %s", models.StepTimedOut, result.Status)
+		}
+
+		if !strings.Contains(result.Error, "step timed out after 20ms") {
+			t.Errorf("Expected timeout error, got %s", result.Error)
+		}
+
+		if status := state.StepResults["after"].Status; status != models.StepPending {
+			t.Errorf("Expected the next step not to run, got %s", status)
+		}
+
+		if state.Status != "failed" {
+			t.Errorf("Expected workflow status failed, got %s", state.Status)
+		}
+	}
+}
+
+func TestStepTimeoutRetry(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.RegisterTask(&SlowTask{name: "slow", delay: time.Second})
+
+	engine.workflows["timeout"] = &models.Workflow{
+		Name: "timeout",
+		Steps: []models.Step{
+			{ID: "slow", Task: "slow", Timeout: "10ms", Retry: &models.RetryPolicy{MaxAttempts: 3, InitialDelay: "1ms", RetryOn: []string{"timeout"}}},
+		},
+	}
+
+	state, err := engine.Run("timeout")
+	if err == nil {
+		t.Fatal("Expected workflow to fail")
+	}
+
+	// Each attempt gets its own timeout
+	if attempts := len(state.StepResults["slow"].Attempts); attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestWorkflowTimeout(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.RegisterTask(&MockTask{name: "first"})
+	engine.RegisterTask(&SlowTask{name: "slow", delay: 2 * time.Second})
+	engine.RegisterTask(&MockTask{name: "last"})
+
+	engine.workflows["timeout"] = &models.Workflow{
+		Name:    "timeout",
+		Timeout: "30ms",
+		Steps: []models.Step{
+			{ID: "first", Task: "first", Next: []string{"slow"}},
+			{ID: "slow", Task: "slow", Next: []string{"last"}},
+			{ID: "last", Task: "last"},
+		},
+	}
+
+	started := time.Now()
+	state, err := engine.Run("timeout")
+	if err == nil {
+		t.Fatal("Expected workflow to time out")
+	}
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Expected the workflow timeout to end the run early, took %v", elapsed)
+	}
+
+	if !strings.Contains(err.Error(), "workflow timeout timed out after 30ms") {
+		t.Errorf("Expected workflow timeout error, got %v", err)
+	}
+
+	if state.Status != "timed_out" {
+		t.Errorf("Expected workflow status timed_out, got %s", state.Status)
+	}
+
+	if status := state.StepResults["first"].Status; status != models.StepSucceeded {
+		t.Errorf("Expected first step status %s, got %s", models.StepSucceeded, status)
+	}
+
+	if status := state.StepResults["slow"].Status; status != models.StepTimedOut {
+		t.Errorf("Expected slow step status %s, got %s", models.StepTimedOut, status)
+	}
+
+	if status := state.StepResults["last"].Status; status != models.StepPending {
+		t.Errorf("Expected the last step not to run, got %s", status)
+	}
+}
+
+// BlockedTask blocks until it is released, even when its context is done
+type BlockedTask struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (t *BlockedTask) Name() string {
+	return "blocked"
+}
+
+func (t *BlockedTask) Execute(ctx context.Context, params map[string]string, state *models.WorkflowState) (map[string]any, error) {
+	t.started <- struct{}{}
+	<-t.release
+	return nil, nil
+}
+
+func TestTimeoutsOnClock(t *testing.T) {
+	// The clock is far ahead of the system clock, which run IDs would
+	// otherwise start with
+	fake := clock.NewFake(time.Date(2100, 3, 1, 9, 0, 0, 0, time.UTC))
+	engine := NewEngine()
+	engine.SetClock(fake)
+	engine.SetGracePeriod(time.Minute)
+	engine.RegisterTask(&SlowTask{name: "slow", delay: time.Hour})
+	blocked := &BlockedTask{started: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(blocked.release)
+	engine.RegisterTask(blocked)
+
+	engine.workflows["timeout"] = &models.Workflow{
+		Name:  "timeout",
+		Steps: []models.Step{{ID: "slow", Task: "slow", Timeout: "10m"}},
+	}
+	engine.workflows["blocked"] = &models.Workflow{
+		Name:  "blocked",
+		Steps: []models.Step{{ID: "blocked", Task: "blocked"}},
+	}
+
+	// The step times out when the clock passes its timeout
+	runID, err := engine.Start(context.Background(), "timeout", nil)
+	if err != nil {
+		t.Fatalf("Failed to start run: %v", err)
+	}
+	started, _ := strconv.ParseInt(runID[:16], 16, 64)
+	if started < fake.Now().UnixNano() {
+		t.Errorf("Expected run ID to start with the clock's time %s, got %s", fake.Now(), runID)
+	}
+
+	fake.BlockUntil(1)
+	fake.Advance(10 * time.Minute)
+	state, _ := engine.Wait(context.Background(), runID)
+	if status := state.StepResults["slow"].Status; status != models.StepTimedOut {
+		t.Errorf("Expected step status %s, got %s", models.StepTimedOut, status)
+	}
+
+	// A cancelled task that ignores its context is left behind once the
+	// clock passes the grace period
+	runID, err = engine.Start(context.Background(), "blocked", nil)
+	if err != nil {
+		t.Fatalf("Failed to start run: %v", err)
+	}
+	<-blocked.started
+	if err := engine.Cancel(runID); err != nil {
+		t.Fatalf("Failed to cancel run: %v", err)
+	}
+
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	state, _ = engine.Wait(context.Background(), runID)
+	if state.Status != models.RunCancelled || !strings.Contains(state.StepResults["blocked"].Error, "task did not stop within 1m0s") {
+		t.Errorf("Expected a cancelled run that left its task behind, got %s: %s", state.Status, state.StepResults["blocked"].Error)
+	}
+}
+
+func TestTimeoutValidation(t *testing.T) {
+	workflow := &models.Workflow{
+		Name:    "invalid_timeout",
+		Timeout: "forever",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Timeout: "-1s"},
+		},
+	}
+
+	err := Validate(workflow, nil)
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+
+	for _, expected := range []string{"step step1: timeout: must be positive", "timeout: time: invalid duration"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected error containing %q, got %v", expected, err)
+		}
+	}
+}
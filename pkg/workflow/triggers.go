@@ -0,0 +1,211 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mstgnz/goflow/pkg/expr"
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// Trigger expressions can use these variables, along with env:
+//
+//	body           the JSON body of the request, null when it is empty
+//	headers.<name> a request header by lower case name, e.g. headers["idempotency-key"]
+//	query.<name>   a query parameter
+const (
+	bodyVar    = "body"
+	headersVar = "headers"
+	queryVar   = "query"
+)
+
+// triggerMethods are the HTTP methods a trigger can be declared for
+var triggerMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// TriggerVars returns the values of the variables of trigger expressions
+// for a request, see models.Trigger. Header names must be in lower case. The
+// environment is added when an expression reads env
+func (e *Engine) TriggerVars(body any, headers, query map[string]string) map[string]any {
+	return map[string]any{
+		bodyVar:    body,
+		headersVar: stringMap(headers),
+		queryVar:   stringMap(query),
+	}
+}
+
+// stringMap converts a map of strings for expressions
+func stringMap(m map[string]string) map[string]any {
+	converted := make(map[string]any, len(m))
+	for k, v := range m {
+		converted[k] = v
+	}
+	return converted
+}
+
+// TriggerInputs returns the inputs of a run started by a trigger, evaluated
+// against the variables of TriggerVars. A trigger without input expressions
+// takes the fields of the body that are declared inputs
+func (e *Engine) TriggerInputs(workflow *models.Workflow, trigger *models.Trigger, vars map[string]any) (map[string]any, error) {
+	if len(trigger.Inputs) == 0 {
+		body, _ := vars[bodyVar].(map[string]any)
+		inputs := make(map[string]any)
+		for _, input := range workflow.Inputs {
+			if value, ok := body[input.Name]; ok {
+				inputs[input.Name] = value
+			}
+		}
+		return inputs, nil
+	}
+
+	inputs := make(map[string]any, len(trigger.Inputs))
+	for _, name := range sortedKeys(trigger.Inputs) {
+		value, err := e.evaluate(trigger.Inputs[name], vars)
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %w", name, err)
+		}
+		inputs[name] = value
+	}
+	return inputs, nil
+}
+
+// TriggerKey returns the idempotency key of a request to a trigger, or an
+// empty string when the request has none. It defaults to the
+// Idempotency-Key header
+func (e *Engine) TriggerKey(trigger *models.Trigger, vars map[string]any) (string, error) {
+	source := trigger.IdempotencyKey
+	if source == "" {
+		source = `headers["idempotency-key"]`
+	}
+
+	value, err := e.evaluate(source, vars)
+	if err != nil {
+		return "", fmt.Errorf("idempotency key: %w", err)
+	}
+	if value == nil {
+		return "", nil
+	}
+	return expr.ToString(value), nil
+}
+
+// evaluate evaluates an expression with the given variables
+func (e *Engine) evaluate(source string, vars map[string]any) (any, error) {
+	program, err := e.program(source)
+	if err != nil {
+		return nil, err
+	}
+	return e.eval(program, vars)
+}
+
+// ErrNoTrigger is returned by FindTrigger when no workflow declares a trigger for a path
+var ErrNoTrigger = errors.New("no trigger for path")
+
+// ErrTriggerMethod is returned by FindTrigger when the triggers for a path
+// are declared for other methods
+var ErrTriggerMethod = errors.New("method not allowed")
+
+// FindTrigger returns the loaded workflow that declares a trigger for a
+// method and path, along with the trigger
+func (e *Engine) FindTrigger(method, path string) (*models.Workflow, *models.Trigger, error) {
+	found := false
+	for _, workflow := range e.Workflows() {
+		for i := range workflow.Triggers {
+			trigger := &workflow.Triggers[i]
+			if trigger.Path != path {
+				continue
+			}
+			if triggerMethod(trigger) == strings.ToUpper(method) {
+				return workflow, trigger, nil
+			}
+			found = true
+		}
+	}
+
+	if found {
+		return nil, nil, fmt.Errorf("%w: %s %s", ErrTriggerMethod, method, path)
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrNoTrigger, path)
+}
+
+// checkTriggerConflicts reports the triggers of a workflow that another
+// loaded workflow declares too. The lock must be held
+func (e *Engine) checkTriggerConflicts(workflow *models.Workflow) error {
+	for _, trigger := range workflow.Triggers {
+		for _, other := range e.workflows {
+			if other.Name == workflow.Name {
+				continue
+			}
+			for _, otherTrigger := range other.Triggers {
+				if otherTrigger.Path == trigger.Path && triggerMethod(&otherTrigger) == triggerMethod(&trigger) {
+					return fmt.Errorf("trigger %s %s is already declared by workflow %s", triggerMethod(&trigger), trigger.Path, other.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// triggerMethod returns the HTTP method of a trigger
+func triggerMethod(trigger *models.Trigger) string {
+	if trigger.Method == "" {
+		return "POST"
+	}
+	return strings.ToUpper(trigger.Method)
+}
+
+// checkTriggers validates the triggers of a workflow
+func checkTriggers(workflow *models.Workflow) []error {
+	var errs []error
+	scope := expr.Scope{
+		bodyVar:    expr.AnySchema,
+		headersVar: expr.OpenMap(),
+		queryVar:   expr.OpenMap(),
+		envVar:     expr.OpenMap(),
+	}
+	declared := make(map[string]bool, len(workflow.Inputs))
+	for _, input := range workflow.Inputs {
+		declared[input.Name] = true
+	}
+	seen := make(map[string]bool)
+
+	for i, trigger := range workflow.Triggers {
+		method := triggerMethod(&trigger)
+		name := fmt.Sprintf("trigger %s %s", method, trigger.Path)
+
+		if !strings.HasPrefix(trigger.Path, "/") {
+			errs = append(errs, fmt.Errorf("trigger %d: path must start with /, got %q", i+1, trigger.Path))
+			continue
+		}
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("duplicate %s", name))
+			continue
+		}
+		seen[name] = true
+
+		if !slices.Contains(triggerMethods, method) {
+			errs = append(errs, fmt.Errorf("%s: unsupported method", name))
+		}
+
+		for _, input := range sortedKeys(trigger.Inputs) {
+			if !declared[input] {
+				errs = append(errs, fmt.Errorf("%s: unknown input %s", name, input))
+			}
+			if _, err := expr.Compile(trigger.Inputs[input], scope); err != nil {
+				errs = append(errs, fmt.Errorf("%s: input %s: %w", name, input, err))
+			}
+		}
+
+		if trigger.IdempotencyKey != "" {
+			if _, err := expr.Compile(trigger.IdempotencyKey, scope); err != nil {
+				errs = append(errs, fmt.Errorf("%s: idempotency key: %w", name, err))
+			}
+		}
+
+		if _, err := parseTimeout(trigger.Wait); err != nil {
+			errs = append(errs, fmt.Errorf("%s: wait: %w", name, err))
+		}
+	}
+
+	return errs
+}
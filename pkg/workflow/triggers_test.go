@@ -0,0 +1,226 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/goflow/pkg/models"
+)
+
+// newTriggerEngine returns an engine with an "order" workflow that declares
+// a trigger with input expressions and one that takes the body as it is
+func newTriggerEngine() *Engine {
+	engine := NewEngine()
+	engine.RegisterTask(&EchoTask{name: "echo"})
+
+	engine.workflows["order"] = &models.Workflow{
+		Name: "order",
+		Inputs: []models.Input{
+			{Name: "order_id", Type: models.InputString, Required: true},
+			{Name: "amount", Type: models.InputNumber},
+		},
+		Triggers: []models.Trigger{
+			{
+				Path:           "/orders",
+				Inputs:         map[string]string{"order_id": "body.order.id", "amount": "body.order.total"},
+				IdempotencyKey: "headers[\"x-delivery\"]",
+			},
+			{Path: "/orders/raw", Method: "put"},
+		},
+		Steps: []models.Step{
+			{ID: "echo", Task: "echo", Params: map[string]string{"id": "${{ inputs.order_id }}"}},
+		},
+	}
+	return engine
+}
+
+func TestFindTrigger(t *testing.T) {
+	engine := newTriggerEngine()
+
+	wf, trigger, err := engine.FindTrigger("POST", "/orders")
+	if err != nil {
+		t.Fatalf("Failed to find trigger: %v", err)
+	}
+	if wf.Name != "order" || trigger.Path != "/orders" {
+		t.Errorf("Expected trigger /orders of order, got %s of %s", trigger.Path, wf.Name)
+	}
+
+	// Methods are matched regardless of case
+	if _, trigger, err := engine.FindTrigger("PUT", "/orders/raw"); err != nil || trigger.Path != "/orders/raw" {
+		t.Errorf("Expected trigger /orders/raw, got %v", err)
+	}
+
+	if _, _, err := engine.FindTrigger("GET", "/orders"); !errors.Is(err, ErrTriggerMethod) {
+		t.Errorf("Expected ErrTriggerMethod, got %v", err)
+	}
+	if _, _, err := engine.FindTrigger("POST", "/missing"); !errors.Is(err, ErrNoTrigger) {
+		t.Errorf("Expected ErrNoTrigger, got %v", err)
+	}
+}
+
+func TestTriggerInputs(t *testing.T) {
+	engine := newTriggerEngine()
+	wf := engine.workflows["order"]
+
+	body := map[string]any{
+		"order_id": "raw-1",
+		"order":    map[string]any{"id": "A-1", "total": 12.5},
+		"extra":    true,
+	}
+	vars := engine.TriggerVars(body, map[string]string{"x-delivery": "d-1"}, nil)
+
+	// Input expressions map the body to the inputs
+	inputs, err := engine.TriggerInputs(wf, &wf.Triggers[0], vars)
+	if err != nil {
+		t.Fatalf("Failed to map inputs: %v", err)
+	}
+	if inputs["order_id"] != "A-1" || inputs["amount"] != 12.5 {
+		t.Errorf("Expected order_id A-1 and amount 12.5, got %v", inputs)
+	}
+
+	// Without expressions the declared inputs are taken from the body
+	inputs, err = engine.TriggerInputs(wf, &wf.Triggers[1], vars)
+	if err != nil {
+		t.Fatalf("Failed to map inputs: %v", err)
+	}
+	if len(inputs) != 1 || inputs["order_id"] != "raw-1" {
+		t.Errorf("Expected only order_id raw-1, got %v", inputs)
+	}
+
+	// Fields missing from the body are null, so required inputs are reported missing
+	inputs, err = engine.TriggerInputs(wf, &wf.Triggers[0], engine.TriggerVars(nil, nil, nil))
+	if err != nil {
+		t.Fatalf("Failed to map inputs: %v", err)
+	}
+	if _, err := engine.Start(context.Background(), "order", inputs); err == nil || !strings.Contains(err.Error(), "missing required input order_id") {
+		t.Errorf("Expected a missing input error, got %v", err)
+	}
+}
+
+func TestTriggerKey(t *testing.T) {
+	engine := newTriggerEngine()
+	wf := engine.workflows["order"]
+
+	tests := []struct {
+		trigger  *models.Trigger
+		headers  map[string]string
+		expected string
+	}{
+		{&wf.Triggers[0], map[string]string{"x-delivery": "d-1"}, "d-1"},
+		{&wf.Triggers[0], map[string]string{}, ""},
+		{&wf.Triggers[1], map[string]string{"idempotency-key": "k-1"}, "k-1"},
+		{&wf.Triggers[1], map[string]string{"x-delivery": "d-1"}, ""},
+	}
+	for _, tt := range tests {
+		key, err := engine.TriggerKey(tt.trigger, engine.TriggerVars(nil, tt.headers, nil))
+		if err != nil {
+			t.Fatalf("Failed to evaluate key: %v", err)
+		}
+		if key != tt.expected {
+			t.Errorf("Expected key %q for %v, got %q", tt.expected, tt.headers, key)
+		}
+	}
+}
+
+func TestStartOnce(t *testing.T) {
+	engine := newTriggerEngine()
+	inputs := map[string]any{"order_id": "A-1"}
+
+	first, started, err := engine.StartOnce(context.Background(), "order", inputs, "d-1")
+	if err != nil || !started {
+		t.Fatalf("Expected a run to start, got %v", err)
+	}
+
+	// The same key returns the run it started
+	second, started, err := engine.StartOnce(context.Background(), "order", inputs, "d-1")
+	if err != nil {
+		t.Fatalf("Failed to start run: %v", err)
+	}
+	if started || second != first {
+		t.Errorf("Expected run %s again, got %s (started %v)", first, second, started)
+	}
+
+	// Other keys and empty keys start new runs
+	for _, key := range []string{"d-2", "", ""} {
+		runID, started, err := engine.StartOnce(context.Background(), "order", inputs, key)
+		if err != nil || !started || runID == first {
+			t.Errorf("Expected a new run for key %q, got %s (started %v, err %v)", key, runID, started, err)
+		}
+	}
+
+	state, err := engine.Wait(context.Background(), first)
+	if err != nil {
+		t.Fatalf("Failed to wait for run: %v", err)
+	}
+	if state.IdempotencyKey != "d-1" {
+		t.Errorf("Expected idempotency key d-1, got %q", state.IdempotencyKey)
+	}
+
+	runs, err := engine.ListRuns(RunFilter{IdempotencyKey: "d-1"})
+	if err != nil || len(runs) != 1 || runs[0].RunID != first {
+		t.Errorf("Expected only run %s for key d-1, got %d runs (%v)", first, len(runs), err)
+	}
+}
+
+func TestTriggerValidation(t *testing.T) {
+	workflow := &models.Workflow{
+		Name:   "invalid_triggers",
+		Inputs: []models.Input{{Name: "id", Type: models.InputString}},
+		Triggers: []models.Trigger{
+			{Path: "orders"},
+			{Path: "/orders"},
+			{Path: "/orders", Method: "post"},
+			{Path: "/orders", Method: "TRACE"},
+			{Path: "/other", Inputs: map[string]string{"missing": "body.id", "id": "body.id +"}},
+			{Path: "/keyed", IdempotencyKey: "nope.key", Wait: "soon"},
+		},
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1"},
+		},
+	}
+
+	err := Validate(workflow, nil)
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	for _, message := range []string{
+		`trigger 1: path must start with /, got "orders"`,
+		"duplicate trigger POST /orders",
+		"trigger TRACE /orders: unsupported method",
+		"trigger POST /other: unknown input missing",
+		"trigger POST /other: input id:",
+		"trigger POST /keyed: idempotency key:",
+		"trigger POST /keyed: wait:",
+	} {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error to contain %q, got %v", message, err)
+		}
+	}
+}
+
+func TestTriggerConflicts(t *testing.T) {
+	engine := NewEngine()
+	engine.RegisterTask(&EchoTask{name: "echo"})
+
+	definition := `{"name": "%s", "triggers": [{"path": "/orders"}], "steps": [{"id": "a", "task": "echo"}]}`
+	if err := engine.LoadBytes([]byte(fmt.Sprintf(definition, "first")), FormatJSON); err != nil {
+		t.Fatalf("Failed to load workflow: %v", err)
+	}
+
+	// Reloading a workflow keeps its own triggers
+	if err := engine.LoadBytes([]byte(fmt.Sprintf(definition, "first")), FormatJSON); err != nil {
+		t.Errorf("Expected a reload to succeed, got %v", err)
+	}
+
+	err := engine.LoadBytes([]byte(fmt.Sprintf(definition, "second")), FormatJSON)
+	if err == nil || !strings.Contains(err.Error(), "trigger POST /orders is already declared by workflow first") {
+		t.Errorf("Expected a conflict with workflow first, got %v", err)
+	}
+	if _, ok := engine.Workflow("second"); ok {
+		t.Error("Expected the conflicting workflow not to be loaded")
+	}
+}
@@ -0,0 +1,247 @@
+package workflow
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mstgnz/goflow/pkg/expr"
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+// ValidationError is a single problem found in a workflow definition
+type ValidationError struct {
+	StepID  string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.StepID == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("step %s: %s", e.StepID, e.Message)
+}
+
+// ValidationErrors holds every problem found in a workflow definition
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the individual validation errors
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Validate checks the structure of a workflow definition before it runs.
+// Task names are only checked when a registry is given. All problems are
+// returned at once as ValidationErrors
+func Validate(workflow *models.Workflow, registry *tasks.Registry) error {
+	var errs ValidationErrors
+	add := func(stepID, format string, args ...any) {
+		errs = append(errs, &ValidationError{StepID: stepID, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(workflow.Steps) == 0 {
+		add("", "workflow has no steps")
+		return errs
+	}
+
+	g := newGraph(workflow)
+	scope := expressionScope(workflow)
+	seen := make(map[string]bool)
+
+	for i, step := range workflow.Steps {
+		if step.ID == "" {
+			add("", "step %d has no id", i+1)
+			continue
+		}
+
+		if seen[step.ID] {
+			add(step.ID, "duplicate step id")
+			continue
+		}
+		seen[step.ID] = true
+
+		switch {
+		case step.Task == "" && step.Workflow == nil && !waits(&step):
+			add(step.ID, "no task")
+		case step.Task != "" && step.Workflow != nil:
+			add(step.ID, "task and workflow cannot be combined")
+		case step.Task != "" && registry != nil:
+			if _, ok := registry.Get(step.Task); !ok {
+				add(step.ID, "unknown task %s", step.Task)
+			}
+		}
+
+		if step.Workflow != nil {
+			for _, err := range checkSubWorkflow(&step, scope) {
+				add(step.ID, "%v", err)
+			}
+		}
+
+		if step.WaitSignal != nil {
+			for _, err := range checkWaitSignal(&step, g) {
+				add(step.ID, "%v", err)
+			}
+		}
+
+		if isSleep(&step) {
+			for _, err := range checkSleep(&step, scope) {
+				add(step.ID, "%v", err)
+			}
+		}
+
+		for _, next := range step.Next {
+			if _, ok := g.steps[next]; !ok {
+				add(step.ID, "next step %s does not exist", next)
+			}
+		}
+
+		for j, branch := range step.Branches {
+			if branch.Next == "" {
+				add(step.ID, "branch %d has no next step", j+1)
+			} else if _, ok := g.steps[branch.Next]; !ok {
+				add(step.ID, "branch step %s does not exist", branch.Next)
+			}
+
+			if branch.When == "" && j != len(step.Branches)-1 {
+				add(step.ID, "default branch to %s must be the last branch", branch.Next)
+			} else if branch.When != "" {
+				if err := checkCondition(branch.When, scope); err != nil {
+					add(step.ID, "branch %q: %v", branch.When, err)
+				}
+			}
+		}
+
+		for _, handler := range step.OnFailure {
+			if _, ok := g.steps[handler]; !ok {
+				add(step.ID, "on_failure step %s does not exist", handler)
+			}
+		}
+
+		for _, route := range step.OnErrorMatch {
+			if _, err := path.Match(route.Pattern, ""); err != nil || route.Pattern == "" {
+				add(step.ID, "on_error_match: invalid pattern %q", route.Pattern)
+			}
+			if len(route.Next) == 0 {
+				add(step.ID, "on_error_match %q has no next steps", route.Pattern)
+			}
+			for _, handler := range route.Next {
+				if _, ok := g.steps[handler]; !ok {
+					add(step.ID, "on_error_match step %s does not exist", handler)
+				}
+			}
+		}
+
+		if step.Condition != "" {
+			if err := checkCondition(step.Condition, scope); err != nil {
+				add(step.ID, "condition %q: %v", step.Condition, err)
+			}
+		}
+
+		if _, err := parseRetryPolicy(step.Retry); err != nil {
+			add(step.ID, "retry: %v", err)
+		}
+
+		if _, err := parseTimeout(step.Timeout); err != nil {
+			add(step.ID, "timeout: %v", err)
+		}
+
+		for _, err := range checkLoop(&step, scope) {
+			add(step.ID, "%v", err)
+		}
+
+		for _, name := range sortedKeys(step.Params) {
+			if err := checkTemplate(step.Params[name], loopScope(scope, &step)); err != nil {
+				add(step.ID, "param %s: %v", name, err)
+			}
+		}
+
+		if c := step.Compensate; c != nil {
+			if c.Task == "" {
+				add(step.ID, "compensate: no task")
+			} else if registry != nil {
+				if _, ok := registry.Get(c.Task); !ok {
+					add(step.ID, "compensate: unknown task %s", c.Task)
+				}
+			}
+
+			for _, name := range sortedKeys(c.Params) {
+				if err := checkTemplate(c.Params[name], scope); err != nil {
+					add(step.ID, "compensate param %s: %v", name, err)
+				}
+			}
+		}
+	}
+
+	reachable := g.reachable()
+	for i := range workflow.Steps {
+		// Duplicates have already been reported
+		step := &workflow.Steps[i]
+		if step.ID != "" && g.steps[step.ID] == step && !reachable[step.ID] {
+			add(step.ID, "not reachable from entry step %s", g.entry)
+		}
+	}
+
+	if cycle := g.findCycle(); cycle != nil {
+		add(cycle[0], "cycle %s", strings.Join(cycle, " -> "))
+	}
+
+	if _, err := parseTimeout(workflow.Timeout); err != nil {
+		add("", "timeout: %v", err)
+	}
+
+	for _, err := range checkInputDeclarations(workflow.Inputs) {
+		add("", "%v", err)
+	}
+
+	for _, err := range checkTriggers(workflow) {
+		add("", "%v", err)
+	}
+
+	for _, name := range sortedKeys(workflow.Outputs) {
+		if _, err := expr.Compile(workflow.Outputs[name], scope); err != nil {
+			add("", "output %s: %v", name, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkCondition compiles a condition and checks that it can produce a bool
+func checkCondition(source string, scope expr.Scope) error {
+	program, err := expr.Compile(source, scope)
+	if err != nil {
+		return err
+	}
+
+	if t := program.Type(); t != expr.Bool && t != expr.Any {
+		return fmt.Errorf("condition must be a bool, got %s", t)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of a map in order, so errors are reported deterministically
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,195 @@
+package workflow
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mstgnz/goflow/pkg/models"
+	"github.com/mstgnz/goflow/pkg/tasks"
+)
+
+func TestValidateValidWorkflow(t *testing.T) {
+	registry := tasks.NewRegistry()
+	registry.Register(&MockTask{name: "task1"})
+
+	workflow := &models.Workflow{
+		Name: "valid",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Next: []string{"step2", "step3"}},
+			{ID: "step2", Task: "task1", Next: []string{"step4"}},
+			{ID: "step3", Task: "task1", Next: []string{"step4"}, Condition: "step1.success && steps.step2.data.count > 1"},
+			{ID: "step4", Task: "task1"},
+		},
+	}
+
+	if err := Validate(workflow, registry); err != nil {
+		t.Errorf("Expected valid workflow, got %v", err)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	registry := tasks.NewRegistry()
+	registry.Register(&MockTask{name: "task1"})
+
+	workflow := &models.Workflow{
+		Name: "invalid",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Next: []string{"step2", "missing"}},
+			{ID: "step2", Task: "unknown_task", Next: []string{"step3"}},
+			{ID: "step2", Task: "task1"},
+			{ID: "step3", Task: "task1", Next: []string{"step2"}, Condition: "nope.success"},
+			{ID: "orphan", Task: "task1"},
+		},
+	}
+
+	err := Validate(workflow, registry)
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %T", err)
+	}
+
+	// Every problem should be reported against its step
+	expected := map[string]string{
+		"step1":  "next step missing does not exist",
+		"step2":  "unknown task unknown_task",
+		"step3":  `condition "nope.success": column 1: unknown variable nope`,
+		"orphan": "not reachable from entry step step1",
+	}
+
+	found := make(map[string]bool)
+	for _, e := range errs {
+		if expected[e.StepID] == e.Message {
+			found[e.StepID] = true
+		}
+	}
+
+	for stepID, message := range expected {
+		if !found[stepID] {
+			t.Errorf("Expected error %q for step %s, got %v", message, stepID, err)
+		}
+	}
+
+	// The duplicate ID and the cycle should be reported as well
+	var duplicate, cycle bool
+	for _, e := range errs {
+		switch e.Message {
+		case "duplicate step id":
+			duplicate = e.StepID == "step2"
+		case "cycle step2 -> step3 -> step2":
+			cycle = true
+		}
+	}
+
+	if !duplicate {
+		t.Errorf("Expected duplicate step id error, got %v", err)
+	}
+
+	if !cycle {
+		t.Errorf("Expected cycle error, got %v", err)
+	}
+
+	// Individual errors can be matched with errors.As
+	var single *ValidationError
+	if !errors.As(err, &single) {
+		t.Error("Expected errors.As to find a ValidationError")
+	}
+}
+
+func TestValidateWithoutRegistry(t *testing.T) {
+	workflow := &models.Workflow{
+		Name:  "no_registry",
+		Steps: []models.Step{{ID: "step1", Task: "anything"}},
+	}
+
+	// Task names are not checked without a registry
+	if err := Validate(workflow, nil); err != nil {
+		t.Errorf("Expected valid workflow, got %v", err)
+	}
+
+	// A workflow without steps is invalid
+	if err := Validate(&models.Workflow{Name: "empty"}, nil); err == nil {
+		t.Error("Expected error for workflow without steps")
+	}
+}
+
+func TestLoadRejectsInvalidWorkflow(t *testing.T) {
+	// Create a new engine
+	engine := NewEngine()
+	engine.RegisterTask(&MockTask{name: "task1"})
+
+	err := engine.LoadBytes([]byte(`{"name": "typo", "steps": [{"id": "step1", "task": "task1", "next": ["setp2"]}, {"id": "step2", "task": "task1"}]}`), FormatJSON)
+	if err == nil {
+		t.Fatal("Expected load to fail")
+	}
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ValidationErrors, got %v", err)
+	}
+
+	// The dangling reference and the unreachable step are both reported
+	if len(errs) != 2 {
+		t.Errorf("Expected 2 validation errors, got %v", errs)
+	}
+
+	if _, ok := engine.workflows["typo"]; ok {
+		t.Error("Expected invalid workflow not to be stored")
+	}
+}
+
+func TestValidateConditionType(t *testing.T) {
+	workflow := &models.Workflow{
+		Name: "types",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Next: []string{"step2"}},
+			{ID: "step2", Task: "task1", Condition: "step1.count + 1"},
+		},
+	}
+
+	err := Validate(workflow, nil)
+	if err == nil || !strings.Contains(err.Error(), "condition must be a bool, got number") {
+		t.Errorf("Expected non-bool condition to be rejected, got %v", err)
+	}
+}
+
+func TestValidateFailureRoutes(t *testing.T) {
+	registry := tasks.NewRegistry()
+	registry.Register(&MockTask{name: "task1"})
+
+	workflow := &models.Workflow{
+		Name: "routes",
+		Steps: []models.Step{
+			{ID: "step1", Task: "task1", Next: []string{"step2"}, OnFailure: []string{"missing"},
+				OnErrorMatch: []models.ErrorRoute{{Pattern: "[", Next: []string{"handler"}}, {Pattern: "net*"}}},
+			{ID: "step2", Task: "task1", OnFailure: []string{"step1"}},
+			{ID: "handler", Task: "task1"},
+		},
+	}
+
+	err := Validate(workflow, registry)
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+
+	expected := []string{
+		"step step1: on_failure step missing does not exist",
+		`step step1: on_error_match: invalid pattern "["`,
+		`step step1: on_error_match "net*" has no next steps`,
+		"cycle step1 -> step2 -> step1",
+	}
+	for _, message := range expected {
+		if !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error %q, got %v", message, err)
+		}
+	}
+
+	// Handlers count as reachable
+	if strings.Contains(err.Error(), "handler: not reachable") {
+		t.Errorf("Expected handler to be reachable, got %v", err)
+	}
+}